@@ -0,0 +1,56 @@
+package main
+
+import "fmt"
+
+// Output formats selected with -format; the animated image formats are also used by -preview.
+const (
+	formatMP4  = "mp4"
+	formatGIF  = "gif"
+	formatWebP = "webp"
+	// formatFrames writes numbered still images into a directory instead of a single file.
+	formatFrames = "frames"
+)
+
+// defaultAnimationFPS is the frame rate of animated outputs unless -fps is given, as GIF and WebP
+// files grow quickly with the frame rate and viewers rarely play them smoothly above it.
+const defaultAnimationFPS = 15
+
+// isAnimatedFormat reports whether format is an animated image format.
+func isAnimatedFormat(format string) bool {
+	return format == formatGIF || format == formatWebP
+}
+
+// addAnimationFilters scales the stream down to at most maxWidth pixels wide and, for GIF,
+// reduces it to a palette.
+func addAnimationFilters(g *filterGraph, format string, maxWidth int) {
+	g.add(fmt.Sprintf("scale='min(%d,iw)':-2:flags=lanczos", maxWidth))
+	if format == formatGIF {
+		addGIFPalette(g)
+	}
+}
+
+// addGIFPalette ends the graph with a palette computed from the whole stream, as GIF allows only 256
+// colors per frame. The frames are buffered until the palette is known. Ordered dithering and rectangle diffs keep static areas from shimmering, which
+// matters for timelapses where most of the frame never changes.
+func addGIFPalette(g *filterGraph) {
+	g.graft(func(in, out string) string {
+		return fmt.Sprintf("[%s]split[%sa][%sb];[%sa]palettegen=stats_mode=diff[%sp];[%sb][%sp]paletteuse=dither=bayer:bayer_scale=4:diff_mode=rectangle[%s]",
+			in, out, out, out, out, out, out, out)
+	})
+}
+
+// animatedOutputArgs returns the ffmpeg output options for an animated image that loops forever.
+func animatedOutputArgs(format string) []string {
+	if format == formatWebP {
+		return []string{"-c:v", "libwebp", "-q:v", "70", "-loop", "0"}
+	}
+	return []string{"-c:v", "gif", "-loop", "0"}
+}
+
+// animatedComponents returns the ffmpeg components needed to write an animated image in format.
+func animatedComponents(format string) ffmpegComponents {
+	if format == formatWebP {
+		return ffmpegComponents{encoders: []string{"libwebp"}}
+	}
+	return ffmpegComponents{encoders: []string{"gif"}, filters: []string{"split", "palettegen", "paletteuse"}}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// azureAPIVersion is the Blob service REST API version requests are made with.
+const azureAPIVersion = "2021-08-06"
+
+// azureConfig is an Azure Blob Storage container accessed with a shared access signature (SAS)
+// that allows creating and writing blobs.
+type azureConfig struct {
+	// ContainerURL is e.g. https://account.blob.core.windows.net/timelapses.
+	ContainerURL string `json:"container_url"`
+	// SASToken is the query string of the SAS, with or without the leading "?".
+	SASToken string `json:"sas_token"`
+	Prefix   string `json:"prefix"`
+}
+
+// validate checks that the container and SAS are set.
+func (c azureConfig) validate() error {
+	if c.ContainerURL == "" || c.SASToken == "" {
+		return errors.New("azure needs container_url and sas_token")
+	}
+	return nil
+}
+
+// uploadAzure uploads file as a block blob, one block per part, and returns the blob's URL without the SAS.
+func uploadAzure(c azureConfig, file string, retries int) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	blobURL := strings.TrimSuffix(c.ContainerURL, "/") + "/" + s3Escape(objectName(c.Prefix, file), false)
+	sas := strings.TrimPrefix(c.SASToken, "?")
+
+	partSize := partSizeFor(info.Size())
+	parts := int((info.Size() + partSize - 1) / partSize)
+	var blockList struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}
+	for n := 0; n < parts; n++ {
+		body, err := readPart(f, n, partSize, info.Size())
+		if err != nil {
+			return "", err
+		}
+		// Block IDs must all have the same length before encoding
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%06d", n)))
+		query := url.Values{"comp": {"block"}, "blockid": {id}}
+		if err := withRetries(retries, func() error { return azurePut(blobURL, query, sas, nil, body) }); err != nil {
+			return "", fmt.Errorf("block %d of %d: %w", n+1, parts, err)
+		}
+		blockList.Latest = append(blockList.Latest, id)
+	}
+
+	body, err := xml.Marshal(blockList)
+	if err != nil {
+		return "", err
+	}
+	header := http.Header{"x-ms-blob-content-type": {contentType(file)}}
+	if err := withRetries(retries, func() error {
+		return azurePut(blobURL, url.Values{"comp": {"blocklist"}}, sas, header, body)
+	}); err != nil {
+		return "", fmt.Errorf("committing blocks: %w", err)
+	}
+	return blobURL, nil
+}
+
+// azurePut sends a PUT request to the blob with the given query parameters and the SAS appended.
+func azurePut(blobURL string, query url.Values, sas string, header http.Header, body []byte) error {
+	req, err := http.NewRequest(http.MethodPut, blobURL+"?"+query.Encode()+"&"+sas, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
+	}
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// The URL carries the SAS, so leave it out
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var e struct {
+			Code    string
+			Message string
+		}
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			return fmt.Errorf("%s: %s: %s", resp.Status, e.Code, lastLine(e.Message))
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// benchSource is the synthetic footage encoded by bench without -clip.
+const benchSource = "testsrc2=size=1920x1080:rate=30"
+
+// benchEncoder is an encoder and preset compared by bench, with the rate control a merge would use.
+type benchEncoder struct {
+	encoder string
+	preset  string
+	args    []string
+	// merge marks the encoder and preset a merge uses, with or without -gpu.
+	merge bool
+}
+
+// benchEncoders lists the encoders and presets compared by bench, as far as ffmpeg provides them.
+var benchEncoders = []benchEncoder{
+	{encoder: "h264_nvenc", preset: "p1", args: []string{"-cq", "23", "-pix_fmt", "yuv420p"}},
+	{encoder: "h264_nvenc", preset: "p4", args: []string{"-cq", "23", "-pix_fmt", "yuv420p"}, merge: true},
+	{encoder: "h264_nvenc", preset: "p7", args: []string{"-cq", "23", "-pix_fmt", "yuv420p"}},
+	{encoder: "hevc_nvenc", preset: "p4", args: []string{"-cq", "25", "-profile:v", "main10", "-pix_fmt", "p010le"}},
+	{encoder: "libx264", preset: "veryfast", args: []string{"-crf", "23", "-pix_fmt", "yuv420p"}},
+	{encoder: "libx264", preset: "medium", args: []string{"-crf", "23", "-pix_fmt", "yuv420p"}, merge: true},
+	{encoder: "libx264", preset: "slow", args: []string{"-crf", "23", "-pix_fmt", "yuv420p"}},
+	{encoder: "libx265", preset: "medium", args: []string{"-crf", "26", "-pix_fmt", "yuv420p10le"}},
+}
+
+// benchResult is the outcome of encoding the sample with one benchEncoder.
+type benchResult struct {
+	elapsed time.Duration
+	size    int64
+	err     error
+}
+
+// runBench implements the bench subcommand: it encodes a short sample with each encoder and preset
+// available and reports how fast each was and how large its output, to choose between them.
+func runBench(args []string) error {
+	fset := flagSetFor("bench")
+	clip := fset.String("clip", "", "Clip to encode a sample of (default: synthetic footage, whose sizes say little about real footage)")
+	length := fset.Duration("duration", 10*time.Second, "Length of the sample")
+	ffmpegPath := fset.String("ffmpeg", "ffmpeg", "Path to ffmpeg executable")
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+
+	if *length <= 0 {
+		return fmt.Errorf("duration must be greater than 0")
+	}
+	input := []string{"-f", "lavfi", "-t", formatSeconds(*length), "-i", benchSource}
+	if *clip != "" {
+		if _, err := os.Stat(*clip); err != nil {
+			return err
+		}
+		input = []string{"-t", formatSeconds(*length), "-i", *clip}
+	}
+
+	version, err := ffmpegVersion(*ffmpegPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Using %s\n", version)
+	available, err := ffmpegList(*ffmpegPath, "-encoders")
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "unifi-timelapse-bench-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	results := make([]benchResult, len(benchEncoders))
+	nvencErrs := make(map[string]error)
+	for i, e := range benchEncoders {
+		if !available[e.encoder] {
+			results[i].err = fmt.Errorf("not in this ffmpeg")
+			continue
+		}
+		// NVENC may be compiled in but unusable without an NVIDIA GPU or driver
+		if strings.HasSuffix(e.encoder, "_nvenc") {
+			if _, tested := nvencErrs[e.encoder]; !tested {
+				nvencErrs[e.encoder] = testEncoder(*ffmpegPath, e.encoder)
+			}
+			if err := nvencErrs[e.encoder]; err != nil {
+				results[i].err = fmt.Errorf("failed to start: %v", err)
+				continue
+			}
+		}
+		fmt.Printf("Encoding the sample with %s (%s)...\n", e.encoder, e.preset)
+		results[i] = benchEncode(*ffmpegPath, input, e, filepath.Join(dir, fmt.Sprintf("%d.mp4", i)))
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENCODER\tPRESET\tTIME\tSPEED\tSIZE\t")
+	for i, e := range benchEncoders {
+		name := e.encoder
+		if e.merge {
+			name += " *"
+		}
+		r := results[i]
+		if r.err != nil {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\t%v\n", name, e.preset, r.err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1fs\t%.1fx\t%s\t\n", name, e.preset, r.elapsed.Seconds(),
+			length.Seconds()/r.elapsed.Seconds(), formatBytes(uint64(r.size)))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Println("\n* used by merges, with -gpu and -gpu=false. SPEED is the sample's length divided by the time it took.")
+	return nil
+}
+
+// benchEncode encodes the sample read with input using e into output, timing it.
+func benchEncode(ffmpegPath string, input []string, e benchEncoder, output string) benchResult {
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, input...)
+	args = append(args, "-an", "-c:v", e.encoder, "-preset", e.preset)
+	args = append(args, e.args...)
+	args = append(args, "-y", output)
+
+	cmd := exec.Command(ffmpegPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		return benchResult{err: fmt.Errorf("%v: %s", err, lastLine(stderr.String()))}
+	}
+	elapsed := time.Since(start)
+	info, err := os.Stat(output)
+	if err != nil {
+		return benchResult{err: err}
+	}
+	return benchResult{elapsed: elapsed, size: info.Size()}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Values of -bit-depth.
+const (
+	// bitDepth8 encodes 8-bit H.264, which plays everywhere.
+	bitDepth8 = "8"
+	// bitDepth10 encodes 10-bit HEVC (main10), which keeps smooth gradients such as skies free of banding.
+	bitDepth10 = "10"
+	// bitDepthAuto encodes 10-bit HEVC when the footage is 10-bit or deeper, and 8-bit H.264 otherwise.
+	bitDepthAuto = "auto"
+)
+
+// pixelFormatDepth matches the bit depth in the name of a planar pixel format such as yuv420p10le or p010le.
+var pixelFormatDepth = regexp.MustCompile(`p0?(\d+)(le|be)$`)
+
+// probeBitDepth returns the bits per color component of the first video stream of path, as reported by ffprobe.
+// Pixel formats that carry no depth in their name, such as yuv420p, are 8-bit.
+func probeBitDepth(ffprobe, path string) (int, error) {
+	cmd := exec.Command(ffprobe,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=pix_fmt",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("probing %s: %w: %s", path, err, lastLine(stderr.String()))
+	}
+	pixFmt := strings.TrimSpace(stdout.String())
+	if m := pixelFormatDepth.FindStringSubmatch(pixFmt); m != nil {
+		depth, _ := strconv.Atoi(m[1])
+		return depth, nil
+	}
+	return 8, nil
+}
+
+// videoEncoderArgs returns the ffmpeg arguments encoding the video of an MP4 output, and a description
+// of the encoding for the log. Software encodes aim at opts.bitrate when it is set, and a constant quality otherwise.
+// A -keyint keyframe interval and a -threads limit apply to every encoder, and -gpu-index to NVENC; -draft
+// switches to the fastest preset.
+func videoEncoderArgs(opts options) ([]string, string) {
+	args, encoding := encoderArgs(opts)
+	if opts.draft {
+		args, encoding = draftEncoderArgs(args), encoding+", draft"
+	}
+	if opts.useGPU {
+		args = append(args, gpuArgs(opts)...)
+	}
+	if opts.keyint > 0 {
+		args = append(args, "-g", strconv.Itoa(opts.keyint))
+	}
+	if opts.threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(opts.threads))
+	}
+	return args, encoding
+}
+
+// encoderArgs returns videoEncoderArgs's arguments for the codec and rate control.
+func encoderArgs(opts options) ([]string, string) {
+	quality := func(crf string) []string {
+		if opts.bitrate != "" {
+			return []string{"-b:v", opts.bitrate}
+		}
+		return []string{"-crf", crf}
+	}
+	switch {
+	case opts.bitDepth == bitDepth10 && opts.useGPU:
+		// Tagged hvc1 so that Apple players accept the HEVC stream
+		return []string{"-c:v", "hevc_nvenc", "-preset", "p4", "-cq", "25", "-profile:v", "main10", "-pix_fmt", "p010le", "-tag:v", "hvc1"},
+			"10-bit HEVC GPU acceleration"
+	case opts.bitDepth == bitDepth10:
+		// x265 at CRF 26 matches the quality of x264 at CRF 23 in a smaller file
+		args := append([]string{"-c:v", "libx265", "-preset", "medium"}, quality("26")...)
+		return append(args, "-pix_fmt", "yuv420p10le", "-tag:v", "hvc1"), "10-bit HEVC software encoding"
+	case opts.useGPU:
+		// NVIDIA GPU acceleration
+		return []string{"-c:v", "h264_nvenc", "-preset", "p4", "-cq", "23", "-pix_fmt", "yuv420p"}, "GPU acceleration"
+	default:
+		// Software encoding
+		args := append([]string{"-c:v", "libx264", "-preset", "medium"}, quality("23")...)
+		return append(args, "-pix_fmt", "yuv420p"), "software encoding"
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// isCameraPattern reports whether a -camera or -with value is a glob pattern, e.g. "*flex*", rather than a name.
+func isCameraPattern(camera string) bool {
+	return strings.ContainsAny(camera, "*?[")
+}
+
+// hasCameraPrefix reports whether the filename starts with the camera name, ignoring case, as Protect
+// exports and snapshots do.
+func hasCameraPrefix(filename, camera string) bool {
+	return strings.HasPrefix(strings.ToLower(filename), strings.ToLower(camera))
+}
+
+// resolveCameras replaces the -camera and -with values given as glob patterns with the one camera in the
+// input directories that each matches, ignoring case, and the cameras given by their name in the clip
+// filenames with their alias, so that outputs, locks and config sections use the same name for a camera
+// however it was given.
+func resolveCameras(opts *options) error {
+	patterns := isCameraPattern(opts.cameraName)
+	for _, c := range opts.withCameras {
+		patterns = patterns || isCameraPattern(c)
+	}
+	var names []string
+	if patterns {
+		if opts.images {
+			return fmt.Errorf("camera patterns do not apply to -images; give the name the images start with")
+		}
+		var err error
+		if names, err = cameraNamesIn(*opts); err != nil {
+			return err
+		}
+	}
+	cameras := []*string{&opts.cameraName}
+	for i := range opts.withCameras {
+		cameras = append(cameras, &opts.withCameras[i])
+	}
+	for _, camera := range cameras {
+		name, err := matchCameraPattern(*camera, names, opts.aliases)
+		if err != nil {
+			return err
+		}
+		// Through the camera's name, so an alias given in another case is written as in the config
+		*camera = cameraAlias(opts.aliases, clipCamera(opts.aliases, name))
+	}
+	return nil
+}
+
+// matchCameraPattern returns the one of names that pattern matches, by the name or its alias, or pattern
+// itself if it is a name.
+func matchCameraPattern(pattern string, names []string, aliases map[string]string) (string, error) {
+	if !isCameraPattern(pattern) {
+		return pattern, nil
+	}
+	var matched []string
+	for _, name := range names {
+		for _, candidate := range []string{name, cameraAlias(aliases, name)} {
+			ok, err := filepath.Match(strings.ToLower(pattern), strings.ToLower(candidate))
+			if err != nil {
+				return "", fmt.Errorf("invalid camera pattern %q: %w", pattern, err)
+			}
+			if ok {
+				matched = append(matched, name)
+				break
+			}
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return "", withExitCode(exitNoFootage, fmt.Errorf("no camera matches %q (cameras found: %s)", pattern, strings.Join(names, ", ")))
+	case 1:
+		return matched[0], nil
+	default:
+		return "", fmt.Errorf("%q matches several cameras: %s", pattern, strings.Join(matched, ", "))
+	}
+}
+
+// clipCamera returns the camera name in the clip filenames that camera stands for: the camera of the
+// alias, ignoring case, or camera itself.
+func clipCamera(aliases map[string]string, camera string) string {
+	for alias, name := range aliases {
+		if strings.EqualFold(alias, camera) {
+			return name
+		}
+	}
+	return camera
+}
+
+// cameraAlias returns the alias of the camera named name in the clip filenames, or name itself if it has
+// none. Of several aliases of a camera, the first in order is used.
+func cameraAlias(aliases map[string]string, name string) string {
+	for _, alias := range sortedNames(aliases) {
+		if strings.EqualFold(aliases[alias], name) {
+			return alias
+		}
+	}
+	return name
+}
+
+// cameraNamesIn returns the names of the cameras with clips in the input directories of opts.
+func cameraNamesIn(opts options) ([]string, error) {
+	naming, err := namingFor(opts.source, opts.dateOrder)
+	if err != nil {
+		return nil, err
+	}
+	files, err := findVideoFiles(inputDirsFor(opts), naming, "")
+	if err != nil {
+		return nil, fmt.Errorf("finding video files: %w", err)
+	}
+	var names []string
+	for _, cam := range summarizeCameras(loadClips(files, naming)) {
+		names = append(names, cam.Name)
+	}
+	return names, nil
+}
+
+// cameraSuggestion returns a hint naming the cameras camera was probably meant to be, for the error of a
+// camera without clips: those whose name contains it, or else the one closest to it.
+func cameraSuggestion(camera string, names []string) string {
+	lower := strings.ToLower(camera)
+	var similar []string
+	for _, name := range names {
+		if strings.Contains(strings.ToLower(name), lower) {
+			similar = append(similar, name)
+		}
+	}
+	if len(similar) == 0 {
+		lowerNames := make([]string, len(names))
+		for i, name := range names {
+			lowerNames[i] = strings.ToLower(name)
+		}
+		if closest := closestName(lower, lowerNames); closest != "" {
+			for i, l := range lowerNames {
+				if l == closest {
+					similar = append(similar, names[i])
+					break
+				}
+			}
+		}
+	}
+	if len(similar) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean: %s?)", strings.Join(similar, ", "))
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Defaults of the capture subcommand.
+const (
+	defaultCaptureInterval = 30 * time.Second
+	defaultCaptureDir      = "snapshots"
+	// maxCaptureTimeout bounds each snapshot, so that a camera that stops answering cannot stall the loop.
+	maxCaptureTimeout = 30 * time.Second
+)
+
+// runCapture implements the capture subcommand: it saves a snapshot of a camera at a fixed interval,
+// named like a Protect export so that the snapshots can later be merged with -images.
+func runCapture(args []string) error {
+	fset := flagSetFor("capture")
+	camera := fset.String("camera", "", "Camera name used in the snapshot filenames (required)")
+	source := fset.String("url", "", "Camera stream (rtsp:// or rtsps://) or snapshot URL (http:// or https://) (required without -protect)")
+	viaProtect := fset.Bool("protect", false, "Fetch the snapshots through the Protect controller of the config file or UNIFI_* environment variables instead of -url")
+	site := fset.String("site", "", "With -protect, the site of the camera when several controllers are configured")
+	onvif := fset.String("onvif", "", "Address of an ONVIF camera, e.g. 192.168.1.20 or http://192.168.1.20:8000, to ask for its snapshot or stream address instead of -url")
+	onvifStream := fset.Bool("onvif-stream", false, "With -onvif, grab frames from the camera's RTSP stream even if it offers snapshots")
+	username := fset.String("username", "", "With -onvif, the camera user")
+	password := fset.String("password", "", "With -onvif, the camera password, or \"env:NAME\" or \"keychain:NAME\" to read it from there")
+	configFile := fset.String("config", "", "Path to the JSON config file with the \"protect\" section (default: \""+defaultConfigFile+"\" if present)")
+	configFromEnv := fset.Bool("config-from-env", false, configFromEnvUsage)
+	interval := fset.Duration("interval", defaultCaptureInterval, "Time between snapshots")
+	dir := fset.String("dir", defaultCaptureDir, "Directory to save the snapshots in, one subdirectory per day")
+	ffmpegPath := fset.String("ffmpeg", "ffmpeg", "Path to ffmpeg executable, used for rtsp:// and rtsps:// streams")
+	hours := fset.String("hours", "", "Only capture within this daily window, e.g. 06:00-20:00")
+	daylightOnly := fset.Bool("daylight-only", false, "Only capture between sunrise and sunset at -lat/-lon")
+	lat := fset.Float64("lat", 0, "Camera latitude in degrees, north positive (used by -daylight-only)")
+	lon := fset.Float64("lon", 0, "Camera longitude in degrees, east positive (used by -daylight-only)")
+	margin := fset.Duration("daylight-margin", 0, "Extend daylight by this long before sunrise and after sunset")
+	healthListen := fset.String("health-listen", "", healthListenUsage)
+	healthMaxAge := fset.Duration("health-max-age", 0, healthMaxAgeUsage)
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+
+	sources := 0
+	for _, set := range []bool{*source != "", *viaProtect, *onvif != ""} {
+		if set {
+			sources++
+		}
+	}
+	if *camera == "" || sources != 1 {
+		return fmt.Errorf("capture needs -camera, and one of -url, -protect or -onvif")
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("-interval must be positive")
+	}
+	var grab func(dest string, timeout time.Duration) (string, error)
+	if *viaProtect {
+		var err error
+		// Name the files after the camera in Protect, which -camera may only identify by ID
+		grab, *camera, err = protectSnapshotGrabber(*configFile, *configFromEnv, *site, *camera)
+		if err != nil {
+			return err
+		}
+	} else {
+		if *onvif != "" {
+			secret, err := resolveSecret(*password)
+			if err != nil {
+				return err
+			}
+			if *source, err = resolveONVIFSource(*onvif, *username, secret, *onvifStream); err != nil {
+				return fmt.Errorf("ONVIF camera %s: %w", *onvif, err)
+			}
+		}
+		u, err := url.Parse(*source)
+		if err != nil {
+			return fmt.Errorf("invalid -url: %w", err)
+		}
+		switch u.Scheme {
+		case "rtsp", "rtsps":
+			grab = func(dest string, timeout time.Duration) (string, error) {
+				return dest + ".jpg", grabStreamFrame(*ffmpegPath, *source, dest+".jpg", timeout)
+			}
+		case "http", "https":
+			grab = func(dest string, timeout time.Duration) (string, error) {
+				return fetchSnapshot(*source, dest, timeout)
+			}
+		default:
+			return fmt.Errorf("unsupported -url scheme %q (use rtsp://, rtsps://, http:// or https://)", u.Scheme)
+		}
+	}
+
+	var spansFor func(day time.Time) []timeSpan
+	switch {
+	case *hours != "" && *daylightOnly:
+		return fmt.Errorf("-hours and -daylight-only cannot be combined")
+	case *hours != "":
+		w, err := parseDailyWindow(*hours)
+		if err != nil {
+			return fmt.Errorf("invalid -hours: %w", err)
+		}
+		spansFor = w.spans
+	case *daylightOnly:
+		if !isFlagSet(fset, "lat") || !isFlagSet(fset, "lon") {
+			return fmt.Errorf("-daylight-only requires -lat and -lon")
+		}
+		spansFor = daylightSpans(*lat, *lon, *margin)
+	}
+
+	h := newHealth(*healthMaxAge)
+	if *healthListen != "" {
+		serveHealth(*healthListen, h)
+	}
+	h.setReady()
+
+	timeout := min(*interval, maxCaptureTimeout)
+	fmt.Printf("Capturing %s every %s into %s\n", *camera, *interval, *dir)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for now := time.Now(); ; now = <-ticker.C {
+		if spansFor != nil && !withinSpans(spansFor(now), now) {
+			continue
+		}
+		dayDir := filepath.Join(*dir, now.Format("2006-01-02"))
+		if err := os.MkdirAll(dayDir, 0o755); err != nil {
+			return err
+		}
+		// A failed snapshot is only reported: the camera may be rebooting, and the next one may work
+		h.runStarted()
+		file, err := grab(filepath.Join(dayDir, snapshotName(*camera, now)), timeout)
+		h.runFinished(err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: capturing %s: %v\n", *camera, err)
+			continue
+		}
+		fmt.Printf("Saved %s\n", file)
+	}
+}
+
+// snapshotName returns the filename, without extension, of a snapshot taken at t: the camera name
+// followed by the time in the format of Protect exports, so that -images orders the snapshots by it.
+func snapshotName(camera string, t time.Time) string {
+	return filenameText(camera) + " " + formatFilenameTime(t)
+}
+
+// withinSpans reports whether t falls within one of spans.
+func withinSpans(spans []timeSpan, t time.Time) bool {
+	for _, s := range spans {
+		if !t.Before(s.start) && t.Before(s.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// protectSnapshotGrabber returns a function saving snapshots of a camera taken by Protect, and the camera's
+// name. The session is renewed after a failure, as the login expires during a capture of several months.
+func protectSnapshotGrabber(configFile string, configFromEnv bool, site, camera string) (func(dest string, timeout time.Duration) (string, error), string, error) {
+	cfg, err := loadConfigFlag(configFile, configFromEnv)
+	if err != nil {
+		return nil, "", err
+	}
+	sites, err := protectSitesFor(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	if sites, err = sites.selectSite(site); err != nil {
+		return nil, "", err
+	}
+	client, cam, err := findProtectCamera(sites, camera)
+	if err != nil {
+		return nil, "", err
+	}
+	siteCfg, _ := sites.selectSite(cam.Site)
+
+	return func(dest string, timeout time.Duration) (string, error) {
+		if client == nil {
+			if client, err = newProtectClient(siteCfg[0]); err != nil {
+				return "", err
+			}
+		}
+		dest += ".jpg"
+		tmp := filepath.Join(filepath.Dir(dest), ".capture-"+filepath.Base(dest))
+		f, err := os.Create(tmp)
+		if err != nil {
+			return "", err
+		}
+		err = client.snapshot(cam.ID, f, timeout)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(tmp)
+			client = nil
+			return "", err
+		}
+		return dest, os.Rename(tmp, dest)
+	}, cam.Name, nil
+}
+
+// grabStreamFrame saves one frame of an RTSP(S) stream as a JPEG at dest.
+func grabStreamFrame(ffmpegPath, stream, dest string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// Written under a hidden name first, so that a merge running meanwhile never sees half a file
+	tmp := filepath.Join(filepath.Dir(dest), ".capture-"+filepath.Base(dest))
+	cmd := exec.CommandContext(ctx, ffmpegPath, "-hide_banner", "-loglevel", "error",
+		"-rtsp_transport", "tcp",
+		"-i", stream,
+		"-frames:v", "1",
+		"-q:v", "2",
+		"-y", tmp)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmp)
+		if ctx.Err() != nil {
+			return fmt.Errorf("no frame within %s", timeout)
+		}
+		return fmt.Errorf("%w: %s", err, lastLine(stderr.String()))
+	}
+	return os.Rename(tmp, dest)
+}
+
+// fetchSnapshot downloads a still image from a camera's snapshot URL to dest plus the extension matching
+// its type, and returns the file name. Credentials in the URL are sent with Basic or Digest authentication.
+func fetchSnapshot(snapshotURL, dest string, timeout time.Duration) (string, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(snapshotURL)
+	if err != nil {
+		return "", err
+	}
+	if u, _ := url.Parse(snapshotURL); resp.StatusCode == http.StatusUnauthorized && u.User != nil {
+		// Many cameras only accept Digest authentication
+		resp.Body.Close()
+		password, _ := u.User.Password()
+		auth, err := digestAuthorization(resp.Header.Get("WWW-Authenticate"), http.MethodGet, u.RequestURI(), u.User.Username(), password)
+		if err != nil {
+			return "", err
+		}
+		req, err := http.NewRequest(http.MethodGet, snapshotURL, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", auth)
+		if resp, err = client.Do(req); err != nil {
+			return "", err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("snapshot URL returned %s", resp.Status)
+	}
+	switch contentType := resp.Header.Get("Content-Type"); {
+	case strings.HasPrefix(contentType, "image/png"):
+		dest += ".png"
+	case strings.HasPrefix(contentType, "image/jpeg"), contentType == "":
+		dest += ".jpg"
+	default:
+		return "", fmt.Errorf("snapshot URL returned %s instead of an image", contentType)
+	}
+
+	tmp := filepath.Join(filepath.Dir(dest), ".capture-"+filepath.Base(dest))
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return dest, os.Rename(tmp, dest)
+}
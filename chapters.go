@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// chaptersFile is the name of the temporary ffmetadata file carrying chapter markers to ffmpeg.
+	chaptersFile = "chapters.txt"
+	// chapterTitleFormat is the Go time format for per-day chapter titles.
+	chapterTitleFormat = "Monday, January 2, 2006"
+	// hourChapterTitleFormat is the Go time format for per-hour chapter titles.
+	hourChapterTitleFormat = "Mon, January 2, 15:04"
+)
+
+// chapter is a titled span of the output timeline, in seconds.
+type chapter struct {
+	start, end float64
+	title      string
+}
+
+// dayChapters returns one chapter per calendar day of footage, positioned on the output timeline.
+// durations holds the length of each segment; speed and ranges describe the retiming applied by the encode.
+func dayChapters(segments []segment, durations []time.Duration, speed float64, ranges []speedRange) []chapter {
+	var chapters []chapter
+	begin := func(src float64, day time.Time) {
+		at := outputOffset(src, speed, ranges)
+		if n := len(chapters); n > 0 {
+			chapters[n-1].end = at
+		}
+		chapters = append(chapters, chapter{start: at, title: day.Format(chapterTitleFormat)})
+	}
+
+	var pos float64
+	var lastDay time.Time
+	for i, seg := range segments {
+		dur := durations[i].Seconds()
+		if day := startOfDay(seg.start); !day.Equal(lastDay) {
+			begin(pos, day)
+			lastDay = day
+		}
+		// Midnights crossed within the segment
+		for day := startOfDay(seg.start).AddDate(0, 0, 1); day.Sub(seg.start).Seconds() < dur; day = day.AddDate(0, 0, 1) {
+			begin(pos+day.Sub(seg.start).Seconds(), day)
+			lastDay = day
+		}
+		pos += dur
+	}
+	if n := len(chapters); n > 0 {
+		chapters[n-1].end = outputOffset(pos, speed, ranges)
+	}
+	return chapters
+}
+
+// writeChaptersFile writes chapters in ffmpeg's ffmetadata format, for use with -map_chapters.
+func writeChaptersFile(path string, chapters []chapter) error {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for _, c := range chapters {
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(c.start*1000), int64(c.end*1000), escapeMetadata(c.title))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// escapeMetadata escapes the characters that are special in ffmetadata values.
+func escapeMetadata(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace(s)
+}
+
+// hourChapters returns one chapter per hour of footage, positioned on the output timeline. spans are
+// the wall-clock periods of the footage, played one after another; speed and ranges describe the
+// retiming applied by the encode.
+func hourChapters(spans []timeSpan, speed float64, ranges []speedRange) []chapter {
+	var chapters []chapter
+	var pos float64
+	var lastHour time.Time
+	for _, span := range spans {
+		for hour := startOfHour(span.start); hour.Before(span.end); hour = hour.Add(time.Hour) {
+			// Overlapping spans revisit hours that already have a chapter
+			if !hour.After(lastHour) {
+				continue
+			}
+			from := hour
+			if from.Before(span.start) {
+				from = span.start
+			}
+			at := outputOffset(pos+from.Sub(span.start).Seconds(), speed, ranges)
+			if n := len(chapters); n > 0 {
+				chapters[n-1].end = at
+			}
+			chapters = append(chapters, chapter{start: at, title: hour.Format(hourChapterTitleFormat)})
+			lastHour = hour
+		}
+		pos += span.end.Sub(span.start).Seconds()
+	}
+	if n := len(chapters); n > 0 {
+		chapters[n-1].end = outputOffset(pos, speed, ranges)
+	}
+	return chapters
+}
+
+// startOfHour returns the start of the hour containing t.
+func startOfHour(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// API endpoints of the chat services.
+const (
+	discordAPI  = "https://discord.com/api/v10"
+	slackAPI    = "https://slack.com/api"
+	telegramAPI = "https://api.telegram.org"
+)
+
+// notificationsConfig holds the services to notify, from the "notifications" section of the config file.
+type notificationsConfig struct {
+	Discord  *discordConfig  `json:"discord"`
+	Slack    *slackConfig    `json:"slack"`
+	Telegram *telegramConfig `json:"telegram"`
+	Email    *emailConfig    `json:"email"`
+}
+
+// discordConfig posts either through a channel webhook or as a bot.
+type discordConfig struct {
+	WebhookURL string `json:"webhook_url"`
+	BotToken   string `json:"bot_token"`
+	ChannelID  string `json:"channel_id"`
+}
+
+// slackConfig posts as a Slack app with the chat:write and files:write scopes.
+type slackConfig struct {
+	BotToken  string `json:"bot_token"`
+	ChannelID string `json:"channel_id"`
+}
+
+// telegramConfig posts as a Telegram bot.
+type telegramConfig struct {
+	BotToken string `json:"bot_token"`
+	ChatID   string `json:"chat_id"`
+}
+
+// any reports whether at least one service is configured.
+func (n notificationsConfig) any() bool {
+	return n.Discord != nil || n.Slack != nil || n.Telegram != nil || n.Email != nil
+}
+
+// validate checks that every configured service has the settings it needs.
+func (n notificationsConfig) validate() error {
+	if d := n.Discord; d != nil && d.WebhookURL == "" && (d.BotToken == "" || d.ChannelID == "") {
+		return errors.New("discord needs webhook_url, or bot_token and channel_id")
+	}
+	if s := n.Slack; s != nil && (s.BotToken == "" || s.ChannelID == "") {
+		return errors.New("slack needs bot_token and channel_id")
+	}
+	if t := n.Telegram; t != nil && (t.BotToken == "" || t.ChatID == "") {
+		return errors.New("telegram needs bot_token and chat_id")
+	}
+	if e := n.Email; e != nil {
+		return e.validate()
+	}
+	return nil
+}
+
+// chatMessage returns the text posted to chat services about a result.
+func chatMessage(result mergeResult) string {
+	took := time.Duration(result.Duration * float64(time.Second)).Round(time.Second)
+	if result.Status != "success" {
+		return fmt.Sprintf("Timelapse for %s failed after %s: %s", result.Camera, took, result.Error)
+	}
+	return fmt.Sprintf("Timelapse for %s is ready: %s (took %s)", result.Camera, filepath.Base(result.Output), took)
+}
+
+// sendNotifications posts the result to every configured service, attaching the thumbnail
+// image if one is given. All services are tried; the errors of those that failed are joined.
+func sendNotifications(n notificationsConfig, result mergeResult, thumbnail string) error {
+	text := chatMessage(result)
+	var errs []error
+	if n.Discord != nil {
+		if err := sendDiscord(*n.Discord, text, thumbnail); err != nil {
+			errs = append(errs, fmt.Errorf("discord: %w", err))
+		}
+	}
+	if n.Slack != nil {
+		if err := sendSlack(*n.Slack, text, thumbnail); err != nil {
+			errs = append(errs, fmt.Errorf("slack: %w", err))
+		}
+	}
+	if n.Telegram != nil {
+		if err := sendTelegram(*n.Telegram, text, thumbnail); err != nil {
+			errs = append(errs, fmt.Errorf("telegram: %w", err))
+		}
+	}
+	if n.Email != nil {
+		if err := sendEmail(*n.Email, result, thumbnail); err != nil {
+			errs = append(errs, fmt.Errorf("email: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sendDiscord posts the message, with the thumbnail attached, to a Discord channel.
+func sendDiscord(c discordConfig, text, thumbnail string) error {
+	target, header := c.WebhookURL, http.Header{}
+	if target == "" {
+		target = discordAPI + "/channels/" + url.PathEscape(c.ChannelID) + "/messages"
+		header.Set("Authorization", "Bot "+c.BotToken)
+	}
+	payload, err := json.Marshal(map[string]string{"content": text})
+	if err != nil {
+		return err
+	}
+	fields := map[string]string{"payload_json": string(payload)}
+	return postMultipart(target, header, fields, "files[0]", thumbnail)
+}
+
+// sendSlack posts the message to a Slack channel, uploading the thumbnail with it if there is one.
+func sendSlack(c slackConfig, text, thumbnail string) error {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+c.BotToken)
+	if thumbnail == "" {
+		return postSlack("chat.postMessage", header, map[string]interface{}{"channel": c.ChannelID, "text": text})
+	}
+
+	// Files are uploaded in three steps: reserve an upload URL, send the bytes, then share the file
+	data, err := os.ReadFile(thumbnail)
+	if err != nil {
+		return err
+	}
+	form := url.Values{"filename": {filepath.Base(thumbnail)}, "length": {fmt.Sprint(len(data))}}
+	var upload struct {
+		UploadURL string `json:"upload_url"`
+		FileID    string `json:"file_id"`
+	}
+	if err := callSlack("files.getUploadURLExternal?"+form.Encode(), header, nil, &upload); err != nil {
+		return err
+	}
+	if err := postMultipart(upload.UploadURL, http.Header{}, nil, "file", thumbnail); err != nil {
+		return err
+	}
+	return postSlack("files.completeUploadExternal", header, map[string]interface{}{
+		"files":           []map[string]string{{"id": upload.FileID, "title": "Thumbnail"}},
+		"channel_id":      c.ChannelID,
+		"initial_comment": text,
+	})
+}
+
+// postSlack calls a Slack Web API method with a JSON body.
+func postSlack(method string, header http.Header, body map[string]interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return callSlack(method, header, data, nil)
+}
+
+// callSlack calls a Slack Web API method, POSTing body as JSON if it is not nil, and decodes the
+// response into result. Slack reports errors in the response body rather than the status code.
+func callSlack(method string, header http.Header, body []byte, result interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, slackAPI+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header.Clone()
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	data, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	if !status.OK {
+		return fmt.Errorf("%s: %s", method, status.Error)
+	}
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+	return nil
+}
+
+// sendTelegram posts the message to a Telegram chat, as the caption of the thumbnail if there is one.
+func sendTelegram(c telegramConfig, text, thumbnail string) error {
+	base := telegramAPI + "/bot" + c.BotToken
+	if thumbnail == "" {
+		return postMultipart(base+"/sendMessage", http.Header{}, map[string]string{"chat_id": c.ChatID, "text": text}, "", "")
+	}
+	return postMultipart(base+"/sendPhoto", http.Header{}, map[string]string{"chat_id": c.ChatID, "caption": text}, "photo", thumbnail)
+}
+
+// postMultipart POSTs a multipart form with the given fields and, if file is not empty, the file under fileField.
+func postMultipart(target string, header http.Header, fields map[string]string, fileField, file string) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := w.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		part, err := w.CreateFormFile(fileField, filepath.Base(file))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, f); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, &body)
+	if err != nil {
+		return err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	_, err = doRequest(req)
+	return err
+}
+
+// doRequest sends req and returns the response body, treating any non-2xx response as an error.
+// The URL is left out of errors since chat APIs carry tokens in it.
+func doRequest(req *http.Request) ([]byte, error) {
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, lastLine(string(data)))
+	}
+	return data, nil
+}
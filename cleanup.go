@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Values of -cleanup.
+const (
+	cleanupKeep   = "keep"
+	cleanupMove   = "move"
+	cleanupDelete = "delete"
+)
+
+// defaultArchiveDir is where -cleanup move puts the source clips.
+const defaultArchiveDir = "archive"
+
+// cleanupSources moves or deletes the files the merged footage came from, as -cleanup says, after merge
+// has verified the output. Files recorded within the last -keep-days days are left for later runs.
+// Problems are reported as warnings, as the output itself is fine.
+func cleanupSources(opts options, info *mergeInfo) {
+	// A clip split into several segments is cleaned up once, by the time its recording started
+	started := make(map[string]time.Time)
+	var files []string
+	for _, seg := range info.sources {
+		if t, ok := started[seg.path]; !ok || seg.start.Before(t) {
+			if !ok {
+				files = append(files, seg.path)
+			}
+			started[seg.path] = seg.start
+		}
+	}
+	cutoff := time.Now().AddDate(0, 0, -opts.keepDays)
+
+	var done, kept int
+	for _, file := range files {
+		if opts.keepDays > 0 && !started[file].Before(cutoff) {
+			kept++
+			continue
+		}
+		var err error
+		if opts.cleanup == cleanupMove {
+			err = archiveFile(file, opts.archiveDir)
+		} else {
+			err = os.Remove(file)
+		}
+		if err != nil {
+			fmt.Fprintf(opts.stderr, "Warning: cleaning up %s: %v\n", file, err)
+			continue
+		}
+		done++
+	}
+
+	verb := "Deleted"
+	if opts.cleanup == cleanupMove {
+		verb = "Moved"
+	}
+	msg := fmt.Sprintf("%s %d source file(s)", verb, done)
+	if opts.cleanup == cleanupMove {
+		msg += " to " + opts.archiveDir
+	}
+	if kept > 0 {
+		msg += fmt.Sprintf("; kept %d recorded within the last %d day(s)", kept, opts.keepDays)
+	}
+	fmt.Fprintln(opts.stdout, msg)
+}
+
+// archiveFile moves file into dir, copying it when dir is on another volume. An archived file of the
+// same name is never overwritten.
+func archiveFile(file, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	dest := filepath.Join(dir, filepath.Base(file))
+	if fileExists(dest) {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	if err := os.Rename(file, dest); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dest)
+		return err
+	}
+	in.Close()
+	return os.Remove(file)
+}
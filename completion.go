@@ -0,0 +1,364 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// completeCommand is the hidden subcommand the completion scripts call to complete a word: its arguments
+// are the position of the word among the words after the program name, and those words up to the cursor.
+// It prints the completions one per line; when it prints none, the shell completes file names.
+const completeCommand = "__complete"
+
+// hiddenSubcommands are the subcommands run by the program itself, which are not offered.
+var hiddenSubcommands = map[string]bool{completeCommand: true, "run-service": true}
+
+// completionScripts maps the shells completion supports to their scripts, in which {name} is the name
+// of the program and {func} that name made fit for a function name.
+var completionScripts = map[string]string{
+	"bash": `_{func}() {
+    local line
+    COMPREPLY=()
+    while IFS= read -r line; do
+        COMPREPLY+=("$(printf '%q' "$line")")
+    done < <("${COMP_WORDS[0]}" ` + completeCommand + ` "$((COMP_CWORD - 1))" "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null)
+}
+complete -o default -F _{func} {name} {name}.exe
+`,
+	"zsh": `#compdef {name} {name}.exe
+_{func}() {
+    local -a completions
+    completions=(${(f)"$("${words[1]}" ` + completeCommand + ` $((CURRENT - 2)) "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+    if (( ${#completions} )); then
+        compadd -a completions
+    else
+        _files
+    fi
+}
+compdef _{func} {name} {name}.exe
+`,
+	"fish": `function __{func}_complete
+    set -l tokens (commandline -opc)
+    set -l completions (command $tokens[1] ` + completeCommand + ` (math (count $tokens) - 1) $tokens[2..-1] (commandline -ct) 2>/dev/null)
+    if set -q completions[1]
+        printf '%s\n' $completions
+    else
+        __fish_complete_path (commandline -ct)
+    end
+end
+complete -c {name} -f -a '(__{func}_complete)'
+`,
+	// Windows PowerShell drops empty arguments of native commands, so the word is passed last, where
+	// leaving it out still completes an empty one
+	"powershell": `Register-ArgumentCompleter -Native -CommandName '{name}', '{name}.exe' -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements | Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })
+    $previous = @($words | Select-Object -Skip 1)
+    & $words[0] ` + completeCommand + ` $previous.Count @previous $wordToComplete 2>$null | ForEach-Object {
+        $text = if ($_ -match '\s') { "'" + $_ + "'" } else { $_ }
+        [System.Management.Automation.CompletionResult]::new($text, $_, 'ParameterValue', $_)
+    }
+}
+`,
+}
+
+// flagChoices lists the values of the flags that take one of a few, for completing them.
+var flagChoices = map[string][]string{
+	"bit-depth":          {bitDepth8, bitDepth10, bitDepthAuto},
+	"cleanup":            {cleanupKeep, cleanupMove, cleanupDelete},
+	"date-order":         {dateOrderAuto, dateOrderMDY, dateOrderDMY},
+	"export":             {exportEDL, exportFCPXML},
+	"format":             {formatMP4, formatHLS, formatDASH, formatGIF, formatWebP, formatFrames},
+	"frame-format":       {frameFormatJPEG, frameFormatPNG},
+	"gap-fill":           {gapFillFreeze, gapFillBlack, gapFillSlate},
+	"hyperlapse-frame":   {hyperlapseFirst, hyperlapseSharpest},
+	"inset-position":     {"top-left", "top-right", "bottom-left", "bottom-right"},
+	"layout":             {layoutGrid, layoutHStack, layoutVStack, layoutPiP},
+	"mode":               {modeTimelapse, modeHyperlapse, modeHighlights},
+	"output":             {summaryText, summaryJSON},
+	"preview":            {formatGIF, formatWebP},
+	"priority":           {priorityNormal, priorityLow, priorityIdle},
+	"source":             sortedNames(clipNamings),
+	"versioning":         {versioningOff, versioningNumber, versioningTimestamp},
+	"watermark-position": sortedNames(watermarkPositions),
+}
+
+// cameraFlags are the flags whose value is a camera name, completed from the clips in the input directories.
+var cameraFlags = map[string]bool{"camera": true, "with": true}
+
+// cameraLookupFlags are the flags that tell where the clips are, used to find the cameras to complete.
+var cameraLookupFlags = map[string]bool{"config": true, "config-from-env": true, "input": true, "site": true, "source": true}
+
+// runCompletion implements the completion subcommand: it prints the completion script of a shell.
+func runCompletion(args []string) error {
+	fset := flagSetFor("completion")
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s completion <bash|zsh|fish|powershell>\n", os.Args[0])
+	}
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+	script, ok := completionScripts[fset.Arg(0)]
+	if fset.NArg() != 1 || !ok {
+		return withExitCode(exitUsage, fmt.Errorf("completion needs the shell: %s", strings.Join(sortedNames(completionScripts), ", ")))
+	}
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	function := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+	fmt.Print(strings.NewReplacer("{name}", name, "{func}", function).Replace(script))
+	return nil
+}
+
+// runComplete implements the hidden __complete subcommand called by the completion scripts.
+func runComplete(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	position, err := strconv.Atoi(args[0])
+	words := args[1:]
+	if err != nil || position < 0 || position > len(words) {
+		return nil
+	}
+	current := ""
+	if position < len(words) {
+		current = words[position]
+	}
+	for _, c := range complete(words[:position], current) {
+		fmt.Println(c)
+	}
+	return nil
+}
+
+// complete returns the completions of current, the word at the cursor, after the words before it.
+func complete(words []string, current string) []string {
+	// The words are as typed, so quotes and escapes are dropped to match them
+	current = strings.NewReplacer(`\`, "", `"`, "", `'`, "").Replace(current)
+	if len(words) == 0 && !strings.HasPrefix(current, "-") {
+		var names []string
+		for name := range subcommands {
+			if !hiddenSubcommands[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return withPrefix(current, names)
+	}
+
+	command := ""
+	if len(words) > 0 && subcommands[words[0]] != nil && !hiddenSubcommands[words[0]] {
+		command, words = words[0], words[1:]
+	}
+	for {
+		fset := commandFlags(command)
+		if fset == nil {
+			return nil
+		}
+		given, rest, pending := scanFlags(fset, words)
+		if pending != nil {
+			return completeValue(pending.Name, current, given)
+		}
+		if command == "install" {
+			// install takes a daemon command with its own flags
+			if len(rest) > 0 && daemonCommands[rest[0]] {
+				command, words = rest[0], rest[1:]
+				continue
+			}
+			if len(rest) == 0 && !strings.HasPrefix(current, "-") {
+				return withPrefix(current, sortedNames(daemonCommands))
+			}
+		}
+		if len(rest) > 0 || !strings.HasPrefix(current, "-") {
+			return nil
+		}
+
+		dashes := "-"
+		if strings.HasPrefix(current, "--") {
+			dashes = "--"
+		}
+		if name, value, ok := strings.Cut(strings.TrimLeft(current, "-"), "="); ok {
+			var completions []string
+			for _, v := range completeValue(name, value, given) {
+				completions = append(completions, dashes+name+"="+v)
+			}
+			return completions
+		}
+		var names []string
+		fset.VisitAll(func(f *flag.Flag) { names = append(names, dashes+f.Name) })
+		return withPrefix(current, names)
+	}
+}
+
+// commandFlags returns the flag set of a subcommand, or of a merge for "", or nil for an unknown one.
+func commandFlags(command string) *flag.FlagSet {
+	if command == "" {
+		var opts options
+		fset := flag.NewFlagSet(completeCommand, flag.ContinueOnError)
+		defineMergeFlags(fset, &opts)
+		return fset
+	}
+	run, ok := subcommands[command]
+	if !ok {
+		return nil
+	}
+	var fset *flag.FlagSet
+	inspectFlags = func(f *flag.FlagSet) { fset = f }
+	defer func() { inspectFlags = nil }()
+	if err := run(nil); !errors.Is(err, errInspectingFlags) {
+		return nil
+	}
+	return fset
+}
+
+// completeValue returns the completions of value as the value of the flag name, given the flags before it.
+func completeValue(name, value string, given []flagArg) []string {
+	if cameraFlags[name] {
+		return withPrefix(value, cameraNames(given))
+	}
+	return withPrefix(value, flagChoices[name])
+}
+
+// cameraNames returns the names of the cameras with clips in the input directories of the given flags,
+// or of the config file, by their aliases if they have one.
+func cameraNames(given []flagArg) []string {
+	var opts options
+	fset := flag.NewFlagSet(completeCommand, flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+	configFile, configFromEnv, _ := defineMergeFlags(fset, &opts)
+	for _, g := range given {
+		if cameraLookupFlags[g.name] {
+			fset.Set(g.name, g.value)
+		}
+	}
+	var aliases map[string]string
+	if cfg, err := loadConfigFlag(*configFile, *configFromEnv); err == nil {
+		applyConfig(fset, cfg.flags)
+		aliases = cfg.aliases
+	}
+	names, _ := cameraNamesIn(opts)
+	for i, name := range names {
+		names[i] = cameraAlias(aliases, name)
+	}
+	return names
+}
+
+// flagArg is a flag on a command line and its value.
+type flagArg struct {
+	name, value string
+	// f is nil for a flag the command does not define.
+	f *flag.Flag
+}
+
+// scanFlags walks args like fset.Parse does, without setting anything. It returns the flags, the
+// arguments after them and, when args end with a flag missing its value, that flag.
+func scanFlags(fset *flag.FlagSet, args []string) (flags []flagArg, rest []string, pending *flag.Flag) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return flags, args[i+1:], nil
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			return flags, args[i:], nil
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg[1:], "-"), "=")
+		f := fset.Lookup(name)
+		if f != nil && !hasValue && !isBoolFlag(f) {
+			if i+1 == len(args) {
+				return flags, nil, f
+			}
+			i++
+			value, hasValue = args[i], true
+		}
+		if !hasValue {
+			value = "true"
+		}
+		flags = append(flags, flagArg{name: name, value: value, f: f})
+	}
+	return flags, nil, nil
+}
+
+// isBoolFlag reports whether f takes no value, like -force.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// suggestFlag returns an error for the first flag in args that fset does not define when it is close to
+// one that it does, e.g. -sped for -speed. Other mistakes are left to fset.Parse to report.
+func suggestFlag(fset *flag.FlagSet, args []string) error {
+	flags, _, _ := scanFlags(fset, args)
+	for _, f := range flags {
+		if f.f != nil || f.name == "h" || f.name == "help" {
+			continue
+		}
+		var names []string
+		fset.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+		if s := closestName(f.name, names); s != "" {
+			return fmt.Errorf("flag provided but not defined: -%s (did you mean -%s?)", f.name, s)
+		}
+		return nil
+	}
+	return nil
+}
+
+// closestName returns the one of names nearest to name, if it is close enough to be a typo of it.
+func closestName(name string, names []string) string {
+	best, bestDistance := "", 3
+	for _, n := range names {
+		if d := editDistance(name, n); d < bestDistance && d < len(name) {
+			best, bestDistance = n, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the number of letters to insert, delete or change to turn a into b.
+func editDistance(a, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			diagonal, row[j] = row[j], min(row[j]+1, row[j-1]+1, diagonal+cost)
+		}
+	}
+	return row[len(b)]
+}
+
+// withPrefix returns the candidates starting with prefix.
+func withPrefix(prefix string, candidates []string) []string {
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			matches = append(matches, c)
+		}
+	}
+	return matches
+}
+
+// sortedNames returns the keys of m in order.
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+)
+
+// concatInput is the list of segments read by ffmpeg's concat demuxer: the inputs file in the work
+// directory, or with -concat-stdin the list itself, piped to ffmpeg's standard input.
+type concatInput struct {
+	file string
+	list []byte
+}
+
+// newConcatInput lists segments for the concat demuxer, in the inputs file in workDir unless opts.concatStdin is set.
+func newConcatInput(opts options, segments []segment, workDir string) (concatInput, error) {
+	if opts.concatStdin {
+		var b bytes.Buffer
+		if err := writeInputsList(&b, segments); err != nil {
+			return concatInput{}, err
+		}
+		return concatInput{list: b.Bytes()}, nil
+	}
+	file := filepath.Join(workDir, inputsFile)
+	return concatInput{file: file}, createInputsFile(segments, file)
+}
+
+// args returns the ffmpeg arguments reading the segments.
+func (in concatInput) args() []string {
+	if in.list != nil {
+		// The files listed are opened through the file protocol, which lists read from a pipe do not allow by default
+		return []string{"-f", "concat", "-safe", "0", "-protocol_whitelist", "file,pipe", "-i", "pipe:0"}
+	}
+	return []string{"-f", "concat", "-safe", "0", "-i", in.file}
+}
+
+// attach pipes the list to cmd's standard input when it is not read from the inputs file.
+func (in concatInput) attach(cmd *exec.Cmd) {
+	if in.list != nil {
+		cmd.Stdin = bytes.NewReader(in.list)
+	}
+}
+
+// String describes where the list is, for the log.
+func (in concatInput) String() string {
+	if in.list != nil {
+		return "the list piped to ffmpeg"
+	}
+	return in.file
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultConfigFile is loaded from the working directory when present and -config is not given.
+const defaultConfigFile = "timelapse.json"
+
+// envPrefix starts the names of the environment variables setting flags, e.g. TIMELAPSE_SPEED for -speed.
+const envPrefix = "TIMELAPSE_"
+
+// envConfigJSON holds the whole config, as in the config file, with -config-from-env.
+const envConfigJSON = envPrefix + "CONFIG_JSON"
+
+// configFromEnvUsage describes -config-from-env, which every command reading the config file has.
+const configFromEnvUsage = "Read the JSON config from the " + envConfigJSON + " environment variable instead of a file"
+
+// Config file sections holding settings other than flag values.
+const (
+	// camerasKey holds per-camera settings.
+	camerasKey = "cameras"
+	// notificationsKey holds the services notified when a merge finishes.
+	notificationsKey = "notifications"
+	// uploadKey holds the object storage targets outputs are uploaded to.
+	uploadKey = "upload"
+	// mqttKey holds the MQTT broker serve mode connects to.
+	mqttKey = "mqtt"
+	// protectKey holds the Protect controllers footage is downloaded from.
+	protectKey = "protect"
+	// aliasesKey maps friendly camera names to the names in the clip filenames.
+	aliasesKey = "aliases"
+)
+
+// config holds the settings loaded from the config file.
+type config struct {
+	// path is the file the config was loaded from.
+	path string
+	// flags maps flag names to their JSON values.
+	flags map[string]json.RawMessage
+	// cameras maps camera names to camera-specific settings.
+	cameras map[string]cameraConfig
+	// notifications lists the services to notify.
+	notifications notificationsConfig
+	// upload lists where outputs are uploaded.
+	upload uploadConfig
+	// mqtt is the broker serve mode publishes to, or nil for none.
+	mqtt *mqttConfig
+	// protect lists the controllers to download from.
+	protect protectSites
+	// aliases maps camera aliases to the camera names in the clip filenames.
+	aliases map[string]string
+}
+
+// cameraConfig holds the settings for one camera in the "cameras" section of the config file.
+type cameraConfig struct {
+	PrivacyMasks []maskConfig `json:"privacy_masks"`
+}
+
+// loadConfig reads a JSON config file whose top-level keys are flag names, e.g. {"lat": 52.2, "speed": 60},
+// plus optional "cameras" (keyed by camera name), "aliases", "notifications", "upload", "mqtt" and "protect" objects. A missing file is not an error when optional is true.
+func loadConfig(path string, optional bool) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if optional && errors.Is(err, fs.ErrNotExist) {
+			return &config{path: path}, nil
+		}
+		return nil, err
+	}
+	return parseConfig(path, data)
+}
+
+// parseConfig parses a config loaded from path, which names it in errors.
+func parseConfig(path string, data []byte) (*config, error) {
+	cfg := &config{path: path}
+	if err := json.Unmarshal(data, &cfg.flags); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if raw, ok := cfg.flags[camerasKey]; ok {
+		delete(cfg.flags, camerasKey)
+		if err := json.Unmarshal(raw, &cfg.cameras); err != nil {
+			return nil, fmt.Errorf("parsing %s: %q section: %w", path, camerasKey, err)
+		}
+	}
+	if raw, ok := cfg.flags[aliasesKey]; ok {
+		delete(cfg.flags, aliasesKey)
+		if err := json.Unmarshal(raw, &cfg.aliases); err != nil {
+			return nil, fmt.Errorf("parsing %s: %q section: %w", path, aliasesKey, err)
+		}
+		for alias, camera := range cfg.aliases {
+			if strings.TrimSpace(alias) == "" || strings.TrimSpace(camera) == "" {
+				return nil, fmt.Errorf("%s: %q section: aliases and camera names must not be empty", path, aliasesKey)
+			}
+		}
+	}
+	if raw, ok := cfg.flags[notificationsKey]; ok {
+		delete(cfg.flags, notificationsKey)
+		if err := json.Unmarshal(raw, &cfg.notifications); err != nil {
+			return nil, fmt.Errorf("parsing %s: %q section: %w", path, notificationsKey, err)
+		}
+		if err := cfg.notifications.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %q section: %w", path, notificationsKey, err)
+		}
+	}
+	if raw, ok := cfg.flags[uploadKey]; ok {
+		delete(cfg.flags, uploadKey)
+		if err := json.Unmarshal(raw, &cfg.upload); err != nil {
+			return nil, fmt.Errorf("parsing %s: %q section: %w", path, uploadKey, err)
+		}
+		if err := cfg.upload.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %q section: %w", path, uploadKey, err)
+		}
+	}
+	if raw, ok := cfg.flags[mqttKey]; ok {
+		delete(cfg.flags, mqttKey)
+		if err := json.Unmarshal(raw, &cfg.mqtt); err != nil {
+			return nil, fmt.Errorf("parsing %s: %q section: %w", path, mqttKey, err)
+		}
+		if cfg.mqtt != nil {
+			if err := cfg.mqtt.validate(); err != nil {
+				return nil, fmt.Errorf("%s: %q section: %w", path, mqttKey, err)
+			}
+		}
+	}
+	if raw, ok := cfg.flags[protectKey]; ok {
+		delete(cfg.flags, protectKey)
+		if err := json.Unmarshal(raw, &cfg.protect); err != nil {
+			return nil, fmt.Errorf("parsing %s: %q section: %w", path, protectKey, err)
+		}
+		if err := cfg.protect.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %q section: %w", path, protectKey, err)
+		}
+	}
+	return cfg, nil
+}
+
+// cameraSettings returns the "cameras" section of camera, which is keyed by either its alias or its name
+// in the clip filenames.
+func (cfg *config) cameraSettings(camera string) cameraConfig {
+	if settings, ok := cfg.cameras[camera]; ok {
+		return settings
+	}
+	return cfg.cameras[clipCamera(cfg.aliases, camera)]
+}
+
+// loadConfigFlag loads the config file named by -config, or the default config file if present when it is empty.
+// With fromEnv (-config-from-env), the config is read from the envConfigJSON environment variable instead.
+func loadConfigFlag(configFile string, fromEnv bool) (*config, error) {
+	if fromEnv {
+		if configFile != "" {
+			return nil, fmt.Errorf("-config and -config-from-env cannot be combined")
+		}
+		data := os.Getenv(envConfigJSON)
+		if strings.TrimSpace(data) == "" {
+			return nil, fmt.Errorf("-config-from-env needs the config in the %s environment variable", envConfigJSON)
+		}
+		cfg, err := parseConfig("$"+envConfigJSON, []byte(data))
+		if err != nil {
+			return nil, fmt.Errorf("loading config: %w", err)
+		}
+		return cfg, nil
+	}
+	path, optional := configFile, false
+	if path == "" {
+		path, optional = defaultConfigFile, true
+	}
+	cfg, err := loadConfig(path, optional)
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	return cfg, nil
+}
+
+// applyConfig sets every flag named in values that was not given explicitly on the command line,
+// so command-line flags always take precedence over the config file.
+func applyConfig(fset *flag.FlagSet, values map[string]json.RawMessage) error {
+	explicit := make(map[string]bool)
+	fset.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// Apply in a stable order so errors are reported deterministically
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if fset.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		if explicit[name] {
+			continue
+		}
+		settings, err := configValueStrings(values[name])
+		if err != nil {
+			return fmt.Errorf("setting %q: %w", name, err)
+		}
+		for _, value := range settings {
+			if err := fset.Set(name, value); err != nil {
+				return fmt.Errorf("setting %q: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// inspectFlags, when set, receives the flag set of a command instead of its arguments being parsed, and
+// the command returns errInspectingFlags without running. Completion uses it to learn the flags of the
+// subcommands.
+var inspectFlags func(fset *flag.FlagSet)
+
+// errInspectingFlags is returned by the commands while inspectFlags is set.
+var errInspectingFlags = errors.New("inspecting flags")
+
+// parseFlags parses the command-line args into fset, then sets the flags they leave out from their
+// environment variables (see applyEnv), which the config file cannot override.
+func parseFlags(fset *flag.FlagSet, args []string) error {
+	if inspectFlags != nil {
+		inspectFlags(fset)
+		return errInspectingFlags
+	}
+	if err := suggestFlag(fset, args); err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	return withExitCode(exitUsage, applyEnv(fset))
+}
+
+// applyEnv sets every flag of fset not set yet from its environment variable, if set: TIMELAPSE_ and
+// the flag name in upper case with dashes as underscores, e.g. TIMELAPSE_MIN_LUMA for -min-luma.
+// A JSON array sets a repeatable flag once per element, like in the config file.
+func applyEnv(fset *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	fset.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var err error
+	fset.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || explicit[f.Name] || err != nil {
+			return
+		}
+		values := []string{value}
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			// A value that merely starts with a bracket is taken as it is
+			if list, jsonErr := configValueStrings(json.RawMessage(value)); jsonErr == nil {
+				values = list
+			}
+		}
+		for _, v := range values {
+			if setErr := fset.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("$%s: %w", envName(f.Name), setErr)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// envName returns the environment variable setting the named flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// configValueStrings converts a JSON value into the strings passed to flag.Set.
+// An array sets a repeatable flag once per element.
+func configValueStrings(raw json.RawMessage) ([]string, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		value, err := configValueString(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []string{value}, nil
+	}
+
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		value, err := configValueString(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// configValueString converts a JSON scalar into the string form accepted by flag.Set.
+func configValueString(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	text := strings.TrimSpace(string(raw))
+	if strings.HasPrefix(text, "{") || strings.HasPrefix(text, "[") || text == "null" {
+		return "", fmt.Errorf("expected a string, number, or boolean")
+	}
+	return text, nil
+}
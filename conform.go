@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// clipFormat is what the concat demuxer needs to be the same in every clip: it decodes them all with the
+// decoder and codec parameters of the first.
+type clipFormat struct {
+	Codec   string `json:"codec_name"`
+	Profile string `json:"profile"`
+	Level   int    `json:"level"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	PixFmt  string `json:"pix_fmt"`
+}
+
+// decodable reports whether footage in format f can be decoded as part of a stream in format g, once
+// the codec parameters travel with the footage instead of once per file.
+func (f clipFormat) decodable(g clipFormat) bool {
+	return f.Codec == g.Codec && f.Width == g.Width && f.Height == g.Height && f.PixFmt == g.PixFmt
+}
+
+// decoding returns f without the fields that may differ in footage decodable as part of the same stream,
+// for telling the clips apart by what decodable compares.
+func (f clipFormat) decoding() clipFormat {
+	return clipFormat{Codec: f.Codec, Width: f.Width, Height: f.Height, PixFmt: f.PixFmt}
+}
+
+func (f clipFormat) String() string {
+	return fmt.Sprintf("%s %s %dx%d %s", f.Codec, f.Profile, f.Width, f.Height, f.PixFmt)
+}
+
+// mezzanineEncoders maps the codecs clips can be re-encoded to, to keep the concatenated stream decodable,
+// to the encoder and rate control used; the quality is high, as the footage is encoded again afterwards.
+var mezzanineEncoders = map[string][]string{
+	"h264": {"-c:v", "libx264", "-preset", "veryfast", "-crf", "16"},
+	"hevc": {"-c:v", "libx265", "-preset", "veryfast", "-crf", "18"},
+}
+
+// probeClipFormat returns the format of the first video stream of path.
+func probeClipFormat(ffprobe, path string) (clipFormat, error) {
+	cmd := exec.Command(ffprobe,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,profile,level,width,height,pix_fmt",
+		"-of", "json",
+		path,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return clipFormat{}, fmt.Errorf("probing %s: %w: %s", path, err, lastLine(stderr.String()))
+	}
+	var probe struct {
+		Streams []clipFormat `json:"streams"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &probe); err != nil || len(probe.Streams) == 0 {
+		return clipFormat{}, fmt.Errorf("probing %s: no video stream", path)
+	}
+	return probe.Streams[0], nil
+}
+
+// commonFormat returns the format of the first clip at paths among the most clips decodable alike, which
+// may differ in profile and level, by the first clip on ties.
+func commonFormat(paths []string, formats map[string]clipFormat) clipFormat {
+	counts := make(map[clipFormat]int)
+	for _, p := range paths {
+		counts[formats[p].decoding()]++
+	}
+	var common clipFormat
+	var most int
+	for _, p := range paths {
+		if f := formats[p]; counts[f.decoding()] > most {
+			common, most = f, counts[f.decoding()]
+		}
+	}
+	return common
+}
+
+// conformSegments checks that the clips of segments share a format the concat demuxer can join, and
+// otherwise rewrites them into MPEG-TS files in workDir, named from prefix, which carry their codec
+// parameters with the footage: clips in the most common format are only remuxed, and the others are
+// re-encoded to it. It returns the segments reading the rewritten files, or segments itself when every
+// clip matches.
+func conformSegments(opts options, segments []segment, workDir, prefix string) ([]segment, error) {
+	ffprobe := ffprobePath(opts.ffmpegPath)
+	formats := make(map[string]clipFormat)
+	// Clips differing only in profile or level are remuxed all the same, so every difference counts here
+	distinct := make(map[clipFormat]bool)
+	var paths []string
+	for _, seg := range segments {
+		if _, ok := formats[seg.path]; ok {
+			continue
+		}
+		f, err := probeClipFormat(ffprobe, seg.path)
+		if err != nil {
+			return nil, err
+		}
+		formats[seg.path] = f
+		distinct[f] = true
+		paths = append(paths, seg.path)
+	}
+	if len(distinct) < 2 {
+		return segments, nil
+	}
+
+	common := commonFormat(paths, formats)
+	mezzanine, ok := mezzanineEncoders[common.Codec]
+	fmt.Fprintf(opts.stdout, "Clips come in %d formats; conforming them to %s for concatenation...\n", len(distinct), common)
+
+	rewritten := make(map[string]string, len(paths))
+	for i, p := range paths {
+		opts.report("conforming clips", float64(i)/float64(len(paths)))
+		ts := filepath.Join(workDir, fmt.Sprintf("%s-%06d.ts", prefix, i+1))
+		args := []string{"-hide_banner", "-loglevel", "error", "-i", p, "-map", "0:v:0", "-an"}
+		if f := formats[p]; f.decodable(common) {
+			args = append(args, "-c:v", "copy")
+		} else {
+			if !ok {
+				return nil, fmt.Errorf("%s is %s, unlike the other clips in %s, and cannot be re-encoded to match; exclude it", p, f, common)
+			}
+			fmt.Fprintf(opts.stdout, "Re-encoding %s (%s)\n", filepath.Base(p), f)
+			args = append(args, mezzanine...)
+			args = append(args, "-vf", fmt.Sprintf("scale=%d:%d", common.Width, common.Height), "-pix_fmt", common.PixFmt)
+		}
+		// Start at zero, so that inpoints and outpoints keep their meaning
+		args = append(args, "-muxdelay", "0", "-muxpreload", "0", "-avoid_negative_ts", "make_zero", "-f", "mpegts", "-y", ts)
+		cmd := ffmpegCommand(opts, args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("conforming %s: %w: %s", p, err, lastLine(strings.TrimSpace(stderr.String())))
+		}
+		rewritten[p] = ts
+	}
+
+	conformed := make([]segment, len(segments))
+	for i, seg := range segments {
+		seg.path = rewritten[seg.path]
+		conformed[i] = seg
+	}
+	return conformed, nil
+}
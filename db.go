@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// dbTimeout is how long a database command waits for another process's write to finish.
+	dbTimeout = 10 * time.Second
+	// checksumChunk is how much of each end of a file quickChecksum reads.
+	checksumChunk = 1 << 20
+)
+
+// Clip states recorded in the database.
+const (
+	clipNew    = "new"
+	clipMerged = "merged"
+)
+
+// dbSchema creates the tables of the database.
+const dbSchema = `
+CREATE TABLE IF NOT EXISTS clips (
+	path TEXT PRIMARY KEY,
+	camera TEXT NOT NULL,
+	start_time TEXT NOT NULL,
+	end_time TEXT,
+	size INTEGER NOT NULL,
+	modified TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	status TEXT NOT NULL,
+	first_seen TEXT NOT NULL,
+	merged TEXT,
+	job INTEGER
+);
+CREATE INDEX IF NOT EXISTS clips_camera ON clips (camera, start_time);
+CREATE TABLE IF NOT EXISTS jobs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	camera TEXT NOT NULL,
+	status TEXT NOT NULL,
+	output TEXT,
+	error TEXT,
+	clips INTEGER,
+	started TEXT NOT NULL,
+	finished TEXT
+);
+`
+
+// clipDB is an SQLite database of the clips found, with their recording times, checksums and whether
+// they have been merged, and of the merges run. It is used through the sqlite3 command-line shell, so
+// that the program needs no database driver of its own.
+type clipDB struct {
+	path    string
+	command string
+}
+
+// clipRecord is a row of the clips table.
+type clipRecord struct {
+	Path     string `json:"path"`
+	Camera   string `json:"camera"`
+	Start    string `json:"start_time"`
+	End      string `json:"end_time"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+	Checksum string `json:"checksum"`
+	Status   string `json:"status"`
+	Merged   string `json:"merged"`
+}
+
+// jobRecord is a row of the jobs table.
+type jobRecord struct {
+	ID       int64  `json:"id"`
+	Camera   string `json:"camera"`
+	Status   string `json:"status"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Clips    int    `json:"clips"`
+	Started  string `json:"started"`
+	Finished string `json:"finished,omitempty"`
+}
+
+// openClipDB opens the database at path with the sqlite3 shell command, creating its tables if needed.
+func openClipDB(path, command string) (*clipDB, error) {
+	db := &clipDB{path: path, command: firstNonEmpty(command, "sqlite3")}
+	if err := db.exec(dbSchema); err != nil {
+		return nil, fmt.Errorf("opening database %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// run runs an SQL script and returns what its queries print, as JSON arrays of rows.
+func (db *clipDB) run(script string) ([]byte, error) {
+	cmd := exec.Command(db.command, "-bail", "-batch", "-json", db.path)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf(".timeout %d\n%s", dbTimeout.Milliseconds(), script))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", db.command, err, lastLine(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// exec runs an SQL script that returns no rows.
+func (db *clipDB) exec(script string) error {
+	_, err := db.run(script)
+	return err
+}
+
+// query runs a single query and decodes its rows into rows, a pointer to a slice of structs.
+func (db *clipDB) query(sql string, rows interface{}) error {
+	out, err := db.run(sql)
+	if err != nil {
+		return err
+	}
+	// The shell prints nothing at all for no rows
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(out, rows)
+}
+
+// sqlText quotes s as an SQL string literal.
+func sqlText(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// sqlList quotes values as a comma-separated list of SQL string literals, e.g. for IN.
+func sqlList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = sqlText(v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// sqlTime quotes t as an RFC 3339 SQL string literal, or returns NULL for the zero time.
+func sqlTime(t time.Time) string {
+	if t.IsZero() {
+		return "NULL"
+	}
+	return sqlText(t.Format(time.RFC3339))
+}
+
+// recordClips adds the clips found on disk to the database, or updates them, under their own camera
+// name or else camera. A file that changed since it was recorded, as seen by its size and modification
+// time, is checksummed again and counts as new.
+func (db *clipDB) recordClips(camera string, clips []clip) error {
+	paths := make([]string, len(clips))
+	for i, c := range clips {
+		paths[i] = c.path
+	}
+	known, err := db.clipsAt(paths)
+	if err != nil {
+		return err
+	}
+	byPath := make(map[string]clipRecord, len(known))
+	for _, r := range known {
+		byPath[r.Path] = r
+	}
+
+	now := time.Now()
+	var script strings.Builder
+	script.WriteString("BEGIN;\n")
+	for _, c := range clips {
+		info, err := os.Stat(c.path)
+		if err != nil {
+			return err
+		}
+		modified := info.ModTime().Format(time.RFC3339)
+		if r, ok := byPath[c.path]; ok && r.Size == info.Size() && r.Modified == modified {
+			continue
+		}
+		sum, err := quickChecksum(c.path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&script, "INSERT INTO clips (path, camera, start_time, end_time, size, modified, checksum, status, first_seen) "+
+			"VALUES (%s, %s, %s, %s, %d, %s, %s, %s, %s) "+
+			"ON CONFLICT (path) DO UPDATE SET start_time = excluded.start_time, end_time = excluded.end_time, size = excluded.size, "+
+			"modified = excluded.modified, checksum = excluded.checksum, status = excluded.status, merged = NULL, job = NULL;\n",
+			sqlText(c.path), sqlText(firstNonEmpty(c.camera, camera)), sqlTime(c.start), sqlTime(c.end), info.Size(),
+			sqlText(modified), sqlText(sum), sqlText(clipNew), sqlTime(now))
+	}
+	script.WriteString("COMMIT;\n")
+	return db.exec(script.String())
+}
+
+// forgetMissing removes the clips whose files no longer exist, e.g. after -cleanup, and returns how many.
+func (db *clipDB) forgetMissing() (int, error) {
+	known, err := db.cameraClips("")
+	if err != nil {
+		return 0, err
+	}
+	var missing []string
+	for _, r := range known {
+		if !fileExists(r.Path) {
+			missing = append(missing, r.Path)
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+	return len(missing), db.exec(fmt.Sprintf("DELETE FROM clips WHERE path IN (%s);", sqlList(missing)))
+}
+
+// clipColumns selects a clipRecord.
+const clipColumns = "SELECT path, camera, start_time, coalesce(end_time, '') AS end_time, size, modified, checksum, status, " +
+	"coalesce(merged, '') AS merged FROM clips"
+
+// cameraClips returns the recorded clips of camera, or of every camera when camera is empty, in
+// chronological order.
+func (db *clipDB) cameraClips(camera string) ([]clipRecord, error) {
+	where := ""
+	if camera != "" {
+		where = " WHERE camera = " + sqlText(camera)
+	}
+	var rows []clipRecord
+	err := db.query(clipColumns+where+" ORDER BY start_time, path;", &rows)
+	return rows, err
+}
+
+// clipsAt returns the recorded clips among the given files.
+func (db *clipDB) clipsAt(paths []string) ([]clipRecord, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	var rows []clipRecord
+	err := db.query(clipColumns+" WHERE path IN ("+sqlList(paths)+");", &rows)
+	return rows, err
+}
+
+// markMerged records that the given files were merged by job.
+func (db *clipDB) markMerged(job int64, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	return db.exec(fmt.Sprintf("UPDATE clips SET status = %s, merged = %s, job = %d WHERE path IN (%s);",
+		sqlText(clipMerged), sqlTime(time.Now()), job, sqlList(paths)))
+}
+
+// startJob records a merge of camera as running and returns its ID.
+func (db *clipDB) startJob(camera string, started time.Time) (int64, error) {
+	var rows []struct {
+		ID int64 `json:"id"`
+	}
+	err := db.query(fmt.Sprintf("INSERT INTO jobs (camera, status, started) VALUES (%s, %s, %s);\nSELECT last_insert_rowid() AS id;",
+		sqlText(camera), sqlText(jobRunning), sqlTime(started)), &rows)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) != 1 {
+		return 0, fmt.Errorf("no job ID returned")
+	}
+	return rows[0].ID, nil
+}
+
+// finishJob records the result of a merge.
+func (db *clipDB) finishJob(id int64, result mergeResult, clips int) error {
+	status := jobDone
+	if result.Status != "success" {
+		status = jobFailed
+	}
+	return db.exec(fmt.Sprintf("UPDATE jobs SET status = %s, output = %s, error = %s, clips = %d, finished = %s WHERE id = %d;",
+		sqlText(status), sqlText(result.Output), sqlText(result.Error), clips, sqlText(result.Finished), id))
+}
+
+// jobs returns the most recent limit merges, newest first.
+func (db *clipDB) jobs(limit int) ([]jobRecord, error) {
+	var rows []jobRecord
+	err := db.query(fmt.Sprintf("SELECT id, camera, status, coalesce(output, '') AS output, coalesce(error, '') AS error, "+
+		"coalesce(clips, 0) AS clips, started, coalesce(finished, '') AS finished FROM jobs ORDER BY id DESC LIMIT %d;", limit), &rows)
+	return rows, err
+}
+
+// asClip converts a recorded clip back to the clip it was found as.
+func (r clipRecord) asClip() clip {
+	c := clip{path: r.Path, camera: r.Camera}
+	c.start, _ = time.Parse(time.RFC3339, r.Start)
+	c.end, _ = time.Parse(time.RFC3339, r.End)
+	return c
+}
+
+// quickChecksum returns a SHA-256 checksum of a file's size and of its first and last checksumChunk
+// bytes. Reading only the ends keeps it fast for large clips, while still telling apart any two
+// recordings, which differ throughout.
+func quickChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, info.Size())
+	if _, err := io.CopyN(h, f, checksumChunk); err != nil && err != io.EOF {
+		return "", err
+	}
+	if info.Size() > 2*checksumChunk {
+		if _, err := f.Seek(-checksumChunk, io.SeekEnd); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	} else if info.Size() > checksumChunk {
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// unmergedClips returns the clips that the database has not recorded as merged, either at their path
+// or, for clips copied, renamed or downloaded again, by their checksum. The clips must be recorded.
+func (db *clipDB) unmergedClips(clips []clip) ([]clip, error) {
+	paths := make([]string, len(clips))
+	for i, c := range clips {
+		paths[i] = c.path
+	}
+	known, err := db.clipsAt(paths)
+	if err != nil {
+		return nil, err
+	}
+	checksums := make([]string, len(known))
+	for i, r := range known {
+		checksums[i] = r.Checksum
+	}
+	var twins []clipRecord
+	if len(checksums) > 0 {
+		err := db.query(clipColumns+" WHERE status = "+sqlText(clipMerged)+" AND checksum IN ("+sqlList(checksums)+");", &twins)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mergedSums := make(map[string]bool, len(twins))
+	for _, r := range twins {
+		mergedSums[r.Checksum] = true
+	}
+	merged := make(map[string]bool)
+	for _, r := range known {
+		merged[r.Path] = r.Status == clipMerged || mergedSums[r.Checksum]
+	}
+	var unmerged []clip
+	for _, c := range clips {
+		if !merged[c.path] {
+			unmerged = append(unmerged, c)
+		}
+	}
+	return unmerged, nil
+}
+
+// segmentFiles returns the files of the segments, each once, in order.
+func segmentFiles(segments []segment) []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, seg := range segments {
+		if !seen[seg.path] {
+			seen[seg.path] = true
+			files = append(files, seg.path)
+		}
+	}
+	return files
+}
+
+// startJobRecord records a merge as running in the -db database, if any, and returns the database and
+// the merge's ID there. A database that cannot be written is reported as a warning and not used.
+func startJobRecord(opts options, started time.Time) (*clipDB, int64) {
+	if opts.db == "" {
+		return nil, 0
+	}
+	db, err := openClipDB(opts.db, opts.sqlitePath)
+	var id int64
+	if err == nil {
+		id, err = db.startJob(opts.cameraName, started)
+	}
+	if err != nil {
+		fmt.Fprintf(opts.stderr, "Warning: recording the merge in %s: %v\n", opts.db, err)
+		return nil, 0
+	}
+	return db, id
+}
+
+// finishJobRecord records the result of merge id, and the files it merged.
+func finishJobRecord(db *clipDB, id int64, result mergeResult, merged []string) error {
+	if err := db.finishJob(id, result, len(merged)); err != nil {
+		return err
+	}
+	return db.markMerged(id, merged)
+}
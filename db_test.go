@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnmergedClipsSkipsRenamedClip(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not found")
+	}
+	dir := t.TempDir()
+	db, err := openClipDB(filepath.Join(dir, "clips.db"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)
+	merged := clip{path: filepath.Join(dir, "Front 1.mp4"), start: start, end: start.Add(time.Hour)}
+	other := clip{path: filepath.Join(dir, "Front 2.mp4"), start: start.Add(time.Hour), end: start.Add(2 * time.Hour)}
+	if err := os.WriteFile(merged.path, []byte("first recording"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(other.path, []byte("second recording"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.recordClips("Front", []clip{merged, other}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.markMerged(1, []string{merged.path}); err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := merged
+	renamed.path = filepath.Join(dir, "Front 1 (copy).mp4")
+	if err := os.Rename(merged.path, renamed.path); err != nil {
+		t.Fatal(err)
+	}
+	clips := []clip{renamed, other}
+	if err := db.recordClips("Front", clips); err != nil {
+		t.Fatal(err)
+	}
+	unmerged, err := db.unmergedClips(clips)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unmerged) != 1 || unmerged[0].path != other.path {
+		t.Errorf("unmerged clips = %v, want only %s", unmerged, other.path)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Values of -denoise.
+const (
+	// denoiseHQDN3D is fast and removes most of the grain of night footage.
+	denoiseHQDN3D = "hqdn3d"
+	// denoiseNLMeans removes noise more cleanly while keeping edges, but is several times slower.
+	denoiseNLMeans = "nlmeans"
+)
+
+// addDenoise removes noise from the current stream of the graph with the -denoise filter, if any,
+// scaled by strength: 1 uses hqdn3d's default strengths, or a comparable amount of nlmeans.
+func addDenoise(g *filterGraph, filter string, strength float64) {
+	switch filter {
+	case denoiseHQDN3D:
+		// Luma and chroma spatial, then luma and chroma temporal
+		g.add(fmt.Sprintf("hqdn3d=%g:%g:%g:%g", 4*strength, 3*strength, 6*strength, 4.5*strength))
+	case denoiseNLMeans:
+		g.add(fmt.Sprintf("nlmeans=s=%g", math.Min(math.Max(3*strength, 1), 30)))
+	}
+}
+
+// addSharpen sharpens the luma of the current stream of the graph by amount, if above 0.
+func addSharpen(g *filterGraph, amount float64) {
+	if amount > 0 {
+		g.add(fmt.Sprintf("unsharp=5:5:%g:5:5:0", amount))
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// outputSizeSafetyFactor pads the output size estimate, since sped-up footage changes more between
+	// frames than the source and compresses worse.
+	outputSizeSafetyFactor = 2.0
+	// stillImageRatio is roughly how much smaller an encoded video frame is than the same picture as a still image.
+	stillImageRatio = 10.0
+	// minOutputSizeEstimate is the smallest amount of free space required for any output.
+	minOutputSizeEstimate = 16 << 20
+)
+
+// estimateOutputSize estimates the size in bytes of the encoded output. It scales the size of the footage
+// actually used by the speedup and output frame rate, on the basis that the encoder settings produce a
+// bitrate comparable to the camera's own, then pads the result by outputSizeSafetyFactor.
+func estimateOutputSize(segments []segment, clips []clip, opts options) int64 {
+	clipLengths := make(map[string]time.Duration, len(clips))
+	for _, c := range clips {
+		if !c.end.IsZero() {
+			clipLengths[c.path] = c.end.Sub(c.start)
+		}
+	}
+
+	var used float64
+	for _, seg := range segments {
+		info, err := os.Stat(seg.path)
+		if err != nil {
+			continue
+		}
+		// Count only the trimmed part of the file when the lengths are known
+		fraction := 1.0
+		if total := clipLengths[seg.path]; total > 0 && !seg.end.IsZero() {
+			fraction = seg.end.Sub(seg.start).Seconds() / total.Seconds()
+		}
+		used += float64(info.Size()) * fraction
+	}
+
+	// Output frames per second of source time, relative to a typical 30 fps camera
+	frameRatio := opts.fps / 30.0
+	estimate := used / opts.speed * frameRatio * outputSizeSafetyFactor
+	if opts.images {
+		// Every image becomes one frame, which the video encoder compresses far better than a still image
+		estimate = used / stillImageRatio * outputSizeSafetyFactor
+	}
+	if opts.mode == modeHyperlapse {
+		estimate = float64(len(segments)) * hyperlapseFrameSize * outputSizeSafetyFactor
+	}
+	if opts.mode == modeHighlights {
+		// The reel keeps only a few moments of the footage, at normal speed
+		var footage time.Duration
+		for _, seg := range segments {
+			if !seg.end.IsZero() {
+				footage += seg.end.Sub(seg.start)
+			}
+		}
+		if reel := time.Duration(opts.highlightCount) * opts.highlightLength; footage > reel {
+			estimate *= reel.Seconds() / footage.Seconds()
+		}
+	}
+	if opts.format == formatFrames {
+		estimate *= frameSizeFactors[opts.frameFormat]
+	}
+	if size, err := parseByteSize(opts.targetSize); err == nil && opts.targetSize != "" {
+		// The encode is sized to fit
+		estimate = float64(size)
+	}
+	if estimate < minOutputSizeEstimate {
+		estimate = minOutputSizeEstimate
+	}
+	return int64(estimate)
+}
+
+// footageSize returns the total size of the files the segments are cut from, counting each file once.
+func footageSize(segments []segment) int64 {
+	seen := make(map[string]bool, len(segments))
+	var size int64
+	for _, seg := range segments {
+		if seen[seg.path] {
+			continue
+		}
+		seen[seg.path] = true
+		if info, err := os.Stat(seg.path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// checkFreeSpace fails if the volume holding outputFile has less than need bytes available.
+func checkFreeSpace(outputFile string, need int64) error {
+	dir := filepath.Dir(outputFile)
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		return fmt.Errorf("checking free space in %s: %w", dir, err)
+	}
+	if free < uint64(need) {
+		return fmt.Errorf("not enough free space in %s: about %s needed for the output but only %s available (use -skip-space-check to try anyway)",
+			dir, formatBytes(uint64(need)), formatBytes(free))
+	}
+	return nil
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. "1.5 GiB".
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
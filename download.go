@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// protectExportChunk is the longest full-rate export requested at once, so that a failed download
+// loses little and the files resemble the exports made by hand.
+const protectExportChunk = time.Hour
+
+// runDownload implements the download subcommand: it exports a camera's footage from Protect, one file
+// per hour or, for timelapse exports, per day, named like the exports made in the Protect web interface.
+func runDownload(args []string) error {
+	fset := flagSetFor("download")
+	configFile := fset.String("config", "", "Path to the JSON config file with the \"protect\" section (default: \""+defaultConfigFile+"\" if present)")
+	configFromEnv := fset.Bool("config-from-env", false, configFromEnvUsage)
+	camera := fset.String("camera", "", "Name or ID of the camera to download (required)")
+	site := fset.String("site", "", "Site of the camera, when several controllers are configured (default: the site that has the camera)")
+	from := fset.String("from", "", "First day to download, as YYYY-MM-DD (default: yesterday)")
+	to := fset.String("to", "", "Last day to download, as YYYY-MM-DD (default: -from)")
+	speed := fset.Int("timelapse", 0, "Download Protect's timelapse export at this speedup (60, 120, 300 or 600) instead of every frame")
+	dir := fset.String("dir", videosDir, "Directory to save the exports in")
+	force := fset.Bool("force", false, "Download again exports that already exist")
+	hours := fset.String("hours", "", "Only download footage recorded within this daily window, e.g. 06:00-20:00")
+	daylightOnly := fset.Bool("daylight-only", false, "Only download footage recorded between sunrise and sunset at -lat/-lon")
+	lat := fset.Float64("lat", 0, "Camera latitude in degrees, north positive (used by -daylight-only)")
+	lon := fset.Float64("lon", 0, "Camera longitude in degrees, east positive (used by -daylight-only)")
+	margin := fset.Duration("daylight-margin", 0, "Extend daylight by this long before sunrise and after sunset")
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+
+	if *camera == "" {
+		return fmt.Errorf("download needs -camera")
+	}
+	if _, ok := protectTimelapseSpeeds[*speed]; *speed != 0 && !ok {
+		return fmt.Errorf("-timelapse must be one of %s", joinSpeeds())
+	}
+	first, last, err := parseDayRange(*from, *to)
+	if err != nil {
+		return err
+	}
+	spansFor := func(day time.Time) []timeSpan {
+		return []timeSpan{{day, day.AddDate(0, 0, 1)}}
+	}
+	switch {
+	case *hours != "" && *daylightOnly:
+		return fmt.Errorf("-hours and -daylight-only cannot be combined")
+	case *hours != "":
+		w, err := parseDailyWindow(*hours)
+		if err != nil {
+			return fmt.Errorf("invalid -hours: %w", err)
+		}
+		spansFor = w.spans
+	case *daylightOnly:
+		if !isFlagSet(fset, "lat") || !isFlagSet(fset, "lon") {
+			return fmt.Errorf("-daylight-only requires -lat and -lon")
+		}
+		spansFor = daylightSpans(*lat, *lon, *margin)
+	}
+
+	cfg, err := loadConfigFlag(*configFile, *configFromEnv)
+	if err != nil {
+		return err
+	}
+	sites, err := protectSitesFor(cfg)
+	if err != nil {
+		return err
+	}
+	sites, err = sites.selectSite(*site)
+	if err != nil {
+		return err
+	}
+	client, cam, err := findProtectCamera(sites, *camera)
+	if err != nil {
+		return err
+	}
+	if client.apiKey != "" {
+		return errExportNeedsLogin
+	}
+	if cam.Site != "" {
+		// Keeps cameras of the same name at different sites apart; merge them with -site
+		*dir = filepath.Join(*dir, filenameText(cam.Site))
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+
+	var downloaded, failed int
+	now := time.Now()
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		for _, span := range spansFor(day) {
+			if span.end.After(now) {
+				span.end = now
+			}
+			for start := span.start; start.Before(span.end); {
+				end := span.end
+				var name string
+				if *speed > 0 {
+					// Without an end time in the name, which would not match the length of the video,
+					// a merge keeps the export whole instead of trimming it by time
+					name = fmt.Sprintf("%s %s timelapse %dx.mp4", filenameText(cam.Name), formatFilenameTime(start), *speed)
+				} else {
+					if chunkEnd := start.Add(protectExportChunk); chunkEnd.Before(end) {
+						end = chunkEnd
+					}
+					name = fmt.Sprintf("%s %s - %s.mp4", filenameText(cam.Name), formatFilenameTime(start), formatFilenameTime(end))
+				}
+				path := filepath.Join(*dir, name)
+
+				if fileExists(path) && !*force {
+					fmt.Printf("Skipping %s, already downloaded\n", name)
+				} else if size, err := downloadExport(client, cam.ID, start, end, *speed, path); err != nil {
+					// Usually a period without recordings; the other exports may still succeed
+					fmt.Fprintf(os.Stderr, "Warning: exporting %s: %v\n", name, err)
+					failed++
+				} else {
+					fmt.Printf("Downloaded %s (%s)\n", name, formatBytes(uint64(size)))
+					downloaded++
+				}
+				start = end
+			}
+		}
+	}
+
+	fmt.Printf("Downloaded %d exports of %s into %s\n", downloaded, cam.Name, *dir)
+	if failed > 0 {
+		return fmt.Errorf("%d exports failed", failed)
+	}
+	return nil
+}
+
+// downloadExport saves a Protect export to path, through a hidden temporary file so that a merge
+// never sees a partial download, and returns its size.
+func downloadExport(client *protectClient, cameraID string, start, end time.Time, speed int, path string) (int64, error) {
+	tmp := filepath.Join(filepath.Dir(path), ".download-"+filepath.Base(path))
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	err = client.export(cameraID, start, end, speed, f)
+	var size int64
+	if info, statErr := f.Stat(); statErr == nil {
+		size = info.Size()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size == 0 {
+		err = fmt.Errorf("no footage recorded")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return size, os.Rename(tmp, path)
+}
+
+// parseDayRange parses the -from and -to dates of a download, defaulting to yesterday.
+func parseDayRange(from, to string) (time.Time, time.Time, error) {
+	first := startOfDay(time.Now()).AddDate(0, 0, -1)
+	if from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid -from: %w", err)
+		}
+		first = t
+	}
+	last := first
+	if to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid -to: %w", err)
+		}
+		last = t
+	}
+	if last.Before(first) {
+		return time.Time{}, time.Time{}, fmt.Errorf("-to is before -from")
+	}
+	return first, last, nil
+}
+
+// joinSpeeds lists the timelapse speeds Protect offers, for error messages.
+func joinSpeeds() string {
+	speeds := make([]int, 0, len(protectTimelapseSpeeds))
+	for s := range protectTimelapseSpeeds {
+		speeds = append(speeds, s)
+	}
+	sort.Ints(speeds)
+	text := make([]string, len(speeds))
+	for i, s := range speeds {
+		text[i] = strconv.Itoa(s)
+	}
+	return strings.Join(text, ", ")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// draftHeight is the height a -draft output is scaled down to, enough to check the order of the footage,
+// the overlays and the speed.
+const draftHeight = 360
+
+// Encoder presets of -draft, the fastest of each encoder.
+const (
+	draftPreset      = "ultrafast"
+	draftPresetNVENC = "p1"
+)
+
+// addDraftScale scales the stream down to at most draftHeight pixels high, after the overlays so that they
+// keep their place and size relative to the picture.
+func addDraftScale(g *filterGraph) {
+	g.add(fmt.Sprintf("scale=-2:'min(%d,ih)'", draftHeight))
+}
+
+// draftEncoderArgs replaces the preset in the encoder arguments args with the fastest of the encoder.
+func draftEncoderArgs(args []string) []string {
+	draft := append([]string(nil), args...)
+	preset := draftPreset
+	for i := 0; i+1 < len(draft); i++ {
+		switch draft[i] {
+		case "-c:v":
+			if strings.HasSuffix(draft[i+1], "_nvenc") {
+				preset = draftPresetNVENC
+			}
+		case "-preset":
+			draft[i+1] = preset
+		}
+	}
+	return draft
+}
+
+// endClips returns the clips that start within span of the start of the first clip, or end within span
+// of the end of the last, which a draft checks the start and the end of the output with.
+func endClips(clips []clip, span time.Duration) []clip {
+	if len(clips) == 0 {
+		return clips
+	}
+	first := clips[0].start.Add(span)
+	last := clipLastTime(clips[len(clips)-1]).Add(-span)
+	var kept []clip
+	for _, c := range clips {
+		if c.start.Before(first) || clipLastTime(c).After(last) {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
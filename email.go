@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxAttachmentMB is the largest output attached to emails unless max_attachment_mb is set.
+const defaultMaxAttachmentMB = 20
+
+// smtpTimeout bounds the whole SMTP exchange, so that a server that stops answering cannot hang the
+// program, while leaving time to upload an attachment over a slow uplink.
+const smtpTimeout = 5 * time.Minute
+
+// emailConfig holds the SMTP settings from the "email" entry of the "notifications" section.
+type emailConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// TLS selects implicit TLS (usually port 465); otherwise STARTTLS is used when the server offers it.
+	TLS bool `json:"tls"`
+	// MaxAttachmentMB is the largest output that is attached; larger ones are sent as a thumbnail and link.
+	MaxAttachmentMB float64 `json:"max_attachment_mb"`
+	// LinkURL is where recipients can fetch outputs too large to attach; "{file}" is replaced by the file name.
+	LinkURL string `json:"link_url"`
+	// SendFailures also emails when a merge fails.
+	SendFailures bool `json:"send_failures"`
+}
+
+// validate checks that the settings needed to send mail are present.
+func (c emailConfig) validate() error {
+	if c.Host == "" || c.From == "" || len(c.To) == 0 {
+		return errors.New("email needs host, from and to")
+	}
+	return nil
+}
+
+// attachmentLimit returns the largest output size in bytes that is attached.
+func (c emailConfig) attachmentLimit() int64 {
+	mb := c.MaxAttachmentMB
+	if mb == 0 {
+		mb = defaultMaxAttachmentMB
+	}
+	return int64(mb * (1 << 20))
+}
+
+// sendEmail mails the result. A successful output is attached when it is a file small enough; otherwise,
+// as for the directory of -format frames, the thumbnail is attached and the message links to the output.
+func sendEmail(c emailConfig, result mergeResult, thumbnail string) error {
+	if result.Status != "success" && !c.SendFailures {
+		return nil
+	}
+
+	text := chatMessage(result)
+	var attachments []string
+	if result.Status == "success" {
+		info, err := os.Stat(result.Output)
+		switch {
+		case err == nil && info.Mode().IsRegular() && info.Size() <= c.attachmentLimit():
+			attachments = append(attachments, result.Output)
+		default:
+			if c.LinkURL != "" {
+				text += "\n\nDownload: " + strings.ReplaceAll(c.LinkURL, "{file}", url.PathEscape(filepath.Base(result.Output)))
+			}
+			if thumbnail != "" {
+				attachments = append(attachments, thumbnail)
+			}
+		}
+	}
+
+	msg, err := buildEmail(c.From, c.To, "Timelapse: "+result.Camera, text, attachments)
+	if err != nil {
+		return err
+	}
+	return sendSMTP(c, msg)
+}
+
+// buildEmail returns a MIME message with a plain text body and the given files attached.
+func buildEmail(from string, to []string, subject, text string, attachments []string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64Lines(part, []byte(text))
+
+	for _, file := range attachments {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(file)
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(part, data)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBase64Lines writes data base64-encoded in lines of 76 characters, as MIME requires.
+func writeBase64Lines(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+// sendSMTP delivers msg through the configured server, authenticating when a username is set.
+func sendSMTP(c emailConfig, msg []byte) error {
+	port := c.Port
+	if port == 0 {
+		port = 587
+		if c.TLS {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(port))
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: notifyTimeout}
+	if c.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: c.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	// The deadline carries over to the connection wrapped by STARTTLS
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !c.TLS {
+		if err := client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return err
+		}
+	}
+	if c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.From); err != nil {
+		return err
+	}
+	for _, to := range c.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// encodeJob describes one ffmpeg encode of the concatenated segments.
+type encodeJob struct {
+	inputs     concatInput
+	outputFile string
+	// ranges lists the spans of the source timeline played at their own speed instead of opts.speed.
+	ranges []speedRange
+	// days holds one chapter per calendar day of footage, on the output timeline before title cards.
+	days []chapter
+	// cuts lists the output-timeline positions, in seconds, of the jumps to cross-fade.
+	cuts []float64
+	// chaptersFile is an ffmetadata file with chapter markers, or empty for none.
+	chaptersFile string
+	// stabilizeFile holds the camera motion recorded by detectShake, or is empty to skip stabilization.
+	stabilizeFile string
+	// duration is the expected output length in seconds, or 0 if unknown; progress is reported against it.
+	duration float64
+	// cameras lists the cameras of a -layout composite, starting with the one in inputs, and
+	// timeline the periods they recorded.
+	cameras  []layoutCamera
+	timeline compositeTimeline
+	// openingCard adds a title card before the first chapter too.
+	openingCard bool
+	// intro and outro are the cards shown before and after the footage, or nil for none.
+	intro, outro *slate
+	// overlayCues holds the -overlay-text showing the time of the footage, on the output timeline before
+	// any title cards, and overlayCommands the sendcmd file setting it, or are empty when it does not change.
+	overlayCues     []chapter
+	overlayCommands string
+	// progressCues holds the position of the -progress-bar, on the output timeline before any title cards,
+	// progressTicks its day ticks, and progressCommands the sendcmd file moving it along.
+	progressCues     []chapter
+	progressTicks    []float64
+	progressCommands string
+	// cues holds the subtitles showing the wall-clock time of the footage, on the final output timeline,
+	// and subtitlesFile them as a SubRip file to mux into the output, or is empty for none.
+	cues          []chapter
+	subtitlesFile string
+	// irSpans lists the spans of the source timeline with infrared footage to tint with -ir-tint.
+	irSpans []outputSpan
+	// passLogFile is the name prefix of the statistics files of a -two-pass encode.
+	passLogFile string
+}
+
+// musicFade is how long the music fades out at the end of the output.
+const musicFade = 3.0
+
+// canStreamCopy reports whether the clips can be merged without re-encoding: at 1x speed, with no
+// explicitly requested frame rate and no option that changes the pictures or the timeline.
+func canStreamCopy(opts options, fpsSet bool) bool {
+	return opts.format == formatMP4 && !opts.images && opts.speed == 1 && !fpsSet && !opts.adaptive && opts.minLuma == 0 &&
+		!opts.hasSpeedProfile() && opts.static == staticKeep && opts.irSpeed == 0 && opts.irTint == "" &&
+		len(opts.privacyMasks) == 0 && opts.crop == nil && opts.rotate == 0 &&
+		!opts.stabilize && !opts.smooth && !opts.deflicker && opts.blendFrames == 0 &&
+		opts.denoise == "" && opts.sharpen == 0 && !opts.hasGrading() && opts.bitDepth != bitDepth10 && !opts.twoPass && opts.keyint == 0 && !opts.draft && opts.intro == "" && opts.outro == "" && opts.overlayText == "" && opts.progressBar == "" &&
+		opts.transition == nil && !opts.titleCards && opts.watermark == "" && opts.layout == "" && opts.mode == modeTimelapse
+}
+
+// runFFmpeg executes ffmpeg to concatenate and speed up the video files.
+// It uses the concat demuxer for better performance and applies a speed factor to the video.
+// If opts.useGPU is true, it uses NVIDIA GPU acceleration (h264_nvenc), otherwise software encoding (libx264);
+// with -bit-depth 10 it encodes 10-bit HEVC instead (see videoEncoderArgs).
+// With opts.streamCopy the video is remuxed as-is, which takes seconds instead of hours.
+func runFFmpeg(opts options, job encodeJob) error {
+	if opts.streamCopy {
+		return runStreamCopy(opts, job)
+	}
+	// Take turns on the GPUs with other runs
+	if opts.useGPU && (opts.nvencSessions > 0 || opts.gpuIndex == gpuIndexAuto) {
+		session, gpu, err := acquireNVENCSession(opts)
+		if err != nil {
+			return err
+		}
+		defer session.release()
+		opts.gpuIndex = strconv.Itoa(gpu)
+	}
+
+	// Use concat demuxer for better performance
+	filter, extraInputs := buildVideoFilter(opts, job)
+	args := threadArgs(opts)
+	if decodeKeyframesOnly(opts, job) {
+		args = append(args, "-skip_frame", "nokey")
+	}
+	args = append(args, job.inputs.args()...)
+	args = append(args, extraInputs...)
+	if job.chaptersFile != "" {
+		// The metadata input comes after the concat input and any inputs used by the filter graph
+		args = append(args, "-f", "ffmetadata", "-i", job.chaptersFile)
+	}
+	if opts.music != "" {
+		// Loop the music for as long as the video lasts
+		args = append(args, "-stream_loop", "-1", "-i", opts.music)
+	}
+	if job.subtitlesFile != "" {
+		args = append(args, "-i", job.subtitlesFile)
+	}
+	args = append(args,
+		"-filter_complex", filter,
+		"-map", "[v]",
+	)
+	// The first pass of a two-pass encode only needs the video
+	video := args
+	if job.chaptersFile != "" {
+		args = append(args, "-map_chapters", strconv.Itoa(1+countInputs(extraInputs)))
+	}
+	if opts.music != "" {
+		music := 1 + countInputs(extraInputs)
+		if job.chaptersFile != "" {
+			music++
+		}
+		args = append(args, "-map", fmt.Sprintf("%d:a", music), "-c:a", "aac", "-b:a", "192k", "-shortest")
+		// The music plays over the -intro and -outro cards too
+		if length := job.duration + slateLength(job.intro) + slateLength(job.outro); length > 2*musicFade {
+			args = append(args, "-af", fmt.Sprintf("afade=t=out:st=%.3f:d=%g", length-musicFade, musicFade))
+		}
+	}
+	if job.subtitlesFile != "" {
+		// The subtitles are the last input
+		subs := 1 + countInputs(extraInputs)
+		if job.chaptersFile != "" {
+			subs++
+		}
+		if opts.music != "" {
+			subs++
+		}
+		args = append(args, "-map", fmt.Sprintf("%d:s", subs), "-c:s", "mov_text")
+	}
+
+	output := job.outputFile
+	switch {
+	case opts.format == formatFrames:
+		args = append(args, frameOutputArgs(opts.frameFormat)...)
+		output = framePattern(job.outputFile, opts.frameFormat)
+		fmt.Fprintf(opts.stdout, "Running ffmpeg to write %s frames from: %s\n", strings.ToUpper(opts.frameFormat), opts.ffmpegPath)
+	case isAnimatedFormat(opts.format):
+		args = append(args, animatedOutputArgs(opts.format)...)
+		fmt.Fprintf(opts.stdout, "Running ffmpeg to write an animated %s from: %s\n", strings.ToUpper(opts.format), opts.ffmpegPath)
+	default:
+		encoderArgs, encoding := videoEncoderArgs(opts)
+		args = append(args, encoderArgs...)
+		if opts.twoPass {
+			if err := runFirstPass(opts, job, video, encoderArgs); err != nil {
+				return fmt.Errorf("first pass: %w", err)
+			}
+			args = append(args, passArgs(opts, 2, job.passLogFile)...)
+			encoding += " (second pass)"
+		}
+		fmt.Fprintf(opts.stdout, "Running ffmpeg with %s from: %s\n", encoding, opts.ffmpegPath)
+	}
+
+	args = append(args, outputArgs(output)...)
+
+	return runWithProgress(opts, job, args)
+}
+
+// runFFmpegWithRetries runs runFFmpeg, trying again up to opts.retries times after a failure,
+// since nvenc sometimes fails with session-limit or driver errors that do not recur.
+// With opts.retryCPU, retries after a failed GPU encode use software encoding instead.
+func runFFmpegWithRetries(opts options, job encodeJob) error {
+	for attempt := 0; ; attempt++ {
+		err := runFFmpeg(opts, job)
+		opts.record(measureFFmpegExitStatus, float64(exitStatus(err)))
+		if err == nil && opts.verify {
+			if err = verifyOutput(opts, job); err != nil {
+				err = withExitCode(exitVerify, fmt.Errorf("verifying output: %w", err))
+			}
+		}
+		if err == nil || attempt >= opts.retries {
+			return err
+		}
+
+		fmt.Fprintf(opts.stderr, "ffmpeg failed: %v; retrying in %s (attempt %d of %d)\n", err, opts.retryDelay, attempt+2, opts.retries+1)
+		if opts.retryCPU && opts.useGPU && !opts.streamCopy {
+			fmt.Fprintln(opts.stderr, "Switching to software encoding for the retry")
+			opts.useGPU = false
+		}
+		time.Sleep(opts.retryDelay)
+	}
+}
+
+// runStreamCopy merges the segments into the output without decoding or re-encoding them.
+func runStreamCopy(opts options, job encodeJob) error {
+	args := job.inputs.args()
+	if isNetworkOutput(job.outputFile) {
+		// Read the footage at its own pace, which streaming servers expect the video to arrive at
+		args = append([]string{"-re"}, args...)
+	}
+	if job.chaptersFile != "" {
+		args = append(args, "-f", "ffmetadata", "-i", job.chaptersFile, "-map_chapters", "1")
+	}
+	if job.subtitlesFile != "" {
+		args = append(args, "-i", job.subtitlesFile)
+	}
+	args = append(args, "-map", "0:v", "-c", "copy")
+	if job.subtitlesFile != "" {
+		// SubRip cannot be stored in MP4 as it is, so the subtitles are converted
+		subs := 1
+		if job.chaptersFile != "" {
+			subs++
+		}
+		args = append(args, "-map", fmt.Sprintf("%d:s", subs), "-c:s", "mov_text")
+	}
+	args = append(args, outputArgs(job.outputFile)...)
+	fmt.Fprintf(opts.stdout, "Running ffmpeg stream copy (no re-encoding) from: %s\n", opts.ffmpegPath)
+
+	return runWithProgress(opts, job, args)
+}
+
+// runWithProgress runs ffmpeg with args. When opts.progress is set, ffmpeg's machine-readable progress
+// output is parsed and reported as the fraction of job.duration written so far, along with the encoding speed.
+func runWithProgress(opts options, job encodeJob, args []string) error {
+	if opts.progress == nil || job.duration <= 0 || job.outputFile == outputStdout {
+		cmd := ffmpegCommand(opts, args...)
+		job.inputs.attach(cmd)
+		cmd.Stdout = opts.stdout
+		if job.outputFile == outputStdout {
+			// The video itself goes there, so progress cannot be reported
+			cmd.Stdout = os.Stdout
+		}
+		cmd.Stderr = opts.stderr
+		return cmd.Run()
+	}
+
+	// The progress options must precede the output file, which is always the last argument
+	args = append(args[:len(args)-1:len(args)-1], "-progress", "pipe:1", "-nostats", args[len(args)-1])
+	cmd := ffmpegCommand(opts, args...)
+	job.inputs.attach(cmd)
+	cmd.Stderr = opts.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "out_time_us":
+			// The position written so far, in microseconds (out_time_ms is misnamed and identical)
+			if us, err := strconv.ParseFloat(value, 64); err == nil && us >= 0 {
+				opts.report("encoding", math.Min(us/1e6/job.duration, 1))
+			}
+		case "fps":
+			if fps, err := strconv.ParseFloat(value, 64); err == nil {
+				opts.record(measureEncodeFPS, fps)
+			}
+		}
+	}
+	opts.record(measureEncodeFPS, 0)
+	return cmd.Wait()
+}
+
+// buildVideoFilter returns the ffmpeg filter graph applied to the concatenated video stream, ending in [v],
+// together with the arguments for any extra inputs the graph reads (such as a watermark image).
+func buildVideoFilter(opts options, job encodeJob) (string, []string) {
+	g := newFilterGraph("0:v")
+	var fps float64
+	if len(job.cameras) > 0 {
+		fps = addLayout(g, opts, job)
+	} else {
+		fps = addRetiming(g, opts, job.ranges)
+		if job.stabilizeFile != "" {
+			addStabilizeTransform(g, job.stabilizeFile, opts.stabilizeSmoothing)
+		}
+		// Masks use source frame coordinates, so they go before any cropping or rotation
+		addPrivacyMasks(g, opts.privacyMasks)
+		if opts.crop != nil {
+			g.add(cropFilter(*opts.crop))
+		}
+		if rotate := rotateFilter(opts.rotate); rotate != "" {
+			g.add(rotate)
+		}
+		addIRTint(g, opts.irTint, job.irSpans, opts.speed, job.ranges)
+	}
+	// Clean up and grade after the retiming, so that only the frames kept are processed; noise is removed
+	// first, as grading would amplify it, and sharpening last, as it would sharpen the noise
+	addDenoise(g, opts.denoise, opts.denoiseStrength)
+	addGrading(g, opts)
+	addSharpen(g, opts.sharpen)
+	if opts.minLuma > 0 {
+		// Drop dark frames, then renumber the survivors so the gaps they leave are closed instead of frozen
+		g.add(
+			"signalstats",
+			fmt.Sprintf("metadata=mode=select:key=lavfi.signalstats.YAVG:value=%g:function=greater_or_equal", opts.minLuma),
+			fmt.Sprintf("setpts=N/(%g*TB)", fps),
+		)
+	}
+	if opts.smooth || (len(job.cameras) == 0 && interpolatesSlowMotion(opts, job.ranges)) {
+		// Fill in the missing frames, after cropping so that only the visible area is interpolated
+		if opts.smoothMode == "mci" {
+			g.add(fmt.Sprintf("minterpolate=fps=%g:mi_mode=mci:mc_mode=aobmc:me_mode=bidir:vsbmc=1", opts.fps))
+		} else {
+			g.add(fmt.Sprintf("minterpolate=fps=%g:mi_mode=blend", opts.fps))
+		}
+	}
+	if opts.deflicker {
+		// Scale each frame's brightness towards the mean of its neighbours to cancel exposure jumps
+		g.add(fmt.Sprintf("deflicker=mode=pm:size=%d", opts.deflickerSize))
+	}
+	if opts.blendFrames > 0 {
+		// Average each frame with its predecessors, trading some motion blur for steadier exposure
+		g.add(fmt.Sprintf("tmix=frames=%d", opts.blendFrames+1))
+	}
+	if opts.transition != nil {
+		addTransitions(g, job.cuts, *opts.transition)
+	}
+	if opts.overlayText != "" {
+		addOverlayText(g, opts, job.overlayCues, job.overlayCommands)
+	}
+	if opts.progressBar != "" {
+		addProgressBar(g, opts, job.progressTicks, job.progressCommands)
+	}
+	if opts.titleCards {
+		addTitleCards(g, job.days, opts.titleCardDuration, opts.fps, opts.titleFont, job.openingCard)
+	}
+	if opts.watermark != "" {
+		addWatermark(g, opts.watermark, opts.watermarkPosition, opts.watermarkMargin, opts.watermarkOpacity)
+	}
+	addSlates(g, job.intro, job.outro, opts.fps, opts.titleFont)
+	if opts.draft {
+		addDraftScale(g)
+	}
+	if isAnimatedFormat(opts.format) {
+		addAnimationFilters(g, opts.format, opts.animWidth)
+	}
+	if isNetworkOutput(job.outputFile) {
+		// Hold the frames back to the pace they play at, which streaming servers expect the video to arrive at
+		g.add("realtime")
+	}
+	return g.finish("v"), g.inputs
+}
+
+// interpolatesSlowMotion reports whether the frames missing from slow motion are synthesized with
+// -interpolate, which needs a constant speed.
+func interpolatesSlowMotion(opts options, ranges []speedRange) bool {
+	return opts.interpolate && opts.speed < 1 && len(ranges) == 0
+}
+
+// decimationSlack is the fraction of the interval between output frames that source frames kept must be
+// apart. It is a little under 1, so that frames with jittery timestamps are not skipped, which would leave
+// the fps filter to fill in with duplicates.
+const decimationSlack = 0.9
+
+// decimationInterval returns how far apart in the footage the source frames kept at speed must be, to
+// give fps output frames per second.
+func decimationInterval(speed, fps float64) float64 {
+	return decimationSlack * speed / fps
+}
+
+// decimationSpeed is the speed factor above which the frames kept are numbered one output frame apart
+// instead of having their timestamps divided by the speed.
+const decimationSpeed = 1000.0
+
+// keyframeInterval is the shortest interval of source time between the frames kept from which only the
+// keyframes are decoded. Cameras record a keyframe every few seconds, so frames that far apart may as well
+// be keyframes, and skipping the other frames makes decoding weeks of footage many times faster.
+const keyframeInterval = 60.0
+
+// decodeKeyframesOnly reports whether only the keyframes of the footage need to be decoded: at a constant
+// speed so high that the frames kept are at least keyframeInterval apart in the footage.
+func decodeKeyframesOnly(opts options, job encodeJob) bool {
+	fps := opts.fps
+	if opts.smooth {
+		fps = opts.fps / float64(opts.smoothFactor)
+	}
+	return opts.mode == modeTimelapse && !opts.images && len(job.cameras) == 0 && len(job.ranges) == 0 &&
+		job.stabilizeFile == "" && opts.speed/fps >= keyframeInterval
+}
+
+// addRetiming adds the speedup to the graph and returns the frame rate of the resulting stream.
+// Source frames are decimated to about fps/speed per second of footage before retiming, so at high
+// speed factors the frames that would be dropped anyway are never retimed or encoded; above decimationSpeed
+// the frames kept are simply played one after the other, which works for any speed.
+// When ranges is non-empty the speed varies along the timeline and frames are retimed incrementally.
+// With opts.smooth, only a fraction of the output frames is kept from the source and the rest are synthesized later.
+func addRetiming(g *filterGraph, opts options, ranges []speedRange) float64 {
+	fps := opts.fps
+	switch {
+	case opts.smooth:
+		fps = opts.fps / float64(opts.smoothFactor)
+	case interpolatesSlowMotion(opts, ranges):
+		// Keep the source frames as they are, slowed down, and leave the frames in between to minterpolate
+		fps = opts.fps * opts.speed
+	}
+
+	if len(ranges) == 0 {
+		if opts.speed > decimationSpeed {
+			// Keep the first frame of every interval of footage between output frames, exactly speed/fps
+			// long so that the output is as long as expected without the fps filter to correct it, and play
+			// the frames kept one after the other, as timestamps divided by such speeds lose too much precision
+			interval := opts.speed / fps
+			g.add(
+				fmt.Sprintf("select='isnan(prev_selected_t)+gt(floor(t/%g),floor(prev_selected_t/%g))'", interval, interval),
+				fmt.Sprintf("setpts=N/(%g*TB)", fps),
+			)
+		} else {
+			// Drop (never duplicate) source frames closer together than the interval between output frames
+			g.add(fmt.Sprintf("select='isnan(prev_selected_t)+gte(t-prev_selected_t,%g)'", decimationInterval(opts.speed, fps)))
+			// Speed up by specified factor (setpts=PTS/speed)
+			g.add(fmt.Sprintf("setpts=PTS/%g", opts.speed))
+		}
+	} else {
+		// Same decimation and retiming, but with the speed evaluated at each frame's source time
+		g.add(
+			fmt.Sprintf("select='isnan(prev_selected_t)+gte((t-prev_selected_t)*%g/(%s),%g)'",
+				fps, speedExpr("t", opts.speed, ranges), decimationSlack),
+			fmt.Sprintf("setpts='if(isnan(PREV_OUTPTS),0,PREV_OUTPTS+(PTS-PREV_INPTS)/(%s))'",
+				speedExpr("T", opts.speed, ranges)),
+		)
+	}
+	// Pin the output frame rate
+	g.add(fmt.Sprintf("fps=%.6f", fps))
+	return fps
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// regexPrefix marks an -exclude pattern as a regular expression rather than a glob.
+const regexPrefix = "re:"
+
+// fileMatcher reports whether a file name matches an exclude pattern.
+type fileMatcher func(name string) bool
+
+// compileExcludes compiles -exclude patterns. Patterns are globs matched against the file name
+// (e.g. "*_old.mp4"), or regular expressions matched anywhere in it when prefixed with "re:".
+func compileExcludes(patterns []string) ([]fileMatcher, error) {
+	matchers := make([]fileMatcher, 0, len(patterns))
+	for _, p := range patterns {
+		if expr, ok := strings.CutPrefix(p, regexPrefix); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid exclude regex %q: %w", expr, err)
+			}
+			matchers = append(matchers, re.MatchString)
+			continue
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude glob %q: %w", p, err)
+		}
+		glob := p
+		matchers = append(matchers, func(name string) bool {
+			matched, _ := filepath.Match(glob, name)
+			return matched
+		})
+	}
+	return matchers, nil
+}
+
+// excludeFiles returns the files whose names match none of the matchers, and how many were removed.
+func excludeFiles(files []string, matchers []fileMatcher) ([]string, int) {
+	kept := files[:0]
+	for _, file := range files {
+		name := filepath.Base(file)
+		excluded := false
+		for _, match := range matchers {
+			if match(name) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			kept = append(kept, file)
+		}
+	}
+	return kept, len(files) - len(kept)
+}
+
+// dropShortClips returns the clips lasting at least min, and how many were removed. The length is taken
+// from the filename timestamps when available and otherwise probed with ffprobe.
+func dropShortClips(clips []clip, min time.Duration, ffprobe string) ([]clip, int, error) {
+	kept := clips[:0]
+	for _, c := range clips {
+		length := c.end.Sub(c.start)
+		if c.end.IsZero() {
+			d, err := probeDuration(ffprobe, c.path)
+			if err != nil {
+				return nil, 0, err
+			}
+			length = d
+		}
+		if length >= min {
+			kept = append(kept, c)
+		}
+	}
+	return kept, len(clips) - len(kept), nil
+}
+
+// dropDuplicateClips returns the clips without those whose file has the same content as an earlier
+// clip, such as a clip Protect exported again under a new name, and the duplicates removed. Only files
+// of the same size are compared, by quickChecksum, so most files are never read.
+func dropDuplicateClips(clips []clip) ([]clip, []duplicateClip, error) {
+	sizes := make([]int64, len(clips))
+	count := make(map[int64]int)
+	for i, c := range clips {
+		info, err := os.Stat(c.path)
+		if err != nil {
+			return nil, nil, err
+		}
+		sizes[i] = info.Size()
+		count[sizes[i]]++
+	}
+
+	first := make(map[string]string)
+	var duplicates []duplicateClip
+	kept := clips[:0]
+	for i, c := range clips {
+		if count[sizes[i]] > 1 {
+			sum, err := quickChecksum(c.path)
+			if err != nil {
+				return nil, nil, err
+			}
+			if original, ok := first[sum]; ok {
+				duplicates = append(duplicates, duplicateClip{path: c.path, original: original})
+				continue
+			}
+			first[sum] = c.path
+		}
+		kept = append(kept, c)
+	}
+	return kept, duplicates, nil
+}
+
+// duplicateClip is a clip skipped by dropDuplicateClips, with the clip of the same content kept.
+type duplicateClip struct {
+	path, original string
+}
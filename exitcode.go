@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Exit statuses of the program, which tell scripts and schedulers what went wrong. They are part of the
+// interface, documented in the README, so existing values must not change.
+const (
+	// exitFailure is any failure without a status of its own.
+	exitFailure = 1
+	// exitUsage is invalid flags or config, like the flag package exits with for unknown flags.
+	exitUsage = 2
+	// exitNoFootage means no clips were found for the camera, or none were left to merge.
+	exitNoFootage = 3
+	// exitFFmpeg means ffmpeg is missing, too old, or lacks a component the options need.
+	exitFFmpeg = 4
+	// exitEncode means ffmpeg failed to encode the output, after any -retries.
+	exitEncode = 5
+	// exitVerify means the output failed the -verify check, after any -retries.
+	exitVerify = 6
+	// exitUpload means the output was written but uploading it failed.
+	exitUpload = 7
+	// exitBusy means another run holds the camera's lock.
+	exitBusy = 8
+	// exitNoSpace means the output would not fit on its drive.
+	exitNoSpace = 9
+)
+
+// exitCodeError is an error that ends the program with a given exit status.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string { return e.err.Error() }
+func (e *exitCodeError) Unwrap() error { return e.err }
+
+// withExitCode returns err ending the program with code, unless err already carries an exit status of
+// its own, which is more specific.
+func withExitCode(code int, err error) error {
+	var coded *exitCodeError
+	if err == nil || errors.As(err, &coded) {
+		return err
+	}
+	return &exitCodeError{code: code, err: err}
+}
+
+// exitCode returns the exit status for err: 0 for nil, exitFailure unless err carries another.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var coded *exitCodeError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return exitFailure
+}
+
+// exitWith prints err and exits with its exit status.
+func exitWith(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Values of -export.
+const (
+	// exportEDL writes a CMX 3600 edit decision list, which DaVinci Resolve and most editors import.
+	exportEDL = "edl"
+	// exportFCPXML writes a Final Cut Pro XML project.
+	exportFCPXML = "fcpxml"
+)
+
+// edlReel is the reel name of every event; editors find the clips by the "FROM CLIP NAME" comment.
+const edlReel = "AX"
+
+// exportFile returns the name of the -export project in format written next to output.
+func exportFile(output, format string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + "." + format
+}
+
+// writeExport writes the timeline of the segments, whose lengths are durations, as an editor project
+// in format: every segment with its in and out points, in order, sped up by opts.speed.
+func writeExport(path, format string, opts options, segments []segment, durations []time.Duration) error {
+	title := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var data []byte
+	if format == exportEDL {
+		data = []byte(edlTimeline(title, segments, durations, opts.speed, opts.fps))
+	} else {
+		var err error
+		if data, err = fcpxmlTimeline(title, segments, durations, opts.speed, opts.fps); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// edlTimeline returns the segments as a CMX 3600 edit decision list at fps, each played at speed
+// with an M2 (motion) effect. The record timeline starts at 01:00:00:00, as editors expect.
+func edlTimeline(title string, segments []segment, durations []time.Duration, speed, fps float64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TITLE: %s\nFCM: NON-DROP FRAME\n\n", title)
+	rate := int64(math.Round(fps))
+	record := 3600 * rate
+	for i, seg := range segments {
+		srcIn := int64(math.Round(seg.inpoint.Seconds() * fps))
+		srcOut := srcIn + max(int64(math.Round(durations[i].Seconds()*fps)), 1)
+		length := max(int64(math.Round(durations[i].Seconds()*fps/speed)), 1)
+		fmt.Fprintf(&b, "%03d  %-8s V     C        %s %s %s %s\n", i+1, edlReel,
+			timecode(srcIn, rate), timecode(srcOut, rate), timecode(record, rate), timecode(record+length, rate))
+		if speed != 1 {
+			fmt.Fprintf(&b, "M2   %-8s %06.1f    %s\n", edlReel, fps*speed, timecode(srcIn, rate))
+		}
+		fmt.Fprintf(&b, "* FROM CLIP NAME: %s\n", filepath.Base(seg.path))
+		if abs, err := filepath.Abs(seg.path); err == nil {
+			fmt.Fprintf(&b, "* SOURCE FILE: %s\n", abs)
+		}
+		b.WriteString("\n")
+		record += length
+	}
+	return b.String()
+}
+
+// timecode formats a number of frames as a non-drop-frame SMPTE timecode at rate frames per second.
+func timecode(frames, rate int64) string {
+	return fmt.Sprintf("%02d:%02d:%02d:%02d", frames/(3600*rate), frames/(60*rate)%60, frames/rate%60, frames%rate)
+}
+
+// FCPXML documents, reduced to what a timeline of retimed clips needs.
+type fcpxmlDocument struct {
+	XMLName   xml.Name        `xml:"fcpxml"`
+	Version   string          `xml:"version,attr"`
+	Resources fcpxmlResources `xml:"resources"`
+	Event     fcpxmlEvent     `xml:"library>event"`
+}
+
+type fcpxmlResources struct {
+	Format fcpxmlFormat  `xml:"format"`
+	Assets []fcpxmlAsset `xml:"asset"`
+}
+
+type fcpxmlFormat struct {
+	ID            string `xml:"id,attr"`
+	FrameDuration string `xml:"frameDuration,attr"`
+}
+
+type fcpxmlAsset struct {
+	ID       string `xml:"id,attr"`
+	Name     string `xml:"name,attr"`
+	Start    string `xml:"start,attr"`
+	Duration string `xml:"duration,attr"`
+	HasVideo string `xml:"hasVideo,attr"`
+	Format   string `xml:"format,attr"`
+	MediaRep struct {
+		Kind string `xml:"kind,attr"`
+		Src  string `xml:"src,attr"`
+	} `xml:"media-rep"`
+}
+
+type fcpxmlEvent struct {
+	Name    string `xml:"name,attr"`
+	Project struct {
+		Name     string         `xml:"name,attr"`
+		Sequence fcpxmlSequence `xml:"sequence"`
+	} `xml:"project"`
+}
+
+type fcpxmlSequence struct {
+	Format   string       `xml:"format,attr"`
+	TCStart  string       `xml:"tcStart,attr"`
+	TCFormat string       `xml:"tcFormat,attr"`
+	Clips    []fcpxmlClip `xml:"spine>asset-clip"`
+}
+
+type fcpxmlClip struct {
+	Ref      string            `xml:"ref,attr"`
+	Offset   string            `xml:"offset,attr"`
+	Name     string            `xml:"name,attr"`
+	Start    string            `xml:"start,attr"`
+	Duration string            `xml:"duration,attr"`
+	TimeMap  []fcpxmlTimePoint `xml:"timeMap>timept,omitempty"`
+}
+
+type fcpxmlTimePoint struct {
+	Time   string `xml:"time,attr"`
+	Value  string `xml:"value,attr"`
+	Interp string `xml:"interp,attr"`
+}
+
+// fcpxmlTimeline returns the segments as an FCPXML project at fps, each retimed to play at speed. Every
+// file is one asset, however many segments are taken from it.
+func fcpxmlTimeline(title string, segments []segment, durations []time.Duration, speed, fps float64) ([]byte, error) {
+	// Times are whole frames, as Final Cut rejects edits between them
+	num, den := int64(1), int64(math.Round(fps))
+	if fps != math.Round(fps) {
+		num, den = 1001, int64(math.Round(fps*1001))
+	}
+	frames := func(n int64) string {
+		if n == 0 {
+			return "0s"
+		}
+		return fmt.Sprintf("%d/%ds", n*num, den)
+	}
+	toFrames := func(secs float64) int64 { return int64(math.Round(secs * float64(den) / float64(num))) }
+
+	doc := fcpxmlDocument{Version: "1.9"}
+	doc.Resources.Format = fcpxmlFormat{ID: "r0", FrameDuration: frames(1)}
+	doc.Event.Name = title
+	doc.Event.Project.Name = title
+	doc.Event.Project.Sequence = fcpxmlSequence{Format: "r0", TCStart: "0s", TCFormat: "NDF"}
+
+	assets := make(map[string]int)
+	var assetEnds []int64
+	var offset int64
+	for i, seg := range segments {
+		in, dur := seg.inpoint.Seconds(), durations[i].Seconds()
+		n, ok := assets[seg.path]
+		if !ok {
+			n = len(doc.Resources.Assets)
+			assets[seg.path] = n
+			asset := fcpxmlAsset{ID: fmt.Sprintf("r%d", n+1), Name: filepath.Base(seg.path), Start: "0s", HasVideo: "1", Format: "r0"}
+			asset.MediaRep.Kind, asset.MediaRep.Src = "original-media", fileURL(seg.path)
+			doc.Resources.Assets = append(doc.Resources.Assets, asset)
+			assetEnds = append(assetEnds, 0)
+		}
+		// The asset lasts at least as long as the footage taken from it
+		asset := &doc.Resources.Assets[n]
+		if end := toFrames(in + dur); end > assetEnds[n] {
+			assetEnds[n] = end
+			asset.Duration = frames(end)
+		}
+
+		// A retimed clip's start and duration are on its own timeline, which the time map relates to the media's
+		length := max(toFrames(dur/speed), 1)
+		clip := fcpxmlClip{Ref: asset.ID, Offset: frames(offset), Name: asset.Name, Start: frames(toFrames(in / speed)), Duration: frames(length)}
+		if speed != 1 {
+			clip.TimeMap = []fcpxmlTimePoint{
+				{Time: "0s", Value: "0s", Interp: "linear"},
+				{Time: frames(toFrames((in + dur) / speed)), Value: frames(toFrames(in + dur)), Interp: "linear"},
+			}
+		}
+		doc.Event.Project.Sequence.Clips = append(doc.Event.Project.Sequence.Clips, clip)
+		offset += length
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header+"<!DOCTYPE fcpxml>\n"), append(data, '\n')...), nil
+}
+
+// fileURL returns the file: URL of path, as editors locate media by it.
+func fileURL(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	p := filepath.ToSlash(abs)
+	if !strings.HasPrefix(p, "/") {
+		// Windows paths start with the drive letter
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
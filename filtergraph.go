@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// filterGraph assembles an ffmpeg filter_complex graph around a single video stream.
+// Plain filters are chained onto the current stream; steps that need extra branches or inputs
+// are grafted in as separate chains joined by generated stream labels.
+type filterGraph struct {
+	inputs  []string
+	chains  []string
+	current string
+	pending []string
+	next    int
+}
+
+// newFilterGraph starts a graph reading from the given input stream label, e.g. "0:v".
+func newFilterGraph(input string) *filterGraph {
+	return &filterGraph{current: input}
+}
+
+// input registers an extra ffmpeg input, given as the arguments preceding and including "-i <path>",
+// and returns the label of its video stream. Input 0 is always the concatenated footage.
+func (g *filterGraph) input(args ...string) string {
+	n := 1 + countInputs(g.inputs)
+	g.inputs = append(g.inputs, args...)
+	return fmt.Sprintf("%d:v", n)
+}
+
+// countInputs returns the number of "-i" inputs in an ffmpeg argument list.
+func countInputs(args []string) int {
+	n := 0
+	for i, a := range args {
+		// Skip option values so a path that happens to be "-i" is not counted
+		if a == "-i" && (i == 0 || args[i-1] != "-i") {
+			n++
+		}
+	}
+	return n
+}
+
+// add appends filters to the chain of the current stream.
+func (g *filterGraph) add(filters ...string) {
+	g.pending = append(g.pending, filters...)
+}
+
+// label returns a new unique stream label.
+func (g *filterGraph) label() string {
+	g.next++
+	return fmt.Sprintf("s%d", g.next)
+}
+
+// flush ends the pending chain in a new labelled stream and returns its label.
+func (g *filterGraph) flush() string {
+	if len(g.pending) > 0 {
+		out := g.label()
+		g.chains = append(g.chains, fmt.Sprintf("[%s]%s[%s]", g.current, strings.Join(g.pending, ","), out))
+		g.current, g.pending = out, nil
+	}
+	return g.current
+}
+
+// graft appends a hand-built section that consumes the current stream and produces a new one.
+// build receives the input and output labels and returns the ";"-separated chains in between.
+func (g *filterGraph) graft(build func(in, out string) string) {
+	in := g.flush()
+	out := g.label()
+	g.chains = append(g.chains, build(in, out))
+	g.current = out
+}
+
+// from ends the pending chain and continues the graph from another stream, e.g. an extra input.
+func (g *filterGraph) from(stream string) {
+	g.flush()
+	g.current = stream
+}
+
+// source appends a chain starting with a source filter, which reads no stream, and returns the label of its output.
+func (g *filterGraph) source(filters ...string) string {
+	out := g.label()
+	g.chains = append(g.chains, fmt.Sprintf("%s[%s]", strings.Join(filters, ","), out))
+	return out
+}
+
+// combine appends a filter reading several streams and makes its output the current stream.
+func (g *filterGraph) combine(streams []string, filter string) {
+	g.flush()
+	out := g.label()
+	g.chains = append(g.chains, fmt.Sprintf("[%s]%s[%s]", strings.Join(streams, "]["), filter, out))
+	g.current = out
+}
+
+// finish ends the graph in the given output label and returns the complete filter_complex string.
+func (g *filterGraph) finish(out string) string {
+	if len(g.pending) == 0 {
+		g.pending = []string{"null"}
+	}
+	g.chains = append(g.chains, fmt.Sprintf("[%s]%s[%s]", g.current, strings.Join(g.pending, ","), out))
+	g.pending = nil
+	return strings.Join(g.chains, ";")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Still image formats for -format frames.
+const (
+	frameFormatJPEG = "jpg"
+	frameFormatPNG  = "png"
+)
+
+// Output size of still frames relative to a video of the same frames, for the free space estimate.
+// Every frame is a keyframe, so even high-quality JPEGs are far larger than the video, and PNGs more so.
+var frameSizeFactors = map[string]float64{frameFormatJPEG: 15, frameFormatPNG: 60}
+
+// framePrefix starts the name of every frame file.
+const framePrefix = "frame_"
+
+// framePattern returns the ffmpeg image2 output pattern for the numbered frames in dir.
+func framePattern(dir, frameFormat string) string {
+	return filepath.Join(dir, framePrefix+"%06d."+frameFormat)
+}
+
+// frameOutputArgs returns the ffmpeg output options for still frames.
+func frameOutputArgs(frameFormat string) []string {
+	if frameFormat == frameFormatPNG {
+		return []string{"-c:v", "png"}
+	}
+	// Near-best JPEG quality, as the frames are meant for further processing
+	return []string{"-c:v", "mjpeg", "-q:v", "2", "-pix_fmt", "yuvj420p"}
+}
+
+// frameEncoder returns the ffmpeg encoder used for still frames.
+func frameEncoder(frameFormat string) string {
+	if frameFormat == frameFormatPNG {
+		return "png"
+	}
+	return "mjpeg"
+}
+
+// prepareFrameDir creates the directory for the frames. Frame files left in it by an earlier run are
+// removed, so that a shorter run does not leave stale frames at the end of the sequence.
+func prepareFrameDir(dir string, log io.Writer) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var removed int
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), framePrefix) {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				return err
+			}
+			removed++
+		}
+	}
+	if removed > 0 {
+		fmt.Fprintf(log, "Removed %d frame(s) of a previous run from %s\n", removed, dir)
+	}
+	return nil
+}
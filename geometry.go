@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// rectPattern matches a rectangle in X11 geometry form: "WxH+X+Y", with the offset optional.
+var rectPattern = regexp.MustCompile(`^(\d+)x(\d+)(?:\+(\d+)\+(\d+))?$`)
+
+// rect is a rectangle in source frame pixels.
+type rect struct {
+	w, h, x, y int
+	// centered is set when no offset was given and the rectangle sits in the middle of the frame.
+	centered bool
+}
+
+// parseRect parses a rectangle in the form "WxH+X+Y" or "WxH" (centered).
+func parseRect(s string) (rect, error) {
+	m := rectPattern.FindStringSubmatch(s)
+	if m == nil {
+		return rect{}, fmt.Errorf("expected WxH+X+Y, got %q", s)
+	}
+	r := rect{centered: m[3] == ""}
+	r.w, _ = strconv.Atoi(m[1])
+	r.h, _ = strconv.Atoi(m[2])
+	if !r.centered {
+		r.x, _ = strconv.Atoi(m[3])
+		r.y, _ = strconv.Atoi(m[4])
+	}
+	if r.w == 0 || r.h == 0 {
+		return rect{}, fmt.Errorf("width and height must be greater than 0 in %q", s)
+	}
+	return r, nil
+}
+
+// cropFilter returns the ffmpeg crop filter for the rectangle.
+func cropFilter(r rect) string {
+	if r.centered {
+		return fmt.Sprintf("crop=%d:%d", r.w, r.h)
+	}
+	return fmt.Sprintf("crop=%d:%d:%d:%d", r.w, r.h, r.x, r.y)
+}
+
+// rotateFilter returns the ffmpeg filter that rotates frames clockwise by the given angle in degrees.
+// Quarter turns use lossless transposes; other angles enlarge the frame to fit and fill the corners black.
+func rotateFilter(degrees float64) string {
+	switch math.Mod(math.Mod(degrees, 360)+360, 360) {
+	case 0:
+		return ""
+	case 90:
+		return "transpose=clock"
+	case 180:
+		return "hflip,vflip"
+	case 270:
+		return "transpose=cclock"
+	}
+	// Round the enlarged frame down to even dimensions as required by yuv420p
+	a := fmt.Sprintf("%g*PI/180", degrees)
+	return fmt.Sprintf("rotate=%s:ow='trunc(rotw(%s)/2)*2':oh='trunc(roth(%s)/2)*2':c=black", a, a, a)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// gpuIndexAuto spreads the GPU encodes of concurrent runs over all NVIDIA GPUs of the machine.
+const gpuIndexAuto = "auto"
+
+// countGPUs returns the number of NVIDIA GPUs listed by nvidia-smi, which ships with the driver.
+func countGPUs() (int, error) {
+	cmd := exec.Command("nvidia-smi", "-L")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("listing GPUs with nvidia-smi: %w: %s", err, lastLine(stderr.String()))
+	}
+	var n int
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if strings.HasPrefix(line, "GPU ") {
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("nvidia-smi lists no GPUs")
+	}
+	return n, nil
+}
+
+// candidateGPUs returns the indexes of the GPUs an encode may run on: all of them with -gpu-index auto,
+// or the chosen one (the first by default).
+func candidateGPUs(opts options) ([]int, error) {
+	switch opts.gpuIndex {
+	case gpuIndexAuto:
+		n, err := countGPUs()
+		if err != nil {
+			return nil, fmt.Errorf("%w; set -gpu-index instead of auto", err)
+		}
+		gpus := make([]int, n)
+		for i := range gpus {
+			gpus[i] = i
+		}
+		return gpus, nil
+	case "":
+		return []int{0}, nil
+	}
+	index, _ := strconv.Atoi(opts.gpuIndex)
+	return []int{index}, nil
+}
+
+// gpuArgs returns the NVENC arguments selecting the GPU of -gpu-index, if one was chosen.
+func gpuArgs(opts options) []string {
+	if opts.gpuIndex == "" || opts.gpuIndex == gpuIndexAuto {
+		return nil
+	}
+	return []string{"-gpu", opts.gpuIndex}
+}
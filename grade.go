@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// addGrading color-grades the current stream of the graph: first with the 3D LUT in lut, if set, then with
+// the -brightness, -contrast and -saturation adjustments, if any differ from neutral.
+func addGrading(g *filterGraph, opts options) {
+	if opts.lut != "" {
+		g.add("lut3d=file=" + escapeFilterValue(opts.lut) + ":interp=tetrahedral")
+	}
+	var eq []string
+	if opts.brightness != 0 {
+		eq = append(eq, fmt.Sprintf("brightness=%g", opts.brightness))
+	}
+	if opts.contrast != 1 {
+		eq = append(eq, fmt.Sprintf("contrast=%g", opts.contrast))
+	}
+	if opts.saturation != 1 {
+		eq = append(eq, fmt.Sprintf("saturation=%g", opts.saturation))
+	}
+	if len(eq) > 0 {
+		g.add("eq=" + strings.Join(eq, ":"))
+	}
+}
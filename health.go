@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Usages of the health flags, shared by the commands that run for a long time.
+const (
+	healthListenUsage = "Address to serve /healthz and /readyz on for liveness and readiness probes, e.g. :8081 (default: none)"
+	healthMaxAgeUsage = "Fail /healthz when no run has succeeded for this long, to have a stuck instance restarted (default: never)"
+)
+
+// health tracks the runs of a long-running command (serve, rolling, capture) for its /healthz and
+// /readyz endpoints, so that Kubernetes or an uptime monitor can restart an instance that is stuck.
+type health struct {
+	mu      sync.Mutex
+	started time.Time
+	ready   bool
+	// running is when the run in progress started, or zero between runs.
+	running     time.Time
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+	// maxAge fails /healthz when no run has succeeded for this long since the last success or the
+	// start, or is 0 to only fail when the process stops answering.
+	maxAge time.Duration
+	// idleOK keeps an idle command healthy, however long ago its last run: serve only runs the jobs
+	// it is sent, so its age only counts while the last job failed.
+	idleOK bool
+}
+
+// healthStatus is the JSON body of /healthz and /readyz.
+type healthStatus struct {
+	Status       string     `json:"status"`
+	Started      time.Time  `json:"started"`
+	RunningSince *time.Time `json:"running_since,omitempty"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	LastFailure  *time.Time `json:"last_failure,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// newHealth returns the health of a command starting now, which is not ready yet.
+func newHealth(maxAge time.Duration) *health {
+	return &health{started: time.Now(), maxAge: maxAge}
+}
+
+// setReady marks the command as ready to do its work, once it has started up, and tells the service
+// manager when it runs as a service.
+func (h *health) setReady() {
+	h.mu.Lock()
+	h.ready = true
+	h.mu.Unlock()
+	notifyServiceReady(h)
+}
+
+// runStarted records the start of a run: a merge, a rolling update or a snapshot.
+func (h *health) runStarted() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = time.Now()
+}
+
+// runFinished records the outcome of the run in progress.
+func (h *health) runFinished(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = time.Time{}
+	if err != nil {
+		h.lastFailure, h.lastError = time.Now(), err.Error()
+		return
+	}
+	h.lastSuccess = time.Now()
+}
+
+// lastSuccessTime returns when a run last succeeded, or the zero time if none has.
+func (h *health) lastSuccessTime() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastSuccess
+}
+
+// status returns the state reported by the endpoints, and whether the command is healthy.
+func (h *health) status() (healthStatus, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	optional := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+	st := healthStatus{
+		Status:       "ok",
+		Started:      h.started,
+		RunningSince: optional(h.running),
+		LastSuccess:  optional(h.lastSuccess),
+		LastFailure:  optional(h.lastFailure),
+		LastError:    h.lastError,
+	}
+	// A run in progress may take longer than maxAge, e.g. a merge of months of footage
+	if !h.running.IsZero() || (h.idleOK && !h.lastFailure.After(h.lastSuccess)) {
+		return st, true
+	}
+	since := h.lastSuccess
+	if since.IsZero() {
+		since = h.started
+	}
+	if h.maxAge > 0 && time.Since(since) > h.maxAge {
+		st.Status = fmt.Sprintf("no successful run for %s", time.Since(since).Round(time.Second))
+		return st, false
+	}
+	return st, true
+}
+
+// handleHealthz answers liveness probes: 200 while runs keep succeeding, and 503 once none has
+// succeeded for maxAge.
+func (h *health) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	st, ok := h.status()
+	code := http.StatusOK
+	if !ok {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, st)
+}
+
+// handleReadyz answers readiness probes: 503 while the command is starting up, then 200.
+func (h *health) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	st, _ := h.status()
+	h.mu.Lock()
+	ready := h.ready
+	h.mu.Unlock()
+	if !ready {
+		st.Status = "starting"
+		writeJSON(w, http.StatusServiceUnavailable, st)
+		return
+	}
+	st.Status = "ready"
+	writeJSON(w, http.StatusOK, st)
+}
+
+// serveHealth serves /healthz and /readyz on addr in the background, for the commands other than
+// serve, which answers them next to its API.
+func serveHealth(addr string, h *health) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.handleHealthz)
+	mux.HandleFunc("/readyz", h.handleReadyz)
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil {
+			exitWithError("health endpoint: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// modeHighlights shows the most active moments of the footage at normal speed.
+const modeHighlights = "highlights"
+
+// highlightBoundary is how close to the start of a segment a scene change is ignored, as the jump
+// from the previous segment's footage scores as a scene change whatever happens in either.
+const highlightBoundary = time.Second
+
+// pickHighlights returns up to count moments of the segments, whose lengths are durations, each lasting
+// up to length and chosen by the highest scene-change scores in samples, in chronological order.
+// Moments never overlap, and a moment that would cross into the next segment is cut short.
+func pickHighlights(samples []motionSample, segments []segment, durations []time.Duration, count int, length time.Duration) []segment {
+	// Where each segment starts on the concatenated source timeline
+	starts := make([]float64, len(segments))
+	var pos float64
+	for i, d := range durations {
+		starts[i] = pos
+		pos += d.Seconds()
+	}
+
+	ranked := make([]motionSample, 0, len(samples))
+	for _, s := range samples {
+		i := sort.SearchFloat64s(starts, s.t+1e-9) - 1
+		if s.score > 0 && i >= 0 && (i == 0 || s.t-starts[i] >= highlightBoundary.Seconds()) {
+			ranked = append(ranked, s)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+
+	var picked []segment
+	var taken []outputSpan
+	for _, s := range ranked {
+		if len(picked) == count {
+			break
+		}
+		i := sort.SearchFloat64s(starts, s.t+1e-9) - 1
+		seg, segLength := segments[i], durations[i].Seconds()
+		// Start a little before the scene change to show what led up to it
+		from := max(s.t-starts[i]-length.Seconds()/3, 0)
+		to := min(from+length.Seconds(), segLength)
+		r := outputSpan{start: starts[i] + from, end: starts[i] + to}
+		if overlapsAny(r, taken) {
+			continue
+		}
+		taken = append(taken, r)
+		offset := time.Duration(from * float64(time.Second))
+		picked = append(picked, segment{
+			path:     seg.path,
+			inpoint:  seg.inpoint + offset,
+			outpoint: seg.inpoint + time.Duration(to*float64(time.Second)),
+			start:    seg.start.Add(offset),
+			end:      seg.start.Add(time.Duration(to * float64(time.Second))),
+		})
+	}
+	sort.Slice(picked, func(i, j int) bool { return picked[i].start.Before(picked[j].start) })
+	return picked
+}
+
+// overlapsAny reports whether span overlaps any of spans.
+func overlapsAny(span outputSpan, spans []outputSpan) bool {
+	for _, s := range spans {
+		if span.start < s.end && s.start < span.end {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for the "mqtt" section of the config file.
+const (
+	defaultMQTTTopic       = "unifi-timelapse"
+	defaultDiscoveryPrefix = "homeassistant"
+	// mqttMaxBackoff is the longest wait between attempts to reconnect to the broker.
+	mqttMaxBackoff = time.Minute
+)
+
+// mqttConfig connects serve mode to an MQTT broker, from the "mqtt" section of the config file.
+type mqttConfig struct {
+	// Broker is the broker's URL, e.g. "mqtt://homeassistant.local:1883" or "mqtts://broker:8883".
+	Broker   string `json:"broker"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	ClientID string `json:"client_id"`
+	// Topic is the prefix of every topic published or subscribed to (default: "unifi-timelapse").
+	Topic string `json:"topic"`
+	// DiscoveryPrefix is where Home Assistant looks for discovery messages (default: "homeassistant").
+	DiscoveryPrefix string `json:"discovery_prefix"`
+}
+
+// validate checks that a broker is given, and a username with a password, which MQTT 3.1.1 only
+// accepts after one.
+func (c mqttConfig) validate() error {
+	if c.Broker == "" {
+		return fmt.Errorf("mqtt needs broker")
+	}
+	if c.Password != "" && c.Username == "" {
+		return fmt.Errorf("mqtt password needs a username")
+	}
+	return nil
+}
+
+// topic returns the full name of a topic below the configured prefix.
+func (c mqttConfig) topic(name string) string {
+	return strings.TrimSuffix(firstNonEmpty(c.Topic, defaultMQTTTopic), "/") + "/" + name
+}
+
+// nodeID identifies this instance in Home Assistant's discovery topics and unique IDs.
+func (c mqttConfig) nodeID() string {
+	return haObjectID(c.clientID())
+}
+
+// clientID returns the MQTT client identifier, which must differ between instances sharing a broker.
+func (c mqttConfig) clientID() string {
+	if c.ClientID != "" {
+		return c.ClientID
+	}
+	host, _ := os.Hostname()
+	return "unifi-timelapse-" + host
+}
+
+// mqttBridge publishes the server's jobs to MQTT and queues the merges requested there.
+//
+// Topics, below the configured prefix:
+//   - status: "online" or "offline" (retained; the broker publishes "offline" if the server disappears)
+//   - job: the running or last finished job, as returned by the API (retained)
+//   - event: the job whenever its status changes, or {"status": "rejected", "error": ...} for a bad command
+//   - merge: commands; a JSON object of settings like POST /api/jobs, or just a camera name
+type mqttBridge struct {
+	cfg mqttConfig
+	s   *server
+
+	mu      sync.Mutex
+	client  *mqttClient
+	pending []serverJob
+	// last maps job IDs to the last status published as an event.
+	last map[string]string
+	wake chan struct{}
+}
+
+// startMQTT connects the server to the broker in the background, reconnecting whenever the connection is lost.
+func startMQTT(s *server, cfg mqttConfig) {
+	b := &mqttBridge{cfg: cfg, s: s, last: make(map[string]string), wake: make(chan struct{}, 1)}
+	s.observers = append(s.observers, b.observe)
+	go b.publishLoop()
+	go b.connectLoop()
+}
+
+// observe records a new or changed job for publishing. Progress updates of the same job replace
+// each other, so a slow broker cannot make the backlog grow.
+func (b *mqttBridge) observe(job serverJob) {
+	b.mu.Lock()
+	if n := len(b.pending); n > 0 && b.pending[n-1].ID == job.ID && b.pending[n-1].Status == job.Status {
+		b.pending[n-1] = job
+	} else {
+		b.pending = append(b.pending, job)
+	}
+	b.mu.Unlock()
+	b.signal()
+}
+
+// signal wakes the publishing loop.
+func (b *mqttBridge) signal() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// publishLoop publishes the recorded job changes while connected.
+func (b *mqttBridge) publishLoop() {
+	for range b.wake {
+		b.mu.Lock()
+		client, jobs := b.client, b.pending
+		if client != nil {
+			b.pending = nil
+		}
+		b.mu.Unlock()
+		if client == nil {
+			// Published once connected; the retained state is refreshed on every connection anyway
+			continue
+		}
+
+		for _, job := range jobs {
+			if err := b.publishJob(client, job); err != nil {
+				// The listener notices the broken connection and reconnects
+				break
+			}
+		}
+	}
+}
+
+// publishJob publishes a job as the retained state, and as an event if its status changed.
+// Queued jobs only produce an event, so that the state follows the job being worked on.
+func (b *mqttBridge) publishJob(client *mqttClient, job serverJob) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if job.Status != jobQueued {
+		if err := client.publish(b.cfg.topic("job"), payload, true); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	changed := b.last[job.ID] != job.Status
+	b.last[job.ID] = job.Status
+	if job.Status == jobDone || job.Status == jobFailed {
+		delete(b.last, job.ID)
+	}
+	b.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return client.publish(b.cfg.topic("event"), payload, false)
+}
+
+// connectLoop keeps a connection to the broker, backing off after failures.
+func (b *mqttBridge) connectLoop() {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := b.session()
+		fmt.Fprintf(os.Stderr, "MQTT: %v; reconnecting in %s\n", err, backoff)
+		time.Sleep(backoff)
+		if time.Since(started) > mqttMaxBackoff {
+			// The connection was up for a while, so this is a new problem
+			backoff = time.Second
+		} else if backoff *= 2; backoff > mqttMaxBackoff {
+			backoff = mqttMaxBackoff
+		}
+	}
+}
+
+// session connects, announces the server, and handles commands until the connection fails.
+func (b *mqttBridge) session() error {
+	status := b.cfg.topic("status")
+	client, err := dialMQTT(b.cfg.Broker, b.cfg.clientID(), b.cfg.Username, b.cfg.Password, mqttWill{topic: status, payload: "offline"})
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", b.cfg.Broker, err)
+	}
+	defer client.close()
+	fmt.Printf("MQTT: connected to %s\n", b.cfg.Broker)
+
+	if err := client.subscribe(b.cfg.topic("merge")); err != nil {
+		return err
+	}
+	if err := b.publishDiscovery(client); err != nil {
+		return err
+	}
+	if err := client.publish(status, []byte("online"), true); err != nil {
+		return err
+	}
+
+	// Refresh the retained state with the running or last finished job, then follow the changes.
+	// Events missed while disconnected are not replayed.
+	b.s.mu.Lock()
+	b.mu.Lock()
+	b.pending = nil
+	for i := len(b.s.order) - 1; i >= 0; i-- {
+		if latest := b.s.jobs[b.s.order[i]].snapshot(); latest.Status != jobQueued {
+			b.pending = append(b.pending, latest)
+			b.last[latest.ID] = latest.Status
+			break
+		}
+	}
+	b.client = client
+	b.mu.Unlock()
+	b.s.mu.Unlock()
+	b.signal()
+
+	defer func() {
+		b.mu.Lock()
+		b.client = nil
+		b.mu.Unlock()
+	}()
+	return client.listen(func(_ string, payload []byte) {
+		b.handleCommand(client, payload)
+	})
+}
+
+// handleCommand queues the merge requested by a message on the merge topic.
+func (b *mqttBridge) handleCommand(client *mqttClient, payload []byte) {
+	var settings map[string]json.RawMessage
+	text := strings.TrimSpace(string(payload))
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal(payload, &settings); err != nil {
+			b.reject(client, fmt.Errorf("parsing command: %w", err))
+			return
+		}
+	} else {
+		camera, _ := json.Marshal(text)
+		settings = map[string]json.RawMessage{"camera": camera}
+	}
+
+	job, err := b.s.enqueue(settings)
+	if err != nil {
+		b.reject(client, err)
+		return
+	}
+	fmt.Printf("MQTT: queued job %s for %s\n", job.ID, job.Camera)
+}
+
+// reject publishes an event explaining why a command was not queued.
+func (b *mqttBridge) reject(client *mqttClient, err error) {
+	fmt.Fprintf(os.Stderr, "MQTT: rejected command: %v\n", err)
+	payload, _ := json.Marshal(map[string]string{"status": "rejected", "error": err.Error()})
+	client.publish(b.cfg.topic("event"), payload, false)
+}
+
+// publishDiscovery announces sensors for the job state and a merge button per camera to Home Assistant.
+func (b *mqttBridge) publishDiscovery(client *mqttClient) error {
+	node := b.cfg.nodeID()
+	prefix := strings.TrimSuffix(firstNonEmpty(b.cfg.DiscoveryPrefix, defaultDiscoveryPrefix), "/")
+	device := map[string]interface{}{
+		"identifiers": []string{node},
+		"name":        "UniFi Timelapse",
+	}
+	common := func(name, id string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":               name,
+			"unique_id":          node + "_" + id,
+			"availability_topic": b.cfg.topic("status"),
+			"device":             device,
+		}
+	}
+
+	entities := map[string]map[string]interface{}{}
+	for _, sensor := range []struct{ id, name, template, icon, unit string }{
+		{"status", "Job status", "{{ value_json.status }}", "mdi:timelapse", ""},
+		{"camera", "Job camera", "{{ value_json.camera }}", "mdi:cctv", ""},
+		{"stage", "Job stage", "{{ value_json.stage | default('') }}", "mdi:progress-wrench", ""},
+		{"progress", "Job progress", "{{ (value_json.progress * 100) | round(0) }}", "mdi:progress-clock", "%"},
+		{"output", "Last output", "{{ value_json.output | default('') }}", "mdi:filmstrip", ""},
+	} {
+		e := common(sensor.name, sensor.id)
+		e["state_topic"] = b.cfg.topic("job")
+		e["value_template"] = sensor.template
+		e["icon"] = sensor.icon
+		if sensor.unit != "" {
+			e["unit_of_measurement"] = sensor.unit
+		}
+		entities["sensor/"+node+"/"+sensor.id] = e
+	}
+
+	clips, err := b.s.findClips("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "MQTT: finding cameras for discovery: %v\n", err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range clips {
+		camera := c.camera
+		if seen[camera] {
+			continue
+		}
+		seen[camera] = true
+		press, _ := json.Marshal(map[string]string{"camera": camera})
+		id := "merge_" + haObjectID(camera)
+		e := common("Merge "+camera, id)
+		e["command_topic"] = b.cfg.topic("merge")
+		e["payload_press"] = string(press)
+		e["icon"] = "mdi:movie-open-play"
+		entities["button/"+node+"/"+id] = e
+	}
+
+	for path, e := range entities {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := client.publish(prefix+"/"+path+"/config", payload, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// haObjectID turns a name into the lowercase letters, digits and underscores Home Assistant allows in IDs.
+func haObjectID(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Values of -mode.
+const (
+	// modeTimelapse speeds up the footage.
+	modeTimelapse = "timelapse"
+	// modeHyperlapse shows one frame of every clip.
+	modeHyperlapse = "hyperlapse"
+)
+
+// Values of -hyperlapse-frame.
+const (
+	hyperlapseFirst    = "first"
+	hyperlapseSharpest = "sharpest"
+)
+
+const (
+	// blurKey is the frame metadata key written by ffmpeg's blurdetect filter.
+	blurKey = "lavfi.blur="
+	// hyperlapseFrameSize is the typical size of one encoded hyperlapse frame, for estimating the output size.
+	hyperlapseFrameSize = 256 << 10
+)
+
+// extractHyperlapseFrames saves one frame of each segment in workDir and returns segments showing
+// the frames as still images. Only the keyframes needed are decoded, which is far quicker than
+// decoding all the footage for the same effective speed.
+func extractHyperlapseFrames(opts options, segments []segment, workDir string) ([]segment, error) {
+	frames := make([]segment, 0, len(segments))
+	for i, seg := range segments {
+		at := seg.inpoint
+		if opts.hyperlapseFrame == hyperlapseSharpest {
+			offset, err := sharpestKeyframe(opts.ffmpegPath, seg)
+			if err != nil {
+				fmt.Fprintf(opts.stderr, "Warning: finding the sharpest frame of %s: %v; using its first frame\n", filepath.Base(seg.path), err)
+			}
+			at += offset
+		}
+
+		file := filepath.Join(workDir, fmt.Sprintf("frame-%06d.jpg", i+1))
+		cmd := ffmpegCommand(opts, "-v", "error", "-ss", formatSeconds(at), "-i", seg.path, "-frames:v", "1", "-q:v", "2", "-y", file)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("extracting a frame of %s: %w: %s", seg.path, err, lastLine(stderr.String()))
+		}
+		if !fileExists(file) {
+			// Seeking past the end writes nothing
+			fmt.Fprintf(opts.stderr, "Warning: no frame in %s; skipping it\n", filepath.Base(seg.path))
+			continue
+		}
+		frames = append(frames, segment{path: file, start: seg.start.Add(at - seg.inpoint), duration: imageDuration(opts)})
+		opts.report("extracting frames", float64(i+1)/float64(len(segments)))
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames could be extracted")
+	}
+	return frames, nil
+}
+
+// sharpestKeyframe returns the offset from the segment's inpoint of its least blurry keyframe, as
+// measured by ffmpeg's blurdetect filter.
+func sharpestKeyframe(ffmpegPath string, seg segment) (time.Duration, error) {
+	args := []string{"-hide_banner", "-nostats", "-loglevel", "info", "-skip_frame", "nokey", "-ss", formatSeconds(seg.inpoint)}
+	if seg.outpoint > 0 {
+		args = append(args, "-to", formatSeconds(seg.outpoint))
+	}
+	args = append(args, "-i", seg.path, "-an", "-vf", fmt.Sprintf("scale=%d:-2,blurdetect,metadata=print", motionAnalysisWidth*4), "-f", "null", "-")
+
+	cmd := exec.Command(ffmpegPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("%w: %s", err, lastLine(stderr.String()))
+	}
+
+	samples := parseMetadataSamples(stderr.String(), blurKey)
+	if len(samples) == 0 {
+		return 0, fmt.Errorf("no keyframes measured")
+	}
+	best := samples[0]
+	for _, s := range samples[1:] {
+		if s.score < best.score {
+			best = s
+		}
+	}
+	return time.Duration(best.t * float64(time.Second)), nil
+}
+
+// formatSeconds formats d as a number of seconds for ffmpeg's time options.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// imageExts are the still image file extensions accepted with -images.
+var imageExts = []string{".jpg", ".jpeg", ".png"}
+
+// compactTimePattern matches the timestamps snapshot tools commonly put in filenames, such as
+// "20260116-080000", "2026-01-16_08-00-00" or "2026-01-16T08:00:00".
+var compactTimePattern = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})[T_ -]?(\d{2})[-:.]?(\d{2})[-:.]?(\d{2})`)
+
+// maxEXIFSearch is how far into a JPEG file the EXIF segment is looked for.
+const maxEXIFSearch = 128 << 10
+
+// findImageFiles searches the given directories for all still images whose name starts with the
+// given camera name, like findVideoFiles does for clips.
+func findImageFiles(dirs []string, cameraName string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	match := func(path string) bool { return hasCameraPrefix(filepath.Base(path), cameraName) }
+	for _, dir := range dirs {
+		if err := walkMediaDir(dir, match, imageExts, seen, &files); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// loadImages returns a clip per image, taken at the time found in its filename (reading dates in order,
+// which may be dateOrderAuto), then in its EXIF
+// data, and finally its modification time, sorted by that time.
+func loadImages(files []string, order string) []clip {
+	if order == dateOrderAuto {
+		order = detectDateOrder(files)
+	}
+	clips := make([]clip, 0, len(files))
+	for _, file := range files {
+		start, undated := imageTime(file, order)
+		clips = append(clips, clip{path: file, start: start, undated: undated})
+	}
+	sort.SliceStable(clips, func(i, j int) bool { return clips[i].start.Before(clips[j].start) })
+	return clips
+}
+
+// closestDailyImages keeps, of each day's images, the one taken closest to the time of day at, so that
+// the timelapse shows the same moment every day. The clips must be sorted by time.
+func closestDailyImages(clips []clip, at time.Duration) []clip {
+	var kept []clip
+	var best time.Duration
+	for _, c := range clips {
+		target := startOfDay(c.start).Add(at)
+		off := c.start.Sub(target).Abs()
+		if n := len(kept); n > 0 && startOfDay(kept[n-1].start).Equal(startOfDay(c.start)) {
+			if off < best {
+				kept[n-1], best = c, off
+			}
+			continue
+		}
+		kept, best = append(kept, c), off
+	}
+	return kept
+}
+
+// imageDuration returns how long each image is shown on the source timeline: one frame of the
+// footage the encode samples, so that every image becomes one output frame.
+func imageDuration(opts options) time.Duration {
+	fps := opts.fps
+	if opts.smooth {
+		// Only every smoothFactor-th output frame is taken from the source
+		fps /= float64(opts.smoothFactor)
+	}
+	return time.Duration(float64(time.Second) / fps)
+}
+
+// imageTime returns the time an image was taken, and whether it is only the file's modification time.
+func imageTime(path, order string) (t time.Time, undated bool) {
+	name := filepath.Base(path)
+	if times := parseFilenameTimes(name, order); len(times) > 0 {
+		return times[0], false
+	}
+	if m := compactTimePattern.FindStringSubmatch(name); m != nil {
+		if t, err := time.ParseInLocation("20060102150405", strings.Join(m[1:], ""), time.Local); err == nil {
+			return t, false
+		}
+	}
+	if t, ok := exifTime(path); ok {
+		return t, false
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime(), true
+	}
+	return time.Time{}, true
+}
+
+// exifTime reads the DateTimeOriginal (or else DateTime) tag from a JPEG file's EXIF data.
+// EXIF times carry no zone and are taken as local time.
+func exifTime(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxEXIFSearch))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	// The EXIF data is a TIFF structure in an APP1 segment, following the "Exif\0\0" marker
+	i := bytes.Index(data, []byte("Exif\x00\x00"))
+	if i < 0 {
+		return time.Time{}, false
+	}
+	tiff := data[i+6:]
+	if len(tiff) < 8 {
+		return time.Time{}, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+
+	ifd0 := order.Uint32(tiff[4:])
+	var value string
+	if exifIFD, ok := exifTag(tiff, order, ifd0, 0x8769); ok {
+		value, _ = exifString(tiff, order, order.Uint32(exifIFD[8:]), 0x9003)
+	}
+	if value == "" {
+		value, _ = exifString(tiff, order, ifd0, 0x0132)
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", value, time.Local)
+	return t, err == nil
+}
+
+// exifTag returns the 12-byte entry for tag in the IFD at offset, if present.
+func exifTag(tiff []byte, order binary.ByteOrder, offset uint32, tag uint16) ([]byte, bool) {
+	if int64(offset)+2 > int64(len(tiff)) {
+		return nil, false
+	}
+	n := int(order.Uint16(tiff[offset:]))
+	for k := 0; k < n; k++ {
+		start := int64(offset) + 2 + int64(k)*12
+		if start+12 > int64(len(tiff)) {
+			return nil, false
+		}
+		entry := tiff[start : start+12]
+		if order.Uint16(entry) == tag {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// exifString returns the ASCII value of tag in the IFD at offset.
+func exifString(tiff []byte, order binary.ByteOrder, offset uint32, tag uint16) (string, bool) {
+	entry, ok := exifTag(tiff, order, offset, tag)
+	// Type 2 is ASCII; the "YYYY:MM:DD HH:MM:SS" values are longer than 4 bytes, so stored elsewhere
+	if !ok || order.Uint16(entry[2:]) != 2 {
+		return "", false
+	}
+	count, at := int64(order.Uint32(entry[4:])), int64(order.Uint32(entry[8:]))
+	if count <= 4 || at+count > int64(len(tiff)) {
+		return "", false
+	}
+	return strings.TrimRight(string(tiff[at:at+count]), "\x00 "), true
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// inspectVFRTolerance is how far, as a fraction, the average frame rate of a clip may be from its nominal
+// frame rate before it is reported as variable.
+const inspectVFRTolerance = 0.02
+
+// inspectLengthTolerance is how far, as a fraction, the length of a clip may be from the span in its name
+// before it is reported; merges time the footage by the name.
+const inspectLengthTolerance = 0.05
+
+// clipInfo is what inspect reports about a clip, as probed by ffprobe.
+type clipInfo struct {
+	clipFormat
+	// RFrameRate is the nominal frame rate, and AvgFrameRate the average, as fractions like 30000/1001.
+	RFrameRate   string `json:"r_frame_rate"`
+	AvgFrameRate string `json:"avg_frame_rate"`
+	duration     time.Duration
+}
+
+// probeClipInfo returns the format, frame rates and length of the first video stream of path.
+func probeClipInfo(ffprobe, path string) (clipInfo, error) {
+	cmd := exec.Command(ffprobe,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=codec_name,profile,level,width,height,pix_fmt,r_frame_rate,avg_frame_rate:format=duration",
+		"-of", "json",
+		path,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return clipInfo{}, fmt.Errorf("probing %s: %w: %s", path, err, lastLine(stderr.String()))
+	}
+	var probe struct {
+		Streams []clipInfo `json:"streams"`
+		Format  struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &probe); err != nil || len(probe.Streams) == 0 {
+		return clipInfo{}, fmt.Errorf("probing %s: no video stream", path)
+	}
+	info := probe.Streams[0]
+	if seconds, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		info.duration = time.Duration(seconds * float64(time.Second))
+	}
+	return info, nil
+}
+
+// parseFrameRate returns the frames per second of an ffprobe frame rate like 30000/1001, or 0 if unknown.
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		den = "1"
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// runInspect implements the inspect subcommand: it lists the clips of a camera, or a single clip, with
+// what ffprobe reports about them and the times parsed from their names, and flags what will affect a
+// merge, such as clips in another format, resolution changes and variable frame rates.
+func runInspect(args []string) error {
+	fset := flagSetFor("inspect")
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s inspect [options] <file|camera>\n\nOptions:\n", os.Args[0])
+		fset.PrintDefaults()
+	}
+	var inputDirs stringList
+	fset.Var(&inputDirs, "input", "Directory to search for the clips of the camera; repeatable (default: \""+videosDir+"\")")
+	source := fset.String("source", sourceUniFi, "NVR the clips come from, as for merging")
+	dateOrder := fset.String("date-order", dateOrderAuto, "Order of the day and month in filename dates, as for merging")
+	ffmpegPath := fset.String("ffmpeg", "ffmpeg", "Path to ffmpeg executable, next to which ffprobe is looked for")
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+	if fset.NArg() != 1 {
+		return withExitCode(exitUsage, fmt.Errorf("inspect needs a clip or a camera name"))
+	}
+	if len(inputDirs) == 0 {
+		inputDirs = stringList{videosDir}
+	}
+	naming, err := namingFor(*source, *dateOrder)
+	if err != nil {
+		return err
+	}
+
+	target := fset.Arg(0)
+	files := []string{target}
+	if info, err := os.Stat(target); err != nil || info.IsDir() {
+		if files, err = findVideoFiles(inputDirs, naming, target); err != nil {
+			return fmt.Errorf("finding video files: %w", err)
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no file %s, and no clips of a camera of that name in %s", target, strings.Join(inputDirs, ", "))
+		}
+	}
+	clips := loadClips(files, naming)
+	sort.SliceStable(clips, func(i, j int) bool { return clips[i].start.Before(clips[j].start) })
+
+	ffprobe := ffprobePath(*ffmpegPath)
+	infos := make(map[string]clipInfo, len(clips))
+	formats := make(map[string]clipFormat, len(clips))
+	errs := make(map[string]error)
+	var paths []string
+	for _, c := range clips {
+		info, err := probeClipInfo(ffprobe, c.path)
+		if err != nil {
+			errs[c.path] = err
+			continue
+		}
+		infos[c.path], formats[c.path] = info, info.clipFormat
+		paths = append(paths, c.path)
+	}
+	common := commonFormat(paths, formats)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSTART\tEND\tCODEC\tRESOLUTION\tFPS\tLENGTH\tNOTES")
+	var flagged int
+	var previous *clipInfo
+	for _, c := range clips {
+		end := "-"
+		if !c.end.IsZero() {
+			end = c.end.Format(time.DateTime)
+		}
+		name := filepath.Base(c.path)
+		if err, ok := errs[c.path]; ok {
+			flagged++
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\t-\tunreadable: %v\n", name, c.start.Format(time.DateTime), end, err)
+			continue
+		}
+		info := infos[c.path]
+		notes := inspectNotes(c, info, previous, common)
+		if len(notes) > 0 {
+			flagged++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s %s\t%dx%d\t%.3g\t%s\t%s\n", name, c.start.Format(time.DateTime), end,
+			info.Codec, info.Profile, info.Width, info.Height, parseFrameRate(info.AvgFrameRate),
+			info.duration.Round(time.Second), strings.Join(notes, "; "))
+		previous = &info
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	counts := make(map[clipFormat]int)
+	for _, p := range paths {
+		counts[formats[p]]++
+	}
+	fmt.Printf("\n%d clip(s), %d flagged", len(clips), flagged)
+	if len(counts) > 1 {
+		fmt.Printf("; %d formats, which a merge conforms to %s", len(counts), common)
+	}
+	fmt.Println()
+	return nil
+}
+
+// inspectNotes returns what will affect a merge about clip c, probed as info, after a clip probed as
+// previous, or nil for the first, in a run whose clips are mostly in format common.
+func inspectNotes(c clip, info clipInfo, previous *clipInfo, common clipFormat) []string {
+	var notes []string
+	if c.undated {
+		notes = append(notes, "no date in the name, ordered by modification time")
+	}
+	if !info.clipFormat.decodable(common) {
+		notes = append(notes, fmt.Sprintf("unlike most clips (%s), re-encoded by -conform", common))
+	}
+	if previous != nil && (info.Width != previous.Width || info.Height != previous.Height) {
+		notes = append(notes, fmt.Sprintf("resolution changed from %dx%d", previous.Width, previous.Height))
+	}
+	nominal, average := parseFrameRate(info.RFrameRate), parseFrameRate(info.AvgFrameRate)
+	if nominal > 0 && average > 0 && (average < nominal*(1-inspectVFRTolerance) || average > nominal*(1+inspectVFRTolerance)) {
+		notes = append(notes, fmt.Sprintf("variable frame rate (%.3g on average, %.3g nominal)", average, nominal))
+	}
+	if !c.end.IsZero() && info.duration > 0 {
+		named := c.end.Sub(c.start)
+		if diff := (info.duration - named).Abs(); diff > time.Second && diff.Seconds() > named.Seconds()*inspectLengthTolerance {
+			notes = append(notes, fmt.Sprintf("%s long, but its name spans %s", info.duration.Round(time.Second), named))
+		}
+	}
+	return notes
+}
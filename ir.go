@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// irSaturationKey is the frame metadata key of the average saturation written by ffmpeg's signalstats filter.
+const irSaturationKey = "lavfi.signalstats.SATAVG="
+
+// detectIR reports for each segment, whose lengths are durations, whether its footage is grayscale, as
+// cameras record at night in infrared mode. A segment is judged by the average saturation of a frame
+// from its middle, which is at most threshold for grayscale footage.
+func detectIR(opts options, segments []segment, durations []time.Duration, threshold float64) ([]bool, error) {
+	ir := make([]bool, len(segments))
+	for i, seg := range segments {
+		at := seg.inpoint + durations[i]/2
+		cmd := ffmpegCommand(opts, "-hide_banner", "-nostats", "-loglevel", "info",
+			"-ss", formatSeconds(at), "-i", seg.path, "-frames:v", "1", "-an",
+			"-vf", fmt.Sprintf("scale=%d:-2,signalstats,metadata=print", motionAnalysisWidth), "-f", "null", "-")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("measuring the saturation of %s: %w: %s", seg.path, err, lastLine(stderr.String()))
+		}
+		samples := parseMetadataSamples(stderr.String(), irSaturationKey)
+		if len(samples) == 0 {
+			fmt.Fprintf(opts.stderr, "Warning: could not measure the saturation of %s; treating it as colour footage\n", seg.path)
+			continue
+		}
+		ir[i] = samples[0].score <= threshold
+	}
+	return ir, nil
+}
+
+// excludeIR returns the segments of camera name that are not infrared, as -ir-exclude keeps them.
+func excludeIR(opts options, name string, segments []segment) ([]segment, error) {
+	durations, err := segmentDurations(ffprobePath(opts.ffmpegPath), segments)
+	if err != nil {
+		return nil, fmt.Errorf("measuring segments of %s: %w", name, err)
+	}
+	ir, err := detectIR(opts, segments, durations, opts.irThreshold)
+	if err != nil {
+		return nil, err
+	}
+	kept := segments[:0:0]
+	for i, seg := range segments {
+		if !ir[i] {
+			kept = append(kept, seg)
+		}
+	}
+	fmt.Fprintf(opts.stdout, "Left out %d of %d segment(s) of %s in infrared\n", len(segments)-len(kept), len(segments), name)
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("all footage of camera %s is infrared", name)
+	}
+	return kept, nil
+}
+
+// irSpans returns the spans of the concatenated source timeline taken by the segments flagged in ir,
+// whose lengths are durations, joining adjacent ones.
+func irSpans(ir []bool, durations []time.Duration) []outputSpan {
+	var spans []outputSpan
+	var pos float64
+	for i, d := range durations {
+		if ir[i] {
+			if n := len(spans); n > 0 && spans[n-1].end == pos {
+				spans[n-1].end = pos + d.Seconds()
+			} else {
+				spans = append(spans, outputSpan{start: pos, end: pos + d.Seconds()})
+			}
+		}
+		pos += d.Seconds()
+	}
+	return spans
+}
+
+// parseTint parses a colour in the form "#RRGGBB" or "RRGGBB" into its red, green and blue
+// components between 0 and 1.
+func parseTint(s string) (r, g, b float64, err error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	v, parseErr := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || parseErr != nil {
+		return 0, 0, 0, fmt.Errorf("expected a colour in the form #RRGGBB, e.g. #4060ff, got %q", s)
+	}
+	return float64(v>>16) / 255, float64(v>>8&0xff) / 255, float64(v&0xff) / 255, nil
+}
+
+// addIRTint tints the grayscale footage in spans, given on the source timeline, with the colour tint.
+// It must follow the retiming, as the spans are converted to output positions with speed and ranges.
+func addIRTint(g *filterGraph, tint string, spans []outputSpan, speed float64, ranges []speedRange) {
+	if len(spans) == 0 {
+		return
+	}
+	// The colour was validated by prepareOptions
+	r, gr, b, _ := parseTint(tint)
+	var enable []string
+	for _, s := range spans {
+		enable = append(enable, fmt.Sprintf("between(t,%g,%g)", outputOffset(s.start, speed, ranges), outputOffset(s.end, speed, ranges)))
+	}
+	// Each channel of a gray pixel becomes its brightness scaled by the tint's
+	g.add(fmt.Sprintf("colorchannelmixer=rr=%g:rg=0:rb=0:gr=0:gg=%g:gb=0:br=0:bg=0:bb=%g:enable='%s'", r, gr, b, strings.Join(enable, "+")))
+}
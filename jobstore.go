@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// savedJobs is the content of the -jobs file of serve.
+type savedJobs struct {
+	NextID int         `json:"next_id"`
+	Jobs   []serverJob `json:"jobs"`
+}
+
+// loadJobs restores the jobs of the -jobs file. Jobs that were queued or running when the server stopped,
+// whether it was restarted or crashed, are queued again; a job that was running starts over.
+func (s *server) loadJobs() error {
+	if s.jobsFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.jobsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved savedJobs
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("reading jobs from %s: %w", s.jobsFile, err)
+	}
+
+	s.nextID = saved.NextID
+	requeued := 0
+	for i := range saved.Jobs {
+		job := saved.Jobs[i]
+		job.log = &jobLog{}
+		if job.Status == jobQueued || job.Status == jobRunning {
+			if job.Status == jobRunning {
+				fmt.Fprintf(job.log, "Queued again after the server stopped while the job was running\n")
+			}
+			job.Status, job.Stage, job.Progress, job.Started = jobQueued, "", 0, nil
+			// The config file may have changed since, so the settings are checked again
+			opts, err := jobOptions(s.cfg, job.Settings)
+			if err != nil {
+				job.Status, job.Error = jobFailed, err.Error()
+			} else {
+				job.opts = opts
+				requeued++
+			}
+		}
+		s.jobs[job.ID] = &job
+		s.order = append(s.order, job.ID)
+	}
+	if requeued > 0 {
+		fmt.Printf("Queued %d job(s) again from %s\n", requeued, s.jobsFile)
+	}
+	return nil
+}
+
+// saveJobs writes the jobs to the -jobs file, through a temporary file so that a crash while writing
+// leaves the previous state. Failures are only reported, as the jobs keep running. The server mutex
+// must be held.
+func (s *server) saveJobs() {
+	if s.jobsFile == "" {
+		return
+	}
+	saved := savedJobs{NextID: s.nextID, Jobs: make([]serverJob, 0, len(s.order))}
+	for _, id := range s.order {
+		saved.Jobs = append(saved.Jobs, s.jobs[id].snapshot())
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err == nil {
+		partial := filepath.Join(filepath.Dir(s.jobsFile), "."+filepath.Base(s.jobsFile)+".tmp")
+		if err = os.WriteFile(partial, data, 0o644); err == nil {
+			err = os.Rename(partial, s.jobsFile)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: saving jobs to %s: %v\n", s.jobsFile, err)
+	}
+}
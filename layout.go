@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Arrangements of the cameras in a -layout composite.
+const (
+	layoutGrid   = "grid"
+	layoutHStack = "hstack"
+	layoutVStack = "vstack"
+	// layoutPiP shows the other cameras as insets over the main camera.
+	layoutPiP = "pip"
+)
+
+// insetCorners lists the -inset-position values.
+var insetCorners = []string{"top-left", "top-right", "bottom-left", "bottom-right"}
+
+// layoutCamera is one camera of a composite, with its footage listed in an inputs file of its own.
+type layoutCamera struct {
+	name       string
+	inputsFile string
+	segments   []segment
+	durations  []time.Duration
+	masks      []privacyMask
+}
+
+// layoutCameras finds the footage of the -with cameras and lists it in inputs files in workDir, after
+// the main camera, whose segments are already listed in inputsFile. Each camera's segments are measured.
+func layoutCameras(opts options, naming clipNaming, segments []segment, inputsFile, workDir string) ([]layoutCamera, error) {
+	cameras := []layoutCamera{{name: opts.cameraName, inputsFile: inputsFile, segments: segments, masks: opts.privacyMasks}}
+	for i, name := range opts.withCameras {
+		clips, err := findCameraClips(opts, naming, name)
+		if err != nil {
+			return nil, err
+		}
+		segs := wholeClipSegments(clips)
+		if opts.spansFor != nil {
+			segs = planSegments(clips, opts.spansFor)
+			if len(segs) == 0 {
+				return nil, withExitCode(exitNoFootage, fmt.Errorf("no footage for camera %s falls within the selected hours", name))
+			}
+		}
+		if opts.trimStart > 0 || opts.trimEnd > 0 {
+			if segs, err = trimSegments(segs, opts.trimStart, opts.trimEnd, ffprobePath(opts.ffmpegPath)); err != nil {
+				return nil, err
+			}
+			if len(segs) == 0 {
+				return nil, withExitCode(exitNoFootage, fmt.Errorf("no footage of camera %s is left after trimming the clips", name))
+			}
+		}
+		if opts.irExclude {
+			if segs, err = excludeIR(opts, name, segs); err != nil {
+				return nil, err
+			}
+		}
+		// Each camera is concatenated on its own, so its clips need to share a format only with each other
+		if opts.conform {
+			if segs, err = conformSegments(opts, segs, workDir, fmt.Sprintf("conformed-%d", i+1)); err != nil {
+				return nil, fmt.Errorf("conforming the clips of %s: %w", name, err)
+			}
+		}
+		file := filepath.Join(workDir, fmt.Sprintf("inputs-%d.txt", i+1))
+		if err := createInputsFile(segs, file); err != nil {
+			return nil, fmt.Errorf("creating inputs file: %w", err)
+		}
+		cameras = append(cameras, layoutCamera{name: name, inputsFile: file, segments: segs, masks: opts.withMasks[i]})
+	}
+
+	ffprobe := ffprobePath(opts.ffmpegPath)
+	for i := range cameras {
+		durations, err := segmentDurations(ffprobe, cameras[i].segments)
+		if err != nil {
+			return nil, fmt.Errorf("measuring segments of %s: %w", cameras[i].name, err)
+		}
+		cameras[i].durations = durations
+	}
+	return cameras, nil
+}
+
+// layoutSize returns the number of columns and rows of tiles for n cameras.
+func layoutSize(layout string, n int) (cols, rows int) {
+	switch layout {
+	case layoutHStack:
+		return n, 1
+	case layoutVStack:
+		return 1, n
+	}
+	cols = int(math.Ceil(math.Sqrt(float64(n))))
+	return cols, (n + cols - 1) / cols
+}
+
+// addLayout adds the composite of job.cameras to the graph and returns its frame rate. Every camera is
+// placed on the composite timeline and retimed on its own, so that all tiles show the same moment; a
+// camera without footage at that moment shows its nearest frame, blacked out or captioned as -gap-fill says.
+func addLayout(g *filterGraph, opts options, job encodeJob) float64 {
+	fps := opts.fps
+	if opts.smooth {
+		fps = opts.fps / float64(opts.smoothFactor)
+	}
+	w, h := opts.tileSize.w, opts.tileSize.h
+
+	tiles := make([]string, 0, len(job.cameras))
+	for i, cam := range job.cameras {
+		if i == 0 {
+			g.from("0:v")
+		} else {
+			g.from(g.input("-f", "concat", "-safe", "0", "-i", cam.inputsFile))
+		}
+		g.add(
+			fmt.Sprintf("setpts='(%s)/TB'", timelineExpr(cam, job.timeline)),
+			fmt.Sprintf("select='isnan(prev_selected_t)+gte(t-prev_selected_t,%g)'", decimationInterval(opts.speed, fps)),
+			fmt.Sprintf("setpts=PTS/%g", opts.speed),
+			// Start every tile at the start of the composite, repeating its first frame until its footage begins
+			fmt.Sprintf("fps=%.6f:start_time=0", fps),
+		)
+		addPrivacyMasks(g, cam.masks)
+		if opts.layout == layoutPiP && i > 0 {
+			// Insets keep their aspect ratio within their box, without bars
+			iw, ih := insetBox(opts)
+			g.add(fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", iw, ih), "setsar=1")
+		} else {
+			g.add(
+				fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", w, h),
+				fmt.Sprintf("pad=%d:%d:(ow-iw)/2:(oh-ih)/2", w, h),
+				"setsar=1",
+			)
+		}
+		g.add(gapFillFilters(opts.gapFill, cam.name, opts.titleFont, job.timeline.outputGaps(cam, opts.speed, fps))...)
+		tiles = append(tiles, g.flush())
+	}
+
+	if opts.layout == layoutPiP {
+		g.from(tiles[0])
+		_, ih := insetBox(opts)
+		for i, inset := range tiles[1:] {
+			g.combine([]string{g.flush(), inset}, "overlay="+insetPosition(opts.insetPosition, i, ih, opts.insetMargin))
+		}
+		return fps
+	}
+
+	cols, rows := layoutSize(opts.layout, len(tiles))
+	for len(tiles) < cols*rows {
+		// Fill the empty cells of a grid for as long as the composite lasts
+		tiles = append(tiles, g.source(fmt.Sprintf("color=c=black:s=%dx%d:r=%g:d=%.3f", w, h, fps, job.duration)))
+	}
+	positions := make([]string, len(tiles))
+	for i := range tiles {
+		positions[i] = fmt.Sprintf("%d_%d", i%cols*w, i/cols*h)
+	}
+	g.combine(tiles, fmt.Sprintf("xstack=inputs=%d:layout=%s", len(tiles), strings.Join(positions, "|")))
+	return fps
+}
+
+// insetBox returns the size of the box each inset of a pip layout is scaled to fit, a fraction of the main camera's size.
+func insetBox(opts options) (w, h int) {
+	return int(float64(opts.tileSize.w)*opts.insetSize) / 2 * 2, int(float64(opts.tileSize.h)*opts.insetSize) / 2 * 2
+}
+
+// insetPosition returns the overlay coordinates of the n-th inset (from 0) in the corner named position.
+// Insets after the first are stacked towards the middle of the frame, one box height plus margin apart.
+func insetPosition(position string, n, boxHeight, margin int) string {
+	x := fmt.Sprintf("%d", margin)
+	if strings.HasSuffix(position, "right") {
+		x = fmt.Sprintf("W-w-%d", margin)
+	}
+	offset := margin + n*(boxHeight+margin)
+	y := fmt.Sprintf("%d", offset)
+	if strings.HasPrefix(position, "bottom") {
+		y = fmt.Sprintf("H-h-%d", offset)
+	}
+	return x + ":" + y
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cameraLock is a PID file held while a camera is being processed, so that overlapping runs
+// (e.g. a cron job firing while the previous run is still encoding) do not clash.
+type cameraLock struct {
+	path string
+}
+
+// lockFilePath returns the lock file used for the named camera.
+func lockFilePath(camera string) string {
+	return filepath.Join(os.TempDir(), "unifi-timelapse-"+sanitizeFilename(strings.ToLower(camera))+".lock")
+}
+
+// acquireCameraLock creates the camera's lock file holding the current PID.
+// A lock left behind by a process that is no longer running is taken over.
+func acquireCameraLock(camera string) (*cameraLock, error) {
+	path := lockFilePath(camera)
+	lock, pid, err := tryLock(path)
+	if err == nil && lock == nil {
+		return nil, withExitCode(exitBusy, fmt.Errorf("camera %s is already being processed by process %d (lock file %s)", camera, pid, path))
+	}
+	return lock, err
+}
+
+// tryLock creates the lock file at path holding the current PID, taking over a lock left behind by a
+// process that is no longer running. If another running process holds the lock, it returns a nil lock
+// and that process's PID.
+func tryLock(path string) (*cameraLock, int, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, 0, fmt.Errorf("writing lock file %s: %w", path, err)
+			}
+			return &cameraLock{path: path}, 0, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, 0, fmt.Errorf("creating lock file %s: %w", path, err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, 0, fmt.Errorf("reading lock file %s: %w", path, err)
+		}
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && processRunning(pid) {
+			return nil, pid, nil
+		}
+		// Stale or unreadable lock: remove it and try again
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, 0, fmt.Errorf("removing stale lock file %s: %w", path, err)
+		}
+	}
+	return nil, 0, fmt.Errorf("could not acquire lock file %s", path)
+}
+
+// release removes the lock file.
+func (l *cameraLock) release() {
+	if err := os.Remove(l.path); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove lock file %s: %v\n", l.path, err)
+	}
+}
@@ -2,117 +2,2057 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	// videosDir is the directory containing video files to process.
+	// videosDir is the default directory containing video files to process.
 	videosDir = "videos"
 	// videoExt is the expected video file extension.
 	videoExt = ".mp4"
-	// inputsFile is the temporary file used by ffmpeg for concatenation.
+	// inputsFile is the name of the temporary file used by ffmpeg for concatenation, in the work directory.
 	inputsFile = "inputs.txt"
 	// datePattern is the regex pattern for extracting dates and times from filenames.
-	// Pattern: "M-D-YYYY, HH.MM.SS" or "M-D-YYYY, HH:MM:SS"
-	dateTimePattern = `(\d{1,2})-(\d{1,2})-(\d{4}),\s+(\d{2})[.:](\d{2})[.:](\d{2})`
-	// dateTimeFormat is the Go time format for parsing dates and times from filenames.
-	dateTimeFormat = "1-2-2006, 15:04:05"
-	// minSpeedFactor is the minimum allowed speed factor.
+	// Pattern: "M-D-YYYY, HH.MM.SS" or "M-D-YYYY, HH:MM:SS", or "D-M-YYYY" or "D.M.YYYY" in other locales
+	// (see -date-order), or ISO-like "YYYY-MM-DD HH.MM.SS" or "YYYY-MM-DDTHH-MM-SS", optionally followed by
+	// a "GMT+X" or "GMT+X:30" offset
+	dateTimePattern = `(?:(\d{1,2})[-.](\d{1,2})[-.](\d{4}),?|(\d{4})-(\d{2})-(\d{2})[T_,]?)\s*(\d{2})[.:-](\d{2})[.:-](\d{2})(?:\s*GMT([+-]\d{1,2})(?::?(\d{2}))?)?`
+	// dateTimeFormat is the Go time format for parsing dates and times from filenames, once in year, month, day order.
+	dateTimeFormat = "2006-1-2 15:04:05"
+	// minSpeedFactor is the minimum allowed speed factor. There is no maximum: at high speeds the footage
+	// is decimated to the frames kept (see addRetiming).
 	minSpeedFactor = 0.1
-	// maxSpeedFactor is the maximum allowed speed factor.
-	maxSpeedFactor = 1000.0
+	// maxOutputFPS is the maximum allowed output frame rate.
+	maxOutputFPS = 240.0
 )
 
+// options holds the settings collected from command-line flags.
+type options struct {
+	// cameraName is the camera as given, which may be an alias from the config file; see clipCamera.
+	cameraName string
+	// aliases maps the camera aliases of the config file to the camera names in the clip filenames.
+	aliases    map[string]string
+	inputDirs  stringList
+	ffmpegPath string
+	output     string
+	force      bool
+	versioning string
+	skipSpace  bool
+	useGPU     bool
+	retries    int
+	retryDelay time.Duration
+	retryCPU   bool
+	verify     bool
+	workDir    string
+	speed      float64
+	fps        float64
+	// keepFailedWorkDir leaves the work directory of a failed run in place for debugging.
+	keepFailedWorkDir bool
+	// priority is the -priority ffmpeg runs at, threads the number of threads it may use (0 for all),
+	// and nvencSessions the number of GPU encodes that may run at once across all runs (0 for no limit).
+	priority      string
+	threads       int
+	nvencSessions int
+	// gpuIndex is the NVIDIA GPU encoding the output, auto to spread concurrent runs over all GPUs,
+	// or empty for the driver's default.
+	gpuIndex string
+	// bitDepth is 8 or 10 to encode 8-bit H.264 or 10-bit HEVC, or auto to follow the footage until
+	// merge has probed it.
+	bitDepth string
+	// keyint is the number of frames between keyframes, or 0 for the encoder's default.
+	keyint int
+	// concatStdin pipes the list of segments to ffmpeg instead of writing it to the inputs file.
+	concatStdin bool
+	// conform rewrites clips whose formats differ so that the concat demuxer can join them.
+	conform bool
+	// twoPass encodes in software twice to meet bitrate, a target such as 8M, or the one filling targetSize
+	// bytes, which merge sets bitrate to.
+	twoPass    bool
+	bitrate    string
+	targetSize string
+	// streamCopy is set when the clips can be merged without re-encoding (see canStreamCopy).
+	streamCopy bool
+
+	// site selects the subdirectory of each input directory that holds the footage of one site.
+	site string
+	// source names the NVR whose naming convention the clips follow (see clipNamings).
+	source string
+	// dateOrder is the order of the day and month in filename dates, or dateOrderAuto to detect it.
+	dateOrder string
+
+	// layout arranges the cameras of a composite, which shows withCameras next to cameraName.
+	layout      string
+	withCameras stringList
+	// withMasks holds the privacy masks of each of withCameras.
+	withMasks [][]privacyMask
+	tileSize  rect
+	// gapFill is how a camera without footage is shown (see gapFillFreeze).
+	gapFill string
+	// insetSize, insetPosition and insetMargin place the other cameras of a pip layout.
+	insetSize     float64
+	insetPosition string
+	insetMargin   int
+
+	// mode is modeTimelapse, modeHyperlapse to show one frame of each clip, picked as hyperlapseFrame says,
+	// or modeHighlights to show the highlightCount most active moments, each up to highlightLength long.
+	mode            string
+	hyperlapseFrame string
+	highlightCount  int
+	highlightLength time.Duration
+
+	format    string
+	animWidth int
+	// frameFormat is the image format of -format frames.
+	frameFormat string
+	// playlist is hls or dash with -format hls or dash, which write an MP4 like -format mp4 (format is
+	// set to mp4) and then split it into segments listed by a playlist in this format.
+	playlist string
+
+	excludes        stringList
+	minClipDuration time.Duration
+	// trimStart and trimEnd are cut off the start and end of every clip.
+	trimStart time.Duration
+	trimEnd   time.Duration
+	// Infrared (grayscale) footage, whose average saturation is at most irThreshold, is left out with
+	// irExclude, or played at irSpeed instead of speed and tinted with the colour irTint when they are set.
+	irExclude   bool
+	irSpeed     float64
+	irTint      string
+	irThreshold float64
+	// images selects still images as the input instead of video clips.
+	images bool
+	// excludeMatchers are the compiled -exclude patterns.
+	excludeMatchers []fileMatcher
+	// dedup skips clips with the same content as an earlier one.
+	dedup bool
+	// strict fails the run on files without a date in their name instead of ordering them by modification time.
+	strict bool
+	// sample keeps every sample-th clip and limit the first limit of those, for a quick preview (0 = all).
+	sample, limit int
+	// draft encodes a fast low-resolution output, of only the footage within draftEnds of the start and the
+	// end when draftEnds is set, to check the order, overlays and speed before the real encode.
+	draft     bool
+	draftEnds time.Duration
+
+	hours          string
+	daylightOnly   bool
+	lat, lon       float64
+	daylightMargin time.Duration
+	minLuma        float64
+	// dailyAt and dailyLength select the footage at one time of every day; dailyTime is dailyAt parsed.
+	dailyAt     string
+	dailyLength time.Duration
+	dailyTime   time.Duration
+	// spansFor lists the periods of each day to include, or is nil to include everything.
+	spansFor func(day time.Time) []timeSpan
+
+	privacyMasks []privacyMask
+	crop         *rect
+	rotate       float64
+
+	smooth       bool
+	smoothFactor int
+	smoothMode   string
+	// interpolate synthesizes the missing frames of slow motion (-speed below 1) like -smooth, instead of
+	// repeating each source frame.
+	interpolate bool
+
+	stabilize          bool
+	stabilizeShakiness int
+	stabilizeSmoothing int
+
+	deflicker     bool
+	deflickerSize int
+	blendFrames   int
+
+	// denoise is hqdn3d or nlmeans to remove noise before grading, or empty for none.
+	denoise         string
+	denoiseStrength float64
+	sharpen         float64
+
+	// lut is a .cube 3D LUT file graded onto the footage, or empty for none.
+	lut        string
+	brightness float64
+	contrast   float64
+	saturation float64
+
+	chapters          bool
+	titleCards        bool
+	titleCardDuration time.Duration
+	titleFont         string
+	transition        *transition
+	// intro and outro are the -intro and -outro cards: slate, a line of text, or an image or video file.
+	intro, outro  string
+	slateDuration time.Duration
+	// overlayText is a template drawn on the footage, with {camera}, {site}, {date}, {time}, {speed}, {fps},
+	// {temperature} and {weather} placeholders, styled by the other overlay options.
+	overlayText     string
+	overlayFont     string
+	overlaySize     string
+	overlayColor    string
+	overlayPosition string
+	overlayMargin   int
+	overlayBox      string
+	// weatherURL is an Open-Meteo compatible endpoint the weather of the overlay is fetched from, or empty
+	// for Open-Meteo itself, and weatherUnits the temperature unit, celsius or fahrenheit.
+	weatherURL   string
+	weatherUnits string
+	// progressBar is the color of the bar drawn along the bottom of the footage showing how far through
+	// the recorded period it is, or empty for none, and progressBarHeight its height in pixels.
+	progressBar       string
+	progressBarHeight int
+
+	// subtitles is srt or vtt to write the wall-clock time of the footage on screen to a file next to
+	// the output, mux to add it to the output as a subtitle track, or empty for none.
+	subtitles        string
+	subtitleInterval time.Duration
+	// youtubeChapters is day or hour to write a YouTube description listing a chapter per day or hour
+	// of footage next to the output, or empty for none.
+	youtubeChapters string
+
+	// music is an audio file played under the output, faded out at its end (set by yearly).
+	music string
+
+	watermark         string
+	watermarkPosition string
+	watermarkMargin   int
+	watermarkOpacity  float64
+
+	adaptive        bool
+	activeSpeed     float64
+	motionThreshold float64
+	motionWindow    float64
+
+	// static says how periods where the picture barely changes (scene-change scores below staticThreshold
+	// for at least staticMin) are played: as usual, staticSpeedup times faster, or as a single frame.
+	static          string
+	staticThreshold float64
+	staticMin       time.Duration
+	staticSpeedup   float64
+
+	// rampIn and rampOut ramp the speed between rampSpeed and speed over the start and end of the output,
+	// and windowSpeeds (parsed from speedAt, then read from speedFile) play the footage of daily windows or
+	// periods at their own speed.
+	rampIn       time.Duration
+	rampOut      time.Duration
+	rampSpeed    float64
+	speedAt      stringList
+	speedFile    string
+	windowSpeeds []windowSpeed
+
+	thumbnail       bool
+	preview         string
+	previewDuration time.Duration
+
+	// export is edl or fcpxml to write the timeline as an editor project next to the output, or empty
+	// for none; with exportOnly the project is written instead of the output.
+	export     string
+	exportOnly bool
+
+	// cleanup says what happens to the source clips once the output is verified: they are kept,
+	// moved to archiveDir, or deleted, unless recorded within the last keepDays days.
+	cleanup    string
+	archiveDir string
+	keepDays   int
+
+	// db is the SQLite database of the clips found and the merges run, used through the sqlite3 shell
+	// at sqlitePath. incremental merges only the clips it has not recorded as merged.
+	db          string
+	sqlitePath  string
+	incremental bool
+
+	webhook string
+	// notifications lists the services told about the result, and upload where the output is
+	// uploaded to, from the config file.
+	notifications notificationsConfig
+	upload        uploadConfig
+
+	// stdout and stderr receive status messages and ffmpeg's output.
+	stdout, stderr io.Writer
+	// interactive is set when questions such as whether to overwrite the output can be asked on stdin.
+	interactive bool
+	// progress, when set, is told about each stage of the run and the completed fraction (0-1) of the encode.
+	progress func(stage string, fraction float64)
+	// measure, when set, receives measurements of the run for monitoring, named by the measure* constants.
+	measure func(name string, value float64)
+	// undated, when set, receives the files ordered by modification time for lack of a date in their name.
+	undated func(paths []string)
+	// ctx, when set, cancels the run: its ffmpeg processes are killed once it is done.
+	ctx context.Context
+}
+
+// Measurements passed to options.measure.
+const (
+	// measureClips is the number of clips found for the camera.
+	measureClips = "clips"
+	// measureBytes is the size of the footage merged, in bytes.
+	measureBytes = "bytes"
+	// measureEncodeFPS is the number of frames ffmpeg writes per second, reported while encoding.
+	measureEncodeFPS = "encode_fps"
+	// measureSkipped is the number of files left out as excluded, duplicate or too short.
+	measureSkipped = "skipped"
+	// measureGaps is the number of periods of at least summaryMinGap without footage between the clips.
+	measureGaps = "gaps"
+	// measureEncodeSeconds is the time ffmpeg took to encode the output, including retries.
+	measureEncodeSeconds = "encode_seconds"
+	// measureOutputSeconds is the expected length of the output, reported when it was measured.
+	measureOutputSeconds = "output_seconds"
+	// measureFFmpegExitStatus is the exit status of each ffmpeg encode, or -1 if it did not run to the end.
+	measureFFmpegExitStatus = "ffmpeg_exit_status"
+)
+
+// report passes the current stage and encode progress to opts.progress, if set.
+func (opts options) report(stage string, fraction float64) {
+	if opts.progress != nil {
+		opts.progress(stage, fraction)
+	}
+}
+
+// validSpeed reports whether speed is a usable speed factor: finite and at least minSpeedFactor.
+func validSpeed(speed float64) bool {
+	return speed >= minSpeedFactor && !math.IsInf(speed, 1)
+}
+
+// hasSpeedProfile reports whether the speed varies along the output as -ramp-in, -ramp-out or -speed-at say.
+func (opts options) hasSpeedProfile() bool {
+	return opts.rampIn > 0 || opts.rampOut > 0 || len(opts.speedAt) > 0 || opts.speedFile != ""
+}
+
+// hasGrading reports whether -lut, -brightness, -contrast or -saturation change the colors of the footage.
+func (opts options) hasGrading() bool {
+	return opts.lut != "" || opts.brightness != 0 || opts.contrast != 1 || opts.saturation != 1
+}
+
+// record passes a measurement to opts.measure, if set.
+func (opts options) record(name string, value float64) {
+	if opts.measure != nil {
+		opts.measure(name, value)
+	}
+}
+
+// clip is a video file together with its camera and the recording period parsed from its filename.
+type clip struct {
+	path   string
+	camera string
+	start  time.Time
+	// end is the zero time when the filename carries no end timestamp.
+	end time.Time
+	// undated is set when the filename carries no start either, so start is the file's modification time,
+	// which copying the file may have changed.
+	undated bool
+}
+
+// segment is a portion of a clip listed in the ffmpeg concat file.
+// A zero inpoint or outpoint means the start or end of the file respectively.
+type segment struct {
+	path              string
+	inpoint, outpoint time.Duration
+	// start and end are the wall-clock times of the footage; end is zero when unknown.
+	start, end time.Time
+	// duration is how long a still image is shown, or zero for video.
+	duration time.Duration
+}
+
+// subcommands maps the names of the subcommands to their implementations. Without a subcommand the
+// program merges the clips of one camera.
+var subcommands map[string]func(args []string) error
+
+func init() {
+	// Assigned here rather than in the declaration, since the subcommands refer to the map themselves
+	subcommands = map[string]func(args []string) error{
+		"bench":         runBench,
+		"cameras":       runCameras,
+		"capture":       runCapture,
+		"completion":    runCompletion,
+		"download":      runDownload,
+		"inspect":       runInspect,
+		"install":       runInstall,
+		"report":        runReport,
+		"rolling":       runRolling,
+		"run-service":   runService,
+		"serve":         runServe,
+		"set-secret":    runSetSecret,
+		"tui":           runTUI,
+		"uninstall":     runUninstall,
+		"yearly":        runYearly,
+		"youtube-login": runYouTubeLogin,
+		completeCommand: runComplete,
+	}
+}
+
+// flagSetFor returns a flag set for a subcommand that exits on errors and prints its usage.
+func flagSetFor(name string) *flag.FlagSet {
+	fset := flag.NewFlagSet(name, flag.ExitOnError)
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [options]\n\nOptions:\n", os.Args[0], name)
+		fset.PrintDefaults()
+	}
+	return fset
+}
+
+// defineMergeFlags defines the flags of a merge without a subcommand: those of the options, and those
+// of the program itself, which it returns.
+func defineMergeFlags(fset *flag.FlagSet, opts *options) (configFile *string, configFromEnv *bool, outputMode *string) {
+	configFile = fset.String("config", "", "Path to a JSON config file of flag values (default: \""+defaultConfigFile+"\" if present)")
+	configFromEnv = fset.Bool("config-from-env", false, configFromEnvUsage)
+	outputMode = fset.String("output", summaryText, "How the result is reported on stdout: text, or json for a summary of the run for scripts, with the status messages on stderr")
+	defineFlags(fset, opts)
+	return configFile, configFromEnv, outputMode
+}
+
 // exitWithError prints an error message and exits with status code 1.
 func exitWithError(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
 	os.Exit(1)
 }
 
-func main() {
-	var (
-		cameraName = flag.String("camera", "", "Camera name to match video files (required)")
-		ffmpegPath = flag.String("ffmpeg", "ffmpeg", "Path to ffmpeg executable (default: \"ffmpeg\" from PATH)")
-		useGPU     = flag.Bool("gpu", true, "Use NVIDIA GPU acceleration (h264_nvenc)")
-		speed      = flag.Float64("speed", 10.0, "Speedup factor for timelapse (default: 10.0 = 10x speed)")
-	)
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s -camera <camera-name> [options]\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Options:\n")
-		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "\nExamples:\n")
-		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\"\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -ffmpeg \"C:\\ffmpeg\\bin\\ffmpeg.exe\"\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -gpu=false\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -speed=5\n", os.Args[0])
+func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				exitWith(err)
+			}
+			return
+		}
+		// A merge takes no arguments besides its flags, so a word is a mistyped subcommand
+		if arg := os.Args[1]; !strings.HasPrefix(arg, "-") {
+			if s := closestName(arg, sortedNames(subcommands)); s != "" && !hiddenSubcommands[s] {
+				exitWith(withExitCode(exitUsage, fmt.Errorf("unknown command %q (did you mean %s?)", arg, s)))
+			}
+		}
+	}
+
+	var opts options
+	configFile, configFromEnv, outputMode := defineMergeFlags(flag.CommandLine, &opts)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -camera <camera-name> [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s bench [-clip file] [-duration 10s]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s capture -camera <camera-name> -url <stream-or-snapshot-url> [-interval 30s] [-dir snapshots]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s cameras [-config file] [-json]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s completion <bash|zsh|fish|powershell>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s download -camera <camera-name> [-from YYYY-MM-DD] [-to YYYY-MM-DD] [-timelapse 60|120|300|600]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s install [-name unifi-timelapse] <serve|rolling|capture> [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s report [-input dir] [-camera name] [-timelapses dir] [-o coverage_report.html]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s rolling -camera <camera-name> [-days 30] [-at 01:00] [-once] [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s serve [-listen addr] [-config file] [-token secret]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s set-secret -name <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s tui [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s uninstall [-name unifi-timelapse]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s yearly -input <directory> [-year YYYY] [-length 3m] [-music file]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s youtube-login [-config file]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -input \"D:\\exports\" -input \"\\\\nas\\protect\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -exclude \"*_old.mp4\" -min-clip-duration=10s\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -o \"{camera}/{date}_x{speed}.mp4\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -ffmpeg \"C:\\ffmpeg\\bin\\ffmpeg.exe\"\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -gpu=false\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -speed=5\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -speed=60 -fps=24\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -speed=120 -adaptive -active-speed=4\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -hours=06:00-20:00 -min-luma=16\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -daylight-only -lat=52.23 -lon=21.01\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -speed=60 -deflicker\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -speed=300 -smooth\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -crop=1280x720+640+360 -rotate=90\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -camera \"G5 Flex\" -watermark logo.png -watermark-position=top-left\n", os.Args[0])
+	}
+	if err := parseFlags(flag.CommandLine, os.Args[1:]); err != nil {
+		exitWith(err)
+	}
+
+	cfg, err := loadConfigFlag(*configFile, *configFromEnv)
+	if err != nil {
+		exitWith(withExitCode(exitUsage, err))
+	}
+	if err := applyConfig(flag.CommandLine, cfg.flags); err != nil {
+		exitWith(withExitCode(exitUsage, fmt.Errorf("applying config %s: %w", cfg.path, err)))
+	}
+
+	if opts.cameraName == "" {
+		fmt.Fprintf(os.Stderr, "Error: -camera flag is required\n\n")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if err := prepareOptions(flag.CommandLine, &opts, cfg); err != nil {
+		exitWith(withExitCode(exitUsage, err))
+	}
+	if *outputMode != summaryText && *outputMode != summaryJSON {
+		exitWith(withExitCode(exitUsage, fmt.Errorf("unknown output %q (use text or json)", *outputMode)))
+	}
+	opts.stdout, opts.stderr = os.Stdout, os.Stderr
+	if opts.output == outputStdout || *outputMode == summaryJSON {
+		// Keep the messages out of the video or the summary
+		opts.stdout = os.Stderr
+	}
+	if *outputMode == summaryJSON && opts.output == outputStdout {
+		exitWith(withExitCode(exitUsage, fmt.Errorf("-output json cannot be combined with -o -, which writes the video to stdout")))
+	}
+	opts.interactive = isTerminal(os.Stdin)
+
+	var summary *runSummary
+	if *outputMode == summaryJSON {
+		summary = &runSummary{Camera: opts.cameraName}
+		opts.measure = summary.measure
+		opts.undated = summary.undated
+	}
+	outputFile, err := mergeAndNotify(opts)
+	if summary != nil {
+		if err := summary.finish(os.Stdout, outputFile, err); err != nil {
+			exitWithError("writing the summary: %v", err)
+		}
+	}
+	if err != nil {
+		exitWith(err)
+	}
+
+	if isStreamOutput(outputFile) {
+		fmt.Fprintf(opts.stdout, "Successfully streamed to %s\n", streamTarget(outputFile))
+		return
+	}
+	fmt.Fprintf(opts.stdout, "Successfully created: %s\n", outputFile)
+}
+
+// defineFlags registers the merge options on fset, storing their values in opts.
+func defineFlags(fset *flag.FlagSet, opts *options) {
+	fset.StringVar(&opts.cameraName, "camera", "", "Camera name to match video files (required)")
+	fset.StringVar(&opts.site, "site", "", "Merge footage downloaded from this Protect site, kept in a subdirectory of each -input directory")
+	fset.StringVar(&opts.source, "source", sourceUniFi, "NVR the clips come from, which sets how their names are read: unifi, frigate, blueiris, or reolink")
+	fset.StringVar(&opts.dateOrder, "date-order", dateOrderAuto, "Order of the day and month in filename dates such as 1-2-2026: mdy (US), dmy, or auto to tell from the filenames")
+	fset.StringVar(&opts.layout, "layout", "", "Show the cameras in -with next to -camera in one video, time-aligned: grid, hstack (side by side), vstack (one above the other), or pip (insets over -camera)")
+	fset.Var(&opts.withCameras, "with", "Another camera to show with -layout; repeat for several cameras")
+	fset.Func("tile-size", "With -layout, the size each camera is scaled to (with pip, the size of -camera), as WxH (default: 960x540, or 1920x1080 with pip)", func(s string) error {
+		r, err := parseRect(s)
+		if err != nil {
+			return err
+		}
+		if !r.centered || r.w%2 != 0 || r.h%2 != 0 {
+			return fmt.Errorf("tile size must be WxH with even width and height, got %q", s)
+		}
+		opts.tileSize = r
+		return nil
+	})
+	fset.StringVar(&opts.gapFill, "gap-fill", gapFillFreeze, "With -layout, how a camera is shown while it has no footage: freeze (its nearest frame), black, or slate (black, captioned with its name)")
+	fset.Float64Var(&opts.insetSize, "inset-size", 0.3, "With -layout pip, the size of each inset as a fraction of the frame (0.05-0.9)")
+	fset.StringVar(&opts.insetPosition, "inset-position", "bottom-right", "With -layout pip, the corner of the insets: top-left, top-right, bottom-left, or bottom-right; several insets are stacked from it")
+	fset.IntVar(&opts.insetMargin, "inset-margin", 20, "With -layout pip, the distance in pixels between the insets and the frame edges")
+	fset.Var(&opts.inputDirs, "input", "Directory to search for video files; repeat for several directories (default: \""+videosDir+"\")")
+	fset.BoolVar(&opts.images, "images", false, "Build the timelapse from still images (.jpg, .jpeg, .png) instead of video clips, showing each image for one output frame")
+	fset.Var(&opts.excludes, "exclude", "Skip files whose name matches this glob (e.g. \"*_old.mp4\"), or regex when prefixed with \"re:\"; repeatable")
+	fset.DurationVar(&opts.minClipDuration, "min-clip-duration", 0, "Skip clips shorter than this, e.g. 10s")
+	fset.DurationVar(&opts.trimStart, "trim-start", 0, "Cut this much off the start of every clip, e.g. 1s to remove exposure flares")
+	fset.DurationVar(&opts.trimEnd, "trim-end", 0, "Cut this much off the end of every clip")
+	fset.BoolVar(&opts.irExclude, "ir-exclude", false, "Leave out infrared (grayscale) night footage")
+	fset.Float64Var(&opts.irSpeed, "ir-speed", 0, "Play infrared (grayscale) night footage at this speedup factor instead of -speed (0 = -speed)")
+	fset.StringVar(&opts.irTint, "ir-tint", "", "Tint infrared (grayscale) night footage with this colour, e.g. #4060ff")
+	fset.Float64Var(&opts.irThreshold, "ir-threshold", 4, "Average saturation (0-255) at or below which footage counts as infrared")
+	fset.BoolVar(&opts.dedup, "dedup", true, "Skip clips whose file has the same content as an earlier clip, such as one exported twice under different names")
+	fset.BoolVar(&opts.strict, "strict", false, "Stop with an error when a file has no date in its name, instead of ordering it by its modification time")
+	fset.IntVar(&opts.sample, "sample", 0, "Merge only every Nth clip, for a quick preview of the whole period (0 = every clip)")
+	fset.IntVar(&opts.limit, "limit", 0, "Merge only the first N clips (after -sample), for a quick preview (0 = no limit)")
+	fset.BoolVar(&opts.draft, "draft", false, fmt.Sprintf("Encode a fast %dp draft with the fastest encoder preset, to check the order, overlays and speed before the real encode", draftHeight))
+	fset.DurationVar(&opts.draftEnds, "draft-ends", 0, "With -draft, merge only the footage recorded within this long of the start and the end, e.g. 2h (0 = all)")
+	fset.StringVar(&opts.ffmpegPath, "ffmpeg", "ffmpeg", "Path to ffmpeg executable (default: \"ffmpeg\" from PATH)")
+	fset.StringVar(&opts.output, "o", defaultOutputTemplate, "Output file name template; placeholders: {camera}, {site}, {date}, {end_date}, {speed}, {fps}; - writes to standard output, and an rtmp://, rtmps:// or srt:// URL streams to that server")
+	fset.StringVar(&opts.format, "format", formatMP4, "Output format: mp4, hls or dash for an MP4 plus a segmented playlist for web players, gif or webp for an animated image to share in chats and tickets, or frames for a directory of numbered images")
+	fset.StringVar(&opts.frameFormat, "frame-format", frameFormatJPEG, "With -format frames, the image format: jpg or png")
+	fset.IntVar(&opts.animWidth, "anim-width", 480, "With -format gif or webp, scale the output down to at most this many pixels wide")
+	fset.BoolVar(&opts.force, "force", false, "Overwrite the output file if it already exists")
+	fset.StringVar(&opts.versioning, "versioning", versioningOff, "Keep existing outputs by writing to a new name: off, number (_001, _002, ...), or timestamp")
+	fset.StringVar(&opts.workDir, "workdir", "", "Directory the work directory of each run is created in, for its temporary files and log (default: the system temp directory)")
+	fset.BoolVar(&opts.keepFailedWorkDir, "keep-failed-workdir", false, "Keep the work directory of a failed run, with its inputs list and log, for debugging")
+	fset.StringVar(&opts.db, "db", "", "Record the clips found and the merges run in this SQLite database file, e.g. timelapse.db")
+	fset.StringVar(&opts.sqlitePath, "sqlite3", "sqlite3", "Path to the sqlite3 command-line shell used for -db")
+	fset.BoolVar(&opts.incremental, "incremental", false, "Only merge the clips that -db has not recorded as merged by an earlier run")
+	fset.BoolVar(&opts.thumbnail, "thumbnail", false, "Also write a poster frame of the output as a JPEG next to it")
+	fset.StringVar(&opts.preview, "preview", "", "Also write a short low-resolution animated preview of the output next to it: gif or webp")
+	fset.DurationVar(&opts.previewDuration, "preview-duration", 6*time.Second, "Maximum length of the -preview animation; longer outputs are sped up to fit")
+	fset.StringVar(&opts.export, "export", "", "Also write the clips, their in and out points and the speed as an editor project next to the output: edl (DaVinci Resolve) or fcpxml (Final Cut Pro)")
+	fset.BoolVar(&opts.exportOnly, "export-only", false, "With -export, write only the project and skip encoding")
+	fset.StringVar(&opts.webhook, "webhook", "", "POST a JSON summary (camera, output, duration, exit status, error) to this URL when the merge finishes")
+	fset.StringVar(&opts.cleanup, "cleanup", cleanupKeep, "What to do with the source clips once the output is verified: keep, move (to -archive), or delete")
+	fset.StringVar(&opts.archiveDir, "archive", defaultArchiveDir, "With -cleanup move, the directory the source clips are moved to")
+	fset.IntVar(&opts.keepDays, "keep-days", 0, "With -cleanup, leave the clips recorded within this many days in place (0 = clean up every merged clip)")
+	fset.BoolVar(&opts.skipSpace, "skip-space-check", false, "Start encoding even if the output volume seems too full for the estimated output size")
+	fset.BoolVar(&opts.useGPU, "gpu", true, "Use NVIDIA GPU acceleration (h264_nvenc)")
+	fset.IntVar(&opts.retries, "retries", 0, "Run ffmpeg again up to this many times if the encode fails")
+	fset.DurationVar(&opts.retryDelay, "retry-delay", 10*time.Second, "Wait this long before each -retries attempt")
+	fset.StringVar(&opts.bitDepth, "bit-depth", bitDepth8, "Output bit depth: 8 (H.264), 10 (HEVC main10, no banding in skies), or auto to encode 10-bit HEVC only for 10-bit footage")
+	fset.BoolVar(&opts.concatStdin, "concat-stdin", false, "Pipe the list of clips to ffmpeg's standard input instead of writing it to a temporary inputs.txt file")
+	fset.BoolVar(&opts.conform, "conform", true, "Check that the clips share one format, and remux or re-encode them to one if not, since mismatched clips garble the merged output")
+	fset.IntVar(&opts.keyint, "keyint", 0, "Frames between keyframes: small (e.g. 30) for smooth scrubbing in web players and editors, large (e.g. 600) for smaller archives (0 = encoder default)")
+	fset.BoolVar(&opts.twoPass, "two-pass", false, "Encode twice in software to meet -bitrate or -target-size with consistent quality, e.g. for uploads with a size cap")
+	fset.StringVar(&opts.bitrate, "bitrate", "", "With -two-pass, the video bitrate to aim at, e.g. 8M or 2500k")
+	fset.StringVar(&opts.targetSize, "target-size", "", "With -two-pass, the size the output should fit in, e.g. 2GB or 700MB")
+	fset.StringVar(&opts.priority, "priority", priorityNormal, "Priority of ffmpeg: normal, low, or idle (only when nothing else needs the CPU), so encodes do not starve other workloads")
+	fset.IntVar(&opts.threads, "threads", 0, "Maximum number of CPU threads ffmpeg uses (0 = all)")
+	fset.StringVar(&opts.gpuIndex, "gpu-index", "", "Index of the NVIDIA GPU to encode on (see nvidia-smi -L), or auto to spread concurrent runs over all GPUs")
+	fset.IntVar(&opts.nvencSessions, "nvenc-sessions", 0, "Maximum number of encodes running at once on each GPU across all runs; further runs wait for their turn (0 = no limit)")
+	fset.BoolVar(&opts.retryCPU, "retry-cpu", false, "Switch to software encoding (libx264) when retrying a failed GPU encode")
+	fset.BoolVar(&opts.verify, "verify", true, "Check the output with ffprobe after encoding, and fail (or retry with -retries) if it is incomplete or not as long as expected")
+	fset.StringVar(&opts.mode, "mode", modeTimelapse, "timelapse to speed up the footage, hyperlapse to show one frame of each clip, which is far quicker for very high speeds, or highlights to show the most active moments at normal speed")
+	fset.StringVar(&opts.hyperlapseFrame, "hyperlapse-frame", hyperlapseFirst, "With -mode hyperlapse, the frame taken from each clip: first, or sharpest (the least blurry keyframe)")
+	fset.IntVar(&opts.highlightCount, "highlights", 10, "With -mode highlights, the number of moments shown")
+	fset.DurationVar(&opts.highlightLength, "highlight-length", 5*time.Second, "With -mode highlights, how long each moment is shown")
+	fset.Float64Var(&opts.speed, "speed", 10.0, "Speedup factor for timelapse (default: 10.0 = 10x speed)")
+	fset.Float64Var(&opts.fps, "fps", 30.0, "Output frame rate; surplus frames are dropped before encoding")
+	fset.StringVar(&opts.hours, "hours", "", "Only include footage recorded within this daily window, e.g. 06:00-20:00")
+	fset.StringVar(&opts.dailyAt, "daily-at", "", "Build the timelapse from the footage at this time of every day, e.g. 12:00, for the same view day after day (one frame per day unless -daily-length is set)")
+	fset.DurationVar(&opts.dailyLength, "daily-length", 0, "With -daily-at, how much footage to take from each day, e.g. 5s, played at -speed (default: one frame)")
+	fset.BoolVar(&opts.daylightOnly, "daylight-only", false, "Only include footage recorded between sunrise and sunset at -lat/-lon")
+	fset.Float64Var(&opts.lat, "lat", 0, "Camera latitude in degrees, north positive (used by -daylight-only and the weather of -overlay-text)")
+	fset.Float64Var(&opts.lon, "lon", 0, "Camera longitude in degrees, east positive (used by -daylight-only and the weather of -overlay-text)")
+	fset.DurationVar(&opts.daylightMargin, "daylight-margin", 0, "Extend daylight by this long before sunrise and after sunset, e.g. 30m (negative shortens it)")
+	fset.Float64Var(&opts.minLuma, "min-luma", 0, "Drop output frames whose average luma (0-255) is below this value, e.g. 16 for black night frames")
+	fset.Func("crop", "Crop to a region of interest before encoding, as WxH+X+Y in source pixels (WxH crops the center)", func(s string) error {
+		r, err := parseRect(s)
+		if err != nil {
+			return err
+		}
+		if r.w%2 != 0 || r.h%2 != 0 {
+			return fmt.Errorf("crop width and height must be even, got %dx%d", r.w, r.h)
+		}
+		opts.crop = &r
+		return nil
+	})
+	fset.Float64Var(&opts.rotate, "rotate", 0, "Rotate clockwise by this many degrees after cropping (90, 180, 270, or any angle)")
+	fset.BoolVar(&opts.smooth, "smooth", false, "Synthesize intermediate frames for fluid motion instead of jittery frame skipping")
+	fset.IntVar(&opts.smoothFactor, "smooth-factor", 2, "With -smooth, how many output frames are generated per kept source frame (2-8)")
+	fset.StringVar(&opts.smoothMode, "smooth-mode", "mci", "With -smooth or -interpolate, how frames are synthesized: mci (motion-compensated, slow) or blend (cross-fade, fast)")
+	fset.BoolVar(&opts.interpolate, "interpolate", true, "With -speed below 1 (slow motion), synthesize the frames in between instead of repeating each frame")
+	fset.BoolVar(&opts.stabilize, "stabilize", false, "Remove camera shake (e.g. a pole swaying in the wind) with an extra analysis pass")
+	fset.IntVar(&opts.stabilizeShakiness, "stabilize-shakiness", 5, "With -stabilize, how shaky the footage is from 1 (little) to 10 (very)")
+	fset.IntVar(&opts.stabilizeSmoothing, "stabilize-smoothing", 15, "With -stabilize, number of output frames before and after each frame used to smooth the camera path")
+	fset.BoolVar(&opts.deflicker, "deflicker", false, "Smooth out frame-to-frame brightness changes caused by camera exposure adjustments")
+	fset.IntVar(&opts.deflickerSize, "deflicker-size", 10, "Number of output frames averaged by -deflicker (2-129)")
+	fset.IntVar(&opts.blendFrames, "blend-frames", 0, "Blend each output frame with this many preceding frames to soften residual flicker (0 = disabled)")
+	fset.StringVar(&opts.denoise, "denoise", "", "Remove noise, e.g. the grain of night footage: hqdn3d (fast) or nlmeans (cleaner but slow)")
+	fset.Float64Var(&opts.denoiseStrength, "denoise-strength", 1, "With -denoise, how strongly noise is removed, from 0.1 to 10")
+	fset.Float64Var(&opts.sharpen, "sharpen", 0, "Sharpen the picture by this amount, from 0 (disabled) to 3")
+	fset.StringVar(&opts.lut, "lut", "", "Path to a .cube 3D LUT file to color-grade the footage with")
+	fset.Float64Var(&opts.brightness, "brightness", 0, "Brightness adjustment from -1 to 1 (0 = unchanged)")
+	fset.Float64Var(&opts.contrast, "contrast", 1, "Contrast multiplier from 0 to 3 (1 = unchanged)")
+	fset.Float64Var(&opts.saturation, "saturation", 1, "Color saturation multiplier from 0 (grayscale) to 3 (1 = unchanged)")
+	fset.BoolVar(&opts.chapters, "chapters", true, "Add a chapter marker at the start of each day when the footage spans several days")
+	fset.BoolVar(&opts.titleCards, "title-cards", false, "Insert a title card with the date between days when the footage spans several days")
+	fset.DurationVar(&opts.titleCardDuration, "title-card-duration", 2*time.Second, "How long each -title-cards card is shown")
+	fset.StringVar(&opts.subtitles, "subtitles", "", "Add subtitles showing the wall-clock time of the footage on screen: srt or vtt for a file next to the output, or mux for a subtitle track in the MP4")
+	fset.DurationVar(&opts.subtitleInterval, "subtitle-interval", time.Second, "With -subtitles, or -overlay-text showing {date} or {time}, how long each time is shown before it is updated")
+	fset.StringVar(&opts.youtubeChapters, "youtube-chapters", "", "Write a YouTube description next to the output, with chapter timestamps for each day or hour of footage to paste when uploading")
+	fset.StringVar(&opts.titleFont, "title-font", "", "Font file used for title cards, slates and end cards (default: ffmpeg's default font)")
+	fset.StringVar(&opts.intro, "intro", "", "Start the output with a card: slate for the camera name and dates, a line of text, or an image or video file")
+	fset.StringVar(&opts.outro, "outro", "", "End the output with a card: a line of text, or an image or video file")
+	fset.StringVar(&opts.overlayText, "overlay-text", "", "Draw this text on the footage; {camera}, {site}, {date}, {time}, {speed}, {fps}, {temperature} and {weather} are replaced, e.g. \"{camera} {date} {time} ({speed}x)\"")
+	fset.StringVar(&opts.overlayFont, "overlay-font", "", "Font file of -overlay-text (default: -title-font)")
+	fset.StringVar(&opts.overlaySize, "overlay-size", "h/24", "Font size of -overlay-text in pixels, or an expression of the frame height h")
+	fset.StringVar(&opts.overlayColor, "overlay-color", "white", "Color of -overlay-text, e.g. yellow or #ffcc00@0.8")
+	fset.StringVar(&opts.overlayPosition, "overlay-position", "bottom-left", "Position of -overlay-text: top-left, top, top-right, bottom-left, bottom, bottom-right or center")
+	fset.IntVar(&opts.overlayMargin, "overlay-margin", 20, "Distance in pixels between -overlay-text and the frame edges")
+	fset.StringVar(&opts.overlayBox, "overlay-box", "", "Draw a box of this color behind -overlay-text, e.g. black@0.5")
+	fset.StringVar(&opts.weatherURL, "weather-url", "", "Fetch the {temperature} and {weather} of -overlay-text from this Open-Meteo compatible endpoint (default: Open-Meteo's archive and forecast APIs)")
+	fset.StringVar(&opts.weatherUnits, "weather-units", weatherCelsius, "Unit of {temperature}: celsius or fahrenheit")
+	fset.StringVar(&opts.progressBar, "progress-bar", "", "Draw a bar of this color along the bottom showing how far through the recorded period the footage on screen is, with a tick at each day, e.g. white@0.8")
+	fset.IntVar(&opts.progressBarHeight, "progress-bar-height", 6, "Height of -progress-bar in pixels")
+	fset.DurationVar(&opts.slateDuration, "slate-duration", 3*time.Second, "How long -intro and -outro text and image cards are shown; videos play in full")
+	fset.Func("transition", "Cross-fade where the footage jumps in time, as name=seconds using an ffmpeg xfade transition, e.g. fade=0.5", func(s string) error {
+		t, err := parseTransition(s)
+		if err != nil {
+			return err
+		}
+		opts.transition = &t
+		return nil
+	})
+	fset.StringVar(&opts.watermark, "watermark", "", "Path to an image (e.g. a PNG logo) to overlay on the output")
+	fset.StringVar(&opts.watermarkPosition, "watermark-position", "bottom-right", "Watermark position: top-left, top-right, bottom-left, bottom-right, or center")
+	fset.IntVar(&opts.watermarkMargin, "watermark-margin", 20, "Distance in pixels between the watermark and the frame edges")
+	fset.Float64Var(&opts.watermarkOpacity, "watermark-opacity", 0.8, "Watermark opacity from 0 (invisible) to 1 (opaque)")
+	fset.BoolVar(&opts.adaptive, "adaptive", false, "Slow down to -active-speed during motion and use -speed for static periods")
+	fset.Float64Var(&opts.activeSpeed, "active-speed", 2.0, "Speedup factor used during detected motion with -adaptive")
+	fset.StringVar(&opts.static, "static", staticKeep, "What to do with long periods where the picture barely changes: keep, speed (play them -static-speedup times faster), or drop (show one frame of each)")
+	fset.Float64Var(&opts.staticThreshold, "static-threshold", 0.003, "Scene-change score (0 to 1) below which footage counts as static for -static")
+	fset.DurationVar(&opts.staticMin, "static-min", 10*time.Minute, "Shortest period of footage that -static speeds up or drops")
+	fset.Float64Var(&opts.staticSpeedup, "static-speedup", 10, "With -static speed, how many times faster than -speed static periods play")
+	fset.DurationVar(&opts.rampIn, "ramp-in", 0, "Ramp the speed up from -ramp-speed to -speed over this much of the start of the output, e.g. 10s")
+	fset.DurationVar(&opts.rampOut, "ramp-out", 0, "Ramp the speed down from -speed to -ramp-speed over this much of the end of the output")
+	fset.Float64Var(&opts.rampSpeed, "ramp-speed", 1.0, "Speedup factor at the start of -ramp-in and the end of -ramp-out")
+	fset.Var(&opts.speedAt, "speed-at", "Play the footage recorded within a daily window at its own speed, e.g. 06:00-09:00=20, or within a period, e.g. 2026-05-01 09:00-10:00=5; repeatable")
+	fset.StringVar(&opts.speedFile, "speed-file", "", "CSV file of start,end,speed rows playing footage at their own speed, like -speed-at")
+	fset.Float64Var(&opts.motionThreshold, "motion-threshold", 0.01, "Scene-change score (0-1) at which a segment counts as active with -adaptive")
+	fset.Float64Var(&opts.motionWindow, "motion-window", 30.0, "Length in seconds of source footage scored as one segment with -adaptive")
+}
+
+// prepareOptions validates the options set through fset and fills in the settings derived from them,
+// including the camera's settings from cfg.
+func prepareOptions(fset *flag.FlagSet, opts *options, cfg *config) error {
+	if !validSpeed(opts.speed) {
+		return fmt.Errorf("speed factor must be at least %.1f", minSpeedFactor)
+	}
+
+	if opts.format == formatHLS || opts.format == formatDASH {
+		opts.playlist, opts.format = opts.format, formatMP4
+	}
+	switch opts.format {
+	case formatMP4:
+	case formatGIF, formatWebP:
+		if opts.animWidth < 2 {
+			return fmt.Errorf("animation width must be at least 2 pixels")
+		}
+		if !isFlagSet(fset, "fps") {
+			opts.fps = defaultAnimationFPS
+		}
+		if !isFlagSet(fset, "o") {
+			opts.output = strings.TrimSuffix(defaultOutputTemplate, filepath.Ext(defaultOutputTemplate)) + "." + opts.format
+		}
+		// Animated images are always encoded in software
+		opts.useGPU = false
+	case formatFrames:
+		if opts.frameFormat != frameFormatJPEG && opts.frameFormat != frameFormatPNG {
+			return fmt.Errorf("unknown frame format %q (use jpg or png)", opts.frameFormat)
+		}
+		if !isFlagSet(fset, "o") {
+			// The output is a directory
+			opts.output = strings.TrimSuffix(defaultOutputTemplate, filepath.Ext(defaultOutputTemplate))
+		}
+		if opts.thumbnail || opts.preview != "" {
+			return fmt.Errorf("-thumbnail and -preview cannot be used with -format frames")
+		}
+		opts.useGPU = false
+	default:
+		return fmt.Errorf("unknown format %q (use mp4, hls, dash, gif, webp, or frames)", opts.format)
+	}
+	switch opts.bitDepth {
+	case bitDepth8:
+	case bitDepth10, bitDepthAuto:
+		if opts.format != formatMP4 {
+			return fmt.Errorf("-bit-depth %s only applies to mp4 output", opts.bitDepth)
+		}
+	default:
+		return fmt.Errorf("unknown bit depth %q (use 8, 10, or auto)", opts.bitDepth)
+	}
+	switch opts.priority {
+	case priorityNormal, priorityLow, priorityIdle:
+	default:
+		return fmt.Errorf("unknown priority %q (use normal, low, or idle)", opts.priority)
+	}
+	if opts.threads < 0 {
+		return fmt.Errorf("threads must not be negative")
+	}
+	if opts.nvencSessions < 0 {
+		return fmt.Errorf("NVENC sessions must not be negative")
+	}
+	if opts.gpuIndex != "" {
+		if n, err := strconv.Atoi(opts.gpuIndex); opts.gpuIndex != gpuIndexAuto && (err != nil || n < 0) {
+			return fmt.Errorf("invalid GPU index %q (use a number from nvidia-smi -L, or auto)", opts.gpuIndex)
+		}
+		if !opts.useGPU {
+			return fmt.Errorf("-gpu-index only applies to GPU encoding")
+		}
+	}
+	if opts.keyint < 0 {
+		return fmt.Errorf("keyframe interval must not be negative")
+	}
+	if opts.keyint > 0 && opts.format != formatMP4 {
+		return fmt.Errorf("-keyint only applies to mp4 output")
+	}
+	if opts.twoPass {
+		if opts.format != formatMP4 {
+			return fmt.Errorf("-two-pass only applies to mp4 output")
+		}
+		if (opts.bitrate == "") == (opts.targetSize == "") {
+			return fmt.Errorf("-two-pass needs either -bitrate or -target-size")
+		}
+		if opts.bitrate != "" && !bitratePattern.MatchString(opts.bitrate) {
+			return fmt.Errorf("invalid bitrate %q (use e.g. 8M or 2500k)", opts.bitrate)
+		}
+		if opts.targetSize != "" {
+			if _, err := parseByteSize(opts.targetSize); err != nil {
+				return fmt.Errorf("target size: %w", err)
+			}
+		}
+		// Two-pass encoding is an x264 and x265 feature
+		opts.useGPU = false
+	} else if opts.bitrate != "" || opts.targetSize != "" {
+		return fmt.Errorf("-bitrate and -target-size need -two-pass")
+	}
+	if _, err := namingFor(opts.source, opts.dateOrder); err != nil {
+		return err
+	}
+	switch opts.cleanup {
+	case cleanupKeep, cleanupMove, cleanupDelete:
+	default:
+		return fmt.Errorf("unknown cleanup %q (use keep, move, or delete)", opts.cleanup)
+	}
+	if opts.cleanup != cleanupKeep && (!opts.verify || opts.format == formatFrames || opts.format == formatWebP) {
+		return fmt.Errorf("-cleanup needs -verify and an mp4 or gif output, which can be verified")
+	}
+	if opts.keepDays < 0 {
+		return fmt.Errorf("keep days cannot be negative")
+	}
+	if opts.incremental && opts.db == "" {
+		return fmt.Errorf("-incremental needs -db")
+	}
+	if opts.sample < 0 || opts.limit < 0 {
+		return fmt.Errorf("-sample and -limit must not be negative")
+	}
+	if opts.sample > 1 || opts.limit > 0 {
+		// A preview leaves out clips, which must not count as merged nor be cleaned up, and the
+		// cameras of a layout are lined up by time
+		if opts.incremental || opts.cleanup != cleanupKeep || len(opts.withCameras) > 0 {
+			return fmt.Errorf("-sample and -limit cannot be combined with -incremental, -cleanup or -layout")
+		}
+	}
+	if opts.draftEnds < 0 {
+		return fmt.Errorf("-draft-ends must not be negative")
+	}
+	if opts.draftEnds > 0 && !opts.draft {
+		return fmt.Errorf("-draft-ends needs -draft")
+	}
+	if opts.draft {
+		// A draft must not count as merged nor have its clips cleaned up
+		if opts.incremental || opts.cleanup != cleanupKeep {
+			return fmt.Errorf("-draft cannot be combined with -incremental or -cleanup")
+		}
+		if opts.draftEnds > 0 && len(opts.withCameras) > 0 {
+			return fmt.Errorf("-draft-ends cannot be combined with -layout")
+		}
+		if opts.format != formatMP4 {
+			return fmt.Errorf("-draft applies to MP4 outputs")
+		}
+	}
+	if opts.images && opts.source != sourceUniFi {
+		return fmt.Errorf("-source does not apply to -images")
+	}
+
+	if err := checkSiteName(opts.site); err != nil {
+		return err
+	}
+	if opts.site != "" && !isFlagSet(fset, "o") {
+		// Outputs of cameras with the same name at different sites would overwrite each other
+		opts.output = "{site}_" + opts.output
+	}
+
+	if opts.images {
+		// Every image is one frame, so the output's pace is set by -fps alone
+		if isFlagSet(fset, "speed") {
+			return fmt.Errorf("-speed does not apply to -images; use -fps to set how many images are shown per second")
+		}
+		if opts.adaptive || opts.minClipDuration > 0 {
+			return fmt.Errorf("-adaptive and -min-clip-duration cannot be used with -images")
+		}
+		opts.speed = 1
+	}
+
+	if opts.fps <= 0 || opts.fps > maxOutputFPS {
+		return fmt.Errorf("fps must be greater than 0 and at most %.0f", maxOutputFPS)
+	}
+
+	if opts.hours != "" {
+		w, err := parseDailyWindow(opts.hours)
+		if err != nil {
+			return fmt.Errorf("invalid -hours: %w", err)
+		}
+		opts.spansFor = w.spans
+	}
+
+	if opts.daylightOnly {
+		if opts.hours != "" {
+			return fmt.Errorf("-hours and -daylight-only cannot be combined")
+		}
+		if !isFlagSet(fset, "lat") || !isFlagSet(fset, "lon") {
+			return fmt.Errorf("-daylight-only requires -lat and -lon (on the command line or in the config file)")
+		}
+		if opts.lat < -90 || opts.lat > 90 || opts.lon < -180 || opts.lon > 180 {
+			return fmt.Errorf("latitude must be between -90 and 90 and longitude between -180 and 180")
+		}
+		opts.spansFor = daylightSpans(opts.lat, opts.lon, opts.daylightMargin)
+	}
+
+	if opts.dailyAt != "" {
+		if opts.spansFor != nil {
+			return fmt.Errorf("-daily-at cannot be combined with -hours or -daylight-only")
+		}
+		at, err := parseTimeOfDay(opts.dailyAt)
+		if err != nil || at >= 24*time.Hour {
+			return fmt.Errorf("invalid -daily-at %q: expected HH:MM", opts.dailyAt)
+		}
+		if opts.dailyLength < 0 || opts.dailyLength >= 24*time.Hour {
+			return fmt.Errorf("daily length must be between 0 and 24h")
+		}
+		if opts.cleanup != cleanupKeep {
+			return fmt.Errorf("-cleanup does not apply to -daily-at, which leaves out most of each day")
+		}
+		opts.dailyTime = at
+		switch {
+		case opts.images:
+			// Images are picked one per day in merge, as the closest to the time rarely falls on it exactly
+			if opts.dailyLength > 0 {
+				return fmt.Errorf("-daily-length does not apply to -images, of which the one closest to -daily-at is taken")
+			}
+		case opts.dailyLength > 0:
+			opts.spansFor = dailyWindow{start: at, end: at + opts.dailyLength}.spans
+		default:
+			// Take one second of each day and speed it up into a single output frame
+			if isFlagSet(fset, "speed") {
+				return fmt.Errorf("-speed does not apply to -daily-at without -daily-length, which shows one frame per day")
+			}
+			opts.speed = opts.fps
+			opts.spansFor = dailyWindow{start: at, end: at + time.Second}.spans
+		}
+	}
+
+	switch opts.mode {
+	case modeTimelapse:
+	case modeHyperlapse:
+		if opts.images {
+			return fmt.Errorf("-mode hyperlapse does not apply to -images, which already show one frame each")
+		}
+		if isFlagSet(fset, "speed") {
+			return fmt.Errorf("-speed does not apply to -mode hyperlapse; use -fps to set how many clips are shown per second")
+		}
+		if opts.adaptive || opts.layout != "" {
+			return fmt.Errorf("-mode hyperlapse cannot be combined with -adaptive or -layout")
+		}
+		if opts.cleanup != cleanupKeep {
+			return fmt.Errorf("-cleanup does not apply to -mode hyperlapse, which keeps one frame of each clip")
+		}
+		if opts.hyperlapseFrame != hyperlapseFirst && opts.hyperlapseFrame != hyperlapseSharpest {
+			return fmt.Errorf("unknown hyperlapse frame %q (use first or sharpest)", opts.hyperlapseFrame)
+		}
+		// The frames are shown like -images
+		opts.speed = 1
+	case modeHighlights:
+		if opts.images {
+			return fmt.Errorf("-mode highlights does not apply to -images")
+		}
+		if isFlagSet(fset, "speed") {
+			return fmt.Errorf("-speed does not apply to -mode highlights, which shows its moments at normal speed")
+		}
+		if opts.adaptive || opts.layout != "" {
+			return fmt.Errorf("-mode highlights cannot be combined with -adaptive or -layout")
+		}
+		if opts.cleanup != cleanupKeep {
+			return fmt.Errorf("-cleanup does not apply to -mode highlights, which leaves out most of the footage")
+		}
+		if opts.highlightCount < 1 {
+			return fmt.Errorf("highlights must be at least 1")
+		}
+		if opts.highlightLength <= 0 {
+			return fmt.Errorf("highlight length must be greater than 0")
+		}
+		opts.speed = 1
+	default:
+		return fmt.Errorf("unknown mode %q (use timelapse, hyperlapse, or highlights)", opts.mode)
+	}
+
+	opts.aliases = cfg.aliases
+	if err := resolveCameras(opts); err != nil {
+		return err
+	}
+	var err error
+	camCfg := cfg.cameraSettings(opts.cameraName)
+	opts.privacyMasks, err = parsePrivacyMasks(camCfg.PrivacyMasks)
+	if err != nil {
+		return fmt.Errorf("camera %s in config %s: %w", opts.cameraName, cfg.path, err)
+	}
+
+	switch opts.layout {
+	case "":
+		if len(opts.withCameras) > 0 {
+			return fmt.Errorf("-with needs -layout")
+		}
+	case layoutGrid, layoutHStack, layoutVStack, layoutPiP:
+		if len(opts.withCameras) == 0 {
+			return fmt.Errorf("-layout %s needs the other cameras in -with", opts.layout)
+		}
+		if opts.concatStdin {
+			// Each camera is read from a list of its own, and ffmpeg has only one standard input
+			return fmt.Errorf("-concat-stdin cannot be combined with -layout")
+		}
+		if opts.images || opts.adaptive || opts.stabilize || opts.minLuma > 0 || opts.crop != nil || opts.rotate != 0 || opts.transition != nil || opts.titleCards {
+			return fmt.Errorf("-layout cannot be combined with -images, -adaptive, -stabilize, -min-luma, -crop, -rotate, -transition or -title-cards")
+		}
+		if opts.gapFill != gapFillFreeze && opts.gapFill != gapFillBlack && opts.gapFill != gapFillSlate {
+			return fmt.Errorf("unknown gap fill %q (use freeze, black, or slate)", opts.gapFill)
+		}
+		if opts.tileSize.w == 0 {
+			opts.tileSize = rect{w: 960, h: 540, centered: true}
+			if opts.layout == layoutPiP {
+				opts.tileSize = rect{w: 1920, h: 1080, centered: true}
+			}
+		}
+		if opts.layout == layoutPiP {
+			if opts.insetSize < 0.05 || opts.insetSize > 0.9 {
+				return fmt.Errorf("inset size must be between 0.05 and 0.9")
+			}
+			if !slices.Contains(insetCorners, opts.insetPosition) {
+				return fmt.Errorf("unknown inset position %q (use %s)", opts.insetPosition, strings.Join(insetCorners, ", "))
+			}
+			if opts.insetMargin < 0 {
+				return fmt.Errorf("inset margin must not be negative")
+			}
+			if _, h := insetBox(*opts); len(opts.withCameras)*(h+opts.insetMargin)+opts.insetMargin > opts.tileSize.h {
+				return fmt.Errorf("%d insets of size %g do not fit above each other; lower -inset-size", len(opts.withCameras), opts.insetSize)
+			}
+		}
+		opts.withMasks = make([][]privacyMask, len(opts.withCameras))
+		for i, name := range opts.withCameras {
+			opts.withMasks[i], err = parsePrivacyMasks(cfg.cameraSettings(name).PrivacyMasks)
+			if err != nil {
+				return fmt.Errorf("camera %s in config %s: %w", name, cfg.path, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown layout %q (use grid, hstack, vstack, or pip)", opts.layout)
+	}
+
+	switch opts.versioning {
+	case versioningOff, versioningNumber, versioningTimestamp:
+	default:
+		return fmt.Errorf("unknown versioning mode %q (use off, number, or timestamp)", opts.versioning)
+	}
+	if opts.force && opts.versioning != versioningOff {
+		return fmt.Errorf("-force and -versioning cannot be combined")
+	}
+
+	opts.excludeMatchers, err = compileExcludes(opts.excludes)
+	if err != nil {
+		return err
+	}
+
+	switch opts.preview {
+	case "", formatGIF, formatWebP:
+	default:
+		return fmt.Errorf("unknown preview format %q (use gif or webp)", opts.preview)
+	}
+	if opts.preview != "" && opts.previewDuration <= 0 {
+		return fmt.Errorf("preview duration must be greater than 0")
+	}
+
+	if opts.retries < 0 {
+		return fmt.Errorf("retries must not be negative")
+	}
+	if opts.retryDelay < 0 {
+		return fmt.Errorf("retry delay must not be negative")
+	}
+
+	if opts.minClipDuration < 0 {
+		return fmt.Errorf("min clip duration must not be negative")
+	}
+
+	if opts.trimStart < 0 || opts.trimEnd < 0 {
+		return fmt.Errorf("trim durations must not be negative")
+	}
+	if opts.images && (opts.trimStart > 0 || opts.trimEnd > 0) {
+		return fmt.Errorf("-trim-start and -trim-end do not apply to -images")
+	}
+
+	if opts.irThreshold < 0 {
+		return fmt.Errorf("IR threshold must not be negative")
+	}
+	if opts.irSpeed != 0 || opts.irTint != "" {
+		if opts.irExclude {
+			return fmt.Errorf("-ir-exclude leaves no infrared footage for -ir-speed or -ir-tint")
+		}
+		if opts.layout != "" || opts.mode != modeTimelapse {
+			return fmt.Errorf("-ir-speed and -ir-tint cannot be combined with -layout or -mode hyperlapse or highlights")
+		}
+		if opts.irSpeed != 0 && !validSpeed(opts.irSpeed) {
+			return fmt.Errorf("IR speed factor must be at least %.1f", minSpeedFactor)
+		}
+		if opts.irTint != "" {
+			if _, _, _, err := parseTint(opts.irTint); err != nil {
+				return fmt.Errorf("invalid -ir-tint: %w", err)
+			}
+		}
+	}
+
+	if opts.minLuma < 0 || opts.minLuma > 255 {
+		return fmt.Errorf("min luma must be between 0 and 255")
+	}
+
+	if opts.smooth {
+		if opts.smoothFactor < 2 || opts.smoothFactor > 8 {
+			return fmt.Errorf("smooth factor must be between 2 and 8")
+		}
+		if opts.speed < 1 {
+			return fmt.Errorf("-smooth is for speedups; slow motion is interpolated with -interpolate")
+		}
+	}
+	if opts.smoothMode != "mci" && opts.smoothMode != "blend" {
+		return fmt.Errorf("unknown smooth mode %q (use mci or blend)", opts.smoothMode)
+	}
+
+	if opts.stabilize {
+		if opts.stabilizeShakiness < 1 || opts.stabilizeShakiness > 10 {
+			return fmt.Errorf("stabilize shakiness must be between 1 and 10")
+		}
+		if opts.stabilizeSmoothing < 0 {
+			return fmt.Errorf("stabilize smoothing must not be negative")
+		}
+	}
+
+	if opts.deflicker && (opts.deflickerSize < 2 || opts.deflickerSize > 129) {
+		return fmt.Errorf("deflicker size must be between 2 and 129 frames")
+	}
+
+	if opts.blendFrames < 0 || opts.blendFrames > 16 {
+		return fmt.Errorf("blend frames must be between 0 and 16")
+	}
+
+	switch opts.denoise {
+	case "":
+	case denoiseHQDN3D, denoiseNLMeans:
+		if opts.denoiseStrength < 0.1 || opts.denoiseStrength > 10 {
+			return fmt.Errorf("denoise strength must be between 0.1 and 10")
+		}
+	default:
+		return fmt.Errorf("unknown denoise filter %q (use hqdn3d or nlmeans)", opts.denoise)
+	}
+	if opts.sharpen < 0 || opts.sharpen > 3 {
+		return fmt.Errorf("sharpen amount must be between 0 and 3")
+	}
+
+	if opts.lut != "" {
+		if _, err := os.Stat(opts.lut); err != nil {
+			return fmt.Errorf("LUT file: %w", err)
+		}
+		if !strings.EqualFold(filepath.Ext(opts.lut), ".cube") {
+			return fmt.Errorf("LUT file %s must be a .cube file", opts.lut)
+		}
+	}
+	if opts.brightness < -1 || opts.brightness > 1 {
+		return fmt.Errorf("brightness must be between -1 and 1")
+	}
+	if opts.contrast < 0 || opts.contrast > 3 {
+		return fmt.Errorf("contrast must be between 0 and 3")
+	}
+	if opts.saturation < 0 || opts.saturation > 3 {
+		return fmt.Errorf("saturation must be between 0 and 3")
+	}
+
+	if opts.titleCards && opts.titleCardDuration <= 0 {
+		return fmt.Errorf("title card duration must be greater than 0")
+	}
+	if opts.intro != "" || opts.outro != "" {
+		if opts.slateDuration <= 0 {
+			return fmt.Errorf("slate duration must be greater than 0")
+		}
+		if err := checkSlate("intro", opts.intro); err != nil {
+			return err
+		}
+		if err := checkSlate("outro", opts.outro); err != nil {
+			return err
+		}
+	}
+
+	switch opts.subtitles {
+	case "":
+	case subtitlesSRT, subtitlesVTT, subtitlesMux:
+		if opts.minLuma > 0 {
+			return fmt.Errorf("-subtitles cannot be combined with -min-luma, which drops frames from the timeline")
+		}
+		if opts.format == formatFrames || (opts.subtitles == subtitlesMux && opts.format != formatMP4) {
+			return fmt.Errorf("-subtitles %s does not apply to %s output", opts.subtitles, opts.format)
+		}
+		if opts.subtitleInterval <= 0 {
+			return fmt.Errorf("subtitle interval must be greater than 0")
+		}
+	default:
+		return fmt.Errorf("unknown subtitles format %q (use srt, vtt, or mux)", opts.subtitles)
+	}
+
+	if opts.overlayText != "" {
+		if err := checkOverlayText(opts.overlayText); err != nil {
+			return err
+		}
+		if _, ok := overlayPositions[opts.overlayPosition]; !ok {
+			return fmt.Errorf("unknown overlay position %q", opts.overlayPosition)
+		}
+		if opts.overlayMargin < 0 {
+			return fmt.Errorf("overlay margin must not be negative")
+		}
+		if isTimedOverlay(opts.overlayText) {
+			if opts.minLuma > 0 {
+				return fmt.Errorf("-overlay-text with {date}, {time} or the weather cannot be combined with -min-luma, which drops frames from the timeline")
+			}
+			if opts.subtitleInterval <= 0 {
+				return fmt.Errorf("subtitle interval must be greater than 0")
+			}
+		}
+		if usesWeather(opts.overlayText) {
+			if !isFlagSet(fset, "lat") || !isFlagSet(fset, "lon") {
+				return fmt.Errorf("-overlay-text with {temperature} or {weather} requires -lat and -lon (on the command line or in the config file)")
+			}
+			if opts.lat < -90 || opts.lat > 90 || opts.lon < -180 || opts.lon > 180 {
+				return fmt.Errorf("latitude must be between -90 and 90 and longitude between -180 and 180")
+			}
+		}
+	}
+	if opts.progressBar != "" {
+		if opts.progressBarHeight <= 0 {
+			return fmt.Errorf("progress bar height must be greater than 0")
+		}
+		if opts.minLuma > 0 {
+			return fmt.Errorf("-progress-bar cannot be combined with -min-luma, which drops frames from the timeline")
+		}
+	}
+	switch opts.weatherUnits {
+	case weatherCelsius, weatherFahrenheit:
+	default:
+		return fmt.Errorf("unknown weather units %q (use celsius or fahrenheit)", opts.weatherUnits)
+	}
+
+	switch opts.youtubeChapters {
+	case "":
+	case youtubeChaptersDay, youtubeChaptersHour:
+		if opts.format != formatMP4 {
+			return fmt.Errorf("-youtube-chapters only applies to mp4 output")
+		}
+	default:
+		return fmt.Errorf("unknown YouTube chapters %q (use day or hour)", opts.youtubeChapters)
+	}
+
+	if opts.watermark != "" {
+		if _, err := os.Stat(opts.watermark); err != nil {
+			return fmt.Errorf("watermark image: %w", err)
+		}
+		if _, ok := watermarkPositions[opts.watermarkPosition]; !ok {
+			return fmt.Errorf("unknown watermark position %q", opts.watermarkPosition)
+		}
+		if opts.watermarkOpacity < 0 || opts.watermarkOpacity > 1 {
+			return fmt.Errorf("watermark opacity must be between 0 and 1")
+		}
+		if opts.watermarkMargin < 0 {
+			return fmt.Errorf("watermark margin must not be negative")
+		}
+	}
+
+	if opts.adaptive {
+		if !validSpeed(opts.activeSpeed) {
+			return fmt.Errorf("active speed factor must be at least %.1f", minSpeedFactor)
+		}
+		if opts.motionThreshold < 0 || opts.motionThreshold > 1 {
+			return fmt.Errorf("motion threshold must be between 0 and 1")
+		}
+		if opts.motionWindow <= 0 {
+			return fmt.Errorf("motion window must be greater than 0")
+		}
+	}
+
+	switch opts.static {
+	case staticKeep:
+	case staticSpeed, staticDrop:
+		if opts.layout != "" || opts.mode != modeTimelapse {
+			return fmt.Errorf("-static cannot be combined with -layout or -mode hyperlapse or highlights")
+		}
+		if opts.staticThreshold <= 0 || opts.staticThreshold > 1 {
+			return fmt.Errorf("static threshold must be greater than 0 and at most 1")
+		}
+		if opts.staticMin <= 0 {
+			return fmt.Errorf("static min must be greater than 0")
+		}
+		if opts.staticSpeedup < 1 {
+			return fmt.Errorf("static speedup must be at least 1")
+		}
+	default:
+		return fmt.Errorf("unknown static handling %q (use keep, speed, or drop)", opts.static)
+	}
+
+	if opts.rampIn < 0 || opts.rampOut < 0 {
+		return fmt.Errorf("ramp durations must not be negative")
+	}
+	if !validSpeed(opts.rampSpeed) {
+		return fmt.Errorf("ramp speed factor must be at least %.1f", minSpeedFactor)
+	}
+	opts.windowSpeeds = nil
+	for _, s := range opts.speedAt {
+		ws, err := parseWindowSpeed(s)
+		if err != nil {
+			return fmt.Errorf("invalid -speed-at: %w", err)
+		}
+		opts.windowSpeeds = append(opts.windowSpeeds, ws)
+	}
+	if opts.speedFile != "" {
+		speeds, err := readSpeedFile(opts.speedFile)
+		if err != nil {
+			return fmt.Errorf("reading -speed-file %s: %w", opts.speedFile, err)
+		}
+		opts.windowSpeeds = append(opts.windowSpeeds, speeds...)
+	}
+	if opts.hasSpeedProfile() && (opts.adaptive || opts.layout != "" || opts.mode != modeTimelapse) {
+		return fmt.Errorf("-ramp-in, -ramp-out, -speed-at and -speed-file cannot be combined with -adaptive, -layout or -mode hyperlapse or highlights")
+	}
+
+	opts.notifications = cfg.notifications
+	opts.upload = cfg.upload
+	if opts.upload.YouTube != nil && opts.format != formatMP4 {
+		return fmt.Errorf("only mp4 outputs can be uploaded to YouTube, not %s", opts.format)
+	}
+	if opts.upload.any() && opts.format == formatFrames {
+		return fmt.Errorf("-format frames writes a directory, which cannot be uploaded")
+	}
+
+	switch opts.export {
+	case "":
+		if opts.exportOnly {
+			return fmt.Errorf("-export-only needs -export")
+		}
+	case exportEDL, exportFCPXML:
+		if opts.images || opts.mode != modeTimelapse || opts.adaptive || opts.layout != "" || opts.hasSpeedProfile() || opts.static != staticKeep {
+			return fmt.Errorf("-export cannot be combined with -images, -mode hyperlapse or highlights, -adaptive, -layout, -static or a speed profile")
+		}
+		if opts.exportOnly && (opts.upload.any() || opts.cleanup != cleanupKeep || opts.thumbnail || opts.preview != "") {
+			return fmt.Errorf("-export-only writes no output to upload, clean up after, or make previews of")
+		}
+	default:
+		return fmt.Errorf("unknown export format %q (use edl or fcpxml)", opts.export)
+	}
+
+	if isStreamOutput(opts.output) {
+		if opts.format != formatMP4 {
+			return fmt.Errorf("-o %s needs -format mp4", opts.output)
+		}
+		if opts.upload.any() || opts.cleanup != cleanupKeep || opts.thumbnail || opts.preview != "" ||
+			opts.export != "" || opts.subtitles != "" || opts.youtubeChapters != "" || opts.playlist != "" {
+			return fmt.Errorf("-o %s writes no file to upload, clean up after, make previews or playlists of, or write subtitles, projects or descriptions next to", opts.output)
+		}
+		if opts.twoPass && isNetworkOutput(opts.output) {
+			return fmt.Errorf("-two-pass cannot stream to %s, as the first pass would be held to real time too", opts.output)
+		}
+		// A stream cannot be read back
+		opts.verify = false
+	}
+
+	opts.streamCopy = canStreamCopy(*opts, isFlagSet(fset, "fps"))
+	return nil
+}
+
+// mergeInfo describes the timelapse written by merge.
+type mergeInfo struct {
+	output string
+	// vars holds the output template values of the merged footage, e.g. its date range.
+	vars     map[string]string
+	segments []segment
+	// sources are the segments of the footage merged, before -mode hyperlapse replaced them with
+	// frames, and including the other cameras of a -layout.
+	sources []segment
+	// duration is the expected output length in seconds, or 0 if it was not measured.
+	duration float64
+	// youtubeChapters lists the -youtube-chapters chapters for the description of YouTube uploads.
+	youtubeChapters string
+}
+
+// merge finds the camera's clips and builds the timelapse described by opts.
+func merge(opts options) (info *mergeInfo, err error) {
+	// Make sure ffmpeg can do everything the options ask for before doing any work
+	opts.report("checking ffmpeg", 0)
+	version, err := preflightFFmpeg(opts)
+	if err != nil {
+		return nil, withExitCode(exitFFmpeg, err)
+	}
+	fmt.Fprintf(opts.stdout, "Using %s\n", version)
+
+	// Keep overlapping runs for the same camera from clobbering each other's files
+	lock, err := acquireCameraLock(strings.TrimSpace(opts.site + " " + opts.cameraName))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	opts.inputDirs = inputDirsFor(opts)
+
+	// Find all matching video files, or images with -images
+	naming, err := namingFor(opts.source, opts.dateOrder)
+	if err != nil {
+		return nil, err
+	}
+	clips, err := findCameraClips(opts, naming, opts.cameraName)
+	if err != nil {
+		return nil, err
+	}
+	if opts.db != "" {
+		db, err := openClipDB(opts.db, opts.sqlitePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.recordClips(opts.cameraName, clips); err != nil {
+			return nil, fmt.Errorf("recording clips in %s: %w", opts.db, err)
+		}
+		if opts.incremental {
+			if clips, err = db.unmergedClips(clips); err != nil {
+				return nil, fmt.Errorf("reading %s: %w", opts.db, err)
+			}
+			if len(clips) == 0 {
+				return nil, withExitCode(exitNoFootage, fmt.Errorf("no new footage for camera %s since the last merge", opts.cameraName))
+			}
+			fmt.Fprintf(opts.stdout, "Kept %d clip(s) not merged before\n", len(clips))
+		}
+	}
+	if opts.images && opts.dailyAt != "" {
+		clips = closestDailyImages(clips, opts.dailyTime)
+		fmt.Fprintf(opts.stdout, "Kept %d image(s), the closest to %s on each day\n", len(clips), opts.dailyAt)
+	}
+	if opts.sample > 1 || opts.limit > 0 {
+		clips = sampleClips(clips, opts.sample, opts.limit)
+		fmt.Fprintf(opts.stdout, "Kept %d clip(s) for a preview with -sample and -limit\n", len(clips))
+	}
+	if opts.draftEnds > 0 {
+		clips = endClips(clips, opts.draftEnds)
+		fmt.Fprintf(opts.stdout, "Kept %d clip(s) recorded within %s of the start and the end for the draft\n", len(clips), opts.draftEnds)
+	}
+	opts.record(measureClips, float64(len(clips)))
+	opts.record(measureGaps, float64(len(findGaps(clips, summaryMinGap))))
+
+	// Limit each clip to the footage recorded inside the daily window
+	segments := wholeClipSegments(clips)
+	if opts.spansFor != nil {
+		segments = planSegments(clips, opts.spansFor)
+		if len(segments) == 0 {
+			return nil, withExitCode(exitNoFootage, fmt.Errorf("no footage for camera %s falls within the selected hours", opts.cameraName))
+		}
+		fmt.Fprintf(opts.stdout, "Kept %d segment(s) recorded within the selected hours\n", len(segments))
+	}
+	if opts.images {
+		for i := range segments {
+			segments[i].duration = imageDuration(opts)
+		}
+	}
+	if opts.trimStart > 0 || opts.trimEnd > 0 {
+		segments, err = trimSegments(segments, opts.trimStart, opts.trimEnd, ffprobePath(opts.ffmpegPath))
+		if err != nil {
+			return nil, err
+		}
+		if len(segments) == 0 {
+			return nil, withExitCode(exitNoFootage, fmt.Errorf("no footage of camera %s is left after trimming the clips", opts.cameraName))
+		}
+	}
+
+	// Tell the infrared night footage from the colour daytime footage
+	var irSegments []bool
+	if opts.irExclude || opts.irSpeed != 0 || opts.irTint != "" {
+		fmt.Fprintln(opts.stdout, "Looking for infrared (grayscale) footage...")
+		opts.report("detecting infrared footage", 0)
+		durations, err := segmentDurations(ffprobePath(opts.ffmpegPath), segments)
+		if err != nil {
+			return nil, fmt.Errorf("measuring segments: %w", err)
+		}
+		irSegments, err = detectIR(opts, segments, durations, opts.irThreshold)
+		if err != nil {
+			return nil, err
+		}
+		var count int
+		kept := segments[:0:0]
+		for i, ir := range irSegments {
+			if ir {
+				count++
+			} else {
+				kept = append(kept, segments[i])
+			}
+		}
+		fmt.Fprintf(opts.stdout, "Found %d of %d segment(s) in infrared\n", count, len(segments))
+		if opts.irExclude {
+			segments, irSegments = kept, nil
+			if len(segments) == 0 {
+				return nil, fmt.Errorf("all footage of camera %s is infrared", opts.cameraName)
+			}
+		}
+	}
+
+	// Keep the depth of 10-bit footage, which stream copies keep anyway
+	if opts.bitDepth == bitDepthAuto {
+		opts.bitDepth = bitDepth8
+		if !opts.images && !opts.streamCopy && !opts.exportOnly {
+			depth, err := probeBitDepth(ffprobePath(opts.ffmpegPath), segments[0].path)
+			if err != nil {
+				return nil, err
+			}
+			if depth > 8 {
+				fmt.Fprintf(opts.stdout, "Footage is %d-bit; encoding 10-bit HEVC\n", depth)
+				opts.bitDepth = bitDepth10
+				if err := preflightEncoder(opts); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	opts.record(measureBytes, float64(footageSize(segments)))
+	sources := segments
+
+	vars := outputTemplateVars(opts, segments)
+	outputFile, err := expandOutputTemplate(opts.output, vars)
+	if err != nil {
+		return nil, err
+	}
+	streaming := isStreamOutput(outputFile)
+	if streaming {
+		fmt.Fprintf(opts.stdout, "Streaming the output to %s as it is encoded\n", streamTarget(outputFile))
+	} else if err := prepareOutputDir(outputFile); err != nil {
+		return nil, fmt.Errorf("creating output directory: %w", err)
+	}
+	// With -export-only the output is only used to name the project, which is replaced like other files written next to it
+	if !opts.exportOnly && !streaming {
+		outputFile, err = resolveOutputFile(outputFile, opts.force, opts.versioning, opts.interactive)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.format == formatFrames && !opts.exportOnly {
+		if err := prepareFrameDir(outputFile, opts.stdout); err != nil {
+			return nil, fmt.Errorf("preparing frame directory: %w", err)
+		}
 	}
-	flag.Parse()
 
-	if *cameraName == "" {
-		fmt.Fprintf(os.Stderr, "Error: -camera flag is required\n\n")
-		flag.Usage()
-		os.Exit(1)
+	// Fail fast rather than running out of space hours into the encode
+	if !opts.skipSpace && !opts.exportOnly && !streaming {
+		need := estimateOutputSize(segments, clips, opts)
+		if err := checkFreeSpace(outputFile, need); err != nil {
+			return nil, withExitCode(exitNoSpace, err)
+		}
+		fmt.Fprintf(opts.stdout, "Estimated output size: up to %s\n", formatBytes(uint64(need)))
+	}
+
+	// Keep temporary files in a directory of their own so concurrent runs never share them
+	ws, err := newWorkspace(opts, opts.cameraName)
+	if err != nil {
+		return nil, err
 	}
+	defer func() { ws.remove(opts, err) }()
+	workDir := ws.dir
+	opts.stdout, opts.stderr = ws.logged(opts.stdout), ws.logged(opts.stderr)
 
-	if *speed < minSpeedFactor || *speed > maxSpeedFactor {
-		exitWithError("speed factor must be between %.1f and %.1f", minSpeedFactor, maxSpeedFactor)
+	// Make sure the concat demuxer can join the clips, remembering them for the editor project
+	originals := segments
+	if opts.conform && !opts.images && !opts.exportOnly && opts.mode != modeHyperlapse {
+		opts.report("checking clip formats", 0)
+		segments, err = conformSegments(opts, segments, workDir, "conformed")
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	outputFile := fmt.Sprintf("%s_merged_timelapse.mp4", sanitizeFilename(*cameraName))
+	if opts.mode == modeHyperlapse {
+		fmt.Fprintf(opts.stdout, "Extracting the %s frame of %d segment(s)...\n", opts.hyperlapseFrame, len(segments))
+		opts.report("extracting frames", 0)
+		segments, err = extractHyperlapseFrames(opts, segments, workDir)
+		if err != nil {
+			return nil, err
+		}
+		// From here on the frames are encoded like -images
+		opts.images = true
+	}
 
-	// Find all matching video files
-	files, err := findVideoFiles(*cameraName)
+	// Create the inputs file
+	inputs, err := newConcatInput(opts, segments, workDir)
 	if err != nil {
-		exitWithError("finding video files: %v", err)
+		return nil, fmt.Errorf("creating inputs file: %w", err)
 	}
 
-	if len(files) == 0 {
-		exitWithError("no video files found for camera: %s", *cameraName)
+	fmt.Fprintf(opts.stdout, "Created %s with %d segment(s)\n", inputs, len(segments))
+
+	// Cut the most active moments out of the footage
+	if opts.mode == modeHighlights {
+		fmt.Fprintln(opts.stdout, "Finding the most active moments (keyframes only)...")
+		opts.report("analyzing motion", 0)
+		durations, err := segmentDurations(ffprobePath(opts.ffmpegPath), segments)
+		if err != nil {
+			return nil, fmt.Errorf("measuring segments: %w", err)
+		}
+		samples, err := analyzeMotion(opts, inputs)
+		if err != nil {
+			return nil, fmt.Errorf("analyzing motion: %w", err)
+		}
+		segments = pickHighlights(samples, segments, durations, opts.highlightCount, opts.highlightLength)
+		if len(segments) == 0 {
+			return nil, withExitCode(exitNoFootage, fmt.Errorf("no activity found in the footage of camera %s", opts.cameraName))
+		}
+		if inputs, err = newConcatInput(opts, segments, workDir); err != nil {
+			return nil, fmt.Errorf("creating inputs file: %w", err)
+		}
+		fmt.Fprintf(opts.stdout, "Picked %d highlight(s)\n", len(segments))
 	}
 
-	fmt.Printf("Found %d video file(s) for camera: %s\n", len(files), *cameraName)
+	// Analyze motion to find the segments that should play slower, or faster when nothing happens
+	var ranges []speedRange
+	var samples []motionSample
+	if opts.adaptive || opts.static != staticKeep {
+		fmt.Fprintln(opts.stdout, "Analyzing motion (keyframes only)...")
+		opts.report("analyzing motion", 0)
+		samples, err = analyzeMotion(opts, inputs)
+		if err != nil {
+			return nil, fmt.Errorf("analyzing motion: %w", err)
+		}
+	}
+	if opts.adaptive {
+		ranges = adaptiveSpeedRanges(samples, opts.motionWindow, opts.motionThreshold, opts.activeSpeed)
+		var active float64
+		for _, r := range ranges {
+			active += r.end - r.start
+		}
+		fmt.Fprintf(opts.stdout, "Detected motion in %d segment(s) covering %s of footage\n", len(ranges), time.Duration(active*float64(time.Second)))
+	}
 
-	// Sort files chronologically by parsing dates from filenames
-	sort.Slice(files, func(i, j int) bool {
-		dateI := extractDateFromPath(files[i])
-		dateJ := extractDateFromPath(files[j])
-		return dateI.Before(dateJ)
-	})
+	// Vary the speed as the speed profile says
+	var durations []time.Duration
+	if opts.hasSpeedProfile() {
+		durations, err = segmentDurations(ffprobePath(opts.ffmpegPath), segments)
+		if err != nil {
+			return nil, fmt.Errorf("measuring segments: %w", err)
+		}
+		ranges, err = profileSpeedRanges(opts, segments, durations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Play the infrared footage at its own speed, except where the speed is already set
+	var infrared []outputSpan
+	if irSegments != nil {
+		if durations == nil {
+			durations, err = segmentDurations(ffprobePath(opts.ffmpegPath), segments)
+			if err != nil {
+				return nil, fmt.Errorf("measuring segments: %w", err)
+			}
+		}
+		infrared = irSpans(irSegments, durations)
+		if opts.irSpeed != 0 {
+			for _, s := range infrared {
+				ranges = append(ranges, uncovered(speedRange{start: s.start, end: s.end, speed: opts.irSpeed}, ranges)...)
+			}
+			sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+		}
+	}
+
+	// Skip through the periods where the picture barely changes, except where the speed is already set
+	if opts.static != staticKeep {
+		static := staticSpeedRanges(samples, opts.staticThreshold, opts.staticMin, opts.static, opts.speed, opts.staticSpeedup, opts.fps)
+		var length float64
+		for _, r := range static {
+			length += r.end - r.start
+			ranges = append(ranges, uncovered(r, ranges)...)
+		}
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+		fmt.Fprintf(opts.stdout, "Found %d static period(s) covering %s of footage\n", len(static), time.Duration(length*float64(time.Second)))
+	}
+
+	job := encodeJob{inputs: inputs, outputFile: outputFile, ranges: ranges}
+	last := segments[len(segments)-1]
+	if job.intro, err = newSlate(opts, opts.intro, segments[0].start, latest(last.start, last.end)); err != nil {
+		return nil, fmt.Errorf("measuring -intro: %w", err)
+	}
+	if job.outro, err = newSlate(opts, opts.outro, segments[0].start, latest(last.start, last.end)); err != nil {
+		return nil, fmt.Errorf("measuring -outro: %w", err)
+	}
+	// weather stays nil when it cannot be fetched, which leaves the weather of the overlay blank
+	var weather *weatherRecord
+	if usesWeather(opts.overlayText) {
+		if weather, err = fetchWeather(opts, segments[0].start, latest(last.start, last.end)); err != nil {
+			fmt.Fprintf(opts.stderr, "Warning: fetching the weather for -overlay-text: %v\n", err)
+		}
+	}
+	if opts.irTint != "" {
+		job.irSpans = infrared
+	}
+	// youtubeChapters holds the -youtube-chapters chapters other than days, which are in job.days
+	var youtubeChapters []chapter
+
+	if opts.layout != "" {
+		// Line the other cameras up with this one by the wall-clock time of their footage
+		job.cameras, err = layoutCameras(opts, naming, segments, inputs.file, workDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, cam := range job.cameras[1:] {
+			sources = append(sources, cam.segments...)
+		}
+		job.timeline = newCompositeTimeline(job.cameras)
+		job.duration = job.timeline.length() / opts.speed
+		job.days = job.timeline.chapters(opts.speed)
+		if opts.subtitles != "" {
+			job.cues = timeCues(job.timeline, opts.subtitleInterval, opts.speed, nil, subtitleTitle)
+		}
+		if isTimedOverlay(opts.overlayText) {
+			job.overlayCues = timeCues(job.timeline, opts.subtitleInterval, opts.speed, nil, overlayTitle(opts, weather))
+		}
+		if opts.progressBar != "" {
+			job.progressCues, job.progressTicks = progressCues(job.timeline, opts.speed, nil), progressTicks(job.timeline)
+		}
+		if opts.youtubeChapters == youtubeChaptersHour {
+			youtubeChapters = hourChapters(job.timeline, opts.speed, nil)
+		}
+		fmt.Fprintf(opts.stdout, "Arranging %d cameras in a %s layout\n", len(job.cameras), opts.layout)
+	} else if opts.chapters || opts.titleCards || opts.transition != nil || opts.progress != nil || opts.verify || opts.subtitles != "" || opts.youtubeChapters != "" || opts.export != "" || opts.targetSize != "" || isTimedOverlay(opts.overlayText) || opts.progressBar != "" || durations != nil {
+		// Find the start of each day and the jumps between segments on the output timeline
+		if durations == nil {
+			durations, err = segmentDurations(ffprobePath(opts.ffmpegPath), segments)
+			if err != nil {
+				return nil, fmt.Errorf("measuring segments: %w", err)
+			}
+		}
+		var total float64
+		for _, d := range durations {
+			total += d.Seconds()
+		}
+		job.duration = outputOffset(total, opts.speed, ranges)
+		job.days = dayChapters(segments, durations, opts.speed, ranges)
+		if opts.subtitles != "" {
+			job.cues = timeCues(segmentSpans(segments, durations), opts.subtitleInterval, opts.speed, ranges, subtitleTitle)
+		}
+		if isTimedOverlay(opts.overlayText) {
+			job.overlayCues = timeCues(segmentSpans(segments, durations), opts.subtitleInterval, opts.speed, ranges, overlayTitle(opts, weather))
+		}
+		if opts.progressBar != "" {
+			spans := segmentSpans(segments, durations)
+			job.progressCues, job.progressTicks = progressCues(spans, opts.speed, ranges), progressTicks(spans)
+		}
+		if opts.youtubeChapters == youtubeChaptersHour {
+			youtubeChapters = hourChapters(segmentSpans(segments, durations), opts.speed, ranges)
+		}
+		if opts.transition != nil && len(job.days) > 0 {
+			total := job.days[len(job.days)-1].end
+			job.cuts = usableCuts(segmentCuts(segments, durations, opts.speed, ranges), total, *opts.transition)
+			job.days = withTransitions(job.days, job.cuts, *opts.transition)
+			job.cues = withTransitions(job.cues, job.cuts, *opts.transition)
+			job.overlayCues = withTransitions(job.overlayCues, job.cuts, *opts.transition)
+			job.progressCues = withTransitions(job.progressCues, job.cuts, *opts.transition)
+			youtubeChapters = withTransitions(youtubeChapters, job.cuts, *opts.transition)
+			fmt.Fprintf(opts.stdout, "Adding %d transition(s) where the footage jumps in time\n", len(job.cuts))
+		}
+	}
+
+	// Mark the start of each day with a chapter
+	if opts.chapters && len(job.days) > 1 && opts.format == formatMP4 {
+		chapters := job.days
+		if opts.titleCards {
+			chapters = withTitleCards(chapters, opts.titleCardDuration, false)
+		}
+		chapters = shiftChapters(chapters, slateLength(job.intro))
+		job.chaptersFile = filepath.Join(workDir, chaptersFile)
+		if err := writeChaptersFile(job.chaptersFile, chapters); err != nil {
+			return nil, fmt.Errorf("creating chapters file: %w", err)
+		}
+		fmt.Fprintf(opts.stdout, "Added %d day chapter(s)\n", len(chapters))
+	}
+
+	if opts.youtubeChapters == youtubeChaptersDay {
+		youtubeChapters = job.days
+	}
+	if opts.titleCards {
+		if opts.youtubeChapters == youtubeChaptersDay {
+			youtubeChapters = withTitleCards(job.days, opts.titleCardDuration, false)
+		} else {
+			youtubeChapters = shiftForTitleCards(youtubeChapters, job.days, opts.titleCardDuration, false)
+		}
+	}
+	youtubeChapters = shiftChapters(youtubeChapters, slateLength(job.intro))
+
+	// Hand the timeline to an editor instead of encoding it
+	if opts.exportOnly {
+		project := exportFile(outputFile, opts.export)
+		if err := writeExport(project, opts.export, opts, segments, durations); err != nil {
+			return nil, fmt.Errorf("writing %s project: %w", strings.ToUpper(opts.export), err)
+		}
+		fmt.Fprintf(opts.stdout, "Created %s project with %d clip(s): %s\n", strings.ToUpper(opts.export), len(segments), project)
+		opts.report("done", 1)
+		return &mergeInfo{output: project, vars: vars, segments: segments}, nil
+	}
+
+	// Show the wall-clock time of the footage in subtitles
+	if len(job.cues) > 0 {
+		if opts.titleCards {
+			job.cues = shiftForTitleCards(job.cues, job.days, opts.titleCardDuration, job.openingCard)
+		}
+		job.cues = shiftChapters(job.cues, slateLength(job.intro))
+		if opts.subtitles == subtitlesMux {
+			job.subtitlesFile = filepath.Join(workDir, subtitlesFile)
+			if err := writeSubtitles(job.subtitlesFile, job.cues, subtitlesSRT); err != nil {
+				return nil, fmt.Errorf("creating subtitles: %w", err)
+			}
+		}
+	}
 
-	// Create inputs.txt file
-	if err := createInputsFile(files, inputsFile); err != nil {
-		exitWithError("creating inputs file: %v", err)
+	// Update the overlay with the time of the footage on screen
+	if len(job.overlayCues) > 0 {
+		job.overlayCommands = filepath.Join(workDir, overlayCommandsFile)
+		if err := writeOverlayCommands(job.overlayCommands, job.overlayCues); err != nil {
+			return nil, fmt.Errorf("creating overlay commands: %w", err)
+		}
 	}
-	defer func() {
-		if err := os.Remove(inputsFile); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: failed to remove temporary file %s: %v\n", inputsFile, err)
+	if opts.progressBar != "" {
+		job.progressCommands = filepath.Join(workDir, progressCommandsFile)
+		if err := writeProgressCommands(job.progressCommands, job.progressCues); err != nil {
+			return nil, fmt.Errorf("creating progress bar commands: %w", err)
 		}
-	}()
+	}
+
+	// Analyze camera shake on exactly the frames the encode will produce
+	if opts.stabilize {
+		fmt.Fprintln(opts.stdout, "Analyzing camera shake for stabilization...")
+		opts.report("analyzing camera shake", 0)
+		transforms := filepath.Join(workDir, stabilizeFile)
+		if err := detectShake(opts, job, transforms); err != nil {
+			return nil, fmt.Errorf("analyzing camera shake: %w", err)
+		}
+		job.stabilizeFile = transforms
+	}
 
-	fmt.Printf("Created %s with %d file(s)\n", inputsFile, len(files))
+	// Find the bitrate that fills the target size over the whole output, title cards included
+	if opts.targetSize != "" {
+		size, _ := parseByteSize(opts.targetSize)
+		var audio float64
+		if opts.music != "" {
+			audio = 192_000
+		}
+		bitrate, err := targetBitrate(size, expectedLength(opts, job), audio)
+		if err != nil {
+			return nil, err
+		}
+		opts.bitrate = strconv.FormatInt(bitrate, 10)
+		fmt.Fprintf(opts.stdout, "Encoding at %.0f kbit/s to fit in %s\n", float64(bitrate)/1000, opts.targetSize)
+	}
+	if opts.twoPass {
+		job.passLogFile = filepath.Join(workDir, passLogFile)
+	}
 
 	// Run ffmpeg
-	if err := runFFmpeg(*ffmpegPath, inputsFile, outputFile, *useGPU, *speed); err != nil {
-		exitWithError("running ffmpeg: %v", err)
+	opts.report("encoding", 0)
+	encodeStarted := time.Now()
+	err = runFFmpegWithRetries(opts, job)
+	opts.record(measureEncodeSeconds, time.Since(encodeStarted).Seconds())
+	if err != nil {
+		return nil, withExitCode(exitEncode, fmt.Errorf("running ffmpeg: %w", err))
+	}
+	if job.duration > 0 {
+		opts.record(measureOutputSeconds, job.duration)
+	}
+	if len(job.cues) > 0 && opts.subtitles != subtitlesMux {
+		sidecar := subtitlesSidecar(outputFile, opts.subtitles)
+		if err := writeSubtitles(sidecar, job.cues, opts.subtitles); err != nil {
+			return nil, fmt.Errorf("writing subtitles: %w", err)
+		}
+		fmt.Fprintf(opts.stdout, "Created subtitles: %s\n", sidecar)
+	}
+	if opts.export != "" {
+		project := exportFile(outputFile, opts.export)
+		if err := writeExport(project, opts.export, opts, originals, durations); err != nil {
+			return nil, fmt.Errorf("writing %s project: %w", strings.ToUpper(opts.export), err)
+		}
+		fmt.Fprintf(opts.stdout, "Created %s project: %s\n", strings.ToUpper(opts.export), project)
+	}
+	info = &mergeInfo{output: outputFile, vars: vars, segments: segments, sources: sources, duration: job.duration}
+	if opts.youtubeChapters != "" {
+		if err := writeYouTubeDescription(opts, info, youtubeChapters); err != nil {
+			return nil, fmt.Errorf("writing YouTube description: %w", err)
+		}
+	}
+	if opts.playlist != "" {
+		opts.report("writing playlist", 1)
+		playlist, err := writePlaylist(opts, outputFile, opts.playlist)
+		if err != nil {
+			return nil, fmt.Errorf("writing %s playlist: %w", strings.ToUpper(opts.playlist), err)
+		}
+		fmt.Fprintf(opts.stdout, "Created %s playlist: %s\n", strings.ToUpper(opts.playlist), playlist)
+	}
+	if opts.thumbnail || opts.preview != "" {
+		opts.report("creating previews", 1)
+		createExtras(opts, outputFile)
+	}
+	opts.report("done", 1)
+
+	return info, nil
+}
+
+// inputDirsFor returns the directories searched for footage: the -input directories, or videosDir,
+// or their subdirectories of the site with -site.
+func inputDirsFor(opts options) stringList {
+	dirs := opts.inputDirs
+	if len(dirs) == 0 {
+		dirs = stringList{videosDir}
+	}
+	if opts.site != "" {
+		siteDirs := make(stringList, len(dirs))
+		for i, dir := range dirs {
+			siteDirs[i] = filepath.Join(dir, filenameText(opts.site))
+		}
+		dirs = siteDirs
+	}
+	return dirs
+}
+
+// findCameraClips finds the clips of camera in the input directories, or its images with -images,
+// and returns them in chronological order without the excluded and short ones.
+func findCameraClips(opts options, naming clipNaming, camera string) ([]clip, error) {
+	var files []string
+	var err error
+	kind := "video files"
+	if opts.images {
+		kind = "images"
+		files, err = findImageFiles(opts.inputDirs, clipCamera(opts.aliases, camera))
+	} else {
+		files, err = findVideoFiles(opts.inputDirs, naming, clipCamera(opts.aliases, camera))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("finding %s: %w", kind, err)
+	}
+
+	if len(opts.excludeMatchers) > 0 {
+		var skipped int
+		files, skipped = excludeFiles(files, opts.excludeMatchers)
+		opts.record(measureSkipped, float64(skipped))
+		if skipped > 0 {
+			fmt.Fprintf(opts.stdout, "Excluded %d file(s) matching -exclude\n", skipped)
+		}
+	}
+
+	if len(files) == 0 {
+		hint := ""
+		if !opts.images {
+			// Protect camera names are easy to mistype
+			if names, err := cameraNamesIn(opts); err == nil {
+				hint = cameraSuggestion(camera, append(names, sortedNames(opts.aliases)...))
+			}
+		}
+		return nil, withExitCode(exitNoFootage, fmt.Errorf("no %s found for camera: %s%s", kind, camera, hint))
+	}
+
+	fmt.Fprintf(opts.stdout, "Found %d %s for camera: %s\n", len(files), kind, camera)
+
+	var clips []clip
+	if opts.images {
+		clips = loadImages(files, opts.dateOrder)
+	} else {
+		// Sort chronologically by the dates in the filenames
+		clips = loadClips(files, naming)
+		sort.SliceStable(clips, func(i, j int) bool { return clips[i].start.Before(clips[j].start) })
+	}
+	if err := reportUndated(opts, clips); err != nil {
+		return nil, err
+	}
+
+	if opts.dedup {
+		var duplicates []duplicateClip
+		clips, duplicates, err = dropDuplicateClips(clips)
+		if err != nil {
+			return nil, fmt.Errorf("comparing clips: %w", err)
+		}
+		for _, d := range duplicates {
+			fmt.Fprintf(opts.stdout, "Skipped %s, which has the same content as %s\n", filepath.Base(d.path), filepath.Base(d.original))
+		}
+		opts.record(measureSkipped, float64(len(duplicates)))
+	}
+
+	if opts.minClipDuration > 0 {
+		var skipped int
+		clips, skipped, err = dropShortClips(clips, opts.minClipDuration, ffprobePath(opts.ffmpegPath))
+		if err != nil {
+			return nil, fmt.Errorf("measuring clips: %w", err)
+		}
+		opts.record(measureSkipped, float64(skipped))
+		if skipped > 0 {
+			fmt.Fprintf(opts.stdout, "Skipped %d clip(s) shorter than %s\n", skipped, opts.minClipDuration)
+		}
+		if len(clips) == 0 {
+			return nil, withExitCode(exitNoFootage, fmt.Errorf("no clips for camera %s are at least %s long", camera, opts.minClipDuration))
+		}
+	}
+	return clips, nil
+}
+
+// isFlagSet reports whether the named flag was set, e.g. on the command line or in the config file.
+func isFlagSet(fset *flag.FlagSet, name string) bool {
+	set := false
+	fset.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// sampleClips returns every sample-th of the clips, starting with the first, and then the first limit of
+// those; a sample of 0 or 1 keeps every clip and a limit of 0 all of them.
+func sampleClips(clips []clip, sample, limit int) []clip {
+	if sample > 1 {
+		sampled := make([]clip, 0, (len(clips)+sample-1)/sample)
+		for i := 0; i < len(clips); i += sample {
+			sampled = append(sampled, clips[i])
+		}
+		clips = sampled
 	}
+	if limit > 0 && len(clips) > limit {
+		clips = clips[:limit]
+	}
+	return clips
+}
 
-	fmt.Printf("Successfully created: %s\n", outputFile)
+// maxUndatedListed is how many of the files without a date in their name are named in the warning.
+const maxUndatedListed = 10
+
+// reportUndated warns about the clips ordered by modification time for lack of a date in their name, or
+// fails with -strict, since copying files often resets their modification times and so their order.
+func reportUndated(opts options, clips []clip) error {
+	var undated []string
+	for _, c := range clips {
+		if c.undated {
+			undated = append(undated, c.path)
+		}
+	}
+	if len(undated) == 0 {
+		return nil
+	}
+	if opts.undated != nil {
+		opts.undated(undated)
+	}
+	names := make([]string, 0, maxUndatedListed+1)
+	for i, path := range undated {
+		if i == maxUndatedListed {
+			names = append(names, fmt.Sprintf("and %d more", len(undated)-i))
+			break
+		}
+		names = append(names, filepath.Base(path))
+	}
+	if opts.strict {
+		return fmt.Errorf("%d file(s) have no date in their name: %s; rename them or leave them out with -exclude", len(undated), strings.Join(names, ", "))
+	}
+	fmt.Fprintf(opts.stderr, "Warning: %d file(s) have no date in their name and are ordered by their modification time, which copying may have changed (-strict stops instead): %s\n", len(undated), strings.Join(names, ", "))
+	return nil
 }
 
-// findVideoFiles searches the videos directory for all MP4 files that start with the given camera name.
-// It returns a slice of absolute file paths, or an error if the directory cannot be walked.
-func findVideoFiles(cameraName string) ([]string, error) {
+// findVideoFiles searches the given directories for all MP4 files that naming identifies as recordings
+// of the given camera, or of any camera when cameraName is empty. It returns a slice of absolute file
+// paths, each listed once even if the directories overlap, or an error if a directory cannot be walked.
+func findVideoFiles(dirs []string, naming clipNaming, cameraName string) ([]string, error) {
 	var files []string
+	seen := make(map[string]bool)
+	match := func(path string) bool { return naming.matches(path, cameraName) }
+
+	for _, dir := range dirs {
+		if err := walkMediaDir(dir, match, []string{videoExt}, seen, &files); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
 
-	err := filepath.Walk(videosDir, func(path string, info os.FileInfo, err error) error {
+// walkMediaDir adds the files under dir with one of the extensions that match and are not yet in seen to files.
+func walkMediaDir(dir string, match func(path string) bool, exts []string, seen map[string]bool, files *[]string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -121,39 +2061,126 @@ func findVideoFiles(cameraName string) ([]string, error) {
 			return nil
 		}
 
-		if !strings.HasSuffix(strings.ToLower(path), videoExt) {
+		if !hasExtension(path, exts) {
 			return nil
 		}
 
-		filename := filepath.Base(path)
-		if strings.HasPrefix(filename, cameraName) {
+		if match(path) {
 			absPath, err := filepath.Abs(path)
 			if err != nil {
 				return err
 			}
-			files = append(files, absPath)
+			if !seen[absPath] {
+				seen[absPath] = true
+				*files = append(*files, absPath)
+			}
 		}
 
 		return nil
 	})
+}
 
-	return files, err
+// hasExtension reports whether path ends in one of the lowercase extensions, ignoring case.
+func hasExtension(path string, exts []string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range exts {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
 }
 
-// createInputsFile creates a temporary file listing all video files for ffmpeg's concat demuxer.
-// It normalizes Windows paths and escapes special characters for ffmpeg compatibility.
-func createInputsFile(files []string, inputsFile string) error {
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
+
+// String returns the values joined by commas.
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set appends a value.
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// loadClips pairs each file with its camera and the recording period read from its name by naming.
+// Files whose name carries no time are placed at their modification time.
+func loadClips(files []string, naming clipNaming) []clip {
+	order := naming.dateOrder
+	if order == dateOrderAuto {
+		order = detectDateOrder(files)
+	}
+	clips := make([]clip, 0, len(files))
+	for _, file := range files {
+		c := clip{path: file, camera: naming.camera(file)}
+		c.start, c.end = naming.times(file, order)
+		if c.start.IsZero() {
+			c.undated = true
+			if info, err := os.Stat(file); err == nil {
+				c.start = info.ModTime()
+			}
+		}
+		clips = append(clips, c)
+	}
+	return clips
+}
+
+// wholeClipSegments returns one segment per clip covering the entire file.
+func wholeClipSegments(clips []clip) []segment {
+	segments := make([]segment, 0, len(clips))
+	for _, c := range clips {
+		segments = append(segments, segment{path: c.path, start: c.start, end: c.end})
+	}
+	return segments
+}
+
+// createInputsFile creates a temporary file listing all segments for ffmpeg's concat demuxer.
+func createInputsFile(segments []segment, inputsFile string) error {
 	f, err := os.Create(inputsFile)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
+	return writeInputsList(f, segments)
+}
 
-	for _, file := range files {
-		// Convert Windows backslashes to forward slashes for ffmpeg compatibility
-		normalized := strings.ReplaceAll(file, "\\", "/")
-		// Escape single quotes for ffmpeg
-		escaped := strings.ReplaceAll(normalized, "'", "'\\''")
+// writeInputsList writes the list of segments read by ffmpeg's concat demuxer to f.
+// It normalizes Windows paths and escapes special characters for ffmpeg compatibility.
+// Segments that cover only part of a file are written with inpoint/outpoint directives.
+func writeInputsList(f io.Writer, segments []segment) error {
+	for _, seg := range segments {
+		escaped, err := concatPath(seg.path)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(f, "file '%s'\n", escaped); err != nil {
+			return fmt.Errorf("writing to inputs file: %w", err)
+		}
+		if seg.inpoint > 0 {
+			if _, err := fmt.Fprintf(f, "inpoint %.3f\n", seg.inpoint.Seconds()); err != nil {
+				return fmt.Errorf("writing to inputs file: %w", err)
+			}
+		}
+		if seg.outpoint > 0 {
+			if _, err := fmt.Fprintf(f, "outpoint %.3f\n", seg.outpoint.Seconds()); err != nil {
+				return fmt.Errorf("writing to inputs file: %w", err)
+			}
+		}
+		if seg.duration > 0 {
+			if _, err := fmt.Fprintf(f, "duration %.6f\n", seg.duration.Seconds()); err != nil {
+				return fmt.Errorf("writing to inputs file: %w", err)
+			}
+		}
+	}
+
+	// The concat demuxer ignores the duration of the last entry, so a final image is listed again
+	if n := len(segments); n > 0 && segments[n-1].duration > 0 {
+		escaped, err := concatPath(segments[n-1].path)
+		if err != nil {
+			return err
+		}
 		if _, err := fmt.Fprintf(f, "file '%s'\n", escaped); err != nil {
 			return fmt.Errorf("writing to inputs file: %w", err)
 		}
@@ -162,62 +2189,126 @@ func createInputsFile(files []string, inputsFile string) error {
 	return nil
 }
 
-// runFFmpeg executes ffmpeg to concatenate and speed up the video files.
-// It uses the concat demuxer for better performance and applies a speed factor to the video.
-// If useGPU is true, it uses NVIDIA GPU acceleration (h264_nvenc), otherwise software encoding (libx264).
-func runFFmpeg(ffmpegPath, inputsFile, outputFile string, useGPU bool, speed float64) error {
-	// Use concat demuxer for better performance
-	// Speed up by specified factor (setpts=1/speed*PTS)
-	speedFactor := 1.0 / speed
-	args := []string{
-		"-f", "concat",
-		"-safe", "0",
-		"-i", inputsFile,
-		"-filter_complex", fmt.Sprintf("[0:v]setpts=%.6f*PTS[v]", speedFactor),
-		"-map", "[v]",
-	}
-
-	if useGPU {
-		// NVIDIA GPU acceleration
-		args = append(args, "-c:v", "h264_nvenc", "-preset", "p4", "-cq", "23")
-		fmt.Printf("Running ffmpeg with GPU acceleration from: %s\n", ffmpegPath)
-	} else {
-		// Software encoding
-		args = append(args, "-c:v", "libx264", "-preset", "medium", "-crf", "23")
-		fmt.Printf("Running ffmpeg with software encoding from: %s\n", ffmpegPath)
+// concatPath returns a file's path as written in a concat demuxer list, between single quotes.
+func concatPath(file string) (string, error) {
+	// ffmpeg resolves relative paths against the inputs file's directory, not the working directory
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
 	}
-
-	args = append(args, "-pix_fmt", "yuv420p", "-y", outputFile)
-
-	cmd := exec.Command(ffmpegPath, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	return cmd.Run()
+	// Convert Windows backslashes to forward slashes for ffmpeg compatibility
+	normalized := strings.ReplaceAll(path, "\\", "/")
+	// Escape single quotes for ffmpeg
+	return strings.ReplaceAll(normalized, "'", "'\\''"), nil
 }
 
-// extractDateFromPath extracts the date and time from a video filename for chronological sorting.
-// It looks for a date-time pattern (M-D-YYYY, HH.MM.SS or M-D-YYYY, HH:MM:SS) in the filename.
-// If parsing fails, it falls back to the file's modification time. Returns the zero time if all methods fail.
-// Pattern: "Camera Name M-D-YYYY, HH.MM.SS GMT+X - M-D-YYYY, HH.MM.SS GMT+X"
-func extractDateFromPath(filePath string) time.Time {
-	filename := filepath.Base(filePath)
-	// Extract the first date and time in format M-D-YYYY, HH.MM.SS or M-D-YYYY, HH:MM:SS
+// parseFilenameTimes returns every date and time found in a filename, in order of appearance, reading
+// dates such as 1-2-2026 in order, dateOrderMDY or dateOrderDMY. Protect exports contain two: the start
+// and the end of the recording.
+func parseFilenameTimes(filename, order string) []time.Time {
+	var times []time.Time
 	re := regexp.MustCompile(dateTimePattern)
-	matches := re.FindStringSubmatch(filename)
-	if len(matches) == 7 {
+	for _, matches := range re.FindAllStringSubmatch(filename, -1) {
+		year, month, day := matches[4], matches[5], matches[6]
+		if year == "" {
+			year, month, day = matches[3], matches[1], matches[2]
+			if order == dateOrderDMY {
+				month, day = day, month
+			}
+		}
 		// Reconstruct the date-time string, normalizing time separators to colons
-		dateTimeStr := fmt.Sprintf("%s-%s-%s, %s:%s:%s", matches[1], matches[2], matches[3], matches[4], matches[5], matches[6])
-		t, err := time.Parse(dateTimeFormat, dateTimeStr)
-		if err == nil {
-			return t
+		dateTimeStr := fmt.Sprintf("%s-%s-%s %s:%s:%s", year, month, day, matches[7], matches[8], matches[9])
+		if t, err := time.ParseInLocation(dateTimeFormat, dateTimeStr, filenameLocation(matches[10], matches[11])); err == nil {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
+// detectDateOrder returns the order of the day and month in the dates of the filenames of paths, such as
+// 1-2-2026: dateOrderDMY when a first number is above 12, or when the dates are written with dots as in
+// most of Europe and no second number is above 12, and dateOrderMDY, the order of US exports, otherwise.
+func detectDateOrder(paths []string) string {
+	re := regexp.MustCompile(dateTimePattern)
+	dotted := false
+	for _, path := range paths {
+		for _, matches := range re.FindAllStringSubmatch(filepath.Base(path), -1) {
+			if matches[3] == "" {
+				continue
+			}
+			first, _ := strconv.Atoi(matches[1])
+			second, _ := strconv.Atoi(matches[2])
+			switch {
+			case first > 12:
+				return dateOrderDMY
+			case second > 12:
+				return dateOrderMDY
+			}
+			dotted = dotted || matches[0][len(matches[1])] == '.'
 		}
 	}
-	// Fallback to file modification time if parsing fails
-	if info, err := os.Stat(filePath); err == nil {
-		return info.ModTime()
+	if dotted {
+		return dateOrderDMY
+	}
+	return dateOrderMDY
+}
+
+// cameraFromFilename returns the camera name a Protect export filename starts with, i.e. the text
+// before its first date and time, or "" if the name carries no date and time.
+func cameraFromFilename(filename string) string {
+	re := regexp.MustCompile(dateTimePattern)
+	loc := re.FindStringIndex(filename)
+	if loc == nil {
+		return ""
+	}
+	// ISO-like dates are often joined to the name with an underscore or dash
+	return strings.TrimRight(filename[:loc[0]], " _-")
+}
+
+// filenameLocation returns the fixed zone for a "GMT+X" offset captured from a filename.
+// Filenames without an offset are assumed to be in the local time zone.
+func filenameLocation(hours, minutes string) *time.Location {
+	if hours == "" {
+		return time.Local
+	}
+	h, _ := strconv.Atoi(hours)
+	m, _ := strconv.Atoi(minutes)
+	offset := h*3600 + m*60
+	if strings.HasPrefix(hours, "-") {
+		offset = h*3600 - m*60
+	}
+	name := "GMT" + hours
+	if minutes != "" {
+		name += ":" + minutes
+	}
+	return time.FixedZone(name, offset)
+}
+
+// formatFilenameTime formats t like the times in Protect export filenames, e.g. "1-16-2026, 08.00.00 GMT+1",
+// so that parseFilenameTimes reads it back.
+func formatFilenameTime(t time.Time) string {
+	_, offset := t.Zone()
+	zone := fmt.Sprintf("GMT%+d", offset/3600)
+	if m := offset % 3600 / 60; m != 0 {
+		// No colon, which Windows does not allow in filenames
+		zone = fmt.Sprintf("GMT%+03d%02d", offset/3600, max(m, -m))
+	}
+	return fmt.Sprintf("%d-%d-%d, %02d.%02d.%02d %s", int(t.Month()), t.Day(), t.Year(), t.Hour(), t.Minute(), t.Second(), zone)
+}
+
+// checkSiteName rejects a -site that is not a plain name, since it is joined onto the -input
+// directories and must not lead out of them. Windows drops trailing dots and spaces, so ".. " is ".." there.
+func checkSiteName(site string) error {
+	if site != "" && (strings.Trim(site, ". ") == "" || strings.ContainsAny(site, `/\`)) {
+		return fmt.Errorf("site %q must be a name, not a path", site)
 	}
-	return time.Time{}
+	return nil
+}
+
+// filenameText replaces the characters filenames cannot hold in a camera or site name, keeping spaces
+// so that the files still match -camera and -site.
+func filenameText(name string) string {
+	return strings.NewReplacer(`/`, "_", `\`, "_", ":", "_", "*", "_", "?", "_", `"`, "_", "<", "_", ">", "_", "|", "_").Replace(name)
 }
 
 // sanitizeFilename removes or replaces invalid filename characters with underscores.
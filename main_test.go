@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+)
+
+// prepareArgs parses args as merge flags and returns the error of prepareOptions.
+func prepareArgs(t *testing.T, args ...string) error {
+	t.Helper()
+	var opts options
+	fset := flag.NewFlagSet("test", flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+	defineFlags(fset, &opts)
+	if err := fset.Parse(append([]string{"-camera", "Front"}, args...)); err != nil {
+		t.Fatalf("parsing %v: %v", args, err)
+	}
+	return prepareOptions(fset, &opts, &config{})
+}
+
+func TestPrepareOptions(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{args: nil},
+		{args: []string{"-site", "Cabin"}},
+		{args: []string{"-site", "Home Office"}},
+		{args: []string{"-site", ".."}, wantErr: "must be a name"},
+		{args: []string{"-site", "."}, wantErr: "must be a name"},
+		{args: []string{"-site", ".. "}, wantErr: "must be a name"},
+		{args: []string{"-site", "../other"}, wantErr: "must be a name"},
+		{args: []string{"-site", `..\other`}, wantErr: "must be a name"},
+		{args: []string{"-site", "a/b"}, wantErr: "must be a name"},
+		{args: []string{"-cleanup", "delete"}},
+		{args: []string{"-cleanup", "move", "-mode", "highlights"}, wantErr: "-cleanup does not apply"},
+		{args: []string{"-cleanup", "delete", "-mode", "hyperlapse"}, wantErr: "-cleanup does not apply"},
+		{args: []string{"-cleanup", "move", "-daily-at", "12:00"}, wantErr: "-cleanup does not apply"},
+		{args: []string{"-cleanup", "delete", "-daily-at", "12:00", "-daily-length", "10m"}, wantErr: "-cleanup does not apply"},
+	}
+	for _, tt := range tests {
+		err := prepareArgs(t, tt.args...)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%v: unexpected error: %v", tt.args, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%v: error %v, want one containing %q", tt.args, err, tt.wantErr)
+		}
+	}
+}
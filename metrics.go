@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// jobDurationBuckets are the upper bounds, in seconds, of the job duration histogram: a minute to 12 hours.
+var jobDurationBuckets = []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800, 43200}
+
+// serverMetrics accumulates the measurements of the server's jobs for the /metrics endpoint.
+type serverMetrics struct {
+	mu              sync.Mutex
+	clipsDiscovered float64
+	bytesProcessed  float64
+	encodeFPS       float64
+	jobsFinished    map[string]float64
+	// durationCounts holds the number of jobs per bucket of jobDurationBuckets, not cumulative.
+	durationCounts []float64
+	durationSum    float64
+	durationCount  float64
+}
+
+// newServerMetrics returns metrics with every counter at zero.
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		jobsFinished:   map[string]float64{jobDone: 0, jobFailed: 0, jobCancelled: 0},
+		durationCounts: make([]float64, len(jobDurationBuckets)),
+	}
+}
+
+// measure records a measurement of a running job; it is used as options.measure.
+func (m *serverMetrics) measure(name string, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch name {
+	case measureClips:
+		m.clipsDiscovered += value
+	case measureBytes:
+		m.bytesProcessed += value
+	case measureEncodeFPS:
+		m.encodeFPS = value
+	}
+}
+
+// jobFinished records the outcome and duration of a job.
+func (m *serverMetrics) jobFinished(status string, seconds float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobsFinished[status]++
+	m.durationSum += seconds
+	m.durationCount++
+	for i, bound := range jobDurationBuckets {
+		if seconds <= bound {
+			m.durationCounts[i]++
+			break
+		}
+	}
+	m.encodeFPS = 0
+}
+
+// handleMetrics serves the metrics in the Prometheus text format.
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	s.mu.Lock()
+	active := map[string]float64{jobQueued: 0, jobRunning: 0}
+	for _, job := range s.jobs {
+		if _, ok := active[job.Status]; ok {
+			active[job.Status]++
+		}
+	}
+	s.mu.Unlock()
+
+	m := s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "unifi_timelapse_clips_discovered_total", "counter", "Clips found for the cameras of all jobs.", "", m.clipsDiscovered)
+	writeMetric(w, "unifi_timelapse_processed_bytes_total", "counter", "Size of the footage merged by all jobs.", "", m.bytesProcessed)
+	writeMetric(w, "unifi_timelapse_encode_fps", "gauge", "Frames per second written by the running encode, or 0 when not encoding.", "", m.encodeFPS)
+
+	writeMetricHeader(w, "unifi_timelapse_jobs", "gauge", "Jobs waiting or running.")
+	for _, status := range []string{jobQueued, jobRunning} {
+		writeMetricValue(w, "unifi_timelapse_jobs", `status="`+status+`"`, active[status])
+	}
+	writeMetricHeader(w, "unifi_timelapse_jobs_finished_total", "counter", "Jobs finished, by outcome.")
+	for _, status := range []string{jobDone, jobFailed, jobCancelled} {
+		writeMetricValue(w, "unifi_timelapse_jobs_finished_total", `status="`+status+`"`, m.jobsFinished[status])
+	}
+	writeMetric(w, "unifi_timelapse_job_failures_total", "counter", "Jobs that failed.", "", m.jobsFinished[jobFailed])
+	var lastSuccess float64
+	if t := s.health.lastSuccessTime(); !t.IsZero() {
+		lastSuccess = float64(t.Unix())
+	}
+	writeMetric(w, "unifi_timelapse_last_success_timestamp_seconds", "gauge", "Unix time the last successful job finished, or 0 if none has.", "", lastSuccess)
+
+	writeMetricHeader(w, "unifi_timelapse_job_duration_seconds", "histogram", "Time from the start to the end of each finished job.")
+	var cumulative float64
+	for i, bound := range jobDurationBuckets {
+		cumulative += m.durationCounts[i]
+		writeMetricValue(w, "unifi_timelapse_job_duration_seconds_bucket", `le="`+formatMetricValue(bound)+`"`, cumulative)
+	}
+	writeMetricValue(w, "unifi_timelapse_job_duration_seconds_bucket", `le="+Inf"`, m.durationCount)
+	writeMetricValue(w, "unifi_timelapse_job_duration_seconds_sum", "", m.durationSum)
+	writeMetricValue(w, "unifi_timelapse_job_duration_seconds_count", "", m.durationCount)
+}
+
+// writeMetric writes a metric with a single value.
+func writeMetric(w io.Writer, name, kind, help, labels string, value float64) {
+	writeMetricHeader(w, name, kind, help)
+	writeMetricValue(w, name, labels, value)
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of a metric.
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeMetricValue writes one sample; labels is the text between the braces, or empty for none.
+func writeMetricValue(w io.Writer, name, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s %s\n", name, formatMetricValue(value))
+}
+
+// formatMetricValue formats a number as Prometheus expects it.
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// motionAnalysisWidth is the width frames are scaled to before scene scoring.
+	motionAnalysisWidth = 160
+	// sceneScoreKey is the frame metadata key written by ffmpeg's select filter.
+	sceneScoreKey = "lavfi.scene_score="
+)
+
+// motionSample is the scene-change score of one analyzed frame.
+type motionSample struct {
+	// t is the frame position in seconds on the concatenated source timeline.
+	t float64
+	// score is the scene-change score (0 = identical to the previous sample, 1 = completely different),
+	// or the value of another metadata key parsed by parseMetadataSamples.
+	score float64
+}
+
+// speedRange assigns a speed factor to a span of the concatenated source timeline, in seconds.
+type speedRange struct {
+	start, end float64
+	speed      float64
+}
+
+// analyzeMotion runs ffmpeg over the concatenated inputs and returns a scene-change score for each keyframe.
+// Only keyframes are decoded and they are downscaled before scoring, so the pass is much cheaper than an encode.
+func analyzeMotion(opts options, inputs concatInput) ([]motionSample, error) {
+	args := []string{
+		"-hide_banner", "-nostats", "-loglevel", "info",
+		"-skip_frame", "nokey",
+	}
+	args = append(args, inputs.args()...)
+	args = append(args,
+		"-an",
+		"-vf", fmt.Sprintf("scale=%d:-2,select='gte(scene,0)',metadata=print", motionAnalysisWidth),
+		"-f", "null", "-",
+	)
+
+	cmd := ffmpegCommand(opts, args...)
+	inputs.attach(cmd)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, lastLine(stderr.String()))
+	}
+
+	return parseMetadataSamples(stderr.String(), sceneScoreKey), nil
+}
+
+// parseMetadataSamples extracts (pts_time, value) pairs of the metadata key, such as sceneScoreKey, from the
+// log output of ffmpeg's metadata=print filter. Each frame is logged as a "pts_time:<t>" line followed by its
+// metadata lines.
+func parseMetadataSamples(log, key string) []motionSample {
+	var samples []motionSample
+	current := -1.0
+
+	scanner := bufio.NewScanner(strings.NewReader(log))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "pts_time:"); i >= 0 {
+			fields := strings.Fields(line[i+len("pts_time:"):])
+			if len(fields) > 0 {
+				if t, err := strconv.ParseFloat(fields[0], 64); err == nil {
+					current = t
+				}
+			}
+			continue
+		}
+		if i := strings.Index(line, key); i >= 0 && current >= 0 {
+			score, err := strconv.ParseFloat(strings.TrimSpace(line[i+len(key):]), 64)
+			if err == nil {
+				samples = append(samples, motionSample{t: current, score: score})
+			}
+			current = -1
+		}
+	}
+
+	return samples
+}
+
+// adaptiveSpeedRanges groups samples into fixed windows of the source timeline and returns merged ranges,
+// played at activeSpeed, covering every window whose peak scene score reaches threshold.
+func adaptiveSpeedRanges(samples []motionSample, window, threshold, activeSpeed float64) []speedRange {
+	var ranges []speedRange
+
+	for _, s := range samples {
+		if s.score < threshold {
+			continue
+		}
+		start := float64(int(s.t/window)) * window
+		end := start + window
+		if n := len(ranges); n > 0 && start <= ranges[n-1].end {
+			if end > ranges[n-1].end {
+				ranges[n-1].end = end
+			}
+			continue
+		}
+		ranges = append(ranges, speedRange{start: start, end: end, speed: activeSpeed})
+	}
+
+	return ranges
+}
+
+// speedExpr returns an ffmpeg expression evaluating to the speed factor at the time held in variable v.
+// It is a flat sum rather than nested if() calls so that long range lists do not hit expression nesting limits.
+func speedExpr(v string, defaultSpeed float64, ranges []speedRange) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%g", defaultSpeed)
+	for _, r := range ranges {
+		fmt.Fprintf(&b, "+gte(%s,%g)*lt(%s,%g)*%g", v, r.start, v, r.end, r.speed-defaultSpeed)
+	}
+	return b.String()
+}
+
+// outputOffset maps a position on the concatenated source timeline to the output timeline, in seconds.
+func outputOffset(t, defaultSpeed float64, ranges []speedRange) float64 {
+	out := t / defaultSpeed
+	for _, r := range ranges {
+		if t <= r.start {
+			break
+		}
+		span := math.Min(t, r.end) - r.start
+		out += span/r.speed - span/defaultSpeed
+	}
+	return out
+}
+
+// lastLine returns the last non-empty line of s, used to surface ffmpeg's final error message.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
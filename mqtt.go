@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types, shifted into the high nibble of the fixed header.
+const (
+	mqttConnect     = 1 << 4
+	mqttConnack     = 2 << 4
+	mqttPublish     = 3 << 4
+	mqttPuback      = 4 << 4
+	mqttSubscribe   = 8 << 4
+	mqttPingreq     = 12 << 4
+	mqttDisconnect  = 14 << 4
+	mqttKeepAlive   = 60 * time.Second
+	mqttMaxIncoming = 256 << 10
+)
+
+// mqttConnackErrors explains the CONNACK return codes that refuse a connection.
+var mqttConnackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// mqttClient is a minimal MQTT 3.1.1 client: it publishes and receives messages at QoS 0,
+// which is all a status feed and a command topic need.
+type mqttClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	mu       sync.Mutex // serializes writes
+	packetID uint16
+}
+
+// mqttWill is the message the broker publishes when the client disappears without disconnecting.
+type mqttWill struct {
+	topic   string
+	payload string
+}
+
+// dialMQTT connects to the broker at address, an URL such as "mqtt://host:1883" or "mqtts://host:8883"
+// ("tcp", "ssl" and "tls" are accepted as schemes too).
+func dialMQTT(address, clientID, username, password string, will mqttWill) (*mqttClient, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	var secure bool
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl", "tls":
+		secure = true
+	default:
+		return nil, fmt.Errorf("unsupported broker scheme %q (use mqtt:// or mqtts://)", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "1883"
+		if secure {
+			port = "8883"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	dialer := &net.Dialer{Timeout: notifyTimeout}
+	var conn net.Conn
+	if secure {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &mqttClient{conn: conn, reader: bufio.NewReader(conn)}
+
+	var body bytes.Buffer
+	writeMQTTString(&body, "MQTT")
+	// Protocol level 4, clean session, retained QoS 0 will
+	flags := byte(0x02 | 0x04 | 0x20)
+	if username != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+	body.Write([]byte{4, flags})
+	binary.Write(&body, binary.BigEndian, uint16(mqttKeepAlive/time.Second))
+	writeMQTTString(&body, clientID)
+	writeMQTTString(&body, will.topic)
+	writeMQTTString(&body, will.payload)
+	if username != "" {
+		writeMQTTString(&body, username)
+	}
+	if password != "" {
+		writeMQTTString(&body, password)
+	}
+
+	conn.SetDeadline(time.Now().Add(notifyTimeout))
+	if err := c.write(mqttConnect, body.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	header, ack, err := c.read()
+	if err == nil && (header&0xf0 != mqttConnack || len(ack) != 2) {
+		err = errors.New("broker did not acknowledge the connection")
+	}
+	if err == nil && ack[1] != 0 {
+		err = fmt.Errorf("connection refused: %s", mqttConnackErrors[ack[1]])
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// publish sends a message at QoS 0.
+func (c *mqttClient) publish(topic string, payload []byte, retain bool) error {
+	var body bytes.Buffer
+	writeMQTTString(&body, topic)
+	body.Write(payload)
+	header := byte(mqttPublish)
+	if retain {
+		header |= 0x01
+	}
+	return c.write(header, body.Bytes())
+}
+
+// subscribe asks for the messages published to topic at QoS 0. The acknowledgement is consumed by listen.
+func (c *mqttClient) subscribe(topic string) error {
+	var body bytes.Buffer
+	c.mu.Lock()
+	c.packetID++
+	id := c.packetID
+	c.mu.Unlock()
+	binary.Write(&body, binary.BigEndian, id)
+	writeMQTTString(&body, topic)
+	body.WriteByte(0)
+	// SUBSCRIBE has the reserved flags 0010
+	return c.write(mqttSubscribe|0x02, body.Bytes())
+}
+
+// listen calls handle for every message received until the connection fails or is closed,
+// pinging the broker to keep an idle connection alive.
+func (c *mqttClient) listen(handle func(topic string, payload []byte)) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(mqttKeepAlive / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := c.write(mqttPingreq, nil); err != nil {
+					c.conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		// The pings are answered well within this time by a live broker
+		c.conn.SetReadDeadline(time.Now().Add(mqttKeepAlive))
+		header, body, err := c.read()
+		if err != nil {
+			return err
+		}
+		if header&0xf0 != mqttPublish {
+			// PINGRESP, SUBACK and acknowledgements need no action
+			continue
+		}
+
+		topic, rest, err := readMQTTString(body)
+		if err != nil {
+			return err
+		}
+		if qos := header >> 1 & 0x03; qos > 0 {
+			if len(rest) < 2 {
+				return errors.New("malformed PUBLISH packet")
+			}
+			if qos == 1 {
+				if err := c.write(mqttPuback, rest[:2]); err != nil {
+					return err
+				}
+			}
+			rest = rest[2:]
+		}
+		handle(topic, rest)
+	}
+}
+
+// close disconnects from the broker. The will is not published after a clean disconnect.
+func (c *mqttClient) close() {
+	c.write(mqttDisconnect, nil)
+	c.conn.Close()
+}
+
+// write sends one control packet.
+func (c *mqttClient) write(header byte, body []byte) error {
+	packet := []byte{header}
+	// The remaining length is encoded 7 bits at a time, least significant group first
+	for n := len(body); ; {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// read receives one control packet, returning its fixed header byte and its body.
+func (c *mqttClient) read() (byte, []byte, error) {
+	header, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, shift := 0, 0
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("malformed packet length")
+		}
+	}
+	if length > mqttMaxIncoming {
+		return 0, nil, fmt.Errorf("packet of %d bytes is too large", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// writeMQTTString writes s prefixed with its length, as MQTT encodes strings.
+func writeMQTTString(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
+
+// readMQTTString reads a length-prefixed string from the start of data and returns it with the remaining data.
+func readMQTTString(data []byte) (string, []byte, error) {
+	if len(data) < 2 {
+		return "", nil, errors.New("malformed string")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return "", nil, errors.New("malformed string")
+	}
+	return string(data[2 : 2+n]), data[2+n:], nil
+}
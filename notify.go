@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// notifyTimeout bounds each notification request so a dead endpoint cannot hang the program.
+const notifyTimeout = 10 * time.Second
+
+// mergeResult describes a finished merge for notifications.
+type mergeResult struct {
+	Camera string `json:"camera"`
+	// Output is the file written, empty if none was.
+	Output string `json:"output,omitempty"`
+	// Uploads lists the URLs the output was uploaded to.
+	Uploads []string `json:"uploads,omitempty"`
+	// Status is "success" or "failure", and ExitStatus the matching process exit status.
+	Status     string  `json:"status"`
+	ExitStatus int     `json:"exit_status"`
+	Error      string  `json:"error,omitempty"`
+	Started    string  `json:"started"`
+	Finished   string  `json:"finished"`
+	Duration   float64 `json:"duration_seconds"`
+}
+
+// mergeAndNotify runs merge, uploads the output to the configured object storage, cleans up the source
+// clips as -cleanup says, and then sends the configured notifications about the result. Failed
+// notifications and cleanups are reported as warnings and do not change the result, but a failed upload does.
+func mergeAndNotify(opts options) (string, error) {
+	started := time.Now()
+	db, jobID := startJobRecord(opts, started)
+	info, err := merge(opts)
+	var output string
+	var uploads []string
+	if err == nil {
+		output = info.output
+		if opts.upload.any() {
+			opts.report("uploading", 1)
+			uploads, err = uploadOutput(opts.upload, info, opts)
+			err = withExitCode(exitUpload, err)
+		}
+	}
+	if err == nil && opts.cleanup != cleanupKeep {
+		cleanupSources(opts, info)
+	}
+	finished := time.Now()
+
+	result := mergeResult{
+		Camera:   opts.cameraName,
+		Output:   output,
+		Uploads:  uploads,
+		Status:   "success",
+		Started:  started.Format(time.RFC3339),
+		Finished: finished.Format(time.RFC3339),
+		Duration: finished.Sub(started).Seconds(),
+	}
+	if err != nil {
+		result.Status, result.ExitStatus, result.Error = "failure", exitCode(err), err.Error()
+	}
+	if db != nil {
+		var merged []string
+		if err == nil {
+			merged = segmentFiles(info.sources)
+		}
+		if err := finishJobRecord(db, jobID, result, merged); err != nil {
+			fmt.Fprintf(opts.stderr, "Warning: recording the merge in %s: %v\n", opts.db, err)
+		}
+	}
+
+	if opts.webhook != "" {
+		if err := postWebhook(opts.webhook, result); err != nil {
+			fmt.Fprintf(opts.stderr, "Warning: webhook: %v\n", err)
+		}
+	}
+	if opts.notifications.any() {
+		thumbnail := ""
+		if err == nil && opts.thumbnail && fileExists(posterFile(output)) {
+			// Reuse the poster frame written next to the output
+			thumbnail = posterFile(output)
+		} else if err == nil && opts.format != formatFrames && !opts.exportOnly {
+			var thumbErr error
+			thumbnail, thumbErr = createThumbnail(opts, output)
+			if thumbErr != nil {
+				fmt.Fprintf(opts.stderr, "Warning: %v\n", thumbErr)
+			} else {
+				defer os.Remove(thumbnail)
+			}
+		}
+		if err := sendNotifications(opts.notifications, result, thumbnail); err != nil {
+			fmt.Fprintf(opts.stderr, "Warning: notifications: %v\n", err)
+		}
+	}
+
+	// Only drop the local copy once it is safely stored elsewhere
+	if err == nil && opts.upload.any() && opts.upload.DeleteLocal {
+		if err := os.Remove(output); err != nil {
+			fmt.Fprintf(opts.stderr, "Warning: failed to remove local copy %s: %v\n", output, err)
+		} else {
+			fmt.Fprintf(opts.stdout, "Removed local copy %s after uploading\n", output)
+		}
+	}
+	return output, err
+}
+
+// createThumbnail extracts a thumbnail of the output into a temporary file and returns its name.
+func createThumbnail(opts options, output string) (string, error) {
+	f, err := os.CreateTemp(opts.workDir, "unifi-timelapse-thumbnail-*.jpg")
+	if err != nil {
+		return "", fmt.Errorf("creating thumbnail: %w", err)
+	}
+	f.Close()
+	if err := extractThumbnail(opts.ffmpegPath, output, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// postWebhook POSTs the result as JSON to url.
+func postWebhook(url string, result mergeResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return postJSON(url, body)
+}
+
+// postJSON POSTs a JSON body to url, treating any non-2xx response as an error.
+func postJSON(url string, body []byte) error {
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ONVIF namespaces of the requests sent.
+const (
+	onvifDeviceNS = "http://www.onvif.org/ver10/device/wsdl"
+	onvifMediaNS  = "http://www.onvif.org/ver10/media/wsdl"
+)
+
+// onvifClient calls the SOAP services of an ONVIF camera, authenticating with a WS-Security
+// UsernameToken when credentials are given.
+type onvifClient struct {
+	username, password string
+	http               *http.Client
+	// clockOffset is the camera's clock minus ours, as the token's timestamp must match its clock.
+	clockOffset time.Duration
+}
+
+// onvifEnvelope receives the body of a SOAP response.
+type onvifEnvelope struct {
+	Body struct {
+		Inner []byte `xml:",innerxml"`
+		Fault *struct {
+			Reason string `xml:"Reason>Text"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// resolveONVIFSource asks the ONVIF camera at device (e.g. "http://192.168.1.20") for the address of
+// its snapshots, or of its RTSP stream when stream is set or the camera has no snapshots. The address
+// carries the credentials, for fetchSnapshot and ffmpeg.
+func resolveONVIFSource(device, username, password string, stream bool) (string, error) {
+	endpoint := device
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	if u, err := url.Parse(endpoint); err != nil {
+		return "", fmt.Errorf("invalid ONVIF address: %w", err)
+	} else if u.Path == "" || u.Path == "/" {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/onvif/device_service"
+	}
+	c := &onvifClient{username: username, password: password, http: &http.Client{Timeout: notifyTimeout}}
+
+	// The only request cameras answer without authentication
+	var clock struct {
+		UTC struct {
+			Date struct{ Year, Month, Day int }     `xml:"Date"`
+			Time struct{ Hour, Minute, Second int } `xml:"Time"`
+		} `xml:"SystemDateAndTime>UTCDateTime"`
+	}
+	if err := c.call(endpoint, `<GetSystemDateAndTime xmlns="`+onvifDeviceNS+`"/>`, &clock); err == nil && clock.UTC.Date.Year > 0 {
+		d, t := clock.UTC.Date, clock.UTC.Time
+		c.clockOffset = time.Until(time.Date(d.Year, time.Month(d.Month), d.Day, t.Hour, t.Minute, t.Second, 0, time.UTC))
+	}
+
+	var caps struct {
+		Media string `xml:"Capabilities>Media>XAddr"`
+	}
+	if err := c.call(endpoint, `<GetCapabilities xmlns="`+onvifDeviceNS+`"><Category>Media</Category></GetCapabilities>`, &caps); err != nil {
+		return "", fmt.Errorf("getting the media service: %w", err)
+	}
+	if caps.Media == "" {
+		return "", fmt.Errorf("the camera offers no ONVIF media service")
+	}
+
+	var profiles struct {
+		Profiles []struct {
+			Token string `xml:"token,attr"`
+		} `xml:"Profiles"`
+	}
+	if err := c.call(caps.Media, `<GetProfiles xmlns="`+onvifMediaNS+`"/>`, &profiles); err != nil {
+		return "", fmt.Errorf("getting the media profiles: %w", err)
+	}
+	if len(profiles.Profiles) == 0 {
+		return "", fmt.Errorf("the camera has no media profiles")
+	}
+	// The first profile is the main stream, at the highest resolution
+	token := xmlEscape(profiles.Profiles[0].Token)
+
+	var media struct {
+		URI string `xml:"MediaUri>Uri"`
+	}
+	if !stream {
+		err := c.call(caps.Media, `<GetSnapshotUri xmlns="`+onvifMediaNS+`"><ProfileToken>`+token+`</ProfileToken></GetSnapshotUri>`, &media)
+		if err != nil {
+			fmt.Printf("The camera offers no snapshots (%v); grabbing frames from its stream instead\n", err)
+		} else if media.URI == "" {
+			fmt.Println("The camera offers no snapshots; grabbing frames from its stream instead")
+		}
+	}
+	if media.URI == "" {
+		err := c.call(caps.Media, `<GetStreamUri xmlns="`+onvifMediaNS+`"><StreamSetup><Stream xmlns="http://www.onvif.org/ver10/schema">RTP-Unicast</Stream>`+
+			`<Transport xmlns="http://www.onvif.org/ver10/schema"><Protocol>RTSP</Protocol></Transport></StreamSetup>`+
+			`<ProfileToken>`+token+`</ProfileToken></GetStreamUri>`, &media)
+		if err != nil {
+			return "", fmt.Errorf("getting the stream address: %w", err)
+		}
+	}
+
+	u, err := url.Parse(media.URI)
+	if err != nil {
+		return "", fmt.Errorf("invalid media address %q: %w", media.URI, err)
+	}
+	if username != "" && u.User == nil {
+		u.User = url.UserPassword(username, password)
+	}
+	return u.String(), nil
+}
+
+// call sends an ONVIF request whose body is the XML element body, and decodes the response body into result.
+func (c *onvifClient) call(endpoint, body string, result interface{}) error {
+	var envelope bytes.Buffer
+	envelope.WriteString(`<?xml version="1.0" encoding="UTF-8"?><s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Header>`)
+	if c.username != "" {
+		envelope.WriteString(c.securityHeader())
+	}
+	envelope.WriteString(`</s:Header><s:Body>` + body + `</s:Body></s:Envelope>`)
+
+	resp, err := c.http.Post(endpoint, "application/soap+xml; charset=utf-8", &envelope)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	var parsed onvifEnvelope
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		return fmt.Errorf("%s: invalid SOAP response: %w", resp.Status, err)
+	}
+	if parsed.Body.Fault != nil {
+		return fmt.Errorf("%s", firstNonEmpty(strings.TrimSpace(parsed.Body.Fault.Reason), resp.Status))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	// The response element is the only child of the body
+	return xml.Unmarshal(parsed.Body.Inner, result)
+}
+
+// securityHeader returns a WS-Security UsernameToken with a password digest, as ONVIF requires.
+func (c *onvifClient) securityHeader() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	created := time.Now().Add(c.clockOffset).UTC().Format("2006-01-02T15:04:05Z")
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(c.password))
+	digest := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	return `<Security s:mustUnderstand="1" xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">` +
+		`<UsernameToken><Username>` + xmlEscape(c.username) + `</Username>` +
+		`<Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">` + digest + `</Password>` +
+		`<Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">` + base64.StdEncoding.EncodeToString(nonce) + `</Nonce>` +
+		`<Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` + created + `</Created>` +
+		`</UsernameToken></Security>`
+}
+
+// xmlEscape escapes text for use in an XML element.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// digestAuthorization answers an HTTP Digest challenge (RFC 7616 with MD5, as cameras use it) for a
+// request of method to uri.
+func digestAuthorization(challenge, method, uri, username, password string) (string, error) {
+	params := make(map[string]string)
+	rest, ok := strings.CutPrefix(challenge, "Digest ")
+	if !ok {
+		return "", fmt.Errorf("unsupported authentication %q", challenge)
+	}
+	for _, part := range splitDigestParams(rest) {
+		key, value, _ := strings.Cut(part, "=")
+		params[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	if alg := params["algorithm"]; alg != "" && !strings.EqualFold(alg, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %s", alg)
+	}
+
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := md5hex(username + ":" + params["realm"] + ":" + password)
+	ha2 := md5hex(method + ":" + uri)
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, username, params["realm"], params["nonce"], uri)
+	if strings.Contains(params["qop"], "auth") {
+		cnonce := make([]byte, 8)
+		rand.Read(cnonce)
+		cn := hex.EncodeToString(cnonce)
+		header += fmt.Sprintf(`, qop=auth, nc=00000001, cnonce="%s", response="%s"`, cn,
+			md5hex(ha1+":"+params["nonce"]+":00000001:"+cn+":auth:"+ha2))
+	} else {
+		header += fmt.Sprintf(`, response="%s"`, md5hex(ha1+":"+params["nonce"]+":"+ha2))
+	}
+	if params["opaque"] != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, params["opaque"])
+	}
+	return header + ", algorithm=MD5", nil
+}
+
+// splitDigestParams splits the parameters of a Digest challenge at the commas outside quotes.
+func splitDigestParams(s string) []string {
+	var parts []string
+	var quoted bool
+	start := 0
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
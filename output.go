@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output versioning modes for -versioning.
+const (
+	versioningOff       = "off"
+	versioningNumber    = "number"
+	versioningTimestamp = "timestamp"
+)
+
+// maxOutputVersion is the highest numbered version tried before giving up.
+const maxOutputVersion = 999
+
+// defaultOutputTemplate names the output after the camera in the current directory.
+const defaultOutputTemplate = "{camera}_merged_timelapse.mp4"
+
+// templateVarPattern matches a {name} placeholder in an output template.
+var templateVarPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// outputTemplateVars returns the values available to output templates for the planned segments.
+func outputTemplateVars(opts options, segments []segment) map[string]string {
+	first, last := segments[0], segments[len(segments)-1]
+	end := last.end
+	if end.IsZero() {
+		end = last.start
+	}
+	return map[string]string{
+		"camera":   sanitizeFilename(opts.cameraName),
+		"site":     sanitizeFilename(opts.site),
+		"date":     first.start.Format("2006-01-02"),
+		"end_date": end.Format("2006-01-02"),
+		"speed":    strconv.FormatFloat(opts.speed, 'f', -1, 64),
+		"fps":      strconv.FormatFloat(opts.fps, 'f', -1, 64),
+	}
+}
+
+// expandOutputTemplate replaces every {name} placeholder in tmpl with its value from vars.
+// Slashes in the template create subdirectories; values never do, as they are sanitized.
+func expandOutputTemplate(tmpl string, vars map[string]string) (string, error) {
+	var unknown []string
+	out := templateVarPattern.ReplaceAllStringFunc(tmpl, func(m string) string {
+		name := m[1 : len(m)-1]
+		v, ok := vars[name]
+		if !ok {
+			unknown = append(unknown, m)
+			return m
+		}
+		return sanitizeFilename(v)
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown placeholder(s) %s in output template %q", strings.Join(unknown, ", "), tmpl)
+	}
+	return out, nil
+}
+
+// expandText replaces every known {name} placeholder in text with its value from vars, leaving
+// anything else untouched. Unlike expandOutputTemplate, values are not sanitized for file names.
+func expandText(text string, vars map[string]string) string {
+	return templateVarPattern.ReplaceAllStringFunc(text, func(m string) string {
+		if v, ok := vars[m[1:len(m)-1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// prepareOutputDir creates the directory that will hold the output file, if needed.
+func prepareOutputDir(outputFile string) error {
+	dir := filepath.Dir(outputFile)
+	if dir == "." {
+		return nil
+	}
+	return os.MkdirAll(dir, 0o755)
+}
+
+// resolveOutputFile decides where to write when the output file already exists. With versioning, a free
+// numbered or timestamped name next to it is returned. Otherwise the file is overwritten only with force or
+// after the user confirms, which is only asked when interactive is set.
+func resolveOutputFile(path string, force bool, versioning string, interactive bool) (string, error) {
+	if !fileExists(path) {
+		return path, nil
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	switch versioning {
+	case versioningNumber:
+		for n := 1; n <= maxOutputVersion; n++ {
+			candidate := fmt.Sprintf("%s_%03d%s", base, n, ext)
+			if !fileExists(candidate) {
+				return candidate, nil
+			}
+		}
+		return "", fmt.Errorf("all %d numbered versions of %s already exist", maxOutputVersion, path)
+	case versioningTimestamp:
+		candidate := fmt.Sprintf("%s_%s%s", base, time.Now().Format("20060102-150405"), ext)
+		if fileExists(candidate) {
+			return "", fmt.Errorf("output file %s already exists", candidate)
+		}
+		return candidate, nil
+	}
+
+	if force {
+		return path, nil
+	}
+	if interactive {
+		fmt.Printf("Output file %s already exists. Overwrite? [y/N] ", path)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a == "y" || a == "yes" {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("output file %s already exists; use -force to overwrite it or -versioning to keep both", path)
+}
+
+// fileExists reports whether something exists at path.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// isTerminal reports whether f is a character device such as a terminal rather than a pipe or file.
+// Character devices that are not terminals (e.g. /dev/null) read as an empty answer, which declines.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
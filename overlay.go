@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// overlayCommandsFile is the name of the temporary sendcmd file that updates the -overlay-text with the
+// date and time of the footage on screen.
+const overlayCommandsFile = "overlay.cmd"
+
+// overlayFilter is the instance name of the drawtext filter of -overlay-text, which the commands address
+// so that the title cards' drawtext filters are left alone.
+const overlayFilter = "drawtext@overlay"
+
+// overlayPositions maps the -overlay-position values to drawtext x and y expressions for a margin.
+var overlayPositions = map[string]func(margin int) (string, string){
+	"top-left":     func(m int) (string, string) { return strconv.Itoa(m), strconv.Itoa(m) },
+	"top":          func(m int) (string, string) { return "(w-text_w)/2", strconv.Itoa(m) },
+	"top-right":    func(m int) (string, string) { return fmt.Sprintf("w-text_w-%d", m), strconv.Itoa(m) },
+	"bottom-left":  func(m int) (string, string) { return strconv.Itoa(m), fmt.Sprintf("h-text_h-%d", m) },
+	"bottom":       func(m int) (string, string) { return "(w-text_w)/2", fmt.Sprintf("h-text_h-%d", m) },
+	"bottom-right": func(m int) (string, string) { return fmt.Sprintf("w-text_w-%d", m), fmt.Sprintf("h-text_h-%d", m) },
+	"center":       func(int) (string, string) { return "(w-text_w)/2", "(h-text_h)/2" },
+}
+
+// overlayTimeVars are the -overlay-text placeholders that change with the footage on screen, and the Go
+// time formats they are written in.
+var overlayTimeVars = map[string]string{
+	"date": "2006-01-02",
+	"time": "15:04:05",
+}
+
+// overlayVars returns the values of the -overlay-text placeholders that are the same over the whole output.
+func overlayVars(opts options) map[string]string {
+	return map[string]string{
+		"camera": opts.cameraName,
+		"site":   opts.site,
+		"speed":  strconv.FormatFloat(opts.speed, 'f', -1, 64),
+		"fps":    strconv.FormatFloat(opts.fps, 'f', -1, 64),
+	}
+}
+
+// checkOverlayText returns an error naming the placeholders of text that -overlay-text does not know.
+func checkOverlayText(text string) error {
+	vars := overlayVars(options{})
+	var unknown []string
+	for _, m := range templateVarPattern.FindAllStringSubmatch(text, -1) {
+		if _, ok := vars[m[1]]; !ok && overlayTimeVars[m[1]] == "" && !slices.Contains(weatherVars, m[1]) {
+			unknown = append(unknown, m[0])
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown placeholder(s) in -overlay-text: %s (use {camera}, {site}, {date}, {time}, {speed}, {fps}, {temperature} and {weather})", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// isTimedOverlay reports whether the -overlay-text shows the date, time or weather of the footage on screen,
+// and so is updated as the output plays.
+func isTimedOverlay(text string) bool {
+	for _, m := range templateVarPattern.FindAllStringSubmatch(text, -1) {
+		if overlayTimeVars[m[1]] != "" || slices.Contains(weatherVars, m[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlayTitle returns a function writing the -overlay-text for footage recorded at a given time, with the
+// weather of that hour, if any.
+func overlayTitle(opts options, weather *weatherRecord) func(t time.Time) string {
+	vars := overlayVars(opts)
+	return func(t time.Time) string {
+		at := maps.Clone(vars)
+		for name, layout := range overlayTimeVars {
+			at[name] = t.Format(layout)
+		}
+		maps.Copy(at, weather.vars(t))
+		return expandText(opts.overlayText, at)
+	}
+}
+
+// addOverlayText draws the -overlay-text on the stream: cues, when the text shows the time of the footage,
+// or the text as it is. cues are updated by the sendcmd commands in the file commands.
+func addOverlayText(g *filterGraph, opts options, cues []chapter, commands string) {
+	text := expandText(opts.overlayText, overlayVars(opts))
+	if len(cues) > 0 {
+		text = cues[0].title
+		g.add("sendcmd=f=" + escapeFilterValue(commands))
+	}
+	x, y := overlayPositions[opts.overlayPosition](opts.overlayMargin)
+	f := fmt.Sprintf("%s=expansion=none:text=%s:fontcolor=%s:fontsize=%s:x=%s:y=%s", overlayFilter,
+		escapeFilterValue(text), escapeFilterValue(opts.overlayColor), escapeFilterValue(opts.overlaySize), x, y)
+	if font := firstNonEmpty(opts.overlayFont, opts.titleFont); font != "" {
+		f += ":fontfile=" + escapeFilterValue(font)
+	}
+	if opts.overlayBox != "" {
+		f += fmt.Sprintf(":box=1:boxcolor=%s:boxborderw=%d", escapeFilterValue(opts.overlayBox), max(opts.overlayMargin/2, 1))
+	}
+	g.add(f)
+}
+
+// writeOverlayCommands writes a sendcmd file setting the text of the overlay to the title of each cue at
+// its start.
+func writeOverlayCommands(path string, cues []chapter) error {
+	// The text is escaped for the option parser of the drawtext filter, and then for the command parser
+	option := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`)
+	command := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `,`, `\,`, `;`, `\;`, ` `, `\ `)
+	var b strings.Builder
+	for _, c := range cues {
+		fmt.Fprintf(&b, "%.3f %s reinit %s;\n", c.start, overlayFilter, command.Replace("text="+option.Replace(c.title)))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Values of -format that write an MP4 and split it into segments listed by a playlist next to it,
+// which web players stream and seek in without downloading the whole video.
+const (
+	formatHLS  = "hls"
+	formatDASH = "dash"
+)
+
+// playlistSegmentSeconds is the target length of each segment. Segments start at keyframes, so
+// they are only this long when -keyint puts a keyframe at least this often.
+const playlistSegmentSeconds = "6"
+
+// playlistContentTypes maps the extensions of the files of a playlist to the types players expect
+// them to be served as, which Go does not know.
+var playlistContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mpd":  "application/dash+xml",
+	".m4s":  "video/iso.segment",
+}
+
+// playlistURI matches a URI attribute in an HLS playlist tag, such as the initialization segment of #EXT-X-MAP.
+var playlistURI = regexp.MustCompile(`URI="([^"]+)"`)
+
+// playlistDir returns the directory written next to output holding its playlist in format and the segments.
+func playlistDir(output, format string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + "_" + format
+}
+
+// playlistFile returns the playlist of output in format, in playlistDir.
+func playlistFile(output, format string) string {
+	if format == formatDASH {
+		return filepath.Join(playlistDir(output, format), "manifest.mpd")
+	}
+	return filepath.Join(playlistDir(output, format), "index.m3u8")
+}
+
+// writePlaylist splits the video and audio of output into fragmented MP4 segments without re-encoding
+// them, replacing any earlier playlist in format. It returns the playlist file.
+func writePlaylist(opts options, output, format string) (string, error) {
+	dir := playlistDir(output, format)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	playlist := playlistFile(output, format)
+
+	// Subtitles in MP4 (mov_text) cannot be segmented, so only the video and any music are kept
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", output, "-map", "0:v", "-map", "0:a?", "-c", "copy"}
+	if format == formatDASH {
+		args = append(args, "-f", "dash", "-seg_duration", playlistSegmentSeconds, "-use_template", "1", "-use_timeline", "1",
+			"-init_seg_name", "init-$RepresentationID$.m4s", "-media_seg_name", "segment-$RepresentationID$-$Number%05d$.m4s")
+	} else {
+		args = append(args, "-f", "hls", "-hls_time", playlistSegmentSeconds, "-hls_playlist_type", "vod",
+			"-hls_segment_type", "fmp4", "-hls_fmp4_init_filename", "init.mp4",
+			"-hls_segment_filename", filepath.Join(dir, "segment-%05d.m4s"))
+	}
+	args = append(args, "-y", playlist)
+
+	cmd := ffmpegCommand(opts, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%w: %s", err, lastLine(strings.TrimSpace(stderr.String())))
+	}
+	return playlist, nil
+}
+
+// withPlaylistToken returns the HLS playlist with token added as a query parameter to each file it
+// lists, for servers that require the token on every request.
+func withPlaylistToken(playlist, token string) string {
+	query := "?token=" + url.QueryEscape(token)
+	lines := strings.Split(playlist, "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "#"):
+			lines[i] = playlistURI.ReplaceAllString(line, `URI="${1}`+query+`"`)
+		case line != "":
+			lines[i] = line + query
+		}
+	}
+	return strings.Join(lines, "\n")
+}
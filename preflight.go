@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Minimum supported ffmpeg release; older releases lack filters and options used here (e.g. xfade).
+const (
+	minFFmpegMajor = 4
+	minFFmpegMinor = 3
+)
+
+// ffmpegVersionPattern extracts the release number from the first line of "ffmpeg -version".
+// Git snapshot builds ("N-113245-g...") carry no release number and are not gated.
+var ffmpegVersionPattern = regexp.MustCompile(`^ffmpeg version n?(\d+)\.(\d+)`)
+
+// ffmpegComponents lists the components an ffmpeg build must provide for a run, by kind.
+type ffmpegComponents struct {
+	demuxers []string
+	encoders []string
+	filters  []string
+	// muxers lists the output formats needed besides MP4, which every build has.
+	muxers []string
+}
+
+// requiredComponents returns the ffmpeg components needed for the given options.
+func requiredComponents(opts options) ffmpegComponents {
+	c := encodeComponents(opts)
+	if opts.playlist != "" {
+		c.muxers = append(c.muxers, opts.playlist)
+	}
+	if opts.thumbnail {
+		c.encoders = append(c.encoders, "mjpeg")
+		c.filters = append(c.filters, "scale")
+	}
+	if opts.preview != "" {
+		extra := animatedComponents(opts.preview)
+		c.encoders = append(c.encoders, extra.encoders...)
+		c.filters = append(c.filters, append(extra.filters, "setpts", "fps", "scale")...)
+	}
+	return c
+}
+
+// encodeComponents returns the ffmpeg components needed to write the output itself.
+func encodeComponents(opts options) ffmpegComponents {
+	if opts.streamCopy {
+		c := ffmpegComponents{demuxers: []string{"concat"}}
+		if opts.chapters {
+			c.demuxers = append(c.demuxers, "ffmetadata")
+		}
+		return c
+	}
+
+	c := ffmpegComponents{
+		demuxers: []string{"concat"},
+		encoders: []string{videoEncoder(opts)},
+		filters:  []string{"select", "setpts", "fps"},
+	}
+	if opts.format == formatFrames {
+		c.encoders = []string{frameEncoder(opts.frameFormat)}
+	}
+	if opts.images {
+		c.demuxers = append(c.demuxers, "image2")
+	}
+	if opts.mode == modeHyperlapse {
+		c.demuxers = append(c.demuxers, "image2")
+		c.encoders = append(c.encoders, "mjpeg")
+		if opts.hyperlapseFrame == hyperlapseSharpest {
+			c.filters = append(c.filters, "scale", "blurdetect", "metadata")
+		}
+	}
+	if isAnimatedFormat(opts.format) {
+		extra := animatedComponents(opts.format)
+		c.encoders = extra.encoders
+		c.filters = append(c.filters, append(extra.filters, "scale")...)
+	}
+	if opts.chapters {
+		c.demuxers = append(c.demuxers, "ffmetadata")
+	}
+	if opts.useGPU && opts.retryCPU && opts.retries > 0 {
+		// A failed GPU encode may be retried in software
+		software := opts
+		software.useGPU = false
+		c.encoders = append(c.encoders, videoEncoder(software))
+	}
+	if opts.minLuma > 0 {
+		c.filters = append(c.filters, "signalstats", "metadata")
+	}
+	if opts.adaptive || opts.static != staticKeep || opts.mode == modeHighlights {
+		c.filters = append(c.filters, "scale", "metadata")
+	}
+	if opts.irExclude || opts.irSpeed != 0 || opts.irTint != "" {
+		c.filters = append(c.filters, "scale", "signalstats", "metadata")
+	}
+	if opts.irTint != "" {
+		c.filters = append(c.filters, "colorchannelmixer")
+	}
+	if len(opts.privacyMasks) > 0 {
+		c.filters = append(c.filters, "drawbox", "split", "crop", "boxblur", "overlay")
+	}
+	if opts.crop != nil {
+		c.filters = append(c.filters, "crop")
+	}
+	if opts.rotate != 0 {
+		c.filters = append(c.filters, "transpose", "rotate")
+	}
+	if opts.stabilize {
+		c.filters = append(c.filters, "vidstabdetect", "vidstabtransform", "unsharp")
+	}
+	if opts.smooth || (opts.interpolate && opts.speed < 1 && !opts.hasSpeedProfile()) {
+		c.filters = append(c.filters, "minterpolate")
+	}
+	if opts.denoise != "" {
+		c.filters = append(c.filters, opts.denoise)
+	}
+	if opts.sharpen > 0 {
+		c.filters = append(c.filters, "unsharp")
+	}
+	if opts.lut != "" {
+		c.filters = append(c.filters, "lut3d")
+	}
+	if opts.brightness != 0 || opts.contrast != 1 || opts.saturation != 1 {
+		c.filters = append(c.filters, "eq")
+	}
+	if opts.deflicker {
+		c.filters = append(c.filters, "deflicker")
+	}
+	if opts.blendFrames > 0 {
+		c.filters = append(c.filters, "tmix")
+	}
+	if opts.transition != nil {
+		c.filters = append(c.filters, "split", "trim", "xfade")
+	}
+	if opts.titleCards {
+		c.filters = append(c.filters, "split", "trim", "drawbox", "drawtext", "loop", "concat")
+	}
+	if opts.watermark != "" {
+		c.filters = append(c.filters, "colorchannelmixer", "overlay")
+	}
+	if opts.draft {
+		c.filters = append(c.filters, "scale")
+	}
+	if opts.overlayText != "" {
+		c.filters = append(c.filters, "drawtext")
+		if isTimedOverlay(opts.overlayText) {
+			c.filters = append(c.filters, "sendcmd")
+		}
+	}
+	if opts.progressBar != "" {
+		c.filters = append(c.filters, "sendcmd", "drawbox")
+	}
+	for _, card := range []string{opts.intro, opts.outro} {
+		switch {
+		case card == "":
+		case isSlateFile(card):
+			c.filters = append(c.filters, "split", "trim", "drawbox", "loop", "concat", "fps", "scale2ref", "overlay")
+		default:
+			c.filters = append(c.filters, "split", "trim", "drawbox", "loop", "concat", "drawtext")
+		}
+	}
+	if opts.music != "" {
+		c.encoders = append(c.encoders, "aac")
+		c.filters = append(c.filters, "afade")
+	}
+	if opts.layout != "" {
+		c.filters = append(c.filters, "scale", "pad", "setsar", "color", "xstack", "overlay")
+		switch opts.gapFill {
+		case gapFillBlack:
+			c.filters = append(c.filters, "drawbox")
+		case gapFillSlate:
+			c.filters = append(c.filters, "drawbox", "drawtext")
+		}
+	}
+	return c
+}
+
+// videoEncoder returns the name of the ffmpeg video encoder selected by the options.
+func videoEncoder(opts options) string {
+	switch {
+	case opts.bitDepth == bitDepth10 && opts.useGPU:
+		return "hevc_nvenc"
+	case opts.bitDepth == bitDepth10:
+		return "libx265"
+	case opts.useGPU:
+		return "h264_nvenc"
+	}
+	return "libx264"
+}
+
+// preflightFFmpeg checks that ffmpeg can be run, is recent enough, and provides every required component,
+// returning its version string. Errors explain how to fix the problem.
+func preflightFFmpeg(opts options) (string, error) {
+	version, err := ffmpegVersion(opts.ffmpegPath)
+	if err != nil {
+		return "", err
+	}
+
+	if m := ffmpegVersionPattern.FindStringSubmatch(version); m != nil {
+		major, _ := strconv.Atoi(m[1])
+		minor, _ := strconv.Atoi(m[2])
+		if major < minFFmpegMajor || (major == minFFmpegMajor && minor < minFFmpegMinor) {
+			return "", fmt.Errorf("%s is too old; ffmpeg %d.%d or newer is required", version, minFFmpegMajor, minFFmpegMinor)
+		}
+	}
+
+	required := requiredComponents(opts)
+	for _, check := range []struct {
+		kind  string
+		flag  string
+		names []string
+	}{
+		{"demuxer", "-demuxers", required.demuxers},
+		{"encoder", "-encoders", required.encoders},
+		{"filter", "-filters", required.filters},
+		{"muxer", "-muxers", required.muxers},
+	} {
+		if len(check.names) == 0 {
+			continue
+		}
+		available, err := ffmpegList(opts.ffmpegPath, check.flag)
+		if err != nil {
+			return "", err
+		}
+		if missing := missingNames(check.names, available); len(missing) > 0 {
+			return "", missingComponentError(check.kind, missing)
+		}
+	}
+
+	// The encoder may be compiled in but unusable, e.g. without an NVIDIA GPU or driver
+	if opts.useGPU && !opts.streamCopy {
+		if err := testGPUEncoder(opts); err != nil {
+			return "", err
+		}
+	}
+
+	return version, nil
+}
+
+// preflightEncoder checks that ffmpeg provides the video encoder chosen once the footage is known,
+// as -bit-depth auto switches to HEVC only for 10-bit footage.
+func preflightEncoder(opts options) error {
+	available, err := ffmpegList(opts.ffmpegPath, "-encoders")
+	if err != nil {
+		return err
+	}
+	if missing := missingNames([]string{videoEncoder(opts)}, available); len(missing) > 0 {
+		return missingComponentError("encoder", missing)
+	}
+	if opts.useGPU {
+		return testGPUEncoder(opts)
+	}
+	return nil
+}
+
+// testGPUEncoder checks that the NVENC encoder of the output actually works.
+func testGPUEncoder(opts options) error {
+	encoder := videoEncoder(opts)
+	if err := testEncoder(opts.ffmpegPath, encoder, gpuArgs(opts)...); err != nil {
+		return fmt.Errorf("your ffmpeg has %s but it failed to start (%v); check the NVIDIA driver or use -gpu=false", encoder, err)
+	}
+	return nil
+}
+
+// ffmpegVersion returns the first line of "ffmpeg -version", e.g. "ffmpeg version 6.1.1-full_build ...".
+func ffmpegVersion(ffmpegPath string) (string, error) {
+	out, err := exec.Command(ffmpegPath, "-hide_banner", "-version").Output()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", fmt.Errorf("ffmpeg not found at %q; install it and add it to PATH, or pass its location with -ffmpeg", ffmpegPath)
+		}
+		return "", fmt.Errorf("running %s -version: %w", ffmpegPath, err)
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(line), nil
+}
+
+// ffmpegList returns the names printed by an ffmpeg listing option such as -encoders or -filters.
+// Every line's second column is taken as a comma-separated list of names, which covers all listing
+// formats; legend lines only add harmless entries.
+func ffmpegList(ffmpegPath, listFlag string) (map[string]bool, error) {
+	out, err := exec.Command(ffmpegPath, "-hide_banner", listFlag).Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %s %s: %w", ffmpegPath, listFlag, err)
+	}
+	names := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, name := range strings.Split(fields[1], ",") {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+// missingNames returns the sorted, de-duplicated names that are not available.
+func missingNames(names []string, available map[string]bool) []string {
+	seen := make(map[string]bool)
+	var missing []string
+	for _, n := range names {
+		if !available[n] && !seen[n] {
+			seen[n] = true
+			missing = append(missing, n)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// missingComponentError explains which components are missing and how to get them.
+func missingComponentError(kind string, missing []string) error {
+	list := strings.Join(missing, ", ")
+	switch {
+	case kind == "encoder" && missing[0] == "libwebp":
+		return fmt.Errorf("your ffmpeg lacks the libwebp encoder; use gif instead of webp or install a full build")
+	case kind == "encoder" && strings.HasSuffix(missing[0], "_nvenc"):
+		return fmt.Errorf("your ffmpeg lacks %s; use -gpu=false or install a full build with NVENC support", missing[0])
+	case kind == "encoder":
+		return fmt.Errorf("your ffmpeg lacks the %s encoder; install a full (gpl) build", list)
+	case kind == "filter" && (missing[0] == "vidstabdetect" || missing[0] == "vidstabtransform"):
+		return fmt.Errorf("your ffmpeg lacks libvidstab (filters: %s); drop -stabilize or install a full build", list)
+	default:
+		return fmt.Errorf("your ffmpeg lacks the %s(s) %s; install a full build", kind, list)
+	}
+}
+
+// testEncoder encodes a single blank frame with the named encoder, given encoderArgs, to verify that it actually works.
+func testEncoder(ffmpegPath, encoder string, encoderArgs ...string) error {
+	args := []string{"-hide_banner", "-loglevel", "error",
+		"-f", "lavfi", "-i", "color=c=black:s=256x256:d=0.1",
+		"-frames:v", "1", "-c:v", encoder}
+	args = append(args, encoderArgs...)
+	cmd := exec.Command(ffmpegPath, append(args, "-f", "null", "-")...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(lastLine(msg))
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+)
+
+// privacyMask hides a rectangle of the source frame.
+type privacyMask struct {
+	area rect
+	// blur blurs the area instead of filling it black.
+	blur bool
+}
+
+// maskConfig is the config file form of a privacy mask:
+// {"rect": "WxH+X+Y", "mode": "black" or "blur"}.
+type maskConfig struct {
+	Rect string `json:"rect"`
+	Mode string `json:"mode"`
+}
+
+// parsePrivacyMasks converts config file masks into privacy masks.
+func parsePrivacyMasks(configs []maskConfig) ([]privacyMask, error) {
+	masks := make([]privacyMask, 0, len(configs))
+	for i, mc := range configs {
+		r, err := parseRect(mc.Rect)
+		if err != nil {
+			return nil, fmt.Errorf("privacy mask %d: %w", i+1, err)
+		}
+		if r.centered {
+			return nil, fmt.Errorf("privacy mask %d: rect needs an offset, e.g. %dx%d+X+Y", i+1, r.w, r.h)
+		}
+		m := privacyMask{area: r}
+		switch mc.Mode {
+		case "", "black":
+		case "blur":
+			m.blur = true
+		default:
+			return nil, fmt.Errorf("privacy mask %d: unknown mode %q (use \"black\" or \"blur\")", i+1, mc.Mode)
+		}
+		masks = append(masks, m)
+	}
+	return masks, nil
+}
+
+// addPrivacyMasks draws the masks onto the current stream of the graph.
+// Black masks are filled boxes; blurred masks are cut out, blurred, and overlaid back in place.
+func addPrivacyMasks(g *filterGraph, masks []privacyMask) {
+	for _, m := range masks {
+		a := m.area
+		if !m.blur {
+			g.add(fmt.Sprintf("drawbox=x=%d:y=%d:w=%d:h=%d:color=black:t=fill", a.x, a.y, a.w, a.h))
+			continue
+		}
+		g.graft(func(in, out string) string {
+			base, region := g.label(), g.label()
+			return fmt.Sprintf("[%s]split[%s][%s];[%s]crop=%d:%d:%d:%d,boxblur=lr='min(w,h)/4':lp=3:cr='min(cw,ch)/4':cp=3[%s];[%s][%s]overlay=%d:%d[%s]",
+				in, base, region, region, a.w, a.h, a.x, a.y, region+"b", base, region+"b", a.x, a.y, out)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ffprobePath returns the ffprobe executable that ships alongside the given ffmpeg executable,
+// falling back to "ffprobe" from PATH when the ffmpeg name gives no hint.
+func ffprobePath(ffmpegPath string) string {
+	dir, base := filepath.Split(ffmpegPath)
+	if !strings.Contains(base, "ffmpeg") {
+		return "ffprobe"
+	}
+	return dir + strings.Replace(base, "ffmpeg", "ffprobe", 1)
+}
+
+// probeDuration returns the container duration of a media file as reported by ffprobe.
+func probeDuration(ffprobe, path string) (time.Duration, error) {
+	cmd := exec.Command(ffprobe,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("probing %s: %w: %s", path, err, lastLine(stderr.String()))
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(stdout.String()), 64)
+	if err != nil {
+		return 0, fmt.Errorf("probing %s: unexpected duration %q", path, strings.TrimSpace(stdout.String()))
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// segmentDurations returns the length of footage in each segment. The wall-clock span parsed from the
+// filenames is used when known; otherwise the file is probed and the inpoint subtracted.
+func segmentDurations(ffprobe string, segments []segment) ([]time.Duration, error) {
+	durations := make([]time.Duration, len(segments))
+	for i, seg := range segments {
+		if seg.duration > 0 {
+			durations[i] = seg.duration
+			continue
+		}
+		if !seg.end.IsZero() {
+			durations[i] = seg.end.Sub(seg.start)
+			continue
+		}
+		d, err := probeDuration(ffprobe, seg.path)
+		if err != nil {
+			return nil, err
+		}
+		if seg.outpoint > 0 && seg.outpoint < d {
+			d = seg.outpoint
+		}
+		durations[i] = d - seg.inpoint
+	}
+	return durations, nil
+}
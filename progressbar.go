@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// progressCommandsFile is the name of the temporary sendcmd file that moves the -progress-bar along.
+const progressCommandsFile = "progress.cmd"
+
+// progressFilter is the instance name of the drawbox filter filling the -progress-bar, which the commands
+// address so that the other drawbox filters are left alone.
+const progressFilter = "drawbox@progress"
+
+// progressInterval is how often the -progress-bar is moved along, in output time, often enough for it to
+// look continuous.
+const progressInterval = 100 * time.Millisecond
+
+// progressTrackColor is the color of the part of the -progress-bar not reached yet.
+const progressTrackColor = "black@0.4"
+
+// progressMaxDayTicks is the most days the -progress-bar marks; longer footage is marked at the start of
+// each month instead.
+const progressMaxDayTicks = 60
+
+// progressRange returns the wall-clock period covered by spans, from the start of the first to the end of
+// the last.
+func progressRange(spans []timeSpan) (first, last time.Time) {
+	for i, s := range spans {
+		if i == 0 || s.start.Before(first) {
+			first = s.start
+		}
+		last = latest(last, s.end)
+	}
+	return first, last
+}
+
+// progressCues returns cues titled with the fraction of the wall-clock period of spans up to the footage on
+// screen, as a number from 0 to 1, each lasting about progressInterval on the output timeline. spans are
+// played one after another; speed and ranges describe the retiming applied by the encode.
+func progressCues(spans []timeSpan, speed float64, ranges []speedRange) []chapter {
+	first, last := progressRange(spans)
+	length := last.Sub(first).Seconds()
+	return timeCues(spans, progressInterval, speed, ranges, func(t time.Time) string {
+		fraction := 1.0
+		if length > 0 {
+			fraction = min(max(t.Sub(first).Seconds()/length, 0), 1)
+		}
+		return strconv.FormatFloat(fraction, 'f', 5, 64)
+	})
+}
+
+// progressTicks returns the positions along the -progress-bar, as fractions of its length, of the midnights
+// within the wall-clock period of spans, or of the first days of the months when there are too many days.
+func progressTicks(spans []timeSpan) []float64 {
+	first, last := progressRange(spans)
+	length := last.Sub(first).Seconds()
+	next := func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	tick := next(startOfDay(first))
+	if startOfDay(last).Sub(startOfDay(first)) > progressMaxDayTicks*24*time.Hour {
+		next = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+		tick = next(time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, first.Location()))
+	}
+	var ticks []float64
+	for ; tick.Before(last); tick = next(tick) {
+		ticks = append(ticks, tick.Sub(first).Seconds()/length)
+	}
+	return ticks
+}
+
+// addProgressBar draws the -progress-bar along the bottom of the stream, with ticks at the positions given.
+// The bar is filled by a box as wide as the frame, moved in from the left by the sendcmd commands in the
+// file commands, which keeps it from filling the whole bar as a box 0 pixels wide would.
+func addProgressBar(g *filterGraph, opts options, ticks []float64, commands string) {
+	h := opts.progressBarHeight
+	color := escapeFilterValue(opts.progressBar)
+	g.add(
+		"sendcmd=f="+escapeFilterValue(commands),
+		fmt.Sprintf("drawbox=x=0:y=ih-%d:w=iw:h=%d:color=%s:t=fill", h, h, escapeFilterValue(progressTrackColor)),
+		fmt.Sprintf("%s=x=-iw:y=ih-%d:w=iw:h=%d:color=%s:t=fill", progressFilter, h, h, color),
+	)
+	// Ticks stand out of the bar so that they are seen over the filled part too
+	for _, t := range ticks {
+		g.add(fmt.Sprintf("drawbox=x=iw*%.5f:y=ih-%d:w=2:h=%d:color=%s:t=fill", t, 2*h, 2*h, color))
+	}
+}
+
+// writeProgressCommands writes a sendcmd file moving the fill of the -progress-bar to the fraction each
+// cue is titled with at its start.
+func writeProgressCommands(path string, cues []chapter) error {
+	var b strings.Builder
+	for _, c := range cues {
+		fmt.Fprintf(&b, "%.3f %s x iw*%s-iw;\n", c.start, progressFilter, c.title)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// protectExportTimeout bounds the wait for Protect to start sending an export, which it prepares first.
+const protectExportTimeout = 5 * time.Minute
+
+// errExportNeedsLogin is returned when exporting with an API key.
+var errExportNeedsLogin = errors.New("exports need a username and password, since Protect's integration API offers none")
+
+// protectTimelapseSpeeds maps the speedups Protect offers for timelapse exports to the frame rate
+// requested from its API; Protect keeps one frame every 15 seconds of footage per frame per second.
+var protectTimelapseSpeeds = map[int]int{60: 4, 120: 8, 300: 20, 600: 40}
+
+// Environment variables read when the config file leaves out the controller or its credentials.
+const (
+	envProtectHost     = "UNIFI_HOST"
+	envProtectAPIKey   = "UNIFI_API_KEY"
+	envProtectUsername = "UNIFI_USERNAME"
+	envProtectPassword = "UNIFI_PASSWORD"
+)
+
+// protectConfig holds a controller to download from, from the "protect" section of the config file.
+// The credentials may be "env:NAME" or "keychain:NAME" references instead of the secrets themselves.
+type protectConfig struct {
+	// Site names the controller when there are several, e.g. "Home" and "Cabin". Footage of a named
+	// site is kept in a subdirectory of that name.
+	Site string `json:"site"`
+	// Host is the address of the UniFi console running Protect, e.g. "192.168.1.1".
+	Host string `json:"host"`
+	// APIKey is a key created in the console's Control Plane > Integrations settings, used instead of
+	// a username and password.
+	APIKey   string `json:"api_key"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// VerifyTLS checks the console's certificate, which is self-signed unless one was installed.
+	VerifyTLS bool `json:"verify_tls"`
+}
+
+// validate checks that the controller and credentials are given.
+func (c protectConfig) validate() error {
+	if c.Host == "" {
+		return fmt.Errorf("protect needs host (or %s)", envProtectHost)
+	}
+	if c.APIKey == "" && (c.Username == "" || c.Password == "") {
+		return fmt.Errorf("protect needs api_key, or username and password (or %s, or %s and %s)", envProtectAPIKey, envProtectUsername, envProtectPassword)
+	}
+	return nil
+}
+
+// resolveSecrets replaces the "env:" and "keychain:" references among the credentials by their values.
+func (c protectConfig) resolveSecrets() (protectConfig, error) {
+	for _, field := range []*string{&c.APIKey, &c.Username, &c.Password} {
+		value, err := resolveSecret(*field)
+		if err != nil {
+			return c, err
+		}
+		*field = value
+	}
+	return c, nil
+}
+
+// protectSites lists the controllers of the "protect" section, which holds either one controller
+// or a list of them.
+type protectSites []protectConfig
+
+// UnmarshalJSON accepts a single controller as well as a list.
+func (s *protectSites) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var c protectConfig
+		if err := json.Unmarshal(data, &c); err != nil {
+			return err
+		}
+		*s = protectSites{c}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]protectConfig)(s))
+}
+
+// validate checks that several controllers have distinct site names. The credentials are only
+// checked when a controller is used, since they may come from the environment.
+func (s protectSites) validate() error {
+	seen := make(map[string]bool)
+	for i, c := range s {
+		if len(s) > 1 && c.Site == "" {
+			return fmt.Errorf("controller %d: several controllers need a site name each", i+1)
+		}
+		if seen[strings.ToLower(c.Site)] {
+			return fmt.Errorf("site %q is listed twice", c.Site)
+		}
+		seen[strings.ToLower(c.Site)] = true
+	}
+	return nil
+}
+
+// protectSitesFor returns the controllers configured in cfg, with the settings a single controller
+// leaves out taken from the UNIFI_* environment variables, or the controller described by the
+// environment alone when cfg has none.
+func protectSitesFor(cfg *config) (protectSites, error) {
+	sites := append(protectSites(nil), cfg.protect...)
+	if len(sites) == 0 && os.Getenv(envProtectHost) != "" {
+		sites = protectSites{{}}
+	}
+	if len(sites) == 0 {
+		return nil, fmt.Errorf("no \"protect\" section in %s and no %s in the environment", cfg.path, envProtectHost)
+	}
+	if len(sites) == 1 {
+		c := &sites[0]
+		c.Host = firstNonEmpty(c.Host, os.Getenv(envProtectHost))
+		if c.Username == "" && c.Password == "" {
+			c.APIKey = firstNonEmpty(c.APIKey, os.Getenv(envProtectAPIKey))
+		}
+		if c.APIKey == "" {
+			c.Username = firstNonEmpty(c.Username, os.Getenv(envProtectUsername))
+			c.Password = firstNonEmpty(c.Password, os.Getenv(envProtectPassword))
+		}
+	}
+	for i, c := range sites {
+		if err := c.validate(); err != nil {
+			if len(sites) > 1 {
+				return nil, fmt.Errorf("site %s: %w", c.Site, err)
+			}
+			return nil, err
+		}
+		sites[i] = c
+	}
+	return sites, nil
+}
+
+// named reports whether any controller has a site name, in which case sites are shown and used in paths.
+func (s protectSites) named() bool {
+	for _, c := range s {
+		if c.Site != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// selectSite returns the controllers to use: all of them, or the one named site when it is set.
+func (s protectSites) selectSite(site string) (protectSites, error) {
+	if site == "" {
+		return s, nil
+	}
+	for _, c := range s {
+		if strings.EqualFold(c.Site, site) {
+			return protectSites{c}, nil
+		}
+	}
+	return nil, fmt.Errorf("no site %q in the \"protect\" section", site)
+}
+
+// protectCamera is a camera as listed by the Protect API.
+type protectCamera struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	MarketName  string `json:"marketName"`
+	MAC         string `json:"mac"`
+	State       string `json:"state"`
+	IsConnected bool   `json:"isConnected"`
+	// Site is the site of the controller the camera belongs to; it is not part of the API.
+	Site string `json:"site,omitempty"`
+}
+
+// protectClient is a session with the Protect application of a UniFi console. With an API key it
+// uses the integration API; otherwise it logs in like the web interface and uses its private API.
+type protectClient struct {
+	base   string
+	http   *http.Client
+	apiKey string
+	csrf   string
+}
+
+// newProtectClient connects to the console described by c, logging in unless an API key is given.
+func newProtectClient(c protectConfig) (*protectClient, error) {
+	c, err := c.resolveSecrets()
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(c.Host, "/")
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	jar, _ := cookiejar.New(nil)
+	client := &protectClient{
+		base:   base,
+		apiKey: c.APIKey,
+		http: &http.Client{
+			Jar: jar,
+			Transport: &http.Transport{
+				Proxy:                 http.ProxyFromEnvironment,
+				TLSClientConfig:       &tls.Config{InsecureSkipVerify: !c.VerifyTLS},
+				TLSHandshakeTimeout:   notifyTimeout,
+				ResponseHeaderTimeout: protectExportTimeout,
+			},
+		},
+	}
+	if c.APIKey != "" {
+		return client, nil
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"username": c.Username, "password": c.Password, "rememberMe": false})
+	req, err := http.NewRequest(http.MethodPost, base+"/api/auth/login", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("logging in to %s: %w", c.Host, err)
+	}
+	resp.Body.Close()
+	return client, nil
+}
+
+// do sends a request with the session's CSRF token, which the console rotates through response
+// headers, and turns error statuses into errors.
+func (p *protectClient) do(req *http.Request) (*http.Response, error) {
+	if p.apiKey != "" {
+		req.Header.Set("X-API-KEY", p.apiKey)
+	}
+	if p.csrf != "" {
+		req.Header.Set("X-CSRF-Token", p.csrf)
+	}
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if token := firstNonEmpty(resp.Header.Get("X-Updated-CSRF-Token"), resp.Header.Get("X-CSRF-Token")); token != "" {
+		p.csrf = token
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			if p.apiKey != "" {
+				return nil, fmt.Errorf("%s: check the API key", resp.Status)
+			}
+			return nil, fmt.Errorf("%s: check the username and password, and that the user may access Protect", resp.Status)
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// get requests a path of the Protect API, which is the same for the integration and private APIs
+// where this program uses them.
+func (p *protectClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	prefix := "/proxy/protect/api"
+	if p.apiKey != "" {
+		prefix = "/proxy/protect/integration/v1"
+	}
+	target := p.base + prefix + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	return p.do(req)
+}
+
+// cameras lists the cameras adopted by Protect.
+func (p *protectClient) cameras() ([]protectCamera, error) {
+	resp, err := p.get(context.Background(), "/cameras", nil)
+	if err != nil {
+		return nil, fmt.Errorf("listing cameras: %w", err)
+	}
+	defer resp.Body.Close()
+	var cameras []protectCamera
+	if err := json.NewDecoder(resp.Body).Decode(&cameras); err != nil {
+		return nil, fmt.Errorf("listing cameras: %w", err)
+	}
+	return cameras, nil
+}
+
+// findProtectCamera logs in to the controllers of sites and returns the one with the camera whose ID is,
+// or whose name matches ignoring case, nameOrID. A camera name found at several sites is an error.
+func findProtectCamera(sites protectSites, nameOrID string) (*protectClient, protectCamera, error) {
+	var (
+		client  *protectClient
+		found   []protectCamera
+		names   []string
+		lastErr error
+	)
+	for _, site := range sites {
+		c, err := newProtectClient(site)
+		if err == nil {
+			var cameras []protectCamera
+			if cameras, err = c.cameras(); err == nil {
+				for _, cam := range cameras {
+					cam.Site = site.Site
+					if cam.ID == nameOrID || strings.EqualFold(cam.Name, nameOrID) {
+						client = c
+						found = append(found, cam)
+					}
+					names = append(names, strconv.Quote(cam.Name))
+				}
+			}
+		}
+		if err != nil {
+			if len(sites) == 1 {
+				return nil, protectCamera{}, err
+			}
+			// The camera may well be at one of the other sites
+			fmt.Fprintf(os.Stderr, "Warning: site %s: %v\n", site.Site, err)
+			lastErr = err
+		}
+	}
+
+	switch {
+	case len(found) == 1:
+		return client, found[0], nil
+	case len(found) > 1:
+		var sites []string
+		for _, cam := range found {
+			sites = append(sites, strconv.Quote(cam.Site))
+		}
+		return nil, protectCamera{}, fmt.Errorf("camera %q exists at sites %s; choose one with -site", nameOrID, strings.Join(sites, ", "))
+	case len(names) == 0 && lastErr != nil:
+		return nil, protectCamera{}, lastErr
+	}
+	return nil, protectCamera{}, fmt.Errorf("no camera %q in Protect (cameras: %s)", nameOrID, strings.Join(names, ", "))
+}
+
+// export streams the footage of a camera between start and end as an MP4 to w. With a speed from
+// protectTimelapseSpeeds Protect exports a timelapse instead of every frame.
+func (p *protectClient) export(cameraID string, start, end time.Time, speed int, w io.Writer) error {
+	if p.apiKey != "" {
+		return errExportNeedsLogin
+	}
+	query := url.Values{
+		"camera":  {cameraID},
+		"channel": {"0"},
+		"start":   {strconv.FormatInt(start.UnixMilli(), 10)},
+		"end":     {strconv.FormatInt(end.UnixMilli(), 10)},
+	}
+	if speed > 0 {
+		query.Set("type", "timelapse")
+		query.Set("fps", strconv.Itoa(protectTimelapseSpeeds[speed]))
+	}
+	resp, err := p.get(context.Background(), "/video/export", query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// snapshot writes a current JPEG snapshot of a camera to w, giving up after timeout.
+func (p *protectClient) snapshot(cameraID string, w io.Writer, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := p.get(ctx, "/cameras/"+url.PathEscape(cameraID)+"/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// runCameras implements the cameras subcommand: it lists the cameras adopted by Protect, so that
+// their exact names and IDs can be copied into commands and the config file.
+func runCameras(args []string) error {
+	fset := flagSetFor("cameras")
+	configFile := fset.String("config", "", "Path to the JSON config file with the \"protect\" section (default: \""+defaultConfigFile+"\" if present)")
+	configFromEnv := fset.Bool("config-from-env", false, configFromEnvUsage)
+	site := fset.String("site", "", "Only list the cameras of this site")
+	asJSON := fset.Bool("json", false, "Print the cameras as JSON")
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfigFlag(*configFile, *configFromEnv)
+	if err != nil {
+		return err
+	}
+	sites, err := protectSitesFor(cfg)
+	if err != nil {
+		return err
+	}
+	sites, err = sites.selectSite(*site)
+	if err != nil {
+		return err
+	}
+
+	var cameras []protectCamera
+	var failed int
+	for _, s := range sites {
+		client, err := newProtectClient(s)
+		var list []protectCamera
+		if err == nil {
+			list, err = client.cameras()
+		}
+		if err != nil {
+			if len(sites) == 1 {
+				return err
+			}
+			// List the cameras of the other sites all the same
+			fmt.Fprintf(os.Stderr, "Warning: site %s: %v\n", s.Site, err)
+			failed++
+			continue
+		}
+		for _, c := range list {
+			c.Site = s.Site
+			cameras = append(cameras, c)
+		}
+	}
+	sort.Slice(cameras, func(i, j int) bool {
+		if a, b := strings.ToLower(cameras[i].Site), strings.ToLower(cameras[j].Site); a != b {
+			return a < b
+		}
+		return strings.ToLower(cameras[i].Name) < strings.ToLower(cameras[j].Name)
+	})
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(cameras)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		showSite := sites.named()
+		if showSite {
+			fmt.Fprint(w, "SITE\t")
+		}
+		fmt.Fprintln(w, "NAME\tID\tMODEL\tSTATE")
+		for _, c := range cameras {
+			if showSite {
+				fmt.Fprintf(w, "%s\t", c.Site)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Name, c.ID, firstNonEmpty(c.MarketName, c.Type), strings.ToLower(c.State))
+		}
+		err = w.Flush()
+	}
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("%d of %d sites could not be reached", failed, len(sites))
+	}
+	return err
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rampStep is how long each step of a -ramp-in or -ramp-out speed ramp lasts on the output timeline.
+// The speed is constant within a step, so that ramps are retimed like any other speed range.
+const rampStep = 250 * time.Millisecond
+
+// windowSpeed plays the footage recorded within a daily window, or within a period of absolute time when
+// span is set, at its own speed, for -speed-at and -speed-file.
+type windowSpeed struct {
+	window dailyWindow
+	span   *wallSpan
+	speed  float64
+}
+
+// wallSpan is a period given by its wall-clock start and end, in UTC, which stand for the same times in
+// the time zone of the footage.
+type wallSpan struct {
+	start, end time.Time
+}
+
+// speedDateTimeFormat is the format of the absolute times of -speed-at and -speed-file.
+const speedDateTimeFormat = "2006-01-02 15:04"
+
+// absoluteSpeedPeriod matches a -speed-at period of absolute time, "YYYY-MM-DD HH:MM-YYYY-MM-DD HH:MM",
+// whose end may be only a time of day on the same day.
+var absoluteSpeedPeriod = regexp.MustCompile(`^\s*(\d{4}-\d{2}-\d{2}[ T]\d{1,2}:\d{2})\s*-\s*(\d{4}-\d{2}-\d{2}[ T]\d{1,2}:\d{2}|\d{1,2}:\d{2})\s*$`)
+
+// parseWindowSpeed parses a -speed-at value in the form "HH:MM-HH:MM=speed" or
+// "YYYY-MM-DD HH:MM-YYYY-MM-DD HH:MM=speed".
+func parseWindowSpeed(s string) (windowSpeed, error) {
+	period, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return windowSpeed{}, fmt.Errorf("expected HH:MM-HH:MM=speed, e.g. 06:00-09:00=20, got %q", s)
+	}
+	from, to, ok := strings.Cut(period, "-")
+	if m := absoluteSpeedPeriod.FindStringSubmatch(period); m != nil {
+		from, to, ok = m[1], m[2], true
+	}
+	if !ok {
+		return windowSpeed{}, fmt.Errorf("expected HH:MM-HH:MM=speed, e.g. 06:00-09:00=20, got %q", s)
+	}
+	return newWindowSpeed(from, to, value)
+}
+
+// newWindowSpeed returns the windowSpeed playing the footage from from to to at speed: a daily window when
+// both are times of day, and otherwise a period starting at the date and time from and ending at the date
+// and time to, or at the time of day to on the same day.
+func newWindowSpeed(from, to, speed string) (windowSpeed, error) {
+	ws := windowSpeed{}
+	var err error
+	ws.speed, err = strconv.ParseFloat(strings.TrimSpace(speed), 64)
+	if err != nil || !validSpeed(ws.speed) {
+		return windowSpeed{}, fmt.Errorf("speed %q must be a number of at least %.1f", speed, minSpeedFactor)
+	}
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if !strings.Contains(from, " ") && !strings.Contains(from, "T") {
+		ws.window, err = parseDailyWindow(from + "-" + to)
+		return ws, err
+	}
+	start, err := time.Parse(speedDateTimeFormat, strings.Replace(from, "T", " ", 1))
+	if err != nil {
+		return windowSpeed{}, fmt.Errorf("invalid date and time %q: expected YYYY-MM-DD HH:MM", from)
+	}
+	var end time.Time
+	if strings.Contains(to, "-") {
+		if end, err = time.Parse(speedDateTimeFormat, strings.Replace(to, "T", " ", 1)); err != nil {
+			return windowSpeed{}, fmt.Errorf("invalid date and time %q: expected YYYY-MM-DD HH:MM", to)
+		}
+	} else {
+		offset, err := parseTimeOfDay(to)
+		if err != nil {
+			return windowSpeed{}, err
+		}
+		end = startOfDay(start).Add(offset)
+	}
+	if !end.After(start) {
+		return windowSpeed{}, fmt.Errorf("the period from %s to %s ends before it starts", from, to)
+	}
+	ws.span = &wallSpan{start: start, end: end}
+	return ws, nil
+}
+
+// spans returns the parts of the given day covered by the window or period.
+func (ws windowSpeed) spans(day time.Time) []timeSpan {
+	if ws.span == nil {
+		return ws.window.spans(day)
+	}
+	midnight := startOfDay(day)
+	from := latest(inLocation(ws.span.start, day.Location()), midnight)
+	to := earliest(inLocation(ws.span.end, day.Location()), midnight.AddDate(0, 0, 1))
+	if !from.Before(to) {
+		return nil
+	}
+	return []timeSpan{{from, to}}
+}
+
+// inLocation returns the time with the same wall clock as t in loc.
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// readSpeedFile reads the periods of a -speed-file, a CSV file with the start, the end and the speed of
+// a period on each row, like the parts of a -speed-at value. A first row without a number for the speed
+// is taken for a header, and lines starting with # are comments.
+func readSpeedFile(path string) ([]windowSpeed, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 3
+	r.TrimLeadingSpace = true
+	var speeds []windowSpeed
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			return speeds, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64); first && err != nil {
+			continue
+		}
+		ws, err := newWindowSpeed(record[0], record[1], record[2])
+		if err != nil {
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		speeds = append(speeds, ws)
+	}
+}
+
+// profileSpeedRanges returns the speed ranges of the -ramp-in, -ramp-out, -speed-at and -speed-file speed
+// profile on the concatenated source timeline of the segments, whose lengths are durations. The ramps take
+// precedence over the windows, and earlier windows over later ones.
+func profileSpeedRanges(opts options, segments []segment, durations []time.Duration) ([]speedRange, error) {
+	var total float64
+	for _, d := range durations {
+		total += d.Seconds()
+	}
+
+	in := rampRanges(opts.rampIn, opts.rampSpeed, opts.speed)
+	out := rampRanges(opts.rampOut, opts.speed, opts.rampSpeed)
+	if rampLength(in)+rampLength(out) > total {
+		return nil, fmt.Errorf("the footage is too short for -ramp-in %s and -ramp-out %s", opts.rampIn, opts.rampOut)
+	}
+	ranges := in
+	// The ramp down ends with the footage
+	shift := total - rampLength(out)
+	for _, r := range out {
+		ranges = append(ranges, speedRange{start: r.start + shift, end: r.end + shift, speed: r.speed})
+	}
+
+	for _, ws := range opts.windowSpeeds {
+		var pos float64
+		for i, seg := range segments {
+			segEnd := seg.start.Add(durations[i])
+			for day := startOfDay(seg.start); day.Before(segEnd); day = day.AddDate(0, 0, 1) {
+				for _, span := range ws.spans(day) {
+					from, to := latest(span.start, seg.start), earliest(span.end, segEnd)
+					if from.Before(to) {
+						r := speedRange{start: pos + from.Sub(seg.start).Seconds(), end: pos + to.Sub(seg.start).Seconds(), speed: ws.speed}
+						ranges = append(ranges, uncovered(r, ranges)...)
+					}
+				}
+			}
+			pos += durations[i].Seconds()
+		}
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	return mergeSpeedRanges(ranges), nil
+}
+
+// rampRanges returns the steps of a ramp lasting length on the output timeline, from speed from to speed
+// to, positioned from the start of the source timeline. The speed changes geometrically, so that a ramp
+// from 1x to 60x feels as even as one from 10x to 600x.
+func rampRanges(length time.Duration, from, to float64) []speedRange {
+	if length <= 0 {
+		return nil
+	}
+	steps := int(math.Ceil(float64(length) / float64(rampStep)))
+	step := length.Seconds() / float64(steps)
+	ranges := make([]speedRange, 0, steps)
+	var pos float64
+	for k := 0; k < steps; k++ {
+		speed := from * math.Pow(to/from, (float64(k)+0.5)/float64(steps))
+		ranges = append(ranges, speedRange{start: pos, end: pos + step*speed, speed: speed})
+		pos += step * speed
+	}
+	return ranges
+}
+
+// rampLength returns the length of the source timeline taken by a ramp returned by rampRanges.
+func rampLength(ramp []speedRange) float64 {
+	if len(ramp) == 0 {
+		return 0
+	}
+	return ramp[len(ramp)-1].end
+}
+
+// uncovered returns the parts of r not covered by any of ranges.
+func uncovered(r speedRange, ranges []speedRange) []speedRange {
+	parts := []speedRange{r}
+	for _, c := range ranges {
+		var next []speedRange
+		for _, p := range parts {
+			if c.end <= p.start || c.start >= p.end {
+				next = append(next, p)
+				continue
+			}
+			if p.start < c.start {
+				next = append(next, speedRange{start: p.start, end: c.start, speed: p.speed})
+			}
+			if c.end < p.end {
+				next = append(next, speedRange{start: c.end, end: p.end, speed: p.speed})
+			}
+		}
+		parts = next
+	}
+	return parts
+}
+
+// mergeSpeedRanges joins adjacent ranges of the same speed, which must be sorted, so that speedExpr
+// stays short when a window spans many consecutive segments.
+func mergeSpeedRanges(ranges []speedRange) []speedRange {
+	var merged []speedRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && merged[n-1].speed == r.speed && r.start-merged[n-1].end < 1e-6 {
+			merged[n-1].end = r.end
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// latest returns the later of two times.
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// earliest returns the earlier of two times.
+func earliest(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
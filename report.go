@@ -0,0 +1,289 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultReportFile is where the report subcommand writes the report unless -o is given.
+const defaultReportFile = "coverage_report.html"
+
+// reportPage is the template of the coverage report, filled in with a coverageReport.
+//
+//go:embed ui/report.html
+var reportPage string
+
+// coverageReport is the data shown by the coverage report.
+type coverageReport struct {
+	Generated time.Time
+	Inputs    []string
+	MinGap    time.Duration
+	Cameras   []cameraReport
+}
+
+// cameraReport describes the footage of one camera.
+type cameraReport struct {
+	Name        string
+	Clips       int
+	Hours       float64
+	First, Last time.Time
+	// Days is the number of calendar days from the first to the last footage.
+	Days, DaysWithFootage, DaysWithout int
+	Months                             []reportMonth
+	Gaps                               []reportGap
+	GapHours                           float64
+	Timelapses                         []reportLink
+}
+
+// reportMonth is a calendar of one month, whose first day is preceded by Pad empty cells.
+type reportMonth struct {
+	Title string
+	Pad   []struct{}
+	Days  []reportDay
+}
+
+// reportDay is a day of a calendar, shaded by Shade when it has footage.
+type reportDay struct {
+	Date  string
+	Hours float64
+	Clips int
+	Shade float64
+}
+
+// reportGap is a period without footage between two clips.
+type reportGap struct {
+	From, To time.Time
+	Length   time.Duration
+}
+
+// findGaps returns the periods of at least minGap without footage between clips, which must be sorted
+// by start time.
+func findGaps(clips []clip, minGap time.Duration) []reportGap {
+	if len(clips) == 0 {
+		return nil
+	}
+	var gaps []reportGap
+	// Gaps are measured from the latest end so far, as clips may overlap
+	covered := clipLastTime(clips[0])
+	for _, c := range clips[1:] {
+		if gap := c.start.Sub(covered); gap >= minGap {
+			gaps = append(gaps, reportGap{From: covered, To: c.start, Length: gap})
+		}
+		if end := clipLastTime(c); end.After(covered) {
+			covered = end
+		}
+	}
+	return gaps
+}
+
+// reportLink is a timelapse found for a camera.
+type reportLink struct {
+	Name, Href, Size string
+	Modified         time.Time
+}
+
+// runReport implements the report subcommand: it writes an HTML page showing, for each camera, a
+// calendar of the days with footage, the gaps in the recording, the total footage, and the timelapses
+// made of it, for checking that a long-running capture is healthy.
+func runReport(args []string) error {
+	fset := flagSetFor("report")
+	var inputDirs, cameras, timelapseDirs stringList
+	fset.Var(&inputDirs, "input", "Directory to search for clips; repeatable (default: \""+videosDir+"\")")
+	fset.Var(&cameras, "camera", "Camera to report on; repeatable (default: every camera found)")
+	source := fset.String("source", sourceUniFi, "NVR the clips come from, as for merging")
+	dateOrder := fset.String("date-order", dateOrderAuto, "Order of the day and month in filename dates, as for merging")
+	images := fset.Bool("images", false, "Report on still images, e.g. from capture, instead of video clips (needs -camera)")
+	fset.Var(&timelapseDirs, "timelapses", "Directory to search for the timelapses of each camera, by its name; repeatable (default: the current directory)")
+	dbFile := fset.String("db", "", "Also link the outputs of the merges recorded in this -db database")
+	sqlitePath := fset.String("sqlite3", "sqlite3", "Path to the sqlite3 command-line shell used for -db")
+	minGap := fset.Duration("min-gap", time.Hour, "Shortest period without footage listed as a gap")
+	output := fset.String("o", defaultReportFile, "Output file")
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+
+	if len(inputDirs) == 0 {
+		inputDirs = stringList{videosDir}
+	}
+	if len(timelapseDirs) == 0 {
+		timelapseDirs = stringList{"."}
+	}
+	if *images && len(cameras) == 0 {
+		return fmt.Errorf("-images needs -camera, as images are found by the camera name they start with")
+	}
+	if *minGap <= 0 {
+		return fmt.Errorf("min gap must be greater than 0")
+	}
+	naming, err := namingFor(*source, *dateOrder)
+	if err != nil {
+		return err
+	}
+	var db *clipDB
+	if *dbFile != "" {
+		if db, err = openClipDB(*dbFile, *sqlitePath); err != nil {
+			return err
+		}
+	}
+
+	// Group the clips by camera, as each is reported on its own
+	byCamera := make(map[string][]clip)
+	if *images {
+		for _, camera := range cameras {
+			files, err := findImageFiles(inputDirs, camera)
+			if err != nil {
+				return fmt.Errorf("finding images: %w", err)
+			}
+			byCamera[camera] = loadImages(files, *dateOrder)
+		}
+	} else {
+		files, err := findVideoFiles(inputDirs, naming, "")
+		if err != nil {
+			return fmt.Errorf("finding video files: %w", err)
+		}
+		for _, c := range loadClips(files, naming) {
+			byCamera[c.camera] = append(byCamera[c.camera], c)
+		}
+		if len(cameras) > 0 {
+			selected := make(map[string][]clip)
+			for _, camera := range cameras {
+				selected[camera] = byCamera[camera]
+			}
+			byCamera = selected
+		}
+	}
+
+	outputDir := filepath.Dir(*output)
+	report := coverageReport{Generated: time.Now(), Inputs: inputDirs, MinGap: *minGap}
+	for name, clips := range byCamera {
+		if len(clips) == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: no footage found for camera %s\n", name)
+			continue
+		}
+		sort.SliceStable(clips, func(i, j int) bool { return clips[i].start.Before(clips[j].start) })
+		cam := reportCamera(name, clips, *minGap)
+		if cam.Timelapses, err = findTimelapses(name, timelapseDirs, naming, db, outputDir); err != nil {
+			return err
+		}
+		report.Cameras = append(report.Cameras, cam)
+	}
+	sort.Slice(report.Cameras, func(i, j int) bool { return report.Cameras[i].Name < report.Cameras[j].Name })
+
+	tmpl, err := template.New("report").Parse(reportPage)
+	if err != nil {
+		return err
+	}
+	if err := prepareOutputDir(*output); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(f, report); err != nil {
+		f.Close()
+		return fmt.Errorf("writing report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote the report on %d camera(s) to %s\n", len(report.Cameras), *output)
+	return nil
+}
+
+// reportCamera summarizes the clips of a camera, which must be sorted by start time.
+func reportCamera(name string, clips []clip, minGap time.Duration) cameraReport {
+	cam := cameraReport{Name: name, Clips: len(clips), First: clips[0].start}
+
+	cam.Gaps = findGaps(clips, minGap)
+	for _, gap := range cam.Gaps {
+		cam.GapHours += gap.Length.Hours()
+	}
+	cam.Last = clipLastTime(clips[0])
+	for _, c := range clips[1:] {
+		if end := clipLastTime(c); end.After(cam.Last) {
+			cam.Last = end
+		}
+	}
+
+	days := dailyCoverage(clips)
+	byDate := make(map[string]dayCoverage, len(days))
+	for _, d := range days {
+		byDate[d.Date] = d
+		cam.Hours += d.Seconds / 3600
+	}
+	for m := time.Date(cam.First.Year(), cam.First.Month(), 1, 0, 0, 0, 0, time.Local); !m.After(cam.Last); m = m.AddDate(0, 1, 0) {
+		month := reportMonth{Title: m.Format("January 2006"), Pad: make([]struct{}, (int(m.Weekday())+6)%7)}
+		for d := m; d.Month() == m.Month(); d = d.AddDate(0, 0, 1) {
+			key := d.Format("2006-01-02")
+			day := reportDay{Date: key}
+			if cov, ok := byDate[key]; ok {
+				day.Hours, day.Clips = cov.Seconds/3600, cov.Clips
+				// Clips without an end time in their name count as footage of unknown length
+				day.Shade = 0.25 + 0.75*min(cov.Seconds/86400, 1)
+			}
+			if !d.Before(startOfDay(cam.First)) && !d.After(cam.Last) {
+				cam.Days++
+				if day.Clips > 0 {
+					cam.DaysWithFootage++
+				}
+			}
+			month.Days = append(month.Days, day)
+		}
+		cam.Months = append(cam.Months, month)
+	}
+	cam.DaysWithout = cam.Days - cam.DaysWithFootage
+	return cam
+}
+
+// findTimelapses returns the timelapses of camera: the videos and animations in dirs whose path has
+// the camera's name in it, except its clips, and the outputs of its merges recorded in db. Links are
+// relative to the report's directory.
+func findTimelapses(camera string, dirs []string, naming clipNaming, db *clipDB, reportDir string) ([]reportLink, error) {
+	var files []string
+	seen := make(map[string]bool)
+	name := sanitizeFilename(camera)
+	match := func(path string) bool { return strings.Contains(path, name) && !naming.matches(path, "") }
+	for _, dir := range dirs {
+		if err := walkMediaDir(dir, match, []string{videoExt, "." + formatGIF, "." + formatWebP}, seen, &files); err != nil {
+			return nil, fmt.Errorf("finding timelapses: %w", err)
+		}
+	}
+	if db != nil {
+		jobs, err := db.jobs(maxHistory)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", db.path, err)
+		}
+		for _, j := range jobs {
+			if j.Camera != camera || j.Output == "" {
+				continue
+			}
+			if abs, err := filepath.Abs(j.Output); err == nil && !seen[abs] && fileExists(abs) {
+				seen[abs] = true
+				files = append(files, abs)
+			}
+		}
+	}
+
+	links := make([]reportLink, 0, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		href := file
+		if absDir, err := filepath.Abs(reportDir); err == nil {
+			if rel, err := filepath.Rel(absDir, file); err == nil {
+				href = rel
+			}
+		}
+		links = append(links, reportLink{Name: filepath.Base(file), Href: filepath.ToSlash(href), Size: formatBytes(uint64(info.Size())), Modified: info.ModTime()})
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Modified.After(links[j].Modified) })
+	return links, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Values of -priority.
+const (
+	priorityNormal = "normal"
+	// priorityLow runs ffmpeg below normal priority, so that other workloads on the machine come first.
+	priorityLow = "low"
+	// priorityIdle runs ffmpeg only when the machine has nothing else to do.
+	priorityIdle = "idle"
+)
+
+// nvencPollInterval is how often a run waiting for an NVENC session checks whether one was freed.
+const nvencPollInterval = 5 * time.Second
+
+// ffmpegCommand returns the command running ffmpeg with args at the -priority of opts, which is killed
+// when opts.ctx is cancelled.
+func ffmpegCommand(opts options, args ...string) *exec.Cmd {
+	ctx := opts.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return priorityCommand(ctx, opts.priority, opts.ffmpegPath, args...)
+}
+
+// threadArgs returns the global ffmpeg arguments limiting the threads of the filter graph to -threads, if set.
+func threadArgs(opts options) []string {
+	if opts.threads == 0 {
+		return nil
+	}
+	return []string{"-filter_complex_threads", strconv.Itoa(opts.threads)}
+}
+
+// nvencSessionPath returns the lock file of the nth of the NVENC sessions of a GPU shared by all runs.
+func nvencSessionPath(gpu, n int) string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("unifi-timelapse-nvenc-%d-%d.lock", gpu, n))
+}
+
+// acquireNVENCSession holds an NVENC session on one of the GPUs of -gpu-index until the returned lock is
+// released, and returns the GPU's index. Consumer NVIDIA cards refuse more than a few concurrent encodes,
+// so with -nvenc-sessions a run waits until fewer than that many are encoding on a GPU instead of failing.
+// Sessions are taken in turn from each GPU, so that concurrent runs spread evenly over them. The wait
+// ends with the error of opts.ctx when it is cancelled.
+func acquireNVENCSession(opts options) (*cameraLock, int, error) {
+	gpus, err := candidateGPUs(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctx := opts.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	waiting := false
+	for {
+		for n := 0; opts.nvencSessions == 0 || n < opts.nvencSessions; n++ {
+			for _, gpu := range gpus {
+				lock, _, err := tryLock(nvencSessionPath(gpu, n))
+				if err != nil {
+					return nil, 0, err
+				}
+				if lock != nil {
+					return lock, gpu, nil
+				}
+			}
+		}
+		if !waiting {
+			fmt.Fprintf(opts.stdout, "Waiting for one of %d NVENC session(s) per GPU to become free...\n", opts.nvencSessions)
+			opts.report("waiting for the GPU", 0)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-time.After(nvencPollInterval):
+		}
+	}
+}
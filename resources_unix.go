@@ -0,0 +1,29 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"os/exec"
+)
+
+// priorityCommand returns the command running name with args at priority: under nice, and under
+// ionice where it is available (Linux) so that the encode's disk access yields to others too.
+func priorityCommand(ctx context.Context, priority, name string, args ...string) *exec.Cmd {
+	var prefix []string
+	switch priority {
+	case priorityLow:
+		prefix = []string{"nice", "-n", "10"}
+		if _, err := exec.LookPath("ionice"); err == nil {
+			prefix = append([]string{"ionice", "-c", "2", "-n", "7"}, prefix...)
+		}
+	case priorityIdle:
+		prefix = []string{"nice", "-n", "19"}
+		if _, err := exec.LookPath("ionice"); err == nil {
+			prefix = append([]string{"ionice", "-c", "3"}, prefix...)
+		}
+	default:
+		return exec.CommandContext(ctx, name, args...)
+	}
+	return exec.CommandContext(ctx, prefix[0], append(prefix[1:], append([]string{name}, args...)...)...)
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// Process creation flags setting the priority class of a new process.
+const (
+	belowNormalPriorityClass = 0x00004000
+	idlePriorityClass        = 0x00000040
+)
+
+// priorityCommand returns the command running name with args in the priority class of priority.
+func priorityCommand(ctx context.Context, priority, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	switch priority {
+	case priorityLow:
+		cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: belowNormalPriorityClass}
+	case priorityIdle:
+		cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: idlePriorityClass}
+	}
+	return cmd
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRollingCache is the directory holding the encoded days of rolling timelapses.
+	defaultRollingCache = "rolling-cache"
+	// rollingDayFormat names the cached day files.
+	rollingDayFormat = "2006-01-02"
+)
+
+// runRolling implements the rolling subcommand: a daemon that keeps a timelapse of the last days of a
+// camera up to date. Every day is encoded once into a cache, and the timelapse is rebuilt from the
+// cached days without re-encoding, so each update only encodes the new day.
+func runRolling(args []string) error {
+	fset := flagSetFor("rolling")
+	var opts options
+	configFile := fset.String("config", "", "Path to a JSON config file of flag values (default: \""+defaultConfigFile+"\" if present)")
+	configFromEnv := fset.Bool("config-from-env", false, configFromEnvUsage)
+	days := fset.Int("days", 30, "Number of days the timelapse covers, up to yesterday")
+	cacheDir := fset.String("cache", defaultRollingCache, "Directory for the encoded days, in one subdirectory per camera")
+	at := fset.String("at", "01:00", "Time of day to add the previous day, once its footage is complete")
+	once := fset.Bool("once", false, "Update the timelapse once and exit instead of running daily")
+	healthListen := fset.String("health-listen", "", healthListenUsage)
+	healthMaxAge := fset.Duration("health-max-age", 0, healthMaxAgeUsage)
+	defineFlags(fset, &opts)
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfigFlag(*configFile, *configFromEnv)
+	if err != nil {
+		return err
+	}
+	if err := applyConfig(fset, cfg.flags); err != nil {
+		return fmt.Errorf("applying config %s: %w", cfg.path, err)
+	}
+	if opts.cameraName == "" {
+		return fmt.Errorf("rolling needs -camera")
+	}
+	if *days < 1 {
+		return fmt.Errorf("days must be at least 1")
+	}
+	runAt, err := parseTimeOfDay(*at)
+	if err != nil || runAt >= 24*time.Hour {
+		return fmt.Errorf("invalid -at %q: expected HH:MM", *at)
+	}
+	if err := prepareOptions(fset, &opts, cfg); err != nil {
+		return err
+	}
+	if opts.format != formatMP4 || opts.layout != "" {
+		return fmt.Errorf("rolling timelapses must be mp4 and of a single camera")
+	}
+	// The days are encoded with merge alone, so the steps after a merge would silently not run
+	if opts.cleanup != cleanupKeep || opts.db != "" || opts.webhook != "" || opts.upload.any() || opts.notifications.any() {
+		return fmt.Errorf("-cleanup, -db, -webhook, uploads and notifications do not apply to rolling timelapses")
+	}
+	if !isFlagSet(fset, "o") {
+		opts.output = fmt.Sprintf("{camera}_last_%d_days.mp4", *days)
+	}
+	opts.stdout, opts.stderr = os.Stdout, os.Stderr
+	if *healthListen != "" && *once {
+		return fmt.Errorf("-health-listen is for rolling timelapses updated daily, not -once")
+	}
+	h := newHealth(*healthMaxAge)
+	if *healthListen != "" {
+		serveHealth(*healthListen, h)
+	}
+	h.setReady()
+
+	for {
+		h.runStarted()
+		err := updateRolling(opts, *days, filepath.Join(*cacheDir, filenameText(strings.TrimSpace(opts.site+" "+opts.cameraName))))
+		h.runFinished(err)
+		if err != nil {
+			if *once {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		if *once {
+			return nil
+		}
+		next := startOfDay(time.Now()).Add(runAt)
+		if !next.After(time.Now()) {
+			next = startOfDay(time.Now()).AddDate(0, 0, 1).Add(runAt)
+		}
+		fmt.Printf("Next update at %s\n", next.Format("2006-01-02 15:04"))
+		time.Sleep(time.Until(next))
+	}
+}
+
+// updateRolling encodes the days of the window missing from cache, removes the days before the window,
+// and joins the cached days into the output.
+func updateRolling(opts options, days int, cache string) error {
+	if err := os.MkdirAll(cache, 0o755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+	today := startOfDay(time.Now())
+	first := today.AddDate(0, 0, -days)
+
+	// Drop the days that fell out of the window
+	entries, err := os.ReadDir(cache)
+	if err != nil {
+		return fmt.Errorf("reading cache directory: %w", err)
+	}
+	for _, e := range entries {
+		day, err := time.ParseInLocation(rollingDayFormat, strings.TrimSuffix(e.Name(), videoExt), time.Local)
+		if err != nil || !day.Before(first) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(cache, e.Name())); err != nil {
+			fmt.Fprintf(opts.stderr, "Warning: removing %s: %v\n", e.Name(), err)
+		} else {
+			fmt.Fprintf(opts.stdout, "Removed %s, which is older than %d days\n", e.Name(), days)
+		}
+	}
+
+	// Encode the days not cached yet that have footage
+	naming, err := namingFor(opts.source, opts.dateOrder)
+	if err != nil {
+		return err
+	}
+	findOpts := opts
+	findOpts.inputDirs = inputDirsFor(opts)
+	clips, err := findCameraClips(findOpts, naming, opts.cameraName)
+	if err != nil {
+		return err
+	}
+	var cached []time.Time
+	for day := first; day.Before(today); day = day.AddDate(0, 0, 1) {
+		file := filepath.Join(cache, day.Format(rollingDayFormat)+videoExt)
+		if fileExists(file) {
+			cached = append(cached, day)
+			continue
+		}
+		dayOpts := opts
+		dayOpts.spansFor = onlyDay(day, opts.spansFor)
+		if len(planSegments(clips, dayOpts.spansFor)) == 0 {
+			continue
+		}
+		fmt.Fprintf(opts.stdout, "Encoding %s\n", day.Format(rollingDayFormat))
+		// Encode under another name, so that an interrupted encode is not taken for a cached day
+		partial := filepath.Join(cache, ".encoding-"+filepath.Base(file))
+		dayOpts.output, dayOpts.force, dayOpts.versioning = partial, true, versioningOff
+		dayOpts.thumbnail, dayOpts.preview = false, ""
+		if _, err := merge(dayOpts); err != nil {
+			os.Remove(partial)
+			return fmt.Errorf("encoding %s: %w", day.Format(rollingDayFormat), err)
+		}
+		if err := os.Rename(partial, file); err != nil {
+			return fmt.Errorf("caching %s: %w", day.Format(rollingDayFormat), err)
+		}
+		cached = append(cached, day)
+	}
+	if len(cached) == 0 {
+		return withExitCode(exitNoFootage, fmt.Errorf("no footage for camera %s in the last %d days", opts.cameraName, days))
+	}
+
+	output, err := expandOutputTemplate(opts.output, outputTemplateVars(opts, []segment{{start: cached[0]}, {start: cached[len(cached)-1]}}))
+	if err != nil {
+		return err
+	}
+	if err := joinRollingDays(opts, cache, cached, output); err != nil {
+		return err
+	}
+	fmt.Fprintf(opts.stdout, "Updated %s with %d day(s) from %s to %s\n", output, len(cached),
+		cached[0].Format(rollingDayFormat), cached[len(cached)-1].Format(rollingDayFormat))
+	return nil
+}
+
+// onlyDay restricts spansFor, or the whole day when spansFor is nil, to the given day.
+func onlyDay(day time.Time, spansFor func(day time.Time) []timeSpan) func(day time.Time) []timeSpan {
+	return onlyDays(day, day, spansFor)
+}
+
+// onlyDays restricts spansFor, or whole days when spansFor is nil, to the days from first to last.
+func onlyDays(first, last time.Time, spansFor func(day time.Time) []timeSpan) func(day time.Time) []timeSpan {
+	// Days are compared by calendar date, as clip times are in the camera's zone rather than the local one
+	date := func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC) }
+	first, last = date(first), date(last)
+	return func(d time.Time) []timeSpan {
+		day := startOfDay(d)
+		if date(day).Before(first) || date(day).After(last) {
+			return nil
+		}
+		if spansFor != nil {
+			return spansFor(d)
+		}
+		return []timeSpan{{day, day.AddDate(0, 0, 1)}}
+	}
+}
+
+// joinRollingDays joins the cached days, in order, into output with a stream copy. The result is written next to
+// the output and renamed over it, so that the previous timelapse stays in place until the new one is done.
+func joinRollingDays(opts options, cache string, days []time.Time, output string) (err error) {
+	segments := make([]segment, len(days))
+	for i, day := range days {
+		segments[i] = segment{path: filepath.Join(cache, day.Format(rollingDayFormat)+videoExt)}
+	}
+	ws, err := newWorkspace(opts, opts.cameraName)
+	if err != nil {
+		return err
+	}
+	defer func() { ws.remove(opts, err) }()
+	list := filepath.Join(ws.dir, inputsFile)
+	if err := createInputsFile(segments, list); err != nil {
+		return fmt.Errorf("creating inputs file: %w", err)
+	}
+
+	if err := prepareOutputDir(output); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	partial := filepath.Join(filepath.Dir(output), ".rolling-"+filepath.Base(output))
+	cmd := exec.Command(opts.ffmpegPath, "-v", "error", "-f", "concat", "-safe", "0", "-i", list, "-c", "copy", "-movflags", "+faststart", "-y", partial)
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(&stderr, ws.log)
+	if err := cmd.Run(); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("joining days: %w: %s", err, lastLine(stderr.String()))
+	}
+	if err := os.Rename(partial, output); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("replacing %s: %w", output, err)
+	}
+	return nil
+}
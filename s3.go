@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Config is an S3 or S3-compatible bucket (MinIO, Backblaze B2, Google Cloud Storage with HMAC keys, ...).
+type s3Config struct {
+	// Endpoint defaults to AWS in Region, e.g. https://s3.eu-central-1.amazonaws.com.
+	Endpoint string `json:"endpoint"`
+	Region   string `json:"region"`
+	Bucket   string `json:"bucket"`
+	Prefix   string `json:"prefix"`
+	// AccessKeyID and SecretAccessKey default to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+// validate checks that the bucket and region are set.
+func (c s3Config) validate() error {
+	if c.Bucket == "" || c.Region == "" {
+		return errors.New("s3 needs bucket and region")
+	}
+	return nil
+}
+
+// s3Client signs and sends requests for one bucket with AWS Signature Version 4.
+type s3Client struct {
+	endpoint *url.URL
+	region   string
+	bucket   string
+	keyID    string
+	secret   string
+	retries  int
+	http     *http.Client
+}
+
+// uploadS3 uploads file to the bucket, in parts if it is large, and returns the object's URL.
+func uploadS3(c s3Config, file string, retries int) (string, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("endpoint: %w", err)
+	}
+	client := &s3Client{
+		endpoint: u,
+		region:   c.Region,
+		bucket:   c.Bucket,
+		keyID:    firstNonEmpty(c.AccessKeyID, os.Getenv("AWS_ACCESS_KEY_ID")),
+		secret:   firstNonEmpty(c.SecretAccessKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		retries:  retries,
+		http:     &http.Client{},
+	}
+	if client.keyID == "" || client.secret == "" {
+		return "", errors.New("no credentials; set access_key_id and secret_access_key or the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	key := objectName(c.Prefix, file)
+	// The object is served with this type, rather than as binary/octet-stream
+	header := http.Header{"Content-Type": {contentType(file)}}
+	partSize := partSizeFor(info.Size())
+	if info.Size() <= partSize {
+		body, err := readPart(f, 0, partSize, info.Size())
+		if err != nil {
+			return "", err
+		}
+		if _, err := client.do(http.MethodPut, key, nil, header, body); err != nil {
+			return "", err
+		}
+	} else if err := client.multipartUpload(key, f, header, partSize, info.Size()); err != nil {
+		return "", err
+	}
+	return client.objectURL(key, nil).String(), nil
+}
+
+// multipartUpload uploads f as key, with the headers given, in parts. The upload is aborted if it cannot
+// be completed, so that the parts stored are not left in the bucket.
+func (c *s3Client) multipartUpload(key string, f *os.File, header http.Header, partSize, size int64) error {
+	resp, err := c.do(http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(resp, &initiated); err != nil {
+		return fmt.Errorf("starting multipart upload: %w", err)
+	}
+
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	var complete struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}
+	abort := func() {
+		c.do(http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil)
+	}
+	parts := int((size + partSize - 1) / partSize)
+	for n := 0; n < parts; n++ {
+		body, err := readPart(f, n, partSize, size)
+		if err != nil {
+			abort()
+			return err
+		}
+		query := url.Values{"partNumber": {fmt.Sprint(n + 1)}, "uploadId": {initiated.UploadID}}
+		etag, err := c.putPart(key, query, body)
+		if err != nil {
+			abort()
+			return fmt.Errorf("part %d of %d: %w", n+1, parts, err)
+		}
+		complete.Parts = append(complete.Parts, part{PartNumber: n + 1, ETag: etag})
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		abort()
+		return err
+	}
+	if _, err := c.do(http.MethodPost, key, url.Values{"uploadId": {initiated.UploadID}}, nil, body); err != nil {
+		abort()
+		return fmt.Errorf("completing multipart upload: %w", err)
+	}
+	return nil
+}
+
+// putPart uploads one part and returns its ETag.
+func (c *s3Client) putPart(key string, query url.Values, body []byte) (string, error) {
+	var etag string
+	err := withRetries(c.retries, func() error {
+		resp, err := c.send(http.MethodPut, key, query, nil, body)
+		if err != nil {
+			return err
+		}
+		etag = resp.header.Get("ETag")
+		return nil
+	})
+	return etag, err
+}
+
+// do sends a signed request with retries and returns the response body.
+func (c *s3Client) do(method, key string, query url.Values, header http.Header, body []byte) ([]byte, error) {
+	var data []byte
+	err := withRetries(c.retries, func() error {
+		resp, err := c.send(method, key, query, header, body)
+		if err != nil {
+			return err
+		}
+		data = resp.body
+		return nil
+	})
+	return data, err
+}
+
+// s3Response is a successful response with its body read.
+type s3Response struct {
+	header http.Header
+	body   []byte
+}
+
+// send sends one signed request with the headers given, which are not signed, treating non-2xx
+// responses as errors.
+func (c *s3Client) send(method, key string, query url.Values, header http.Header, body []byte) (*s3Response, error) {
+	req, err := http.NewRequest(method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	c.sign(req, body, time.Now().UTC())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Code    string
+			Message string
+		}
+		if xml.Unmarshal(buf.Bytes(), &e) == nil && e.Code != "" {
+			return nil, fmt.Errorf("%s: %s: %s", resp.Status, e.Code, e.Message)
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return &s3Response{header: resp.Header, body: buf.Bytes()}, nil
+}
+
+// objectURL returns the path-style URL of key in the bucket.
+func (c *s3Client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	base := strings.TrimSuffix(u.Path, "/")
+	u.Path = base + "/" + c.bucket + "/" + key
+	u.RawPath = base + "/" + s3Escape(c.bucket, false) + "/" + s3Escape(key, false)
+	u.RawQuery = s3CanonicalQuery(query)
+	return &u
+}
+
+// sign adds the AWS Signature Version 4 headers to req.
+func (c *s3Client) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + c.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+c.secret), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.keyID, scope, signedHeaders, signature))
+}
+
+// s3CanonicalQuery encodes query parameters sorted by name as SigV4 requires.
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and slashes unless encodeSlash is set.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// sha256Hex returns the hex-encoded SHA-256 of data.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// firstNonEmpty returns the first of values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
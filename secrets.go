@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// keychainService groups the secrets of this program in the OS keychain.
+const keychainService = "unifi-timelapse"
+
+// Prefixes of config values that name where a secret is kept instead of holding it.
+const (
+	envSecretPrefix      = "env:"
+	keychainSecretPrefix = "keychain:"
+)
+
+// errNoSecret is returned for a keychain entry that does not exist.
+var errNoSecret = errors.New("no such secret; store it with set-secret")
+
+// resolveSecret returns the secret a config value refers to: the environment variable NAME for
+// "env:NAME", the OS keychain entry NAME for "keychain:NAME", and the value itself otherwise.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, envSecretPrefix):
+		name := strings.TrimPrefix(value, envSecretPrefix)
+		secret := os.Getenv(name)
+		if secret == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, keychainSecretPrefix):
+		name := strings.TrimPrefix(value, keychainSecretPrefix)
+		secret, err := readKeychain(name)
+		if err != nil {
+			return "", fmt.Errorf("reading %q from the keychain: %w", name, err)
+		}
+		return secret, nil
+	}
+	return value, nil
+}
+
+// runSetSecret implements the set-secret subcommand: it stores a secret read from stdin in the OS
+// keychain, for the config file to refer to as "keychain:NAME".
+func runSetSecret(args []string) error {
+	fset := flagSetFor("set-secret")
+	name := fset.String("name", "", "Name of the secret, e.g. protect-password (required)")
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return fmt.Errorf("set-secret needs -name")
+	}
+
+	var line string
+	var err error
+	if isTerminal(os.Stdin) {
+		fmt.Printf("Secret for %s: ", *name)
+		line, err = readHiddenLine(os.Stdin)
+		fmt.Println()
+	} else {
+		line, err = bufio.NewReader(os.Stdin).ReadString('\n')
+	}
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		if err != nil {
+			return fmt.Errorf("reading the secret: %w", err)
+		}
+		return fmt.Errorf("the secret is empty")
+	}
+	if err := writeKeychain(*name, secret); err != nil {
+		return fmt.Errorf("storing %q in the keychain: %w", *name, err)
+	}
+	fmt.Printf("Stored %s; refer to it as \"%s%s\" in the config file\n", *name, keychainSecretPrefix, *name)
+	return nil
+}
@@ -0,0 +1,102 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// readKeychain returns a secret stored with set-secret in the macOS keychain, or elsewhere in the
+// Secret Service (GNOME Keyring, KWallet) through secret-tool.
+func readKeychain(name string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", name, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "name", name)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return "", errNoSecret
+		}
+		return "", err
+	}
+	secret := strings.TrimRight(string(out), "\r\n")
+	if secret == "" {
+		// secret-tool succeeds without output for unknown secrets
+		return "", errNoSecret
+	}
+	return secret, nil
+}
+
+// writeKeychain stores a secret in the macOS keychain or the Secret Service, replacing any earlier one.
+func writeKeychain(name, secret string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// security prompts for a password on the terminal only, so the command is passed to its
+		// interactive mode on stdin instead, which keeps the secret out of the process list. -U
+		// updates an existing entry.
+		if strings.ContainsAny(secret, "\r\n") {
+			return fmt.Errorf("the secret must be a single line")
+		}
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+			securityQuote(keychainService), securityQuote(name), securityQuote(secret)))
+	} else {
+		cmd = exec.Command("secret-tool", "store", "--label", keychainService+" "+name, "service", keychainService, "name", name)
+		cmd.Stdin = strings.NewReader(secret)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := lastLine(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// securityQuote quotes s as one argument of a command of security's interactive mode.
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// readHiddenLine reads a line from the terminal f without echoing it, turning the echo off with stty.
+// The echo is turned back on when the read ends, and also on Ctrl+C, which exits.
+func readHiddenLine(f *os.File) (string, error) {
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = f
+		return cmd.Run()
+	}
+	if err := stty("-echo"); err != nil {
+		return "", fmt.Errorf("turning off the echo: %w", err)
+	}
+	defer stty("echo")
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupt:
+			stty("echo")
+			fmt.Fprintln(os.Stderr, "\nInterrupted")
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+	return bufio.NewReader(f).ReadString('\n')
+}
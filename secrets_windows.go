@@ -0,0 +1,94 @@
+//go:build windows
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Credential Manager constants from wincred.h.
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = syscall.Errno(1168)
+)
+
+// enableEchoInput is the console mode flag echoing the characters typed.
+const enableEchoInput = 0x0004
+
+// Win32 Credential Manager functions.
+var (
+	advapi32  = syscall.NewLazyDLL("advapi32.dll")
+	credRead  = advapi32.NewProc("CredReadW")
+	credWrite = advapi32.NewProc("CredWriteW")
+	credFree  = advapi32.NewProc("CredFree")
+)
+
+// credential is the Win32 CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// readKeychain returns a secret stored with set-secret in the Windows Credential Manager.
+func readKeychain(name string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(keychainService + ":" + name)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	ok, _, err := credRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ok == 0 {
+		if err == errorNotFound {
+			return "", errNoSecret
+		}
+		return "", err
+	}
+	defer credFree.Call(uintptr(unsafe.Pointer(cred)))
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+// writeKeychain stores a secret in the Windows Credential Manager, replacing any earlier one.
+func writeKeychain(name, secret string) error {
+	target, err := syscall.UTF16PtrFromString(keychainService + ":" + name)
+	if err != nil {
+		return err
+	}
+	blob := []byte(secret)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            credPersistLocalMachine,
+	}
+	ok, _, err := credWrite.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// readHiddenLine reads a line from the console f without echoing it.
+func readHiddenLine(f *os.File) (string, error) {
+	var mode uint32
+	if r, _, err := getConsoleMode.Call(f.Fd(), uintptr(unsafe.Pointer(&mode))); r == 0 {
+		return "", err
+	}
+	setConsoleMode.Call(f.Fd(), uintptr(mode&^enableEchoInput))
+	defer setConsoleMode.Call(f.Fd(), uintptr(mode))
+	return bufio.NewReader(f).ReadString('\n')
+}
@@ -0,0 +1,987 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultListenAddr is the address serve mode listens on unless -listen is given: this machine only,
+	// as other hosts may only reach the API with a -token.
+	defaultListenAddr = "127.0.0.1:8080"
+	// maxQueuedJobs is the number of jobs that may wait for the encoder before new ones are refused.
+	maxQueuedJobs = 100
+	// defaultJobsFile is where the server keeps its jobs unless -jobs is given.
+	defaultJobsFile = "serve-jobs.json"
+	// queuePrioritySetting is the job setting choosing the order of the queue rather than a flag.
+	queuePrioritySetting = "queue-priority"
+	// maxJobLog is the number of bytes of ffmpeg and status output kept per job.
+	maxJobLog = 1 << 20
+	// dbScanInterval is how often the server records new clips in its -db database.
+	dbScanInterval = 10 * time.Minute
+	// maxHistory is the number of merges listed by /api/history.
+	maxHistory = 500
+)
+
+// Job states reported by the API.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// errQueueFull is returned when a job cannot be queued because maxQueuedJobs are waiting.
+var errQueueFull = errors.New("too many queued jobs")
+
+// serverOnlySettings may only be set in the server's config file, not per job, as they choose
+// programs to run, directories to read and write or files to read into the output on the server, move
+// or delete its clips, or send requests to other hosts from it.
+var serverOnlySettings = map[string]bool{
+	"ffmpeg": true, "input": true, "workdir": true, "db": true, "sqlite3": true,
+	"archive": true, "cleanup": true,
+	"lut": true, "watermark": true, "intro": true, "outro": true, "title-font": true, "overlay-font": true, "speed-file": true,
+	"webhook": true, "weather-url": true,
+}
+
+// serverJob is a merge requested through the API.
+type serverJob struct {
+	ID       string                     `json:"id"`
+	Camera   string                     `json:"camera"`
+	Settings map[string]json.RawMessage `json:"settings"`
+	Status   string                     `json:"status"`
+	Stage    string                     `json:"stage,omitempty"`
+	Progress float64                    `json:"progress"`
+	Error    string                     `json:"error,omitempty"`
+	Output   string                     `json:"output,omitempty"`
+	// Priority orders the queue: jobs with a higher one run first, and jobs of equal priority in
+	// submission order.
+	Priority int `json:"priority"`
+	// Thumbnail and Preview are the -thumbnail and -preview files written next to the output, and
+	// Playlist the playlist of -format hls or dash.
+	Thumbnail string     `json:"thumbnail,omitempty"`
+	Preview   string     `json:"preview,omitempty"`
+	Playlist  string     `json:"playlist,omitempty"`
+	Created   time.Time  `json:"created"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+
+	opts options
+	log  *jobLog
+	// cancel stops the job while it is running.
+	cancel context.CancelFunc
+}
+
+// jobLog collects a job's output, keeping only the most recent maxJobLog bytes.
+type jobLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write appends p to the log.
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf.Write(p)
+	if extra := l.buf.Len() - maxJobLog; extra > 0 {
+		l.buf.Next(extra)
+	}
+	return len(p), nil
+}
+
+// String returns the collected output.
+func (l *jobLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+// server exposes cameras, clips, and merge jobs over HTTP. Jobs run one at a time, by priority and then
+// in submission order.
+type server struct {
+	cfg   *config
+	token string
+	// db is the -db database of the config file, or nil. With it, clips are listed from the database
+	// rather than by scanning the input directories on every request.
+	db *clipDB
+
+	mu      sync.Mutex
+	jobs    map[string]*serverJob
+	order   []string
+	nextID  int
+	metrics *serverMetrics
+	health  *health
+	// observers are called with every new or changed job, under the mutex, and must not block.
+	observers []func(job serverJob)
+	// wake tells the worker that a job was queued.
+	wake chan struct{}
+	// jobsFile keeps the jobs across restarts, or is empty to keep them in memory only.
+	jobsFile string
+}
+
+// cameraSummary describes a camera found in the input directories.
+type cameraSummary struct {
+	Name  string    `json:"name"`
+	Clips int       `json:"clips"`
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// dayCoverage is the footage recorded by a camera on one calendar day.
+type dayCoverage struct {
+	Date    string  `json:"date"`
+	Seconds float64 `json:"seconds"`
+	Clips   int     `json:"clips"`
+}
+
+// clipSummary describes one clip of a camera.
+type clipSummary struct {
+	File  string     `json:"file"`
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// isLoopbackAddr reports whether the listen address addr only accepts connections from this machine.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// runServe implements "serve": an HTTP API for listing cameras and clips, queueing merge jobs,
+// polling their progress, and downloading the results.
+func runServe(args []string) error {
+	fset := flagSetFor("serve")
+	listen := fset.String("listen", defaultListenAddr, "Address to listen on, e.g. :8080 for every interface (which needs -token)")
+	configFile := fset.String("config", "", "Path to a JSON config file with the defaults for every job (default: \""+defaultConfigFile+"\" if present)")
+	configFromEnv := fset.Bool("config-from-env", false, configFromEnvUsage)
+	token := fset.String("token", "", "Require this bearer token in the Authorization header of every request")
+	healthMaxAge := fset.Duration("health-max-age", 0, healthMaxAgeUsage)
+	jobsFile := fset.String("jobs", defaultJobsFile, "File keeping the jobs, so that queued and interrupted jobs run after a restart (\"\" to keep them in memory only)")
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+
+	if *token == "" && !isLoopbackAddr(*listen) {
+		return withExitCode(exitUsage, fmt.Errorf("-listen %s accepts jobs from other hosts, so it needs a -token", *listen))
+	}
+
+	cfg, err := loadConfigFlag(*configFile, *configFromEnv)
+	if err != nil {
+		return err
+	}
+	// Catch mistakes in the config file at startup rather than in every job
+	base, err := baseOptions(cfg)
+	if err != nil {
+		return err
+	}
+
+	s := &server{
+		cfg:      cfg,
+		token:    *token,
+		jobs:     make(map[string]*serverJob),
+		wake:     make(chan struct{}, 1),
+		jobsFile: *jobsFile,
+		metrics:  newServerMetrics(),
+		health:   newHealth(*healthMaxAge),
+	}
+	s.health.idleOK = true
+	if err := s.loadJobs(); err != nil {
+		return err
+	}
+	if base.db != "" {
+		if s.db, err = openClipDB(base.db, base.sqlitePath); err != nil {
+			return err
+		}
+		if err := s.scanClips(); err != nil {
+			return err
+		}
+		go func() {
+			for range time.Tick(dbScanInterval) {
+				if err := s.scanClips(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+			}
+		}()
+	}
+	if cfg.mqtt != nil {
+		startMQTT(s, *cfg.mqtt)
+	}
+	go s.work()
+
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Printf("Listening on %s\n", *listen)
+	s.health.setReady()
+	return srv.ListenAndServe()
+}
+
+// baseOptions returns the options set by the environment and the config file alone.
+func baseOptions(cfg *config) (options, error) {
+	var opts options
+	fset := newJobFlagSet(&opts)
+	if err := applyEnv(fset); err != nil {
+		return options{}, err
+	}
+	if err := applyConfig(fset, cfg.flags); err != nil {
+		return options{}, fmt.Errorf("applying config %s: %w", cfg.path, err)
+	}
+	if len(opts.inputDirs) == 0 {
+		opts.inputDirs = stringList{videosDir}
+	}
+	return opts, nil
+}
+
+// jobOptions returns the options for a job: its own settings, then the server's environment variables
+// and the config file for everything else.
+func jobOptions(cfg *config, settings map[string]json.RawMessage) (options, error) {
+	for name := range settings {
+		if serverOnlySettings[name] {
+			return options{}, fmt.Errorf("setting %q can only be set in the server's config file", name)
+		}
+	}
+
+	var opts options
+	fset := newJobFlagSet(&opts)
+	if err := applyConfig(fset, settings); err != nil {
+		return options{}, err
+	}
+	if err := applyEnv(fset); err != nil {
+		return options{}, err
+	}
+	if err := applyConfig(fset, cfg.flags); err != nil {
+		return options{}, fmt.Errorf("applying config %s: %w", cfg.path, err)
+	}
+	if opts.cameraName == "" {
+		return options{}, errors.New("setting \"camera\" is required")
+	}
+	// Outputs stay below the server's working directory so that they can be downloaded
+	if filepath.IsAbs(opts.output) || strings.HasPrefix(opts.output, "/") || strings.Contains(filepath.ToSlash(opts.output), "..") {
+		return options{}, fmt.Errorf("output %q must be a relative path without \"..\"", opts.output)
+	}
+	if opts.output == outputStdout {
+		return options{}, errors.New("the server cannot write outputs to its standard output")
+	}
+	// Sites are subdirectories of the server's input directories, which jobs must not leave
+	if err := checkSiteName(opts.site); err != nil {
+		return options{}, err
+	}
+	if err := prepareOptions(fset, &opts, cfg); err != nil {
+		return options{}, err
+	}
+	return opts, nil
+}
+
+// newJobFlagSet returns a flag set holding the merge flags, for applying settings from JSON.
+func newJobFlagSet(opts *options) *flag.FlagSet {
+	fset := flag.NewFlagSet("job", flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+	defineFlags(fset, opts)
+	return fset
+}
+
+// ServeHTTP routes API requests.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		// The dashboard holds no data of its own; it calls the API with the token the user enters
+		s.handleUI(w, r)
+		return
+	case "/healthz":
+		// Probes cannot send the token, and the health of the server reveals no footage
+		s.health.handleHealthz(w, r)
+		return
+	case "/readyz":
+		s.health.handleReadyz(w, r)
+		return
+	}
+	if !s.authorized(r) {
+		httpError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+		return
+	}
+
+	if r.URL.Path == "/metrics" {
+		s.handleMetrics(w, r)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "api" {
+		httpError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch {
+	case parts[1] == "cameras" && len(parts) == 2:
+		s.handleCameras(w, r)
+	case parts[1] == "cameras" && len(parts) == 4 && parts[3] == "clips":
+		s.handleClips(w, r, parts[2])
+	case parts[1] == "cameras" && len(parts) == 4 && parts[3] == "coverage":
+		s.handleCoverage(w, r, parts[2])
+	case parts[1] == "history" && len(parts) == 2:
+		s.handleHistory(w, r)
+	case parts[1] == "jobs" && len(parts) == 2:
+		s.handleJobs(w, r)
+	case parts[1] == "jobs" && len(parts) == 3:
+		s.handleJob(w, r, parts[2])
+	case parts[1] == "jobs" && len(parts) == 4 && parts[3] == "cancel":
+		s.handleJobCancel(w, r, parts[2])
+	case parts[1] == "jobs" && len(parts) == 4 && parts[3] == "log":
+		s.handleJobLog(w, r, parts[2])
+	case parts[1] == "jobs" && len(parts) == 4 && parts[3] == "output":
+		s.handleJobOutput(w, r, parts[2])
+	case parts[1] == "jobs" && len(parts) == 4 && (parts[3] == "thumbnail" || parts[3] == "preview"):
+		s.handleJobImage(w, r, parts[2], parts[3])
+	case parts[1] == "jobs" && len(parts) == 5 && parts[3] == "playlist":
+		s.handleJobPlaylist(w, r, parts[2], parts[4])
+	default:
+		httpError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authorized reports whether the request carries the server's token, if one is required.
+// Media elements and links cannot send headers, so the token may also be passed as a query parameter.
+func (s *server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if given == "" {
+		given = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) == 1
+}
+
+// handleCameras lists the cameras that have clips in the input directories.
+func (s *server) handleCameras(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	clips, err := s.findClips("")
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "finding video files: %v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, summarizeCameras(clips))
+}
+
+// summarizeCameras groups clips by camera, sorted by name.
+func summarizeCameras(clips []clip) []cameraSummary {
+	byName := make(map[string]*cameraSummary)
+	for _, c := range clips {
+		name := c.camera
+		cam, ok := byName[name]
+		if !ok {
+			cam = &cameraSummary{Name: name, First: c.start, Last: c.start}
+			byName[name] = cam
+		}
+		cam.Clips++
+		if c.start.Before(cam.First) {
+			cam.First = c.start
+		}
+		if end := clipLastTime(c); end.After(cam.Last) {
+			cam.Last = end
+		}
+	}
+
+	cameras := make([]cameraSummary, 0, len(byName))
+	for _, cam := range byName {
+		cameras = append(cameras, *cam)
+	}
+	sort.Slice(cameras, func(i, j int) bool { return cameras[i].Name < cameras[j].Name })
+	return cameras
+}
+
+// handleClips lists the clips of one camera in chronological order.
+func (s *server) handleClips(w http.ResponseWriter, r *http.Request, camera string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	clips, err := s.findClips(camera)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "finding video files: %v", err)
+		return
+	}
+
+	summaries := make([]clipSummary, 0, len(clips))
+	for _, c := range clips {
+		summary := clipSummary{File: filepath.Base(c.path), Start: c.start}
+		if !c.end.IsZero() {
+			end := c.end
+			summary.End = &end
+		}
+		summaries = append(summaries, summary)
+	}
+	if len(summaries) == 0 {
+		httpError(w, http.StatusNotFound, "no clips found for camera %s", camera)
+		return
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+// handleCoverage reports how much footage one camera recorded on each day, for the dashboard's calendar.
+func (s *server) handleCoverage(w http.ResponseWriter, r *http.Request, camera string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	clips, err := s.findClips(camera)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "finding video files: %v", err)
+		return
+	}
+	if len(clips) == 0 {
+		httpError(w, http.StatusNotFound, "no clips found for camera %s", camera)
+		return
+	}
+	writeJSON(w, http.StatusOK, dailyCoverage(clips))
+}
+
+// dailyCoverage sums the recorded time of the clips per calendar day, splitting clips at midnight.
+// Clips without an end time count towards the day they start on with zero seconds.
+func dailyCoverage(clips []clip) []dayCoverage {
+	var days []dayCoverage
+	index := make(map[string]int)
+	add := func(day time.Time, seconds float64, clips int) {
+		key := day.Format("2006-01-02")
+		i, ok := index[key]
+		if !ok {
+			i = len(days)
+			index[key] = i
+			days = append(days, dayCoverage{Date: key})
+		}
+		days[i].Seconds += seconds
+		days[i].Clips += clips
+	}
+
+	for _, c := range clips {
+		add(c.start, 0, 1)
+		for from := c.start; from.Before(c.end); {
+			to := startOfDay(from).AddDate(0, 0, 1)
+			if to.After(c.end) {
+				to = c.end
+			}
+			add(from, to.Sub(from).Seconds(), 0)
+			from = to
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days
+}
+
+// findClips returns the clips of the named camera, or of every camera when camera is empty,
+// sorted by start time. Only files whose name -source identifies as a recording are included.
+func (s *server) findClips(camera string) ([]clip, error) {
+	if s.db != nil {
+		records, err := s.db.cameraClips(camera)
+		if err != nil {
+			return nil, err
+		}
+		clips := make([]clip, len(records))
+		for i, r := range records {
+			clips[i] = r.asClip()
+		}
+		return clips, nil
+	}
+	return s.scanFiles(camera)
+}
+
+// scanFiles finds the clips of the named camera, or of every camera when camera is empty, in the
+// input directories.
+func (s *server) scanFiles(camera string) ([]clip, error) {
+	camera = clipCamera(s.cfg.aliases, camera)
+	opts, err := baseOptions(s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	naming, err := namingFor(opts.source, opts.dateOrder)
+	if err != nil {
+		return nil, err
+	}
+	files, err := findVideoFiles(opts.inputDirs, naming, camera)
+	if err != nil {
+		return nil, err
+	}
+
+	clips := loadClips(files, naming)
+	if camera != "" {
+		// Protect exports are matched by prefix, which also finds cameras whose names merely start with camera
+		matching := clips[:0]
+		for _, c := range clips {
+			if c.camera == camera {
+				matching = append(matching, c)
+			}
+		}
+		clips = matching
+	}
+	sort.Slice(clips, func(i, j int) bool { return clips[i].start.Before(clips[j].start) })
+	return clips, nil
+}
+
+// scanClips records the clips in the input directories in the database, and forgets the ones deleted.
+func (s *server) scanClips() error {
+	clips, err := s.scanFiles("")
+	if err != nil {
+		return fmt.Errorf("finding video files: %w", err)
+	}
+	if err := s.db.recordClips("", clips); err != nil {
+		return fmt.Errorf("recording clips in %s: %w", s.db.path, err)
+	}
+	if _, err := s.db.forgetMissing(); err != nil {
+		return fmt.Errorf("updating %s: %w", s.db.path, err)
+	}
+	return nil
+}
+
+// handleHistory lists the merges recorded in the database, newest first, including those run
+// outside the server.
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if s.db == nil {
+		httpError(w, http.StatusNotFound, "the history needs a database; set \"db\" in the config file")
+		return
+	}
+	jobs, err := s.db.jobs(maxHistory)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "reading history: %v", err)
+		return
+	}
+	if jobs == nil {
+		jobs = []jobRecord{}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// clipLastTime returns the end of the clip's recording, or its start when the end is unknown.
+func clipLastTime(c clip) time.Time {
+	if c.end.IsZero() {
+		return c.start
+	}
+	return c.end
+}
+
+// handleJobs lists all jobs (GET) or queues a new one (POST). The request body of a POST is a JSON
+// object of flag names and values, like the config file, e.g. {"camera": "G5 Flex", "speed": 60}.
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		jobs := make([]serverJob, 0, len(s.order))
+		for _, id := range s.order {
+			jobs = append(jobs, s.jobs[id].snapshot())
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, jobs)
+
+	case http.MethodPost:
+		var settings map[string]json.RawMessage
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&settings); err != nil {
+			httpError(w, http.StatusBadRequest, "parsing request body: %v", err)
+			return
+		}
+		job, err := s.enqueue(settings)
+		if errors.Is(err, errQueueFull) {
+			httpError(w, http.StatusServiceUnavailable, "%v", err)
+			return
+		}
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+
+		w.Header().Set("Location", "/api/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+// enqueue validates a job's settings and queues it, returning a snapshot of the new job.
+func (s *server) enqueue(settings map[string]json.RawMessage) (serverJob, error) {
+	var priority int
+	if raw, ok := settings[queuePrioritySetting]; ok {
+		if err := json.Unmarshal(raw, &priority); err != nil {
+			return serverJob{}, fmt.Errorf("setting %q must be an integer", queuePrioritySetting)
+		}
+		settings = withoutSetting(settings, queuePrioritySetting)
+	}
+	opts, err := jobOptions(s.cfg, settings)
+	if err != nil {
+		return serverJob{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count(jobQueued) >= maxQueuedJobs {
+		return serverJob{}, errQueueFull
+	}
+	job := &serverJob{
+		ID:       strconv.Itoa(s.nextID + 1),
+		Camera:   opts.cameraName,
+		Settings: settings,
+		Priority: priority,
+		Status:   jobQueued,
+		Created:  time.Now(),
+		opts:     opts,
+		log:      &jobLog{},
+	}
+	s.nextID++
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	s.saveJobs()
+	snapshot := job.snapshot()
+	s.notify(snapshot)
+	s.signal()
+	return snapshot, nil
+}
+
+// withoutSetting returns a copy of settings without name.
+func withoutSetting(settings map[string]json.RawMessage, name string) map[string]json.RawMessage {
+	rest := make(map[string]json.RawMessage, len(settings))
+	for k, v := range settings {
+		if k != name {
+			rest[k] = v
+		}
+	}
+	return rest
+}
+
+// count returns the number of jobs in status. The server mutex must be held.
+func (s *server) count(status string) int {
+	n := 0
+	for _, job := range s.jobs {
+		if job.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// signal wakes the worker, if it is waiting for a job.
+func (s *server) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// handleJob reports the state and progress of one job.
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	job, ok := s.job(id)
+	if !ok {
+		httpError(w, http.StatusNotFound, "no job %s", id)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleJobCancel cancels a queued job, or stops a running one by killing its ffmpeg processes.
+func (s *server) handleJobCancel(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		httpError(w, http.StatusNotFound, "no job %s", id)
+		return
+	}
+	switch job.Status {
+	case jobQueued:
+		now := time.Now()
+		job.Status, job.Finished = jobCancelled, &now
+		s.saveJobs()
+		s.notify(job.snapshot())
+	case jobRunning:
+		// The worker records the cancellation once the merge has stopped
+		fmt.Fprintf(job.log, "Cancelling the job\n")
+		job.cancel()
+	default:
+		httpError(w, http.StatusConflict, "job %s is %s", id, job.Status)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+// handleJobLog returns the status messages and ffmpeg output of one job as plain text.
+func (s *server) handleJobLog(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		httpError(w, http.StatusNotFound, "no job %s", id)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, job.log.String())
+}
+
+// handleJobOutput serves the video written by a finished job, with range support for seeking.
+func (s *server) handleJobOutput(w http.ResponseWriter, r *http.Request, id string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	job, ok := s.job(id)
+	if !ok {
+		httpError(w, http.StatusNotFound, "no job %s", id)
+		return
+	}
+	if job.Status != jobDone {
+		httpError(w, http.StatusConflict, "job %s is %s", id, job.Status)
+		return
+	}
+	// Without ?download the video is served inline so that browsers can play it
+	if r.URL.Query().Has("download") {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(job.Output)))
+	}
+	http.ServeFile(w, r, job.Output)
+}
+
+// handleJobImage serves the thumbnail or preview animation of a finished job.
+func (s *server) handleJobImage(w http.ResponseWriter, r *http.Request, id, kind string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	job, ok := s.job(id)
+	if !ok {
+		httpError(w, http.StatusNotFound, "no job %s", id)
+		return
+	}
+	file := job.Thumbnail
+	if kind == "preview" {
+		file = job.Preview
+	}
+	if file == "" {
+		httpError(w, http.StatusNotFound, "job %s has no %s", id, kind)
+		return
+	}
+	http.ServeFile(w, r, file)
+}
+
+// handleJobPlaylist serves the -format hls or dash playlist of a finished job, and the segments it
+// lists, which players fetch by their names relative to it.
+func (s *server) handleJobPlaylist(w http.ResponseWriter, r *http.Request, id, name string) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	job, ok := s.job(id)
+	if !ok {
+		httpError(w, http.StatusNotFound, "no job %s", id)
+		return
+	}
+	if job.Playlist == "" {
+		httpError(w, http.StatusNotFound, "job %s has no playlist", id)
+		return
+	}
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		httpError(w, http.StatusNotFound, "not found")
+		return
+	}
+	file := filepath.Join(filepath.Dir(job.Playlist), name)
+	if ctype, ok := playlistContentTypes[filepath.Ext(name)]; ok {
+		w.Header().Set("Content-Type", ctype)
+	}
+	if token := r.URL.Query().Get("token"); token != "" && filepath.Ext(name) == ".m3u8" {
+		// Players fetch the segments without the query of the playlist, so they are listed with the token
+		data, err := os.ReadFile(file)
+		if err != nil {
+			httpError(w, http.StatusNotFound, "job %s has no %s", id, name)
+			return
+		}
+		io.WriteString(w, withPlaylistToken(string(data), token))
+		return
+	}
+	http.ServeFile(w, r, file)
+}
+
+// job returns a snapshot of the job with the given ID.
+func (s *server) job(id string) (serverJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return serverJob{}, false
+	}
+	return job.snapshot(), true
+}
+
+// snapshot returns a copy of the job's exported state. The server mutex must be held.
+func (j *serverJob) snapshot() serverJob {
+	return serverJob{
+		ID:        j.ID,
+		Camera:    j.Camera,
+		Settings:  j.Settings,
+		Priority:  j.Priority,
+		Status:    j.Status,
+		Stage:     j.Stage,
+		Progress:  j.Progress,
+		Error:     j.Error,
+		Output:    j.Output,
+		Thumbnail: j.Thumbnail,
+		Preview:   j.Preview,
+		Playlist:  j.Playlist,
+		Created:   j.Created,
+		Started:   j.Started,
+		Finished:  j.Finished,
+	}
+}
+
+// work runs the queued jobs one after another.
+func (s *server) work() {
+	for {
+		job, ctx := s.next()
+		if job == nil {
+			<-s.wake
+			continue
+		}
+		s.run(job, ctx)
+	}
+}
+
+// next starts the queued job of the highest priority, submitted first among equals, and returns it with
+// the context cancelling it, or nil when no job is queued.
+func (s *server) next() (*serverJob, context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var job *serverJob
+	for _, id := range s.order {
+		if j := s.jobs[id]; j.Status == jobQueued && (job == nil || j.Priority > job.Priority) {
+			job = j
+		}
+	}
+	if job == nil {
+		return nil, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	job.Status, job.Started, job.cancel = jobRunning, &now, cancel
+	s.saveJobs()
+	s.notify(job.snapshot())
+	return job, ctx
+}
+
+// run performs one job, recording its progress and result.
+func (s *server) run(job *serverJob, ctx context.Context) {
+	opts := job.opts
+	opts.ctx = ctx
+	opts.stdout, opts.stderr = job.log, job.log
+	opts.progress = func(stage string, fraction float64) {
+		s.update(job, func() { job.Stage, job.Progress = stage, fraction })
+	}
+	opts.measure = s.metrics.measure
+	s.health.runStarted()
+	output, err := mergeAndNotify(opts)
+	s.health.runFinished(err)
+	// A job cancelled as it finished is done all the same
+	cancelled := err != nil && ctx.Err() != nil
+	job.cancel()
+	if err == nil {
+		output, err = filepath.Abs(output)
+	}
+
+	s.update(job, func() {
+		now := time.Now()
+		job.Finished = &now
+		defer s.saveJobs()
+		if cancelled {
+			job.Status = jobCancelled
+			return
+		}
+		if err != nil {
+			job.Status, job.Error = jobFailed, err.Error()
+			return
+		}
+		job.Status, job.Output, job.Progress = jobDone, output, 1
+		if poster := posterFile(output); opts.thumbnail && fileExists(poster) {
+			job.Thumbnail = poster
+		}
+		if preview := previewFile(output, opts.preview); opts.preview != "" && fileExists(preview) {
+			job.Preview = preview
+		}
+		if opts.playlist != "" {
+			job.Playlist = playlistFile(output, opts.playlist)
+		}
+	})
+	s.metrics.jobFinished(job.Status, time.Since(*job.Started).Seconds())
+	if cancelled {
+		fmt.Printf("Job %s cancelled\n", job.ID)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Job %s failed: %v\n", job.ID, err)
+	} else {
+		fmt.Printf("Job %s created: %s\n", job.ID, output)
+	}
+}
+
+// update changes a job's state under the server mutex.
+func (s *server) update(job *serverJob, change func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change()
+	s.notify(job.snapshot())
+}
+
+// notify passes a changed job to the observers. The server mutex must be held.
+func (s *server) notify(job serverJob) {
+	for _, observe := range s.observers {
+		observe(job)
+	}
+}
+
+// allowMethod reports whether the request uses method, answering 405 otherwise.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method || (method == http.MethodGet && r.Method == http.MethodHead) {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	httpError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	return false
+}
+
+// writeJSON writes v as an indented JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// httpError writes a JSON error response of the form {"error": "..."}.
+func httpError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJobOptions(t *testing.T) {
+	tests := []struct {
+		settings string
+		wantErr  string
+	}{
+		{settings: `{"camera": "Front"}`},
+		{settings: `{"camera": "Front", "site": "Cabin"}`},
+		{settings: `{"camera": "Front", "site": ".."}`, wantErr: "must be a name"},
+		{settings: `{"camera": "Front", "site": "../../etc"}`, wantErr: "must be a name"},
+		{settings: `{"camera": "Front", "o": "../out.mp4"}`, wantErr: "relative path"},
+		{settings: `{"camera": "Front", "input": "/"}`, wantErr: "server's config file"},
+	}
+	for _, tt := range tests {
+		var settings map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(tt.settings), &settings); err != nil {
+			t.Fatal(err)
+		}
+		_, err := jobOptions(&config{}, settings)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.settings, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error %v, want one containing %q", tt.settings, err, tt.wantErr)
+		}
+	}
+}
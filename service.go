@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// defaultServiceName is the name services are installed under unless -name is given.
+const defaultServiceName = "unifi-timelapse"
+
+// daemonCommands lists the commands that run until stopped, which install can run as a service.
+var daemonCommands = map[string]bool{"capture": true, "rolling": true, "serve": true}
+
+// runInstall implements the install subcommand: it registers a daemon command with its flags as a service
+// started at boot, in the working directory install runs in, so that relative paths keep their meaning.
+func runInstall(args []string) error {
+	fset := flagSetFor("install")
+	name := fset.String("name", defaultServiceName, "Name of the service, to install several with different commands")
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+	command := fset.Args()
+	if len(command) == 0 || !daemonCommands[command[0]] {
+		return fmt.Errorf("install needs the command to run and its flags, e.g. install serve -listen :8080 -token secret; the command is capture, rolling or serve")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	return installService(*name, exe, dir, command)
+}
+
+// runUninstall implements the uninstall subcommand: it stops and removes a service installed by install.
+func runUninstall(args []string) error {
+	fset := flagSetFor("uninstall")
+	name := fset.String("name", defaultServiceName, "Name of the service")
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+	return uninstallService(*name)
+}
+
+// runService implements the run-service subcommand, the command line of an installed Windows service:
+// it runs the daemon command after it while reporting to the service control manager.
+func runService(args []string) error {
+	fset := flagSetFor("run-service")
+	name := fset.String("name", defaultServiceName, "Name of the service")
+	dir := fset.String("dir", "", "Working directory of the command")
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+	command := fset.Args()
+	if len(command) == 0 || !daemonCommands[command[0]] {
+		return fmt.Errorf("run-service needs the command to run: capture, rolling or serve")
+	}
+	if *dir != "" {
+		if err := os.Chdir(*dir); err != nil {
+			return err
+		}
+	}
+	return runAsService(*name, command)
+}
+
+// serviceDescription describes the service running command for the service manager.
+func serviceDescription(command []string) string {
+	return "UniFi timelapse " + command[0]
+}
@@ -0,0 +1,129 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemdUnitDir is where install writes the systemd unit of a service.
+const systemdUnitDir = "/etc/systemd/system"
+
+// systemdWatchdog is the WatchdogSec of installed units: systemd restarts the command when it has not
+// been told within this long that the command is alive and healthy.
+const systemdWatchdog = 2 * time.Minute
+
+// sdNotify sends state to systemd when the command runs as a unit of Type=notify, and does nothing otherwise.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		// An abstract socket, which starts with a NUL byte
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notifyServiceReady tells systemd that the command has started up and, when the unit has a watchdog,
+// keeps telling it that the command is alive for as long as h is healthy, so that systemd restarts a
+// stuck instance.
+func notifyServiceReady(h *health) {
+	if err := sdNotify("READY=1"); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: notifying systemd: %v\n", err)
+		return
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Duration(usec) * time.Microsecond / 2) {
+			if _, healthy := h.status(); healthy {
+				sdNotify("WATCHDOG=1")
+			}
+		}
+	}()
+}
+
+// installService writes a systemd unit running command in dir, for systemctl to enable.
+func installService(name, exe, dir string, command []string) error {
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return fmt.Errorf("install registers services with systemd, which is not running here; run %s from your init system instead", command[0])
+	}
+	execStart := []string{systemdQuote(exe)}
+	for _, arg := range command {
+		execStart = append(execStart, systemdQuote(arg))
+	}
+	unit := fmt.Sprintf(`[Unit]
+Description=%s
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+ExecStart=%s
+WorkingDirectory=%s
+Restart=on-failure
+WatchdogSec=%d
+
+[Install]
+WantedBy=multi-user.target
+`, serviceDescription(command), strings.Join(execStart, " "), strings.ReplaceAll(dir, "%", "%%"), int(systemdWatchdog.Seconds()))
+
+	file := filepath.Join(systemdUnitDir, name+".service")
+	if err := os.WriteFile(file, []byte(unit), 0o644); err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s; start it at boot with: systemctl daemon-reload && systemctl enable --now %s\n", file, name)
+	return nil
+}
+
+// uninstallService removes the systemd unit written by installService, after stopping and disabling it.
+func uninstallService(name string) error {
+	file := filepath.Join(systemdUnitDir, name+".service")
+	if _, err := os.Stat(file); err != nil {
+		return fmt.Errorf("no service %s: %w", name, err)
+	}
+	if systemctl, err := exec.LookPath("systemctl"); err == nil {
+		// Stopping fails harmlessly when the service is not running
+		exec.Command(systemctl, "disable", "--now", name).Run()
+	}
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s\n", file)
+	return nil
+}
+
+// runAsService is only needed by Windows; systemd runs the command itself.
+func runAsService(name string, command []string) error {
+	return fmt.Errorf("run-service is started by the Windows service manager; on Linux, install writes a systemd unit running %s directly", command[0])
+}
+
+// systemdQuote quotes arg for a systemd unit: within double quotes when it has spaces or quotes, and with
+// the specifier and variable characters escaped.
+func systemdQuote(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
@@ -0,0 +1,234 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Service control manager constants from winsvc.h and winnt.h.
+const (
+	scManagerAllAccess     = 0xF003F
+	serviceAllAccess       = 0xF01FF
+	serviceWin32OwnProcess = 0x10
+	serviceAutoStart       = 2
+	serviceErrorNormal     = 1
+	serviceStopped         = 1
+	serviceStartPending    = 2
+	serviceStopPending     = 3
+	serviceRunning         = 4
+	serviceAcceptStop      = 1
+	serviceAcceptShutdown  = 4
+	serviceControlStop     = 1
+	serviceControlShutdown = 5
+	errorServiceSpecific   = 1066
+)
+
+// startPendingWaitHint is how long the service control manager is asked to wait for the next report
+// while the command starts up.
+const startPendingWaitHint = 10 * time.Second
+
+// Win32 service functions.
+var (
+	openSCManager              = advapi32.NewProc("OpenSCManagerW")
+	createService              = advapi32.NewProc("CreateServiceW")
+	openService                = advapi32.NewProc("OpenServiceW")
+	controlService             = advapi32.NewProc("ControlService")
+	deleteService              = advapi32.NewProc("DeleteService")
+	closeServiceHandle         = advapi32.NewProc("CloseServiceHandle")
+	startServiceCtrlDispatcher = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	registerServiceCtrlHandler = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	setServiceStatus           = advapi32.NewProc("SetServiceStatus")
+)
+
+// serviceStatus is the Win32 SERVICE_STATUS structure.
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// serviceTableEntry is the Win32 SERVICE_TABLE_ENTRYW structure.
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+// runningService is the status of the service this process runs as, or has a zero handle when it runs
+// from a console.
+var runningService struct {
+	mu     sync.Mutex
+	handle uintptr
+	status serviceStatus
+}
+
+// reportServiceState reports state to the service control manager, with exitCode when stopping after
+// a failure. Reports of starting up after the command became ready are ignored.
+func reportServiceState(state, exitCode uint32) {
+	runningService.mu.Lock()
+	defer runningService.mu.Unlock()
+	st := &runningService.status
+	if runningService.handle == 0 || (state == serviceStartPending && st.CurrentState == serviceRunning) {
+		return
+	}
+	st.ServiceType, st.CurrentState, st.ControlsAccepted, st.WaitHint = serviceWin32OwnProcess, state, 0, 0
+	switch state {
+	case serviceStartPending:
+		// Each report while starting up tells the manager to keep waiting
+		st.CheckPoint++
+		st.WaitHint = uint32(startPendingWaitHint.Milliseconds())
+	case serviceRunning:
+		st.ControlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+		st.CheckPoint = 0
+	}
+	if exitCode != 0 {
+		st.Win32ExitCode, st.ServiceSpecificExitCode = errorServiceSpecific, exitCode
+	}
+	setServiceStatus.Call(runningService.handle, uintptr(unsafe.Pointer(st)))
+}
+
+// notifyServiceReady tells the service control manager that the command has started up, when it runs
+// as a service. Windows has no watchdog; /healthz serves for that.
+func notifyServiceReady(h *health) {
+	reportServiceState(serviceRunning, 0)
+}
+
+// runAsService runs command as the service name, which the service control manager has started.
+func runAsService(name string, command []string) error {
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	var result error
+	handler := syscall.NewCallback(func(control, eventType, eventData, context uintptr) uintptr {
+		if control == serviceControlStop || control == serviceControlShutdown {
+			// The daemon commands keep nothing in memory worth finishing, so the process ends with them
+			reportServiceState(serviceStopPending, 0)
+			reportServiceState(serviceStopped, 0)
+			os.Exit(0)
+		}
+		return 0
+	})
+	serviceMain := syscall.NewCallback(func(argc, argv uintptr) uintptr {
+		h, _, err := registerServiceCtrlHandler.Call(uintptr(unsafe.Pointer(namePtr)), handler, 0)
+		if h == 0 {
+			result = fmt.Errorf("registering the service handler: %w", err)
+			return 0
+		}
+		runningService.mu.Lock()
+		runningService.handle = h
+		runningService.mu.Unlock()
+
+		// Keep the manager waiting until the command is ready, e.g. once serve has scanned its database
+		reportServiceState(serviceStartPending, 0)
+		done := make(chan error, 1)
+		go func() { done <- subcommands[command[0]](command[1:]) }()
+		ticker := time.NewTicker(startPendingWaitHint / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case result = <-done:
+				var code uint32
+				if result != nil {
+					code = 1
+				}
+				reportServiceState(serviceStopped, code)
+				return 0
+			case <-ticker.C:
+				reportServiceState(serviceStartPending, 0)
+			}
+		}
+	})
+
+	table := []serviceTableEntry{{name: namePtr, proc: serviceMain}, {}}
+	if r, _, err := startServiceCtrlDispatcher.Call(uintptr(unsafe.Pointer(&table[0]))); r == 0 {
+		return fmt.Errorf("run-service is started by the Windows service manager, not by hand: %w", err)
+	}
+	return result
+}
+
+// installService registers a service started at boot that runs command in dir through run-service.
+func installService(name, exe, dir string, command []string) error {
+	args := []string{exe, "run-service", "-name", name, "-dir", dir}
+	args = append(args, command...)
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = syscall.EscapeArg(a)
+	}
+
+	manager, err := openServiceManager()
+	if err != nil {
+		return err
+	}
+	defer closeServiceHandle.Call(manager)
+	ptrs, err := utf16Ptrs(name, serviceDescription(command), strings.Join(quoted, " "))
+	if err != nil {
+		return err
+	}
+	service, _, err := createService.Call(manager, uintptr(unsafe.Pointer(ptrs[0])), uintptr(unsafe.Pointer(ptrs[1])),
+		serviceAllAccess, serviceWin32OwnProcess, serviceAutoStart, serviceErrorNormal, uintptr(unsafe.Pointer(ptrs[2])), 0, 0, 0, 0, 0)
+	if service == 0 {
+		return fmt.Errorf("creating service %s: %w", name, err)
+	}
+	closeServiceHandle.Call(service)
+	fmt.Printf("Installed service %s, which starts at boot; start it now with: sc start %s\n", name, name)
+	return nil
+}
+
+// uninstallService stops the service name and removes it.
+func uninstallService(name string) error {
+	manager, err := openServiceManager()
+	if err != nil {
+		return err
+	}
+	defer closeServiceHandle.Call(manager)
+	ptrs, err := utf16Ptrs(name)
+	if err != nil {
+		return err
+	}
+	service, _, err := openService.Call(manager, uintptr(unsafe.Pointer(ptrs[0])), serviceAllAccess)
+	if service == 0 {
+		return fmt.Errorf("opening service %s: %w", name, err)
+	}
+	defer closeServiceHandle.Call(service)
+	// Stopping fails harmlessly when the service is not running
+	var st serviceStatus
+	controlService.Call(service, serviceControlStop, uintptr(unsafe.Pointer(&st)))
+	if r, _, err := deleteService.Call(service); r == 0 {
+		return fmt.Errorf("removing service %s: %w", name, err)
+	}
+	fmt.Printf("Removed service %s\n", name)
+	return nil
+}
+
+// openServiceManager connects to the service control manager, which needs an elevated prompt.
+func openServiceManager() (uintptr, error) {
+	manager, _, err := openSCManager.Call(0, 0, scManagerAllAccess)
+	if manager == 0 {
+		return 0, fmt.Errorf("connecting to the service manager (run as administrator): %w", err)
+	}
+	return manager, nil
+}
+
+// utf16Ptrs converts strs for Win32 calls.
+func utf16Ptrs(strs ...string) ([]*uint16, error) {
+	ptrs := make([]*uint16, len(strs))
+	for i, s := range strs {
+		p, err := syscall.UTF16PtrFromString(s)
+		if err != nil {
+			return nil, err
+		}
+		ptrs[i] = p
+	}
+	return ptrs, nil
+}
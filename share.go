@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// webdavConfig is a WebDAV collection (e.g. Nextcloud or a NAS) that outputs are PUT into.
+type webdavConfig struct {
+	// URL is the collection, e.g. https://nas.local/remote.php/dav/files/me/timelapses/.
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// sftpConfig is a directory on an SSH server that outputs are copied to with OpenSSH's scp,
+// authenticating with a key since there is no one to type a password.
+type sftpConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	User string `json:"user"`
+	// Path is the remote directory.
+	Path         string `json:"path"`
+	IdentityFile string `json:"identity_file"`
+	// Command is the scp executable (default: "scp" from PATH).
+	Command string `json:"command"`
+}
+
+// validate checks that the collection URL is set.
+func (c webdavConfig) validate() error {
+	if c.URL == "" {
+		return errors.New("webdav needs url")
+	}
+	return nil
+}
+
+// validate checks that the host and path are set.
+func (c sftpConfig) validate() error {
+	if c.Host == "" || c.Path == "" {
+		return errors.New("sftp needs host and path")
+	}
+	return nil
+}
+
+// uploadWebDAV PUTs file into the collection and returns its URL.
+func uploadWebDAV(c webdavConfig, file string, retries int) (string, error) {
+	target := strings.TrimSuffix(c.URL, "/") + "/" + s3Escape(filepath.Base(file), false)
+	err := withRetries(retries, func() error {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodPut, target, f)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = info.Size()
+		req.Header.Set("Content-Type", contentType(file))
+		if c.Username != "" {
+			req.SetBasicAuth(c.Username, c.Password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s", resp.Status)
+		}
+		return nil
+	})
+	return target, err
+}
+
+// uploadSFTP copies file to the remote directory with scp and returns its scp-style location.
+func uploadSFTP(c sftpConfig, file string, retries int) (string, error) {
+	command := firstNonEmpty(c.Command, "scp")
+	remote := c.Host + ":" + strings.TrimSuffix(c.Path, "/") + "/" + filepath.Base(file)
+	if c.User != "" {
+		remote = c.User + "@" + remote
+	}
+	// BatchMode fails instead of prompting for a password or host key confirmation
+	args := []string{"-q", "-o", "BatchMode=yes"}
+	if c.Port != 0 {
+		args = append(args, "-P", strconv.Itoa(c.Port))
+	}
+	if c.IdentityFile != "" {
+		args = append(args, "-i", c.IdentityFile)
+	}
+	args = append(args, file, remote)
+
+	err := withRetries(retries, func() error {
+		cmd := exec.Command(command, args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return fmt.Errorf("%w: %s", err, lastLine(msg))
+			}
+			return err
+		}
+		return nil
+	})
+	return remote, err
+}
+
+// copyToDirectory copies file into dir, e.g. a mounted NAS share or a UNC path such as \\nas\video.
+// The copy is written under a temporary name and renamed when complete, so that media libraries
+// watching the directory never pick up a partial file.
+func copyToDirectory(dir, file string) (string, error) {
+	dest := filepath.Join(dir, filepath.Base(file))
+	tmp := dest + ".part"
+
+	src, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return dest, nil
+}
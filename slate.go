@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// introSlate is the -intro value for a slate generated with the camera's name and the dates of the footage.
+const introSlate = "slate"
+
+// slateDateFormat is the Go time format for the dates on a generated slate.
+const slateDateFormat = "January 2, 2006"
+
+// slateVideoExts are the extensions of the video files -intro and -outro play; imageExts are shown as stills.
+var slateVideoExts = []string{".mp4", ".mov", ".mkv", ".webm"}
+
+// slate is a card shown before or after the footage: lines of text on black, or an image or video file
+// fitted onto black.
+type slate struct {
+	lines []string
+	file  string
+	video bool
+	// length is how long the card is shown, in seconds: the length of a video, or -slate-duration.
+	length float64
+}
+
+// isSlateFile reports whether an -intro or -outro value names an image or video file rather than text.
+func isSlateFile(value string) bool {
+	ext := strings.ToLower(filepath.Ext(value))
+	return slices.Contains(imageExts, ext) || slices.Contains(slateVideoExts, ext)
+}
+
+// checkSlate validates an -intro or -outro value given to flag.
+func checkSlate(flag, value string) error {
+	if !isSlateFile(value) {
+		return nil
+	}
+	if _, err := os.Stat(value); err != nil {
+		return fmt.Errorf("-%s file: %w", flag, err)
+	}
+	return nil
+}
+
+// newSlate returns the card an -intro or -outro value stands for, or nil if it is empty. A generated
+// slate names the camera and the days from first to last.
+func newSlate(opts options, value string, first, last time.Time) (*slate, error) {
+	s := &slate{length: opts.slateDuration.Seconds()}
+	switch {
+	case value == "":
+		return nil, nil
+	case value == introSlate:
+		dates := first.Format(slateDateFormat)
+		if startOfDay(last).After(startOfDay(first)) {
+			dates += " – " + last.Format(slateDateFormat)
+		}
+		s.lines = []string{opts.cameraName, dates}
+	case isSlateFile(value):
+		s.file = value
+		if slices.Contains(slateVideoExts, strings.ToLower(filepath.Ext(value))) {
+			length, err := probeDuration(ffprobePath(opts.ffmpegPath), value)
+			if err != nil {
+				return nil, err
+			}
+			s.video, s.length = true, length.Seconds()
+		}
+	default:
+		s.lines = []string{value}
+	}
+	return s, nil
+}
+
+// slateLength returns how long s is shown, or 0 for no card.
+func slateLength(s *slate) float64 {
+	if s == nil {
+		return 0
+	}
+	return s.length
+}
+
+// shiftChapters returns the chapters moved later by the given number of seconds, such as the length of
+// an -intro card.
+func shiftChapters(chapters []chapter, by float64) []chapter {
+	if by == 0 || chapters == nil {
+		return chapters
+	}
+	shifted := make([]chapter, len(chapters))
+	for i, c := range chapters {
+		shifted[i] = chapter{start: c.start + by, end: c.end + by, title: c.title}
+	}
+	return shifted
+}
+
+// addSlates puts the intro card before the stream and the outro card after it, either of which may be nil.
+// Cards are built from the first frame of the stream, blacked out, like title cards, so they match its
+// resolution and pixel format; images and videos are scaled to fit them, keeping their aspect ratio.
+func addSlates(g *filterGraph, intro, outro *slate, fps float64, font string) {
+	if intro == nil && outro == nil {
+		return
+	}
+	files := map[*slate]string{}
+	for _, s := range []*slate{intro, outro} {
+		switch {
+		case s == nil || s.file == "":
+		case s.video:
+			files[s] = g.input("-i", s.file)
+		default:
+			// Loop the image for as long as the card is shown
+			files[s] = g.input("-loop", "1", "-i", s.file)
+		}
+	}
+
+	g.graft(func(in, out string) string {
+		var parts, split, concat []string
+		card := func(s *slate) {
+			src, label := g.label(), g.label()
+			split = append(split, "["+src+"]")
+			frames := max(int(s.length*fps+0.5), 1)
+			parts = append(parts, fmt.Sprintf("[%s]trim=end_frame=1,setpts=PTS-STARTPTS,drawbox=c=black:t=fill,"+
+				"loop=loop=%d:size=1:start=0,setpts=N/(%g*TB)%s[%s]", src, frames-1, fps, slateText(s.lines, font), label))
+			if file, ok := files[s]; ok {
+				timed, fitted, ref, card := g.label(), g.label(), g.label(), g.label()
+				parts = append(parts,
+					fmt.Sprintf("[%s]fps=%g,setpts=PTS-STARTPTS[%s]", file, fps, timed),
+					fmt.Sprintf("[%s][%s]scale2ref=w='min(main_w,iw*main_h/ih)':h='min(main_h,ih*main_w/iw)'[%s][%s]", timed, label, fitted, ref),
+					fmt.Sprintf("[%s][%s]overlay=(W-w)/2:(H-h)/2:shortest=1[%s]", ref, fitted, card))
+				label = card
+			}
+			concat = append(concat, "["+label+"]")
+		}
+
+		if intro != nil {
+			card(intro)
+		}
+		footage := g.label()
+		split = append(split, "["+footage+"]")
+		concat = append(concat, "["+footage+"]")
+		if outro != nil {
+			card(outro)
+		}
+		parts = append([]string{fmt.Sprintf("[%s]split=%d%s", in, len(split), strings.Join(split, ""))}, parts...)
+		parts = append(parts, fmt.Sprintf("%sconcat=n=%d:v=1:a=0[%s]", strings.Join(concat, ""), len(concat), out))
+		return strings.Join(parts, ";")
+	})
+}
+
+// slateText returns the drawtext filters writing lines on a card, each preceded by a comma, or nothing
+// when there are no lines. The first line is the largest, and the lines are centered together.
+func slateText(lines []string, font string) string {
+	switch len(lines) {
+	case 0:
+		return ""
+	case 1:
+		return "," + drawtextFilter(lines[0], font, "h/12", "(w-text_w)/2", "(h-text_h)/2")
+	}
+	text := "," + drawtextFilter(lines[0], font, "h/10", "(w-text_w)/2", "h/2-text_h-h/40")
+	for i, line := range lines[1:] {
+		text += "," + drawtextFilter(line, font, "h/18", "(w-text_w)/2", fmt.Sprintf("h/2+h/40+%d*h/14", i))
+	}
+	return text
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NVRs whose recordings can be merged, for -source.
+const (
+	sourceUniFi    = "unifi"
+	sourceFrigate  = "frigate"
+	sourceBlueIris = "blueiris"
+	sourceReolink  = "reolink"
+)
+
+// Orders of the day and month in filename dates such as 1-2-2026, for -date-order.
+const (
+	// dateOrderAuto detects the order from the filenames; see detectDateOrder.
+	dateOrderAuto = "auto"
+	dateOrderMDY  = "mdy"
+	dateOrderDMY  = "dmy"
+)
+
+var (
+	// frigateSegmentPattern matches the MM.SS.mp4 recording segments of Frigate, which stores them in
+	// <date>/<hour>/<camera> directories in UTC.
+	frigateSegmentPattern = regexp.MustCompile(`^(\d{2})\.(\d{2})\.mp4$`)
+	// frigateClipPattern matches Frigate event clips such as "front_door-1705329930.475377-mxklsc.mp4".
+	frigateClipPattern = regexp.MustCompile(`^(.+)-(\d{10})(?:\.\d+)?-[0-9a-z]+\.mp4$`)
+	// blueIrisPattern matches Blue Iris clips named with the default "&CAM.%Y%m%d_%H%M%S" pattern,
+	// e.g. "FrontDoor.20260116_080000.mp4".
+	blueIrisPattern = regexp.MustCompile(`^(.+?)\.(\d{8})_(\d{6})`)
+	// reolinkUploadPattern matches the recordings Reolink cameras upload over FTP,
+	// e.g. "Front Door_00_20260116080000.mp4".
+	reolinkUploadPattern = regexp.MustCompile(`^(.+)_\d{2}_(\d{14})`)
+	// reolinkCardPattern matches the recordings on a Reolink SD card or NVR disk, e.g.
+	// "RecM01_20260116_080000_080512_6D28808_1A2B3C.mp4", which hold the date, start and end.
+	reolinkCardPattern = regexp.MustCompile(`^Rec[A-Z]\d+_(\d{8})_(\d{6})_(\d{6})`)
+)
+
+// clipNaming reads the camera and the recording period of a clip from its path, following the naming
+// convention of one NVR.
+type clipNaming struct {
+	// camera returns the camera the recording at path belongs to, or "" if path is not a recording.
+	camera func(path string) string
+	// times returns the start of the recording, or the zero time if the path does not tell, and its
+	// end, or the zero time if only the start is known. Dates such as 1-2-2026 are read in order,
+	// dateOrderMDY or dateOrderDMY.
+	times func(path, order string) (start, end time.Time)
+	// prefixMatch selects files by the camera name they start with rather than by camera, which
+	// also accepts files named after the camera with additions.
+	prefixMatch bool
+	// dateOrder is the order of -date-order, which may be dateOrderAuto.
+	dateOrder string
+}
+
+// clipNamings maps the -source names to the naming conventions of the NVRs.
+var clipNamings = map[string]clipNaming{
+	sourceUniFi:    {camera: unifiCamera, times: unifiTimes, prefixMatch: true},
+	sourceFrigate:  {camera: frigateCamera, times: frigateTimes},
+	sourceBlueIris: {camera: blueIrisCamera, times: blueIrisTimes},
+	sourceReolink:  {camera: reolinkCamera, times: reolinkTimes},
+}
+
+// namingFor returns the naming convention selected by -source, reading dates in the order of -date-order.
+func namingFor(source, dateOrder string) (clipNaming, error) {
+	naming, ok := clipNamings[source]
+	if !ok {
+		names := make([]string, 0, len(clipNamings))
+		for name := range clipNamings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return clipNaming{}, fmt.Errorf("unknown source %q (use %s)", source, strings.Join(names, ", "))
+	}
+	switch dateOrder {
+	case dateOrderAuto, dateOrderMDY, dateOrderDMY:
+	default:
+		return clipNaming{}, fmt.Errorf("unknown date order %q (use auto, mdy, or dmy)", dateOrder)
+	}
+	naming.dateOrder = dateOrder
+	return naming, nil
+}
+
+// matches reports whether the file at path is a recording of camera, or of any camera when camera is empty.
+func (n clipNaming) matches(path, camera string) bool {
+	if camera == "" {
+		return n.camera(path) != ""
+	}
+	if n.prefixMatch {
+		return hasCameraPrefix(filepath.Base(path), camera)
+	}
+	return strings.EqualFold(n.camera(path), camera)
+}
+
+// unifiCamera returns the camera of a Protect export, named "<camera> <start> - <end>.mp4".
+func unifiCamera(path string) string {
+	return cameraFromFilename(filepath.Base(path))
+}
+
+// unifiTimes returns the recording period in the name of a Protect export.
+func unifiTimes(path, order string) (time.Time, time.Time) {
+	var start, end time.Time
+	if times := parseFilenameTimes(filepath.Base(path), order); len(times) > 0 {
+		start = times[0]
+		if len(times) >= 2 && times[1].After(start) {
+			end = times[1]
+		}
+	}
+	return start, end
+}
+
+// frigateCamera returns the camera of a Frigate recording segment, from its directory, or of an event clip.
+func frigateCamera(path string) string {
+	base := filepath.Base(path)
+	if m := frigateClipPattern.FindStringSubmatch(base); m != nil {
+		return m[1]
+	}
+	if frigateSegmentPattern.MatchString(base) {
+		if _, ok := frigateSegmentTime(path); ok {
+			return filepath.Base(filepath.Dir(path))
+		}
+	}
+	return ""
+}
+
+// frigateTimes returns the start of a Frigate recording segment or event clip.
+func frigateTimes(path, _ string) (time.Time, time.Time) {
+	if m := frigateClipPattern.FindStringSubmatch(filepath.Base(path)); m != nil {
+		seconds, _ := strconv.ParseInt(m[2], 10, 64)
+		return time.Unix(seconds, 0), time.Time{}
+	}
+	start, _ := frigateSegmentTime(path)
+	return start, time.Time{}
+}
+
+// frigateSegmentTime returns the start of a recording segment at <date>/<hour>/<camera>/MM.SS.mp4,
+// where the date is YYYY-MM-DD, or YYYY-MM/DD before Frigate 0.12.
+func frigateSegmentTime(path string) (time.Time, bool) {
+	m := frigateSegmentPattern.FindStringSubmatch(filepath.Base(path))
+	if m == nil {
+		return time.Time{}, false
+	}
+	hourDir := filepath.Dir(filepath.Dir(path))
+	date := filepath.Base(filepath.Dir(hourDir))
+	if len(date) == 2 {
+		date = filepath.Base(filepath.Dir(filepath.Dir(hourDir))) + "-" + date
+	}
+	t, err := time.ParseInLocation("2006-01-02 15 04 05", date+" "+filepath.Base(hourDir)+" "+m[1]+" "+m[2], time.UTC)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.Local(), true
+}
+
+// blueIrisCamera returns the camera short name a Blue Iris clip starts with.
+func blueIrisCamera(path string) string {
+	if m := blueIrisPattern.FindStringSubmatch(filepath.Base(path)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// blueIrisTimes returns the start of a Blue Iris clip, in local time.
+func blueIrisTimes(path, _ string) (time.Time, time.Time) {
+	if m := blueIrisPattern.FindStringSubmatch(filepath.Base(path)); m != nil {
+		if t, err := time.ParseInLocation("20060102150405", m[2]+m[3], time.Local); err == nil {
+			return t, time.Time{}
+		}
+	}
+	return time.Time{}, time.Time{}
+}
+
+// reolinkCamera returns the camera of a Reolink upload from its name, or of a recording copied from an
+// SD card from the directory holding it, which is expected to be named after the camera.
+func reolinkCamera(path string) string {
+	base := filepath.Base(path)
+	if reolinkCardPattern.MatchString(base) {
+		return filepath.Base(filepath.Dir(path))
+	}
+	if m := reolinkUploadPattern.FindStringSubmatch(base); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// reolinkTimes returns the recording period of a Reolink recording, in local time. Uploads only
+// carry their start.
+func reolinkTimes(path, _ string) (time.Time, time.Time) {
+	base := filepath.Base(path)
+	if m := reolinkCardPattern.FindStringSubmatch(base); m != nil {
+		start, err := time.ParseInLocation("20060102150405", m[1]+m[2], time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}
+		}
+		end, err := time.ParseInLocation("20060102150405", m[1]+m[3], time.Local)
+		if err != nil {
+			return start, time.Time{}
+		}
+		if !end.After(start) {
+			// The recording ran past midnight
+			end = end.AddDate(0, 0, 1)
+		}
+		return start, end
+	}
+	if m := reolinkUploadPattern.FindStringSubmatch(base); m != nil {
+		if t, err := time.ParseInLocation("20060102150405", m[2], time.Local); err == nil {
+			return t, time.Time{}
+		}
+	}
+	return time.Time{}, time.Time{}
+}
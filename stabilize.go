@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+)
+
+// stabilizeFile is the name of the temporary file holding the camera motion found by the stabilization analysis pass.
+const stabilizeFile = "transforms.trf"
+
+// detectShake runs the first stabilization pass: it retimes the footage exactly like the encode will and
+// records the camera motion between consecutive output frames into transformsFile.
+func detectShake(opts options, job encodeJob, transformsFile string) error {
+	g := newFilterGraph("0:v")
+	addRetiming(g, opts, job.ranges)
+	g.add(fmt.Sprintf("vidstabdetect=shakiness=%d:accuracy=15:result=%s", opts.stabilizeShakiness, escapeFilterValue(transformsFile)))
+
+	args := append(threadArgs(opts), "-hide_banner")
+	args = append(args, job.inputs.args()...)
+	args = append(args,
+		"-filter_complex", g.finish("v"),
+		"-map", "[v]",
+		"-f", "null", "-",
+	)
+	cmd := ffmpegCommand(opts, args...)
+	job.inputs.attach(cmd)
+	cmd.Stdout = opts.stdout
+	cmd.Stderr = opts.stderr
+	return cmd.Run()
+}
+
+// addStabilizeTransform adds the second stabilization pass, which counteracts the recorded camera motion
+// averaged over smoothing frames in each direction, then sharpens away the softness of the resampling.
+func addStabilizeTransform(g *filterGraph, transformsFile string, smoothing int) {
+	g.add(
+		fmt.Sprintf("vidstabtransform=input=%s:smoothing=%d:zoom=0:optzoom=1", escapeFilterValue(transformsFile), smoothing),
+		"unsharp=5:5:0.8:3:3:0.4",
+	)
+}
@@ -0,0 +1,51 @@
+package main
+
+import "time"
+
+// Values of -static.
+const (
+	// staticKeep plays static periods like the rest of the footage.
+	staticKeep = "keep"
+	// staticSpeed plays static periods -static-speedup times faster than -speed.
+	staticSpeed = "speed"
+	// staticDrop shows a single frame of each static period.
+	staticDrop = "drop"
+)
+
+// staticSpeedRanges returns ranges covering the periods of at least minLength in which every sample's
+// scene-change score stays below threshold, i.e. where the picture barely changes. They play speedup
+// times faster than speed, or for a single frame at fps with staticDrop.
+func staticSpeedRanges(samples []motionSample, threshold float64, minLength time.Duration, mode string, speed, speedup, fps float64) []speedRange {
+	var ranges []speedRange
+	add := func(start, end float64) {
+		if end-start < minLength.Seconds() {
+			return
+		}
+		r := speedRange{start: start, end: end, speed: speed * speedup}
+		if mode == staticDrop {
+			r.speed = (end - start) * fps
+		}
+		ranges = append(ranges, r)
+	}
+
+	// A period starts at its first quiet sample and ends at its last one
+	start := -1.0
+	var last float64
+	for _, s := range samples {
+		if s.score < threshold {
+			if start < 0 {
+				start = s.t
+			}
+			last = s.t
+			continue
+		}
+		if start >= 0 {
+			add(start, last)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		add(start, last)
+	}
+	return ranges
+}
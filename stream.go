@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// outputStdout is the -o value that writes the output to standard output, for piping into another tool.
+const outputStdout = "-"
+
+// streamMuxers maps the URL schemes of the network sinks -o can push to, to the container each expects.
+var streamMuxers = map[string]string{
+	"rtmp":  "flv",
+	"rtmps": "flv",
+	"srt":   "mpegts",
+}
+
+// isNetworkOutput reports whether output is the URL of a streaming server rather than a file.
+func isNetworkOutput(output string) bool {
+	scheme, _, ok := strings.Cut(output, "://")
+	return ok && streamMuxers[strings.ToLower(scheme)] != ""
+}
+
+// isStreamOutput reports whether output is written as it is encoded, to standard output or a network
+// sink, and so can neither be read back nor replaced.
+func isStreamOutput(output string) bool {
+	return output == outputStdout || isNetworkOutput(output)
+}
+
+// streamOutputArgs returns the ffmpeg arguments choosing the container of a stream output, followed by
+// the output itself. Standard output gets fragmented MP4, which needs no seeking back to finish.
+func streamOutputArgs(output string) []string {
+	if output == outputStdout {
+		return []string{"-f", "mp4", "-movflags", "frag_keyframe+empty_moov+default_base_moof", "pipe:1"}
+	}
+	scheme, _, _ := strings.Cut(output, "://")
+	return []string{"-f", streamMuxers[strings.ToLower(scheme)], output}
+}
+
+// outputArgs returns the ffmpeg arguments ending the command line with the output file, which was
+// checked (or confirmed for overwriting) by resolveOutputFile, or with a stream output.
+func outputArgs(output string) []string {
+	if isStreamOutput(output) {
+		return streamOutputArgs(output)
+	}
+	return []string{"-y", output}
+}
+
+// streamTarget describes a stream output for the log, leaving out the path of a URL, which usually
+// holds the stream key.
+func streamTarget(output string) string {
+	if output == outputStdout {
+		return "standard output"
+	}
+	if u, err := url.Parse(output); err == nil {
+		return u.Scheme + "://" + u.Host
+	}
+	return output
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Values of -subtitles.
+const (
+	// subtitlesSRT writes a SubRip file next to the output.
+	subtitlesSRT = "srt"
+	// subtitlesVTT writes a WebVTT file next to the output.
+	subtitlesVTT = "vtt"
+	// subtitlesMux adds a subtitle track to the MP4 output itself.
+	subtitlesMux = "mux"
+)
+
+const (
+	// subtitlesFile is the name of the temporary SubRip file muxed into the output with -subtitles mux.
+	subtitlesFile = "subtitles.srt"
+	// subtitleTimeFormat is the Go time format of the wall-clock time shown by each cue.
+	subtitleTimeFormat = "2006-01-02 15:04:05"
+)
+
+// timeCues returns subtitle cues showing the wall-clock time of the footage on screen as written by format,
+// each lasting about interval on the output timeline. spans are the wall-clock periods of the footage,
+// played one after another; speed and ranges describe the retiming applied by the encode.
+func timeCues(spans []timeSpan, interval time.Duration, speed float64, ranges []speedRange, format func(time.Time) string) []chapter {
+	var cues []chapter
+	var pos float64
+	for _, span := range spans {
+		length := span.end.Sub(span.start).Seconds()
+		for s := 0.0; s < length; {
+			next := math.Min(s+interval.Seconds()*speedAt(pos+s, speed, ranges), length)
+			title := format(span.start.Add(time.Duration(s * float64(time.Second))))
+			start, end := outputOffset(pos+s, speed, ranges), outputOffset(pos+next, speed, ranges)
+			// Cues shorter than a second of footage would repeat the same time
+			if n := len(cues); n > 0 && cues[n-1].title == title && cues[n-1].end >= start {
+				cues[n-1].end = end
+			} else {
+				cues = append(cues, chapter{start: start, end: end, title: title})
+			}
+			s = next
+		}
+		pos += length
+	}
+	return cues
+}
+
+// subtitleTitle writes the time of the footage in subtitles.
+func subtitleTitle(t time.Time) string {
+	return t.Format(subtitleTimeFormat)
+}
+
+// speedAt returns the speed factor at position t of the source timeline.
+func speedAt(t, defaultSpeed float64, ranges []speedRange) float64 {
+	for _, r := range ranges {
+		if t >= r.start && t < r.end {
+			return r.speed
+		}
+	}
+	return defaultSpeed
+}
+
+// segmentSpans returns the wall-clock periods recorded by segments, whose lengths are durations.
+func segmentSpans(segments []segment, durations []time.Duration) []timeSpan {
+	spans := make([]timeSpan, len(segments))
+	for i, seg := range segments {
+		spans[i] = timeSpan{start: seg.start, end: seg.start.Add(durations[i])}
+	}
+	return spans
+}
+
+// shiftForTitleCards shifts subtitle cues, or chapters other than the days', to make room for the title
+// cards inserted at the start of each of days (but the first, unless opening is set).
+func shiftForTitleCards(chapters, days []chapter, duration time.Duration, opening bool) []chapter {
+	d := duration.Seconds()
+	shifted := make([]chapter, len(chapters))
+	for i, c := range chapters {
+		cards := 0
+		for j, day := range days {
+			// Compare with a little slack, as both are computed from the same positions
+			if (j > 0 || opening) && day.start <= c.start+1e-6 {
+				cards++
+			}
+		}
+		shift := float64(cards) * d
+		shifted[i] = chapter{start: c.start + shift, end: c.end + shift, title: c.title}
+	}
+	return shifted
+}
+
+// subtitlesSidecar returns the name of the -subtitles file in format written next to output.
+func subtitlesSidecar(output, format string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + "." + format
+}
+
+// writeSubtitles writes cues as a SubRip file, or a WebVTT file when format is vtt.
+func writeSubtitles(path string, cues []chapter, format string) error {
+	var b strings.Builder
+	if format == subtitlesVTT {
+		b.WriteString("WEBVTT\n\n")
+	}
+	for i, c := range cues {
+		if format != subtitlesVTT {
+			fmt.Fprintf(&b, "%d\n", i+1)
+		}
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", cueTime(c.start, format), cueTime(c.end, format), c.title)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// cueTime formats a position on the output timeline as a SubRip or WebVTT timestamp, which differ
+// only in the decimal separator.
+func cueTime(secs float64, format string) string {
+	ms := int64(math.Round(secs * 1000))
+	sep := ","
+	if format == subtitlesVTT {
+		sep = "."
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Values of -output.
+const (
+	summaryText = "text"
+	// summaryJSON prints the status messages on stderr and a runSummary on stdout, for scripts.
+	summaryJSON = "json"
+)
+
+// summaryMinGap is the shortest period without footage between clips counted as a gap in the summary.
+// Consecutive clips of continuous recordings are a few seconds apart at most.
+const summaryMinGap = time.Minute
+
+// runSummary is the JSON printed by -output json once a run is over, whether it succeeded or not.
+type runSummary struct {
+	Camera string `json:"camera"`
+	// Status is "success" or "failure", and ExitStatus the exit status of the program.
+	Status     string `json:"status"`
+	ExitStatus int    `json:"exit_status"`
+	Error      string `json:"error,omitempty"`
+	// Inputs is the number of clips or images merged, and Skipped the number of files left out as
+	// excluded, duplicate or too short.
+	Inputs  int `json:"inputs"`
+	Skipped int `json:"skipped"`
+	// Gaps is the number of periods of at least a minute without footage between the clips.
+	Gaps   int    `json:"gaps"`
+	Output string `json:"output,omitempty"`
+	// Duration is the length of the output in seconds, or 0 when it was not measured.
+	Duration      float64 `json:"duration_seconds"`
+	Size          int64   `json:"size_bytes"`
+	EncodeSeconds float64 `json:"encode_seconds"`
+	// FFmpegExitStatus is the exit status of the last ffmpeg encode, or null if none ran.
+	FFmpegExitStatus *int `json:"ffmpeg_exit_status"`
+	// UndatedFiles are the files without a date in their name, ordered by their modification time.
+	UndatedFiles []string `json:"undated_files,omitempty"`
+
+	mu sync.Mutex
+}
+
+// measure collects the measurements of the run; it is used as options.measure.
+func (s *runSummary) measure(name string, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch name {
+	case measureClips:
+		// -layout measures the main camera first, and only its clips are counted
+		if s.Inputs == 0 {
+			s.Inputs = int(value)
+		}
+	case measureSkipped:
+		s.Skipped += int(value)
+	case measureGaps:
+		s.Gaps = int(value)
+	case measureEncodeSeconds:
+		s.EncodeSeconds += value
+	case measureFFmpegExitStatus:
+		status := int(value)
+		s.FFmpegExitStatus = &status
+	case measureOutputSeconds:
+		s.Duration = value
+	}
+}
+
+// undated collects the files without a date in their name; it is used as options.undated.
+func (s *runSummary) undated(paths []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.UndatedFiles = append(s.UndatedFiles, paths...)
+}
+
+// exitStatus returns the exit status of a command that returned err from Run or Wait: 0 when it
+// succeeded, and -1 when it could not be started or was killed.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// finish completes the summary with the outcome of the run, and writes it to w.
+func (s *runSummary) finish(w io.Writer, output string, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Status, s.Output = "success", output
+	if err != nil {
+		s.Status, s.ExitStatus, s.Error = "failure", exitCode(err), err.Error()
+	}
+	if fi, statErr := os.Stat(output); output != "" && statErr == nil && fi.Mode().IsRegular() {
+		s.Size = fi.Size()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
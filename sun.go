@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// sunAltitude is the solar altitude at sunrise and sunset in degrees, accounting for refraction and the solar disc.
+	sunAltitude = -0.833
+	// earthTilt is the obliquity of the ecliptic in degrees.
+	earthTilt = 23.4397
+	// julianJ2000 is the Julian date of 2000-01-01 12:00 UTC.
+	julianJ2000 = 2451545.0
+	// julianUnixEpoch is the Julian date of 1970-01-01 00:00 UTC.
+	julianUnixEpoch = 2440587.5
+)
+
+// sunTimes returns sunrise and sunset for the calendar date of day at the given latitude and
+// longitude (degrees, north and east positive), in day's location. It uses the sunrise equation,
+// which is accurate to within a minute or two away from the poles.
+// polarDay or polarNight is set instead when the sun does not rise or set that day.
+func sunTimes(day time.Time, lat, lon float64) (rise, set time.Time, polarDay, polarNight bool) {
+	y, m, d := day.Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+	n := math.Round(float64(noon.Unix())/86400 + julianUnixEpoch - julianJ2000)
+
+	// Mean solar noon, solar mean anomaly, equation of the center, and ecliptic longitude
+	jStar := n - lon/360
+	meanAnomaly := math.Mod(357.5291+0.98560028*jStar, 360)
+	mRad := radians(meanAnomaly)
+	center := 1.9148*math.Sin(mRad) + 0.02*math.Sin(2*mRad) + 0.0003*math.Sin(3*mRad)
+	lambda := radians(math.Mod(meanAnomaly+center+180+102.9372, 360))
+	transit := julianJ2000 + jStar + 0.0053*math.Sin(mRad) - 0.0069*math.Sin(2*lambda)
+
+	// Declination of the sun and the hour angle at which it crosses the horizon
+	sinDecl := math.Sin(lambda) * math.Sin(radians(earthTilt))
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	latRad := radians(lat)
+	cosHourAngle := (math.Sin(radians(sunAltitude)) - math.Sin(latRad)*sinDecl) / (math.Cos(latRad) * cosDecl)
+	if cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false, true
+	}
+	if cosHourAngle < -1 {
+		return time.Time{}, time.Time{}, true, false
+	}
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+
+	rise = julianToTime(transit - hourAngle/360).In(day.Location())
+	set = julianToTime(transit + hourAngle/360).In(day.Location())
+	return rise, set, false, false
+}
+
+// daylightSpans returns a function listing the daylight period of each day at the given location,
+// widened by margin on both sides (a negative margin narrows it).
+func daylightSpans(lat, lon float64, margin time.Duration) func(day time.Time) []timeSpan {
+	return func(day time.Time) []timeSpan {
+		rise, set, polarDay, polarNight := sunTimes(day, lat, lon)
+		switch {
+		case polarDay:
+			midnight := startOfDay(day)
+			return []timeSpan{{midnight, midnight.AddDate(0, 0, 1)}}
+		case polarNight:
+			return nil
+		}
+		return []timeSpan{{rise.Add(-margin), set.Add(margin)}}
+	}
+}
+
+// julianToTime converts a Julian date to a UTC time.
+func julianToTime(j float64) time.Time {
+	seconds := (j - julianUnixEpoch) * 86400
+	return time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+}
+
+// radians converts degrees to radians.
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// thumbnailWidth is the width of thumbnails extracted from finished timelapses.
+const thumbnailWidth = 640
+
+// Size and frame rate of -preview animations, kept small enough to embed in chats and web pages.
+const (
+	previewWidth = 320
+	previewFPS   = 10
+)
+
+// posterFile returns the name of the -thumbnail poster frame written next to output.
+func posterFile(output string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".jpg"
+}
+
+// previewFile returns the name of the -preview animation in format written next to output.
+func previewFile(output, format string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".preview." + format
+}
+
+// extractThumbnail writes a JPEG of the frame halfway through video to dest.
+func extractThumbnail(ffmpegPath, video, dest string) error {
+	at := 0.0
+	if d, err := probeDuration(ffprobePath(ffmpegPath), video); err == nil {
+		at = d.Seconds() / 2
+	}
+
+	cmd := exec.Command(ffmpegPath, "-hide_banner", "-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", at),
+		"-i", video,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:-2", thumbnailWidth),
+		"-q:v", "3",
+		"-y", dest)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("extracting thumbnail: %w: %s", err, lastLine(stderr.String()))
+	}
+	return nil
+}
+
+// extractPreview writes a small animation of video in format to dest, sped up further to last at
+// most length so that the whole timelapse is previewed.
+func extractPreview(ffmpegPath, video, dest, format string, length time.Duration) error {
+	g := newFilterGraph("0:v")
+	if d, err := probeDuration(ffprobePath(ffmpegPath), video); err == nil && d > length {
+		g.add(fmt.Sprintf("setpts=%.6f*PTS", length.Seconds()/d.Seconds()))
+	}
+	g.add(fmt.Sprintf("fps=%d", previewFPS), fmt.Sprintf("scale=%d:-2:flags=lanczos", previewWidth))
+	if format == formatGIF {
+		addGIFPalette(g)
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error",
+		"-i", video,
+		"-filter_complex", g.finish("v"),
+		"-map", "[v]",
+	}
+	args = append(args, animatedOutputArgs(format)...)
+	cmd := exec.Command(ffmpegPath, append(args, "-y", dest)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("creating preview: %w: %s", err, lastLine(stderr.String()))
+	}
+	return nil
+}
+
+// createExtras writes the poster frame and preview animation requested by -thumbnail and -preview
+// next to the output. They are conveniences, so failures are only reported as warnings.
+func createExtras(opts options, output string) {
+	if opts.thumbnail {
+		poster := posterFile(output)
+		if err := extractThumbnail(opts.ffmpegPath, output, poster); err != nil {
+			fmt.Fprintf(opts.stderr, "Warning: %v\n", err)
+		} else {
+			fmt.Fprintf(opts.stdout, "Created thumbnail: %s\n", poster)
+		}
+	}
+	if opts.preview != "" {
+		preview := previewFile(output, opts.preview)
+		if err := extractPreview(opts.ffmpegPath, output, preview, opts.preview, opts.previewDuration); err != nil {
+			fmt.Fprintf(opts.stderr, "Warning: %v\n", err)
+		} else {
+			fmt.Fprintf(opts.stdout, "Created preview: %s\n", preview)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ways of showing a camera of a composite while it has no footage, for -gap-fill.
+const (
+	// gapFillFreeze keeps showing the camera's nearest frame.
+	gapFillFreeze = "freeze"
+	// gapFillBlack blacks the camera out.
+	gapFillBlack = "black"
+	// gapFillSlate blacks the camera out and captions it with its name and "no footage".
+	gapFillSlate = "slate"
+)
+
+// outputSpan is a span of a timeline, in seconds.
+type outputSpan struct {
+	start, end float64
+}
+
+// compositeTimeline is the source timeline of a composite: the wall-clock periods recorded by any of
+// its cameras, in order, so that the periods recorded by none (e.g. nights outside -hours) are skipped.
+type compositeTimeline []timeSpan
+
+// newCompositeTimeline returns the timeline of the periods recorded by any of the cameras.
+func newCompositeTimeline(cameras []layoutCamera) compositeTimeline {
+	var spans []timeSpan
+	for _, cam := range cameras {
+		for i, seg := range cam.segments {
+			spans = append(spans, timeSpan{start: seg.start, end: seg.start.Add(cam.durations[i])})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start.Before(spans[j].start) })
+
+	var tl compositeTimeline
+	for _, span := range spans {
+		if n := len(tl); n > 0 && !span.start.After(tl[n-1].end) {
+			if span.end.After(tl[n-1].end) {
+				tl[n-1].end = span.end
+			}
+			continue
+		}
+		tl = append(tl, span)
+	}
+	return tl
+}
+
+// offset returns the position of wall-clock time t on the timeline, in seconds.
+func (tl compositeTimeline) offset(t time.Time) float64 {
+	var pos float64
+	for _, span := range tl {
+		if t.Before(span.end) {
+			if t.After(span.start) {
+				pos += t.Sub(span.start).Seconds()
+			}
+			break
+		}
+		pos += span.end.Sub(span.start).Seconds()
+	}
+	return pos
+}
+
+// length returns the length of the timeline in seconds.
+func (tl compositeTimeline) length() float64 {
+	var total float64
+	for _, span := range tl {
+		total += span.end.Sub(span.start).Seconds()
+	}
+	return total
+}
+
+// chapters returns one chapter per calendar day of the timeline, positioned on the output timeline.
+func (tl compositeTimeline) chapters(speed float64) []chapter {
+	var chapters []chapter
+	var lastDay time.Time
+	for _, span := range tl {
+		for day := startOfDay(span.start); day.Before(span.end); day = day.AddDate(0, 0, 1) {
+			if day.Equal(lastDay) {
+				continue
+			}
+			from := day
+			if from.Before(span.start) {
+				from = span.start
+			}
+			at := tl.offset(from) / speed
+			if n := len(chapters); n > 0 {
+				chapters[n-1].end = at
+			}
+			chapters = append(chapters, chapter{start: at, title: day.Format(chapterTitleFormat)})
+			lastDay = day
+		}
+	}
+	if n := len(chapters); n > 0 {
+		chapters[n-1].end = tl.length() / speed
+	}
+	return chapters
+}
+
+// timelineExpr returns an ffmpeg expression mapping the time T on the concatenated segments of cam to
+// the position of the footage on the timeline, in seconds. Like speedExpr it is a flat sum rather than
+// nested if() calls, shifting T at the start of every segment that does not follow on from the previous one.
+func timelineExpr(cam layoutCamera, tl compositeTimeline) string {
+	var b strings.Builder
+	b.WriteString("T")
+	var pos, shift float64
+	for i, seg := range cam.segments {
+		next := tl.offset(seg.start) - pos
+		if i == 0 {
+			fmt.Fprintf(&b, "%+g", next)
+		} else if math.Abs(next-shift) >= 0.001 {
+			fmt.Fprintf(&b, "+gte(T,%g)*(%g)", pos, next-shift)
+		}
+		shift = next
+		pos += cam.durations[i].Seconds()
+	}
+	return b.String()
+}
+
+// outputGaps returns the spans of the output timeline, in seconds, during which cam has no footage, given
+// the speedup. Gaps too short to show in a single frame at fps, such as those between consecutive exports,
+// are left out.
+func (tl compositeTimeline) outputGaps(cam layoutCamera, speed, fps float64) []outputSpan {
+	covered := make([]outputSpan, len(cam.segments))
+	for i, seg := range cam.segments {
+		start := tl.offset(seg.start)
+		covered[i] = outputSpan{start: start, end: start + cam.durations[i].Seconds()}
+	}
+	sort.Slice(covered, func(i, j int) bool { return covered[i].start < covered[j].start })
+
+	var gaps []outputSpan
+	add := func(start, end float64) {
+		if (end-start)/speed >= 1/fps {
+			gaps = append(gaps, outputSpan{start: start / speed, end: end / speed})
+		}
+	}
+	var pos float64
+	for _, c := range covered {
+		if c.start > pos {
+			add(pos, c.start)
+		}
+		pos = math.Max(pos, c.end)
+	}
+	add(pos, tl.length())
+	return gaps
+}
+
+// gapFillFilters returns the filters that black out, and with gapFillSlate caption, a tile of a composite
+// during its gaps, or nothing for gapFillFreeze.
+func gapFillFilters(mode, camera, font string, gaps []outputSpan) []string {
+	if mode == gapFillFreeze || len(gaps) == 0 {
+		return nil
+	}
+	terms := make([]string, len(gaps))
+	for i, gap := range gaps {
+		terms[i] = fmt.Sprintf("between(t,%.3f,%.3f)", gap.start, gap.end)
+	}
+	enable := ":enable='" + strings.Join(terms, "+") + "'"
+	filters := []string{"drawbox=c=black:t=fill" + enable}
+	if mode == gapFillSlate {
+		filters = append(filters, drawtextFilter(camera+": no footage", font, "h/16", "(w-text_w)/2", "(h-text_h)/2")+enable)
+	}
+	return filters
+}
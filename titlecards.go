@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// addTitleCards inserts a card showing each chapter's title before every chapter but the first, or
+// before the first too with opening. Cards are built from the first frame of the following chapter,
+// blacked out and captioned, so they always match the stream's resolution and pixel format. The chapter
+// positions must be on the stream's timeline.
+func addTitleCards(g *filterGraph, chapters []chapter, duration time.Duration, fps float64, font string, opening bool) {
+	if len(chapters) < 2 && !opening {
+		return
+	}
+	frames := int(duration.Seconds()*fps + 0.5)
+	if frames < 1 {
+		frames = 1
+	}
+
+	g.graft(func(in, out string) string {
+		var parts, concat []string
+		parts = append(parts, "") // placeholder for the split
+		split := []string{}
+
+		for i, c := range chapters {
+			if i > 0 || opening {
+				// Title card: the first frame at the boundary, looped for the card duration
+				src, card := g.label(), g.label()
+				split = append(split, "["+src+"]")
+				parts = append(parts, fmt.Sprintf("[%s]trim=start=%.3f,trim=end_frame=1,setpts=PTS-STARTPTS,"+
+					"drawbox=c=black:t=fill,%s,loop=loop=%d:size=1:start=0,setpts=N/(%g*TB)[%s]",
+					src, c.start, drawtextFilter(c.title, font, "h/12", "(w-text_w)/2", "(h-text_h)/2"), frames-1, fps, card))
+				concat = append(concat, "["+card+"]")
+			}
+
+			// The footage of this chapter
+			src, part := g.label(), g.label()
+			split = append(split, "["+src+"]")
+			trim := fmt.Sprintf("trim=start=%.3f", c.start)
+			if i+1 < len(chapters) {
+				trim += fmt.Sprintf(":end=%.3f", chapters[i+1].start)
+			}
+			parts = append(parts, fmt.Sprintf("[%s]%s,setpts=PTS-STARTPTS[%s]", src, trim, part))
+			concat = append(concat, "["+part+"]")
+		}
+
+		parts[0] = fmt.Sprintf("[%s]split=%d%s", in, len(split), strings.Join(split, ""))
+		parts = append(parts, fmt.Sprintf("%sconcat=n=%d:v=1:a=0[%s]", strings.Join(concat, ""), len(concat), out))
+		return strings.Join(parts, ";")
+	})
+}
+
+// withTitleCards returns the chapters shifted to account for a card of the given duration inserted
+// before every chapter but the first, or before the first too with opening, with each chapter starting
+// at its card.
+func withTitleCards(chapters []chapter, duration time.Duration, opening bool) []chapter {
+	shifted := make([]chapter, len(chapters))
+	d := duration.Seconds()
+	for i, c := range chapters {
+		cardsBefore := i - 1
+		if opening {
+			cardsBefore = i
+		}
+		shifted[i] = chapter{start: c.start + float64(max(cardsBefore, 0))*d, end: c.end + float64(cardsBefore+1)*d, title: c.title}
+	}
+	return shifted
+}
+
+// drawtextFilter returns a drawtext filter rendering text literally in white, with the given font size and
+// position expressions. font is a font file path, or empty for ffmpeg's default font.
+func drawtextFilter(text, font, size, x, y string) string {
+	f := fmt.Sprintf("drawtext=expansion=none:text=%s:fontcolor=white:fontsize=%s:x=%s:y=%s",
+		escapeFilterValue(text), size, x, y)
+	if font != "" {
+		f += ":fontfile=" + escapeFilterValue(font)
+	}
+	return f
+}
+
+// escapeFilterValue escapes an arbitrary string for use as a filter option value inside a filter graph.
+// It applies both levels of ffmpeg escaping: the option parser's (\ ' :) and the graph parser's (\ ' [ ] , ;).
+func escapeFilterValue(s string) string {
+	opt := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(s)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(opt)
+}
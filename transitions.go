@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTransitionDuration is used when -transition names no duration.
+	defaultTransitionDuration = 500 * time.Millisecond
+	// gapTolerance is the largest jump in wall-clock time between segments still treated as continuous.
+	gapTolerance = time.Second
+)
+
+// transition is a cross-fade applied where the footage jumps in time.
+type transition struct {
+	// name is an ffmpeg xfade transition, e.g. "fade", "dissolve", or "wipeleft".
+	name     string
+	duration time.Duration
+}
+
+// parseTransition parses a transition in the form "name=seconds" or "name".
+func parseTransition(s string) (transition, error) {
+	name, secs, hasDuration := strings.Cut(s, "=")
+	t := transition{name: strings.TrimSpace(name), duration: defaultTransitionDuration}
+	if t.name == "" {
+		return transition{}, fmt.Errorf("expected name=seconds, e.g. fade=0.5, got %q", s)
+	}
+	if hasDuration {
+		v, err := strconv.ParseFloat(secs, 64)
+		if err != nil || v <= 0 {
+			return transition{}, fmt.Errorf("invalid transition duration %q", secs)
+		}
+		t.duration = time.Duration(v * float64(time.Second))
+	}
+	return t, nil
+}
+
+// segmentCuts returns the output-timeline positions (seconds) of every boundary between segments where
+// the footage jumps forward in time, i.e. where the next segment does not continue the previous one.
+func segmentCuts(segments []segment, durations []time.Duration, speed float64, ranges []speedRange) []float64 {
+	var cuts []float64
+	var pos float64
+	for i, seg := range segments {
+		if i > 0 {
+			prevEnd := segments[i-1].start.Add(durations[i-1])
+			if seg.start.Sub(prevEnd) > gapTolerance || seg.start.Before(prevEnd.Add(-gapTolerance)) {
+				cuts = append(cuts, outputOffset(pos, speed, ranges))
+			}
+		}
+		pos += durations[i].Seconds()
+	}
+	return cuts
+}
+
+// usableCuts drops cuts that would leave a part too short to cross-fade on both ends,
+// given the total length of the stream in seconds.
+func usableCuts(cuts []float64, total float64, t transition) []float64 {
+	d := t.duration.Seconds()
+	var kept []float64
+	last := 0.0
+	for _, c := range cuts {
+		if c-last >= 2*d && total-c >= 2*d {
+			kept = append(kept, c)
+			last = c
+		}
+	}
+	return kept
+}
+
+// addTransitions cuts the stream at the given output positions and cross-fades consecutive parts.
+// Every transition overlaps the parts by t.duration, shortening the output by that much.
+// The cuts must already be filtered by usableCuts.
+func addTransitions(g *filterGraph, cuts []float64, t transition) {
+	d := t.duration.Seconds()
+	if len(cuts) == 0 {
+		return
+	}
+
+	g.graft(func(in, out string) string {
+		var chains, split, parts []string
+		start := 0.0
+		for i := 0; i <= len(cuts); i++ {
+			src, part := g.label(), g.label()
+			split = append(split, "["+src+"]")
+			trim := fmt.Sprintf("trim=start=%.3f", start)
+			if i < len(cuts) {
+				trim += fmt.Sprintf(":end=%.3f", cuts[i])
+				start = cuts[i]
+			}
+			chains = append(chains, fmt.Sprintf("[%s]%s,setpts=PTS-STARTPTS[%s]", src, trim, part))
+			parts = append(parts, part)
+		}
+
+		// Chain the fades: each one starts d seconds before the end of everything merged so far
+		acc, length := parts[0], cuts[0]
+		for i := 1; i < len(parts); i++ {
+			next := out
+			if i < len(parts)-1 {
+				next = g.label()
+			}
+			chains = append(chains, fmt.Sprintf("[%s][%s]xfade=transition=%s:duration=%.3f:offset=%.3f[%s]",
+				acc, parts[i], t.name, d, length-d, next))
+			if i < len(cuts) {
+				length += cuts[i] - cuts[i-1] - d
+			}
+			acc = next
+		}
+
+		return fmt.Sprintf("[%s]split=%d%s;", in, len(split), strings.Join(split, "")) + strings.Join(chains, ";")
+	})
+}
+
+// withTransitions returns the chapters shifted earlier by the overlap of every transition before them.
+func withTransitions(chapters []chapter, cuts []float64, t transition) []chapter {
+	d := t.duration.Seconds()
+	shift := func(pos float64) float64 {
+		n := 0
+		for _, c := range cuts {
+			if c <= pos {
+				n++
+			}
+		}
+		return pos - float64(n)*d
+	}
+	shifted := make([]chapter, len(chapters))
+	for i, c := range chapters {
+		shifted[i] = chapter{start: shift(c.start), end: shift(c.end), title: c.title}
+	}
+	return shifted
+}
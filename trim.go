@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// trimSegments cuts trimStart off the start and trimEnd off the end of every clip the segments are
+// taken from, where exposure flares and IR-switch flashes tend to be, and returns the segments left.
+// Segments of a clip that does not reach its start or end are unaffected; clips of unknown length are
+// measured with ffprobe to trim their end.
+func trimSegments(segments []segment, trimStart, trimEnd time.Duration, ffprobe string) ([]segment, error) {
+	trimmed := make([]segment, 0, len(segments))
+	for _, seg := range segments {
+		clipStart := seg.start.Add(-seg.inpoint)
+		if seg.inpoint < trimStart {
+			seg.start = seg.start.Add(trimStart - seg.inpoint)
+			seg.inpoint = trimStart
+		}
+		// Only a segment left open by planSegments runs to the end of its clip
+		if trimEnd > 0 && seg.outpoint == 0 {
+			length := seg.end.Sub(clipStart)
+			if seg.end.IsZero() {
+				d, err := probeDuration(ffprobe, seg.path)
+				if err != nil {
+					return nil, fmt.Errorf("measuring %s: %w", seg.path, err)
+				}
+				length = d
+			}
+			if length-trimEnd <= seg.inpoint {
+				continue
+			}
+			seg.outpoint = length - trimEnd
+			seg.end = clipStart.Add(seg.outpoint)
+		}
+		if seg.outpoint > 0 && seg.outpoint <= seg.inpoint {
+			continue
+		}
+		trimmed = append(trimmed, seg)
+	}
+	return trimmed, nil
+}
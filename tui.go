@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// tuiLogLines is the number of the latest status and ffmpeg lines shown under the progress bar.
+	tuiLogLines = 15
+	// tuiRedrawInterval is how often the progress screen is redrawn at most.
+	tuiRedrawInterval = 100 * time.Millisecond
+	// tuiDefaultWidth is the width of the terminal assumed when $COLUMNS is not set.
+	tuiDefaultWidth = 80
+)
+
+// ANSI escape sequences used by the terminal UI.
+const (
+	ansiAltScreen  = "\x1b[?1049h"
+	ansiMainScreen = "\x1b[?1049l"
+	ansiClear      = "\x1b[H\x1b[2J"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiBold       = "\x1b[1m"
+	ansiDim        = "\x1b[2m"
+	ansiRed        = "\x1b[31m"
+	ansiReset      = "\x1b[0m"
+)
+
+// errTUIQuit is returned when the user leaves the terminal UI before starting a merge.
+var errTUIQuit = errors.New("quit without merging")
+
+// tui asks its questions on a terminal, one screen at a time.
+type tui struct {
+	in    *bufio.Reader
+	out   io.Writer
+	width int
+}
+
+// runTUI implements the tui subcommand: a terminal UI for running merges by hand. It lists the cameras
+// found in the input directories, shows the days each has footage for, asks for the days, speed and
+// daily window to merge, and then shows the progress of the merge with its latest log lines. The flags
+// and config file set the defaults of the questions and every other option.
+func runTUI(args []string) error {
+	if err := tuiSession(args); !errors.Is(err, errTUIQuit) {
+		return err
+	}
+	return nil
+}
+
+// tuiSession asks for a merge and runs it, returning errTUIQuit if the user leaves before it starts.
+func tuiSession(args []string) error {
+	fset := flagSetFor("tui")
+	var opts options
+	configFile := fset.String("config", "", "Path to a JSON config file of flag values (default: \""+defaultConfigFile+"\" if present)")
+	configFromEnv := fset.Bool("config-from-env", false, configFromEnvUsage)
+	defineFlags(fset, &opts)
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return withExitCode(exitUsage, errors.New("tui needs an interactive terminal; in scripts, run the merge with flags instead"))
+	}
+	cfg, err := loadConfigFlag(*configFile, *configFromEnv)
+	if err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	if err := applyConfig(fset, cfg.flags); err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("applying config %s: %w", cfg.path, err))
+	}
+	enableANSI(os.Stdout)
+	t := &tui{in: bufio.NewReader(os.Stdin), out: os.Stdout, width: terminalWidth()}
+
+	// Cameras
+	naming, err := namingFor(opts.source, opts.dateOrder)
+	if err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	dirs := inputDirsFor(opts)
+	files, err := findVideoFiles(dirs, naming, "")
+	if err != nil {
+		return fmt.Errorf("finding video files: %w", err)
+	}
+	clips := loadClips(files, naming)
+	cameras := summarizeCameras(clips)
+	if len(cameras) == 0 {
+		return withExitCode(exitNoFootage, fmt.Errorf("no clips found in %s", strings.Join(dirs, ", ")))
+	}
+	t.screen("Cameras in " + strings.Join(dirs, ", "))
+	tw := tabwriter.NewWriter(t.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  #\tCAMERA\tCLIPS\tFROM\tTO\n")
+	names := make([]string, len(cameras))
+	for i, cam := range cameras {
+		names[i] = cam.Name
+		fmt.Fprintf(tw, "  %d\t%s\t%d\t%s\t%s\n", i+1, cam.Name, cam.Clips, cam.First.Format("2006-01-02 15:04"), cam.Last.Format("2006-01-02 15:04"))
+	}
+	tw.Flush()
+	camera, err := t.choose("Camera", names, opts.cameraName)
+	if err != nil {
+		return err
+	}
+
+	// Days, speed and window
+	var cameraClips []clip
+	for _, c := range clips {
+		if c.camera == camera {
+			cameraClips = append(cameraClips, c)
+		}
+	}
+	sort.Slice(cameraClips, func(i, j int) bool { return cameraClips[i].start.Before(cameraClips[j].start) })
+	t.screen("Footage of " + camera)
+	days := dailyCoverage(cameraClips)
+	t.coverage(days)
+	first, _ := time.ParseInLocation(time.DateOnly, days[0].Date, time.Local)
+	last, _ := time.ParseInLocation(time.DateOnly, days[len(days)-1].Date, time.Local)
+	from, err := t.askDate("From", first, first, last)
+	if err != nil {
+		return err
+	}
+	to, err := t.askDate("To", last, from, last)
+	if err != nil {
+		return err
+	}
+	for _, q := range []struct{ flag, question string }{
+		{"speed", "Speed factor"},
+		{"hours", "Daily window, e.g. 06:00-20:00 (- for whole days)"},
+		{"o", "Output"},
+	} {
+		if err := t.askFlag(fset, q.flag, q.question); err != nil {
+			return err
+		}
+	}
+	if err := fset.Set("camera", camera); err != nil {
+		return err
+	}
+	if err := prepareOptions(fset, &opts, cfg); err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	opts.spansFor = onlyDays(from, to, opts.spansFor)
+
+	fmt.Fprintf(t.out, "\nMerging %s from %s to %s at %gx into %s\n", camera, from.Format(time.DateOnly), to.Format(time.DateOnly), opts.speed, opts.output)
+	start, err := t.ask("Start? [Y/n]", "y")
+	if err != nil {
+		return err
+	}
+	if a := strings.ToLower(start); a != "y" && a != "yes" {
+		return errTUIQuit
+	}
+
+	// Progress
+	p := &tuiProgress{t: t, title: fmt.Sprintf("%s, %s to %s at %gx", camera, from.Format(time.DateOnly), to.Format(time.DateOnly), opts.speed)}
+	opts.stdout, opts.stderr = p, p
+	opts.progress = p.report
+	// Questions cannot be answered while the progress is shown, so existing outputs need -force
+	opts.interactive = false
+	p.start()
+	output, err := mergeAndNotify(opts)
+	p.stop()
+	if err != nil {
+		p.printLog()
+		return err
+	}
+	fmt.Fprintf(t.out, "Successfully created: %s (in %s)\n", output, time.Since(p.started).Round(time.Second))
+	return nil
+}
+
+// terminalWidth returns the width of the terminal from $COLUMNS, or tuiDefaultWidth.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n >= 40 {
+		return n
+	}
+	return tuiDefaultWidth
+}
+
+// screen clears the terminal and prints a title.
+func (t *tui) screen(title string) {
+	fmt.Fprintf(t.out, "%s%sUniFi timelapse%s - %s\n\n", ansiClear, ansiBold, ansiReset, title)
+}
+
+// ask prints question with its default answer and returns the answer, or the default for an empty one.
+func (t *tui) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(t.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(t.out, "%s: ", question)
+	}
+	line, err := t.in.ReadString('\n')
+	if err != nil {
+		// Ctrl+D
+		fmt.Fprintln(t.out)
+		return "", errTUIQuit
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// problem reports an invalid answer before the question is asked again.
+func (t *tui) problem(format string, args ...interface{}) {
+	fmt.Fprintf(t.out, "%s%s%s\n", ansiRed, fmt.Sprintf(format, args...), ansiReset)
+}
+
+// choose asks for one of options by its number or name, def being the default if it is one of them.
+func (t *tui) choose(question string, options []string, def string) (string, error) {
+	defNumber := ""
+	for i, o := range options {
+		if o == def || len(options) == 1 {
+			defNumber = strconv.Itoa(i + 1)
+		}
+	}
+	for {
+		answer, err := t.ask(fmt.Sprintf("\n%s (1-%d or name)", question, len(options)), defNumber)
+		if err != nil {
+			return "", err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+			return options[n-1], nil
+		}
+		for _, o := range options {
+			if strings.EqualFold(o, answer) {
+				return o, nil
+			}
+		}
+		t.problem("No %s %q", strings.ToLower(question), answer)
+	}
+}
+
+// askDate asks for a day from min to max, def being the default.
+func (t *tui) askDate(question string, def, min, max time.Time) (time.Time, error) {
+	for {
+		answer, err := t.ask(question+" (YYYY-MM-DD)", def.Format(time.DateOnly))
+		if err != nil {
+			return time.Time{}, err
+		}
+		day, err := time.ParseInLocation(time.DateOnly, answer, time.Local)
+		switch {
+		case err != nil:
+			t.problem("Invalid date %q", answer)
+		case day.Before(min) || day.After(max):
+			t.problem("Pick a day from %s to %s", min.Format(time.DateOnly), max.Format(time.DateOnly))
+		default:
+			return day, nil
+		}
+	}
+}
+
+// askFlag asks for the value of the merge flag name, its current value being the default, until the
+// flag accepts the answer; "-" clears it. The flag is only set when the answer changes it, so that
+// defaults depending on whether it was given, such as the extension of -o, still apply.
+func (t *tui) askFlag(fset *flag.FlagSet, name, question string) error {
+	f := fset.Lookup(name)
+	for {
+		answer, err := t.ask(question, f.Value.String())
+		if err != nil {
+			return err
+		}
+		if answer == "-" {
+			answer = ""
+		}
+		if answer == f.Value.String() {
+			return nil
+		}
+		if err := fset.Set(name, answer); err != nil {
+			t.problem("%v", err)
+			continue
+		}
+		return nil
+	}
+}
+
+// coverage prints one row per month of the days with footage, shaded by the share of the day recorded.
+func (t *tui) coverage(days []dayCoverage) {
+	shades := []string{"░", "▒", "▓", "█"}
+	byDate := make(map[string]dayCoverage, len(days))
+	for _, d := range days {
+		byDate[d.Date] = d
+	}
+	first, _ := time.ParseInLocation(time.DateOnly, days[0].Date, time.Local)
+	last, _ := time.ParseInLocation(time.DateOnly, days[len(days)-1].Date, time.Local)
+	fmt.Fprintf(t.out, "         %s\n", "1        10        20        30")
+	for m := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, time.Local); !m.After(last); m = m.AddDate(0, 1, 0) {
+		var row strings.Builder
+		for d := m; d.Month() == m.Month(); d = d.AddDate(0, 0, 1) {
+			info, ok := byDate[d.Format(time.DateOnly)]
+			if !ok {
+				row.WriteString(ansiDim + "·" + ansiReset)
+				continue
+			}
+			// Clips without an end time in their name count as footage of unknown length
+			shade := int(info.Seconds / 86400 * float64(len(shades)))
+			row.WriteString(shades[max(0, min(shade, len(shades)-1))])
+		}
+		fmt.Fprintf(t.out, "%s  %s\n", m.Format("2006-01"), row.String())
+	}
+	fmt.Fprintf(t.out, "\n%s· no footage  ░ ▒ ▓ █ share of the day recorded%s\n\n", ansiDim, ansiReset)
+}
+
+// tuiProgress shows the progress of a merge on the alternate screen of the terminal, with the latest
+// lines of its log. It is the merge's stdout and stderr.
+type tuiProgress struct {
+	t     *tui
+	title string
+
+	mu       sync.Mutex
+	started  time.Time
+	stage    string
+	fraction float64
+	// stageStarted is when the stage began, to estimate the time left.
+	stageStarted time.Time
+	lines        []string
+	partial      string
+	dirty        bool
+	done         chan struct{}
+	stopped      chan struct{}
+}
+
+// start switches to the alternate screen and redraws it while the merge runs. Ctrl+C restores the
+// screen before exiting.
+func (p *tuiProgress) start() {
+	p.started, p.stageStarted = time.Now(), time.Now()
+	p.done, p.stopped = make(chan struct{}), make(chan struct{})
+	fmt.Fprint(p.t.out, ansiAltScreen+ansiHideCursor)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		defer close(p.stopped)
+		defer signal.Stop(interrupt)
+		ticker := time.NewTicker(tuiRedrawInterval)
+		defer ticker.Stop()
+		p.draw()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-interrupt:
+				fmt.Fprint(p.t.out, ansiShowCursor+ansiMainScreen)
+				p.printLog()
+				fmt.Fprintln(os.Stderr, "Interrupted")
+				os.Exit(130)
+			case <-ticker.C:
+				p.mu.Lock()
+				dirty := p.dirty
+				p.dirty = false
+				p.mu.Unlock()
+				if dirty {
+					p.draw()
+				}
+			}
+		}
+	}()
+}
+
+// stop returns to the main screen once the merge is over.
+func (p *tuiProgress) stop() {
+	close(p.done)
+	<-p.stopped
+	fmt.Fprint(p.t.out, ansiShowCursor+ansiMainScreen)
+}
+
+// report records the stage and progress of the merge; it is used as options.progress.
+func (p *tuiProgress) report(stage string, fraction float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if stage != p.stage {
+		p.stageStarted = time.Now()
+	}
+	p.stage, p.fraction, p.dirty = stage, fraction, true
+}
+
+// Write adds the complete lines of b to the log shown. ffmpeg redraws its status line with carriage
+// returns, which replace the line being written.
+func (p *tuiProgress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	text := p.partial + string(b)
+	for {
+		i := strings.IndexAny(text, "\r\n")
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(text[:i]); line != "" && text[i] == '\n' {
+			p.lines = append(p.lines, line)
+			if len(p.lines) > tuiLogLines {
+				p.lines = p.lines[len(p.lines)-tuiLogLines:]
+			}
+		}
+		text = text[i+1:]
+	}
+	p.partial, p.dirty = text, true
+	return len(b), nil
+}
+
+// draw redraws the progress screen.
+func (p *tuiProgress) draw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	width := p.t.width
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%sUniFi timelapse%s - %s\n\n", ansiClear, ansiBold, ansiReset, p.title)
+	stage := p.stage
+	if stage == "" {
+		stage = "starting"
+	}
+	elapsed := time.Since(p.started).Round(time.Second)
+	fmt.Fprintf(&b, "Stage: %s%s%s    elapsed %s", ansiBold, stage, ansiReset, elapsed)
+	if p.fraction > 0.01 && p.fraction < 1 {
+		spent := time.Since(p.stageStarted)
+		left := time.Duration(float64(spent) / p.fraction * (1 - p.fraction))
+		fmt.Fprintf(&b, ", about %s left", left.Round(time.Second))
+	}
+	barWidth := width - 8
+	filled := int(p.fraction * float64(barWidth))
+	fmt.Fprintf(&b, "\n\n[%s%s] %3.0f%%\n\n", strings.Repeat("█", filled), strings.Repeat("░", barWidth-filled), p.fraction*100)
+	fmt.Fprintf(&b, "%sLog%s\n", ansiBold, ansiReset)
+	for _, line := range p.lines {
+		if r := []rune(line); len(r) > width-1 {
+			line = string(r[:width-2]) + "…"
+		}
+		fmt.Fprintf(&b, "%s%s%s\n", ansiDim, line, ansiReset)
+	}
+	io.WriteString(p.t.out, b.String())
+}
+
+// printLog prints the latest log lines on the main screen, to explain a failure.
+func (p *tuiProgress) printLog() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, line := range p.lines {
+		fmt.Fprintln(p.t.out, line)
+	}
+}
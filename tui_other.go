@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// enableANSI does nothing, as terminals other than the Windows console understand ANSI escapes.
+func enableANSI(f *os.File) {}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// enableVirtualTerminalProcessing is the console mode flag making the console interpret ANSI escapes.
+const enableVirtualTerminalProcessing = 0x0004
+
+var (
+	getConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleMode")
+	setConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+)
+
+// enableANSI makes the console of f interpret ANSI escapes, which Windows Terminal does anyway but the
+// classic console only does when asked. Older consoles without support are left as they are.
+func enableANSI(f *os.File) {
+	var mode uint32
+	if r, _, _ := getConsoleMode.Call(f.Fd(), uintptr(unsafe.Pointer(&mode))); r == 0 {
+		return
+	}
+	setConsoleMode.Call(f.Fd(), uintptr(mode|enableVirtualTerminalProcessing))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// passLogFile is the name prefix of the temporary files in which the first pass of a -two-pass encode
+// records the statistics the second pass distributes the bitrate by.
+const passLogFile = "ffmpeg2pass"
+
+// containerOverhead is the share of a -target-size kept free for the MP4 container and the bitrate the
+// encoder overshoots by.
+const containerOverhead = 0.03
+
+// bitratePattern matches an ffmpeg bitrate such as 8M, 2500k or 800000.
+var bitratePattern = regexp.MustCompile(`^\d+(\.\d+)?[kKmM]?$`)
+
+// sizeUnits maps the suffixes accepted by parseByteSize to their multipliers.
+var sizeUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"GB", 1 << 30}, {"G", 1 << 30},
+	{"MB", 1 << 20}, {"M", 1 << 20},
+	{"KB", 1 << 10}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize parses a file size such as 2GB, 700MB or 1.5G.
+func parseByteSize(s string) (int64, error) {
+	value, mult := strings.ToUpper(strings.TrimSpace(s)), 1.0
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value, mult = strings.TrimSuffix(value, u.suffix), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("expected a size such as 2GB or 700MB, got %q", s)
+	}
+	return int64(n * mult), nil
+}
+
+// targetBitrate returns the video bitrate, in bits per second, that fills size bytes with an output
+// lasting duration seconds, after the audio at audioBitrate and the container overhead.
+func targetBitrate(size int64, duration, audioBitrate float64) (int64, error) {
+	if duration <= 0 {
+		return 0, fmt.Errorf("the output length is unknown, so -target-size cannot be met; use -bitrate instead")
+	}
+	bitrate := float64(size)*8*(1-containerOverhead)/duration - audioBitrate
+	if bitrate < 100_000 {
+		return 0, fmt.Errorf("-target-size is too small for %.0f seconds of video", duration)
+	}
+	return int64(bitrate), nil
+}
+
+// passArgs returns the arguments that make the encoder run the given pass (1 or 2) of a two-pass encode,
+// recording or reading its statistics at logFile.
+func passArgs(opts options, pass int, logFile string) []string {
+	if videoEncoder(opts) == "libx265" {
+		// x265 takes its own parameters; quoting keeps the colon of a Windows drive letter out of the parsing
+		return []string{"-x265-params", fmt.Sprintf("pass=%d:stats='%s'", pass, logFile+".log")}
+	}
+	return []string{"-pass", strconv.Itoa(pass), "-passlogfile", logFile}
+}
+
+// runFirstPass runs the analysis pass of a two-pass encode: ffmpeg with base, which holds the inputs
+// and the video filter graph, encoding only the video to nowhere while recording its statistics.
+func runFirstPass(opts options, job encodeJob, base, encoderArgs []string) error {
+	args := append(base[:len(base):len(base)], encoderArgs...)
+	args = append(args, passArgs(opts, 1, job.passLogFile)...)
+	args = append(args, "-an", "-f", "mp4", "-y", os.DevNull)
+	fmt.Fprintf(opts.stdout, "Running the first of two passes from: %s\n", opts.ffmpegPath)
+	return runWithProgress(opts, job, args)
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// uiPage is the dashboard served by serve mode at "/". It is a single page that uses the REST API
+// to show cameras, coverage calendars, and jobs, and plays finished timelapses in the browser.
+//
+//go:embed ui/index.html
+var uiPage []byte
+
+// handleUI serves the dashboard.
+func (s *server) handleUI(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiPage)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultUploadRetries is how often a failed upload request is retried unless "retries" is set.
+	defaultUploadRetries = 3
+	// uploadPartSize is the size of the parts large outputs are uploaded in.
+	uploadPartSize = 16 << 20
+	// maxUploadParts is the largest number of parts object stores accept for one object.
+	maxUploadParts = 10000
+)
+
+// uploadConfig holds the upload targets from the "upload" section of the config file.
+type uploadConfig struct {
+	S3     *s3Config     `json:"s3"`
+	Azure  *azureConfig  `json:"azure"`
+	WebDAV *webdavConfig `json:"webdav"`
+	SFTP   *sftpConfig   `json:"sftp"`
+	// Directory is a local, mounted or UNC directory (e.g. an SMB share) outputs are copied to.
+	Directory string         `json:"directory"`
+	YouTube   *youtubeConfig `json:"youtube"`
+	// Retries is how often each failed request is retried; nil means defaultUploadRetries.
+	Retries *int `json:"retries"`
+	// DeleteLocal removes the output once every upload has succeeded.
+	DeleteLocal bool `json:"delete_local"`
+}
+
+// any reports whether at least one upload target is configured.
+func (u uploadConfig) any() bool {
+	return u.S3 != nil || u.Azure != nil || u.WebDAV != nil || u.SFTP != nil || u.Directory != "" || u.YouTube != nil
+}
+
+// validate checks that every configured target has the settings it needs.
+func (u uploadConfig) validate() error {
+	if u.S3 != nil {
+		if err := u.S3.validate(); err != nil {
+			return err
+		}
+	}
+	if u.Azure != nil {
+		if err := u.Azure.validate(); err != nil {
+			return err
+		}
+	}
+	if u.WebDAV != nil {
+		if err := u.WebDAV.validate(); err != nil {
+			return err
+		}
+	}
+	if u.SFTP != nil {
+		if err := u.SFTP.validate(); err != nil {
+			return err
+		}
+	}
+	if u.YouTube != nil {
+		if err := u.YouTube.validate(); err != nil {
+			return err
+		}
+	}
+	if u.Retries != nil && *u.Retries < 0 {
+		return errors.New("retries must not be negative")
+	}
+	return nil
+}
+
+// retries returns the configured number of retries.
+func (u uploadConfig) retries() int {
+	if u.Retries == nil {
+		return defaultUploadRetries
+	}
+	return *u.Retries
+}
+
+// uploadOutput pushes the output file to every configured target and returns the locations it was stored at.
+func uploadOutput(u uploadConfig, info *mergeInfo, opts options) ([]string, error) {
+	file, log := info.output, opts.stdout
+	var urls []string
+	if u.S3 != nil {
+		fmt.Fprintf(log, "Uploading %s to S3 bucket %s...\n", file, u.S3.Bucket)
+		url, err := uploadS3(*u.S3, file, u.retries())
+		if err != nil {
+			return urls, fmt.Errorf("uploading to S3: %w", err)
+		}
+		urls = append(urls, url)
+	}
+	if u.Azure != nil {
+		fmt.Fprintf(log, "Uploading %s to Azure Blob Storage...\n", file)
+		url, err := uploadAzure(*u.Azure, file, u.retries())
+		if err != nil {
+			return urls, fmt.Errorf("uploading to Azure: %w", err)
+		}
+		urls = append(urls, url)
+	}
+	if u.WebDAV != nil {
+		fmt.Fprintf(log, "Uploading %s to WebDAV...\n", file)
+		url, err := uploadWebDAV(*u.WebDAV, file, u.retries())
+		if err != nil {
+			return urls, fmt.Errorf("uploading to WebDAV: %w", err)
+		}
+		urls = append(urls, url)
+	}
+	if u.SFTP != nil {
+		fmt.Fprintf(log, "Copying %s to %s over SFTP...\n", file, u.SFTP.Host)
+		remote, err := uploadSFTP(*u.SFTP, file, u.retries())
+		if err != nil {
+			return urls, fmt.Errorf("copying over SFTP: %w", err)
+		}
+		urls = append(urls, remote)
+	}
+	if u.Directory != "" {
+		fmt.Fprintf(log, "Copying %s to %s...\n", file, u.Directory)
+		dest, err := copyToDirectory(u.Directory, file)
+		if err != nil {
+			return urls, fmt.Errorf("copying to %s: %w", u.Directory, err)
+		}
+		urls = append(urls, dest)
+	}
+	if u.YouTube != nil {
+		fmt.Fprintf(log, "Uploading %s to YouTube...\n", file)
+		video, err := uploadYouTube(*u.YouTube, info, opts)
+		if err != nil {
+			return urls, fmt.Errorf("uploading to YouTube: %w", err)
+		}
+		urls = append(urls, video)
+	}
+	return urls, nil
+}
+
+// objectName returns the name an output file is stored under: its base name after the prefix.
+func objectName(prefix, file string) string {
+	return path.Join(prefix, filepath.Base(file))
+}
+
+// contentType returns the MIME type of an output file, from its extension.
+func contentType(file string) string {
+	if t := mime.TypeByExtension(filepath.Ext(file)); t != "" {
+		return t
+	}
+	// Go only knows .mp4 from the system's MIME tables, which Windows lacks
+	if strings.EqualFold(filepath.Ext(file), ".mp4") {
+		return "video/mp4"
+	}
+	return "application/octet-stream"
+}
+
+// partSizeFor returns the part size for an upload of size bytes, grown if needed to stay within maxUploadParts.
+func partSizeFor(size int64) int64 {
+	part := int64(uploadPartSize)
+	if min := (size + maxUploadParts - 1) / maxUploadParts; min > part {
+		part = min
+	}
+	return part
+}
+
+// readPart reads the n-th part of the given size from f.
+func readPart(f *os.File, n int, partSize, fileSize int64) ([]byte, error) {
+	offset := int64(n) * partSize
+	length := partSize
+	if offset+length > fileSize {
+		length = fileSize - offset
+	}
+	buf := make([]byte, length)
+	if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// withRetries calls f until it succeeds or has been retried retries times, waiting twice as long
+// before each retry starting at one second.
+func withRetries(retries int, f func() error) error {
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
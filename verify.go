@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+)
+
+// verifyTolerance is how far, relative to the expected length, a verified output's length may be off.
+const verifyTolerance = 0.02
+
+// verifyOutput checks with ffprobe that the output of job is complete: that it can be read at all, which
+// a truncated MP4 cannot, that it has a video stream, and an audio stream with music, and that it is about
+// as long as expected. This catches encoders that crash without ffmpeg failing.
+func verifyOutput(opts options, job encodeJob) error {
+	if opts.format == formatFrames || opts.format == formatWebP {
+		// ffprobe cannot measure a directory of frames or an animated WebP
+		return nil
+	}
+	cmd := exec.Command(ffprobePath(opts.ffmpegPath), "-v", "error", "-show_entries", "format=duration:stream=codec_type", "-of", "json", job.outputFile)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s cannot be read: %w: %s", job.outputFile, err, lastLine(stderr.String()))
+	}
+	var probe struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &probe); err != nil {
+		return fmt.Errorf("probing %s: %w", job.outputFile, err)
+	}
+
+	streams := make(map[string]bool)
+	for _, s := range probe.Streams {
+		streams[s.CodecType] = true
+	}
+	if !streams["video"] {
+		return fmt.Errorf("%s has no video stream", job.outputFile)
+	}
+	if opts.music != "" && !streams["audio"] {
+		return fmt.Errorf("%s has no audio stream", job.outputFile)
+	}
+	actual, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil || actual <= 0 {
+		return fmt.Errorf("%s has no footage", job.outputFile)
+	}
+	if expected := expectedLength(opts, job); expected > 0 && math.Abs(actual-expected) > math.Max(1, expected*verifyTolerance) {
+		return fmt.Errorf("%s is %.1fs long, but %.1fs were expected", job.outputFile, actual, expected)
+	}
+	fmt.Fprintf(opts.stdout, "Verified %s (%.1fs)\n", job.outputFile, actual)
+	return nil
+}
+
+// expectedLength returns the length in seconds of job's output, including title cards and the -intro and
+// -outro cards, or 0 if it is unknown, as when -min-luma drops frames.
+func expectedLength(opts options, job encodeJob) float64 {
+	if opts.minLuma > 0 || job.duration == 0 {
+		return 0
+	}
+	slates := slateLength(job.intro) + slateLength(job.outro)
+	if len(job.days) == 0 || !opts.titleCards {
+		return job.duration + slates
+	}
+	chapters := withTitleCards(job.days, opts.titleCardDuration, job.openingCard)
+	return chapters[len(chapters)-1].end + slates
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+)
+
+// watermarkPositions maps -watermark-position values to overlay coordinates, given the margin in pixels.
+var watermarkPositions = map[string]func(margin int) string{
+	"top-left":     func(m int) string { return fmt.Sprintf("%d:%d", m, m) },
+	"top-right":    func(m int) string { return fmt.Sprintf("W-w-%d:%d", m, m) },
+	"bottom-left":  func(m int) string { return fmt.Sprintf("%d:H-h-%d", m, m) },
+	"bottom-right": func(m int) string { return fmt.Sprintf("W-w-%d:H-h-%d", m, m) },
+	"center":       func(int) string { return "(W-w)/2:(H-h)/2" },
+}
+
+// addWatermark composites the image at path onto the current stream of the graph.
+// The image's own alpha channel is preserved and further scaled by opacity (0-1).
+func addWatermark(g *filterGraph, path, position string, margin int, opacity float64) {
+	wm := g.input("-i", path)
+	g.graft(func(in, out string) string {
+		logo := g.label()
+		return fmt.Sprintf("[%s]format=rgba,colorchannelmixer=aa=%g[%s];[%s][%s]overlay=%s:format=auto[%s]",
+			wm, opacity, logo, in, logo, watermarkPositions[position](margin), out)
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Open-Meteo endpoints the weather of the footage is fetched from, free and without a key. The archive
+// lags a few days behind, so the forecast API, which also covers the past months, fills in the latest days.
+const (
+	weatherArchiveURL  = "https://archive-api.open-meteo.com/v1/archive"
+	weatherForecastURL = "https://api.open-meteo.com/v1/forecast"
+)
+
+// weatherTimeout is how long a request for the weather may take.
+const weatherTimeout = 30 * time.Second
+
+// Values of -weather-units.
+const (
+	weatherCelsius    = "celsius"
+	weatherFahrenheit = "fahrenheit"
+)
+
+// weatherVars are the -overlay-text placeholders filled in with the weather at the time of the footage.
+var weatherVars = []string{"temperature", "weather"}
+
+// weatherCodes describes the WMO weather interpretation codes Open-Meteo reports.
+var weatherCodes = map[int]string{
+	0:  "Clear sky",
+	1:  "Mainly clear",
+	2:  "Partly cloudy",
+	3:  "Overcast",
+	45: "Fog",
+	48: "Rime fog",
+	51: "Light drizzle",
+	53: "Drizzle",
+	55: "Dense drizzle",
+	56: "Freezing drizzle",
+	57: "Freezing drizzle",
+	61: "Light rain",
+	63: "Rain",
+	65: "Heavy rain",
+	66: "Freezing rain",
+	67: "Freezing rain",
+	71: "Light snow",
+	73: "Snow",
+	75: "Heavy snow",
+	77: "Snow grains",
+	80: "Light showers",
+	81: "Showers",
+	82: "Violent showers",
+	85: "Snow showers",
+	86: "Heavy snow showers",
+	95: "Thunderstorm",
+	96: "Thunderstorm with hail",
+	99: "Thunderstorm with hail",
+}
+
+// weatherHour is the weather of one hour.
+type weatherHour struct {
+	temperature float64
+	code        int
+}
+
+// weatherRecord is the hourly weather at the camera over the period of the footage.
+type weatherRecord struct {
+	// hours are keyed by the start of the hour, in UTC.
+	hours map[time.Time]weatherHour
+	// unit is the unit of the temperatures, e.g. °C.
+	unit string
+}
+
+// usesWeather reports whether the -overlay-text shows the weather.
+func usesWeather(text string) bool {
+	for _, m := range templateVarPattern.FindAllStringSubmatch(text, -1) {
+		for _, name := range weatherVars {
+			if m[1] == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// vars returns the values of the weather placeholders for footage recorded at t, which are empty when
+// the weather of that hour is unknown.
+func (w *weatherRecord) vars(t time.Time) map[string]string {
+	vars := map[string]string{"temperature": "", "weather": ""}
+	if w == nil {
+		return vars
+	}
+	if h, ok := w.hours[t.UTC().Truncate(time.Hour)]; ok {
+		vars["temperature"] = fmt.Sprintf("%.0f%s", math.Round(h.temperature), w.unit)
+		vars["weather"] = weatherCodes[h.code]
+	}
+	return vars
+}
+
+// covers reports whether the weather of every hour from from to to is known.
+func (w *weatherRecord) covers(from, to time.Time) bool {
+	for t := from.UTC().Truncate(time.Hour); !t.After(to); t = t.Add(time.Hour) {
+		if _, ok := w.hours[t]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchWeather returns the hourly weather at the -lat and -lon of opts from from to to: from the
+// -weather-url, or else from the Open-Meteo archive and then its forecast API for the hours missing.
+func fetchWeather(opts options, from, to time.Time) (*weatherRecord, error) {
+	endpoints := []string{weatherArchiveURL, weatherForecastURL}
+	if opts.weatherURL != "" {
+		endpoints = []string{opts.weatherURL}
+	}
+	record := &weatherRecord{hours: map[time.Time]weatherHour{}}
+	var firstErr error
+	for _, endpoint := range endpoints {
+		if err := queryWeather(endpoint, opts, from, to, record); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if record.covers(from, to) {
+			break
+		}
+	}
+	if len(record.hours) == 0 {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("no weather recorded from %s to %s", from.Format(time.DateOnly), to.Format(time.DateOnly))
+		}
+		return nil, firstErr
+	}
+	return record, nil
+}
+
+// weatherResponse is the part of an Open-Meteo response holding the hourly weather. Hours without data
+// yet are null.
+type weatherResponse struct {
+	Hourly struct {
+		Time        []string   `json:"time"`
+		Temperature []*float64 `json:"temperature_2m"`
+		WeatherCode []*int     `json:"weather_code"`
+	} `json:"hourly"`
+	HourlyUnits struct {
+		Temperature string `json:"temperature_2m"`
+	} `json:"hourly_units"`
+	Reason string `json:"reason"`
+}
+
+// queryWeather adds the hourly weather from from to to reported by an Open-Meteo compatible endpoint to
+// record, for the hours it does not hold yet.
+func queryWeather(endpoint string, opts options, from, to time.Time, record *weatherRecord) error {
+	query := url.Values{
+		"latitude":         {strconv.FormatFloat(opts.lat, 'f', -1, 64)},
+		"longitude":        {strconv.FormatFloat(opts.lon, 'f', -1, 64)},
+		"start_date":       {from.UTC().Format(time.DateOnly)},
+		"end_date":         {to.UTC().Format(time.DateOnly)},
+		"hourly":           {"temperature_2m,weather_code"},
+		"temperature_unit": {opts.weatherUnits},
+		"timezone":         {"GMT"},
+	}
+	client := &http.Client{Timeout: weatherTimeout}
+	resp, err := client.Get(endpoint + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var body weatherResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("reading the weather from %s: %w", endpoint, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", endpoint, resp.Status, body.Reason)
+	}
+	h := body.Hourly
+	for i, s := range h.Time {
+		t, err := time.Parse("2006-01-02T15:04", s)
+		if err != nil || i >= len(h.Temperature) || i >= len(h.WeatherCode) || h.Temperature[i] == nil || h.WeatherCode[i] == nil {
+			continue
+		}
+		if _, ok := record.hours[t]; !ok {
+			record.hours[t] = weatherHour{temperature: *h.Temperature[i], code: *h.WeatherCode[i]}
+		}
+	}
+	record.unit = body.HourlyUnits.Temperature
+	return nil
+}
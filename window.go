@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeSpan is a half-open interval [start, end) of wall-clock time.
+type timeSpan struct {
+	start, end time.Time
+}
+
+// dailyWindow is a time-of-day range repeated every day, as offsets from midnight.
+// A window whose end is before its start wraps past midnight (e.g. 22:00-04:00).
+type dailyWindow struct {
+	start, end time.Duration
+}
+
+// parseDailyWindow parses a window in the form "HH:MM-HH:MM". The end may be given as 24:00.
+func parseDailyWindow(s string) (dailyWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return dailyWindow{}, fmt.Errorf("expected HH:MM-HH:MM, got %q", s)
+	}
+	start, err := parseTimeOfDay(from)
+	if err != nil {
+		return dailyWindow{}, err
+	}
+	end, err := parseTimeOfDay(to)
+	if err != nil {
+		return dailyWindow{}, err
+	}
+	return dailyWindow{start: start, end: end}, nil
+}
+
+// parseTimeOfDay parses "HH:MM" into an offset from midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(strings.TrimSpace(s), "%d:%d", &h, &m); err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: expected HH:MM", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// spans returns the parts of the given day covered by the window.
+func (w dailyWindow) spans(day time.Time) []timeSpan {
+	midnight := startOfDay(day)
+	next := midnight.AddDate(0, 0, 1)
+	switch {
+	case w.start < w.end:
+		return []timeSpan{{midnight.Add(w.start), midnight.Add(w.end)}}
+	case w.start == w.end:
+		return []timeSpan{{midnight, next}}
+	default:
+		return []timeSpan{{midnight, midnight.Add(w.end)}, {midnight.Add(w.start), next}}
+	}
+}
+
+// startOfDay returns midnight at the beginning of the day containing t.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// planSegments trims each clip to the footage recorded inside the spans returned by spansFor for every
+// day the clip covers. Clips without an end timestamp cannot be trimmed and are kept whole only if they
+// start inside a span.
+func planSegments(clips []clip, spansFor func(day time.Time) []timeSpan) []segment {
+	var segments []segment
+
+	for _, c := range clips {
+		if c.end.IsZero() {
+			for _, span := range spansFor(c.start) {
+				if !c.start.Before(span.start) && c.start.Before(span.end) {
+					segments = append(segments, segment{path: c.path, start: c.start})
+					break
+				}
+			}
+			continue
+		}
+
+		for day := startOfDay(c.start); day.Before(c.end); day = day.AddDate(0, 0, 1) {
+			for _, span := range spansFor(day) {
+				from, to := span.start, span.end
+				if from.Before(c.start) {
+					from = c.start
+				}
+				if to.After(c.end) {
+					to = c.end
+				}
+				if !from.Before(to) {
+					continue
+				}
+
+				seg := segment{path: c.path, inpoint: from.Sub(c.start), start: from, end: to}
+				// Leave the outpoint open when the span runs to the end of the clip, since the
+				// filename end time is only accurate to the second
+				if to.Before(c.end) {
+					seg.outpoint = to.Sub(c.start)
+				}
+
+				// Join with the previous segment when the spans are contiguous (e.g. across midnight)
+				if n := len(segments); n > 0 && segments[n-1].path == seg.path && segments[n-1].outpoint > 0 && segments[n-1].outpoint == seg.inpoint {
+					segments[n-1].outpoint = seg.outpoint
+					segments[n-1].end = seg.end
+					continue
+				}
+				segments = append(segments, seg)
+			}
+		}
+	}
+
+	return segments
+}
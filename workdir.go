@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// workLogFile is the log in the work directory of a run, holding its status messages and ffmpeg's output.
+const workLogFile = "run.log"
+
+// workspace is the directory of one run's temporary files: the inputs list, conformed segments,
+// extracted frames, two-pass logs and the run's log. Each run has its own below -workdir, so that
+// concurrent runs and serve jobs never share them.
+type workspace struct {
+	dir string
+	log *os.File
+}
+
+// newWorkspace creates the work directory of a run for camera below opts.workDir.
+func newWorkspace(opts options, camera string) (*workspace, error) {
+	prefix := "unifi-timelapse-"
+	if camera != "" {
+		prefix += sanitizeFilename(camera) + "-"
+	}
+	dir, err := os.MkdirTemp(opts.workDir, prefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("creating work directory: %w", err)
+	}
+	log, err := os.Create(filepath.Join(dir, workLogFile))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("creating work directory: %w", err)
+	}
+	return &workspace{dir: dir, log: log}, nil
+}
+
+// logged returns w writing to the run's log too.
+func (ws *workspace) logged(w io.Writer) io.Writer {
+	if w == nil {
+		return ws.log
+	}
+	return io.MultiWriter(w, ws.log)
+}
+
+// remove deletes the work directory once the run is over, unless it failed with -keep-failed-workdir,
+// in which case it is left for debugging and its path reported.
+func (ws *workspace) remove(opts options, err error) {
+	ws.log.Close()
+	if err != nil && opts.keepFailedWorkDir {
+		fmt.Fprintf(opts.stderr, "Kept the work directory of the failed run: %s\n", ws.dir)
+		return
+	}
+	if err := os.RemoveAll(ws.dir); err != nil {
+		fmt.Fprintf(opts.stderr, "Warning: failed to remove work directory %s: %v\n", ws.dir, err)
+	}
+}
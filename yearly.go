@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultYearlyLength is the length the yearly summary is sped up to fit by default.
+	defaultYearlyLength = 3 * time.Minute
+	// monthTitleFormat is the Go time format of the month title cards.
+	monthTitleFormat = "January 2006"
+)
+
+// summaryDatePattern matches the date in the name of a day or month output, e.g. "2026-01-16.mp4" from
+// rolling or "G5_Flex_2026-01_x60.mp4".
+var summaryDatePattern = regexp.MustCompile(`(?:^|\D)(\d{4})-(\d{2})(?:-(\d{2}))?(?:\D|$)`)
+
+// summaryPart is a timelapse compiled into the yearly summary.
+type summaryPart struct {
+	path string
+	// date is the day, or the first of the month for monthly outputs, in the file's name.
+	date     time.Time
+	duration time.Duration
+}
+
+// runYearly implements the yearly subcommand: it compiles the day or month timelapses of a year into one
+// summary with a title card for each month, sped up to a target length, optionally with music.
+func runYearly(args []string) error {
+	fset := flagSetFor("yearly")
+	var inputDirs stringList
+	fset.Var(&inputDirs, "input", "Directory with the day or month timelapses, named with their date (e.g. 2026-01-16.mp4); repeatable (required)")
+	year := fset.Int("year", 0, "Year to compile (default: the latest year found)")
+	length := fset.Duration("length", defaultYearlyLength, "Length to speed the summary up to, including the title cards; it is never slowed down")
+	music := fset.String("music", "", "Audio file to play under the summary, looped if shorter and faded out at the end")
+	output := fset.String("o", "", "Output file (default: \"<year>_in_review.mp4\")")
+	force := fset.Bool("force", false, "Overwrite the output file if it already exists")
+	cardDuration := fset.Duration("card-duration", 2*time.Second, "How long each month's title card is shown")
+	font := fset.String("title-font", "", "Font file used for the title cards (default: ffmpeg's default font)")
+	fps := fset.Float64("fps", 30, "Output frame rate")
+	ffmpegPath := fset.String("ffmpeg", "ffmpeg", "Path to ffmpeg executable")
+	useGPU := fset.Bool("gpu", true, "Use NVIDIA GPU acceleration (h264_nvenc)")
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+
+	if len(inputDirs) == 0 {
+		return fmt.Errorf("yearly needs -input")
+	}
+	if *cardDuration <= 0 || *fps <= 0 || *fps > maxOutputFPS {
+		return fmt.Errorf("card duration must be greater than 0 and fps between 0 and %.0f", maxOutputFPS)
+	}
+	if *music != "" {
+		if _, err := os.Stat(*music); err != nil {
+			return fmt.Errorf("music: %w", err)
+		}
+	}
+
+	opts := options{
+		ffmpegPath:        *ffmpegPath,
+		useGPU:            *useGPU,
+		fps:               *fps,
+		speed:             1,
+		format:            formatMP4,
+		chapters:          true,
+		titleCards:        true,
+		titleCardDuration: *cardDuration,
+		titleFont:         *font,
+		music:             *music,
+		contrast:          1,
+		saturation:        1,
+		verify:            true,
+		stdout:            os.Stdout,
+		stderr:            os.Stderr,
+	}
+	version, err := preflightFFmpeg(opts)
+	if err != nil {
+		return withExitCode(exitFFmpeg, err)
+	}
+	fmt.Printf("Using %s\n", version)
+
+	parts, err := findSummaryParts(inputDirs, *year, ffprobePath(*ffmpegPath))
+	if err != nil {
+		return err
+	}
+	y := parts[0].date.Year()
+
+	// One chapter per month, on the source timeline until the speed is known
+	var months []chapter
+	var total float64
+	for _, p := range parts {
+		title := p.date.Format(monthTitleFormat)
+		if n := len(months); n == 0 || months[n-1].title != title {
+			if n > 0 {
+				months[n-1].end = total
+			}
+			months = append(months, chapter{start: total, title: title})
+		}
+		total += p.duration.Seconds()
+	}
+	months[len(months)-1].end = total
+
+	cards := float64(len(months)) * cardDuration.Seconds()
+	available := length.Seconds() - cards
+	if available <= 0 {
+		return fmt.Errorf("%d title cards of %s do not fit in %s", len(months), *cardDuration, *length)
+	}
+	opts.speed = math.Max(total/available, 1)
+	for i := range months {
+		months[i].start /= opts.speed
+		months[i].end /= opts.speed
+	}
+	fmt.Printf("Compiling %d timelapse(s) of %d month(s) of %d at %.1fx speed\n", len(parts), len(months), y, opts.speed)
+
+	if *output == "" {
+		*output = fmt.Sprintf("%d_in_review.mp4", y)
+	}
+	if err := prepareOutputDir(*output); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	outputFile, err := resolveOutputFile(*output, *force, versioningOff, isTerminal(os.Stdin))
+	if err != nil {
+		return err
+	}
+
+	workDir, err := os.MkdirTemp("", "unifi-timelapse-*")
+	if err != nil {
+		return fmt.Errorf("creating work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	segments := make([]segment, len(parts))
+	for i, p := range parts {
+		segments[i] = segment{path: p.path}
+	}
+	job := encodeJob{
+		inputs:       concatInput{file: filepath.Join(workDir, inputsFile)},
+		outputFile:   outputFile,
+		days:         months,
+		chaptersFile: filepath.Join(workDir, chaptersFile),
+		duration:     total/opts.speed + cards,
+		openingCard:  true,
+	}
+	if err := createInputsFile(segments, job.inputs.file); err != nil {
+		return fmt.Errorf("creating inputs file: %w", err)
+	}
+	if err := writeChaptersFile(job.chaptersFile, withTitleCards(months, *cardDuration, true)); err != nil {
+		return fmt.Errorf("creating chapters file: %w", err)
+	}
+	if err := runFFmpegWithRetries(opts, job); err != nil {
+		return withExitCode(exitEncode, fmt.Errorf("running ffmpeg: %w", err))
+	}
+	fmt.Printf("Successfully created: %s\n", outputFile)
+	return nil
+}
+
+// findSummaryParts returns the timelapses in dirs dated in year, or in the latest year found when year
+// is 0, in date order and measured with ffprobe.
+func findSummaryParts(dirs []string, year int, ffprobe string) ([]summaryPart, error) {
+	var files []string
+	seen := make(map[string]bool)
+	match := func(path string) bool { return summaryDatePattern.MatchString(filepath.Base(path)) }
+	for _, dir := range dirs {
+		if err := walkMediaDir(dir, match, []string{videoExt}, seen, &files); err != nil {
+			return nil, fmt.Errorf("finding timelapses: %w", err)
+		}
+	}
+
+	var parts []summaryPart
+	for _, file := range files {
+		m := summaryDatePattern.FindStringSubmatch(filepath.Base(file))
+		y, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		day := 1
+		if m[3] != "" {
+			day, _ = strconv.Atoi(m[3])
+		}
+		if month < 1 || month > 12 || day < 1 || day > 31 {
+			continue
+		}
+		parts = append(parts, summaryPart{path: file, date: time.Date(y, time.Month(month), day, 0, 0, 0, 0, time.Local)})
+	}
+	if year == 0 {
+		for _, p := range parts {
+			year = max(year, p.date.Year())
+		}
+	}
+	kept := parts[:0]
+	for _, p := range parts {
+		if p.date.Year() == year {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, withExitCode(exitNoFootage, fmt.Errorf("no timelapses named with a date of %d found", year))
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].date.Before(kept[j].date) })
+
+	for i := range kept {
+		d, err := probeDuration(ffprobe, kept[i].path)
+		if err != nil {
+			return nil, err
+		}
+		kept[i].duration = d
+	}
+	return kept, nil
+}
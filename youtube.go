@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Google OAuth and YouTube Data API endpoints.
+const (
+	googleDeviceCodeURL = "https://oauth2.googleapis.com/device/code"
+	googleTokenURL      = "https://oauth2.googleapis.com/token"
+	youtubeUploadURL    = "https://www.googleapis.com/upload/youtube/v3/videos?uploadType=resumable&part=snippet,status"
+	// youtubeScope is the broadest scope Google allows in the device flow; it covers uploading.
+	youtubeScope = "https://www.googleapis.com/auth/youtube"
+)
+
+// Defaults for the "youtube" upload target.
+const (
+	defaultYouTubeTokenFile   = "youtube-token.json"
+	defaultYouTubeTitle       = "{camera} timelapse {date}"
+	defaultYouTubeDescription = "Timelapse from {camera}, {date} to {end_date}, at {speed}x speed."
+	defaultYouTubePrivacy     = "unlisted"
+)
+
+// Values of -youtube-chapters.
+const (
+	youtubeChaptersDay  = "day"
+	youtubeChaptersHour = "hour"
+)
+
+const (
+	// minYouTubeChapter is the shortest chapter YouTube accepts.
+	minYouTubeChapter = 10 * time.Second
+	// minYouTubeChapters is the fewest chapters YouTube shows; with fewer, the timestamps are plain text.
+	minYouTubeChapters = 3
+)
+
+// youtubeConfig publishes outputs to a YouTube channel. ClientID and ClientSecret belong to an
+// OAuth client of type "TVs and Limited Input devices" created in the Google Cloud console.
+type youtubeConfig struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// TokenFile stores the refresh token obtained by "youtube-login".
+	TokenFile string `json:"token_file"`
+	// Title and Description may contain the output template placeholders, e.g. "{camera} {date}".
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	CategoryID  string   `json:"category_id"`
+	// Privacy is "private", "unlisted" (default) or "public".
+	Privacy string `json:"privacy"`
+}
+
+// validate checks the client credentials and privacy setting.
+func (c youtubeConfig) validate() error {
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return errors.New("youtube needs client_id and client_secret")
+	}
+	switch c.Privacy {
+	case "", "private", "unlisted", "public":
+		return nil
+	}
+	return fmt.Errorf("unknown youtube privacy %q (use private, unlisted, or public)", c.Privacy)
+}
+
+// tokenFile returns the file holding the saved OAuth token.
+func (c youtubeConfig) tokenFile() string {
+	return firstNonEmpty(c.TokenFile, defaultYouTubeTokenFile)
+}
+
+// youtubeVars returns the placeholder values of titles and descriptions, which show the camera name as
+// it is, not sanitized for file names.
+func youtubeVars(info *mergeInfo, opts options) map[string]string {
+	vars := make(map[string]string, len(info.vars))
+	for k, v := range info.vars {
+		vars[k] = v
+	}
+	vars["camera"] = opts.cameraName
+	return vars
+}
+
+// youtubeDescription expands a description template, putting the -youtube-chapters list where it
+// has {chapters}, or after it.
+func youtubeDescription(template string, vars map[string]string, chapters string) string {
+	if chapters != "" && !strings.Contains(template, "{chapters}") {
+		template += "\n\n{chapters}"
+	}
+	withChapters := make(map[string]string, len(vars)+1)
+	for k, v := range vars {
+		withChapters[k] = v
+	}
+	withChapters["chapters"] = chapters
+	return strings.TrimSpace(expandText(template, withChapters))
+}
+
+// youtubeChapterList returns chapters as the timestamped lines YouTube turns into chapters when they
+// are in a video's description, and how many there are. YouTube needs the first to start at 0:00 and
+// each to last at least minYouTubeChapter, so shorter ones are merged into the one before them.
+func youtubeChapterList(chapters []chapter) (string, int) {
+	if len(chapters) == 0 {
+		return "", 0
+	}
+	end := chapters[len(chapters)-1].end
+	kept := []chapter{{start: 0, title: chapters[0].title}}
+	for _, c := range chapters[1:] {
+		if c.start-kept[len(kept)-1].start >= minYouTubeChapter.Seconds() && end-c.start >= minYouTubeChapter.Seconds() {
+			kept = append(kept, c)
+		}
+	}
+	var b strings.Builder
+	for _, c := range kept {
+		fmt.Fprintf(&b, "%s %s\n", youtubeTimestamp(c.start), c.title)
+	}
+	return strings.TrimSuffix(b.String(), "\n"), len(kept)
+}
+
+// youtubeTimestamp formats a position in seconds as YouTube expects, e.g. 4:05 or 1:02:03.
+func youtubeTimestamp(secs float64) string {
+	s := int(secs)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// writeYouTubeDescription writes the description of the output to paste when uploading it to YouTube,
+// with chapters listed as timestamps, and keeps the list in info for uploads made by this run.
+func writeYouTubeDescription(opts options, info *mergeInfo, chapters []chapter) error {
+	list, n := youtubeChapterList(chapters)
+	if n < minYouTubeChapters {
+		fmt.Fprintf(opts.stderr, "Warning: YouTube shows chapters only when there are at least %d, and the output has %d\n", minYouTubeChapters, n)
+	}
+	info.youtubeChapters = list
+	template := defaultYouTubeDescription
+	if opts.upload.YouTube != nil {
+		template = firstNonEmpty(opts.upload.YouTube.Description, template)
+	}
+	file := youtubeDescriptionFile(info.output)
+	if err := os.WriteFile(file, []byte(youtubeDescription(template, youtubeVars(info, opts), list)+"\n"), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(opts.stdout, "Created YouTube description with %d chapter(s): %s\n", n, file)
+	return nil
+}
+
+// youtubeDescriptionFile returns the name of the -youtube-chapters description written next to output.
+func youtubeDescriptionFile(output string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".youtube.txt"
+}
+
+// oauthToken is the part of a Google token response that is kept.
+type oauthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// runYouTubeLogin implements "youtube-login": it authorizes uploads to a YouTube channel with the
+// OAuth device flow and saves the refresh token for later runs.
+func runYouTubeLogin(args []string) error {
+	fset := flagSetFor("youtube-login")
+	configFile := fset.String("config", "", "Path to the JSON config file with the \"youtube\" upload settings (default: \""+defaultConfigFile+"\" if present)")
+	configFromEnv := fset.Bool("config-from-env", false, configFromEnvUsage)
+	if err := parseFlags(fset, args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfigFlag(*configFile, *configFromEnv)
+	if err != nil {
+		return err
+	}
+	if cfg.upload.YouTube == nil {
+		return fmt.Errorf("no \"youtube\" entry in the \"upload\" section of %s", cfg.path)
+	}
+	_, err = youtubeDeviceLogin(*cfg.upload.YouTube, os.Stdout)
+	return err
+}
+
+// youtubeDeviceLogin asks the user to approve access on another device, waits for the approval,
+// and saves the token.
+func youtubeDeviceLogin(c youtubeConfig, log io.Writer) (*oauthToken, error) {
+	var code struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURL string `json:"verification_url"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+	}
+	if err := postForm(googleDeviceCodeURL, url.Values{"client_id": {c.ClientID}, "scope": {youtubeScope}}, &code); err != nil {
+		return nil, fmt.Errorf("requesting device code: %w", err)
+	}
+	fmt.Fprintf(log, "To allow uploads to YouTube, visit %s and enter the code %s\n", code.VerificationURL, code.UserCode)
+
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		var token oauthToken
+		err := postForm(googleTokenURL, url.Values{
+			"client_id":     {c.ClientID},
+			"client_secret": {c.ClientSecret},
+			"device_code":   {code.DeviceCode},
+			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &token)
+		var oauthErr *oauthError
+		switch {
+		case err == nil:
+			if err := saveOAuthToken(c.tokenFile(), token); err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(log, "Authorized; token saved to %s\n", c.tokenFile())
+			return &token, nil
+		case errors.As(err, &oauthErr) && oauthErr.Code == "authorization_pending":
+		case errors.As(err, &oauthErr) && oauthErr.Code == "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("waiting for authorization: %w", err)
+		}
+	}
+	return nil, errors.New("the code expired before access was allowed")
+}
+
+// youtubeAccessToken returns a fresh access token from the saved refresh token. Without a saved
+// token it runs the device flow when interactive is set.
+func youtubeAccessToken(c youtubeConfig, interactive bool, log io.Writer) (string, error) {
+	data, err := os.ReadFile(c.tokenFile())
+	if errors.Is(err, os.ErrNotExist) {
+		if !interactive {
+			return "", fmt.Errorf("not authorized yet; run \"%s youtube-login\" first", os.Args[0])
+		}
+		token, err := youtubeDeviceLogin(c, log)
+		if err != nil {
+			return "", err
+		}
+		return token.AccessToken, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var saved oauthToken
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return "", fmt.Errorf("reading %s: %w", c.tokenFile(), err)
+	}
+
+	var token oauthToken
+	if err := postForm(googleTokenURL, url.Values{
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+		"refresh_token": {saved.RefreshToken},
+		"grant_type":    {"refresh_token"},
+	}, &token); err != nil {
+		return "", fmt.Errorf("refreshing access token (run \"%s youtube-login\" if access was revoked): %w", os.Args[0], err)
+	}
+	return token.AccessToken, nil
+}
+
+// saveOAuthToken stores the refresh token, readable only by the current user.
+func saveOAuthToken(path string, token oauthToken) error {
+	data, err := json.MarshalIndent(oauthToken{RefreshToken: token.RefreshToken}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// uploadYouTube publishes the output with a resumable upload and returns the video's URL.
+func uploadYouTube(c youtubeConfig, info *mergeInfo, opts options) (string, error) {
+	token, err := youtubeAccessToken(c, opts.interactive, opts.stdout)
+	if err != nil {
+		return "", err
+	}
+
+	vars := youtubeVars(info, opts)
+	metadata := map[string]interface{}{
+		"snippet": map[string]interface{}{
+			"title":       expandText(firstNonEmpty(c.Title, defaultYouTubeTitle), vars),
+			"description": youtubeDescription(firstNonEmpty(c.Description, defaultYouTubeDescription), vars, info.youtubeChapters),
+			"tags":        c.Tags,
+			"categoryId":  firstNonEmpty(c.CategoryID, "22"),
+		},
+		"status": map[string]interface{}{
+			"privacyStatus": firstNonEmpty(c.Privacy, defaultYouTubePrivacy),
+		},
+	}
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(info.output)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Start a resumable session, then send the whole file to the session URL
+	req, err := http.NewRequest(http.MethodPost, youtubeUploadURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-Upload-Content-Type", "video/mp4")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(stat.Size(), 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("starting upload: %s", resp.Status)
+	}
+	session := resp.Header.Get("Location")
+
+	req, err = http.NewRequest(http.MethodPut, session, f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = stat.Size()
+	req.Header.Set("Content-Type", "video/mp4")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("uploading: %s: %s", resp.Status, lastLine(string(data)))
+	}
+	var video struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &video); err != nil || video.ID == "" {
+		return "", fmt.Errorf("unexpected upload response: %s", lastLine(string(data)))
+	}
+	return "https://youtu.be/" + video.ID, nil
+}
+
+// oauthError is an error response from Google's token endpoints.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Error returns the error code and description.
+func (e *oauthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// postForm POSTs a form and decodes the JSON response into result. OAuth error responses are returned as *oauthError.
+func postForm(target string, form url.Values, result interface{}) error {
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(target, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e oauthError
+		if json.Unmarshal(data, &e) == nil && e.Code != "" {
+			return &e
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.Unmarshal(data, result)
+}